@@ -16,6 +16,13 @@ func InitConf() {
 	IsMasterNode = viper.GetString("node_type") != "slave"
 	RequestInterval = time.Duration(viper.GetInt("polling_interval")) * time.Second
 	SessionSecret = utils.GetOrDefault("session_secret", SessionSecret)
+	ChannelBreakerThreshold = utils.GetOrDefault("channel_breaker.threshold", ChannelBreakerThreshold)
+	ChannelBreakerSeconds = utils.GetOrDefault("channel_breaker.seconds", ChannelBreakerSeconds)
+	FailoverMaxAttempts = utils.GetOrDefault("failover.max_attempts", FailoverMaxAttempts)
+	FailoverRetryOn = viper.GetStringSlice("failover.retry_on")
+	ChannelQueueConcurrency = utils.GetOrDefault("channel_queue.concurrency", ChannelQueueConcurrency)
+	ChannelQueueDepth = utils.GetOrDefault("channel_queue.depth", ChannelQueueDepth)
+	ChannelQueueRetryAfter = utils.GetOrDefault("channel_queue.retry_after", ChannelQueueRetryAfter)
 }
 
 func setEnv() {
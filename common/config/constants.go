@@ -110,6 +110,21 @@ var RetryTimes = 0
 var DefaultChannelWeight = uint(1)
 var RetryCooldownSeconds = 5
 
+// 渠道连续失败达到阈值后熔断的秒数，阈值为 0 时不熔断
+var ChannelBreakerThreshold = 0
+var ChannelBreakerSeconds = 60
+
+// 失败后最多尝试的渠道数（含首次），0 时使用 RetryTimes
+var FailoverMaxAttempts = 0
+
+// 可重试的失败条件：timeout、5xx、429 或具体状态码，为空时使用默认规则
+var FailoverRetryOn []string
+
+// 每个渠道同时处理的请求数及排队等待的请求数，排队已满时返回 503，并发数为 0 时不限制
+var ChannelQueueConcurrency = 0
+var ChannelQueueDepth = 0
+var ChannelQueueRetryAfter = 1
+
 var CFWorkerImageUrl = ""
 var CFWorkerImageKey = ""
 
@@ -142,6 +142,10 @@ func SysError(s string) {
 	Logger.Error("[SYS] | " + s)
 }
 
+func SysDebug(s string) {
+	Logger.Debug("[SYS] | " + s)
+}
+
 func LogInfo(ctx context.Context, msg string) {
 	logHelper(ctx, loggerINFO, msg)
 }
@@ -0,0 +1,81 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common/logger"
+	"one-api/common/stream"
+	"one-api/types"
+	"runtime/debug"
+	"strings"
+)
+
+// 处理一个完整的 SSE 事件，返回 false 时停止读取
+type EventHandler[T streamable] func(event *stream.Event, dataChan chan T, errChan chan error) bool
+
+type eventStreamReader[T streamable] struct {
+	scanner  *stream.SSEScanner
+	response *http.Response
+
+	handler EventHandler[T]
+
+	DataChan chan T
+	ErrChan  chan error
+}
+
+func (r *eventStreamReader[T]) Recv() (<-chan T, <-chan error) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.SysError(fmt.Sprintf("Panic in eventStreamReader.processEvents: %v", err))
+				logger.SysError(fmt.Sprintf("stacktrace from panic: %s", string(debug.Stack())))
+
+				r.ErrChan <- &types.OpenAIError{
+					Code:    "system error",
+					Message: "stream processing panic",
+					Type:    "system_error",
+				}
+			}
+		}()
+		r.processEvents()
+	}()
+
+	return r.DataChan, r.ErrChan
+}
+
+func (r *eventStreamReader[T]) processEvents() {
+	for r.scanner.Scan() {
+		if !r.handler(r.scanner.Event(), r.DataChan, r.ErrChan) {
+			return
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		r.ErrChan <- err
+		return
+	}
+
+	r.ErrChan <- io.EOF
+}
+
+func (r *eventStreamReader[T]) Close() {
+	r.response.Body.Close()
+}
+
+// 获取按事件处理的流式响应
+func RequestEventStream[T streamable](requester *HTTPRequester, resp *http.Response, handler EventHandler[T]) (*eventStreamReader[T], *types.OpenAIErrorWithStatusCode) {
+	// 如果返回的头是json格式 说明有错误
+	if strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil, HandleErrorResp(resp, requester.ErrorHandler, requester.IsOpenAI)
+	}
+
+	return &eventStreamReader[T]{
+		scanner:  stream.NewSSEScanner(resp.Body),
+		response: resp,
+		handler:  handler,
+
+		DataChan: make(chan T),
+		ErrChan:  make(chan error),
+	}, nil
+}
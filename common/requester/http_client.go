@@ -1,25 +1,104 @@
 package requester
 
 import (
+	"crypto/tls"
 	"net/http"
+	"one-api/common/config"
 	"one-api/common/utils"
 	"time"
 )
 
 var HTTPClient *http.Client
 
+// 分阶段的超时设置，连接超时由 connect_timeout 控制，0 表示不限制
+type httpTimeouts struct {
+	// TLS 握手
+	TLSHandshake time.Duration
+	// 发送请求后等待响应头，不包括读取响应体
+	ResponseHeader time.Duration
+	// 整个请求的总时长
+	Request time.Duration
+}
+
+// 连接池设置，Go 默认每个 host 只保留 2 个空闲连接，高并发时会频繁新建连接
+type HTTPPool struct {
+	// 所有 host 的空闲连接总数，0 表示不限制
+	MaxIdleConns int
+	// 每个 host 的空闲连接数
+	MaxIdleConnsPerHost int
+	// 空闲连接的保留时间，0 表示不限制
+	IdleConnTimeout time.Duration
+}
+
+// 全局连接池设置
+func GetHTTPPool() HTTPPool {
+	return HTTPPool{
+		MaxIdleConns:        utils.GetOrDefault("http_pool.max_idle_conns", 500),
+		MaxIdleConnsPerHost: utils.GetOrDefault("http_pool.max_idle_conns_per_host", 100),
+		IdleConnTimeout:     time.Duration(utils.GetOrDefault("http_pool.idle_conn_timeout", 90)) * time.Second,
+	}
+}
+
 func InitHttpClient() {
+	HTTPClient = newHTTPClient(httpTimeouts{
+		TLSHandshake:   time.Duration(utils.GetOrDefault("tls_handshake_timeout", 10)) * time.Second,
+		ResponseHeader: time.Duration(utils.GetOrDefault("response_header_timeout", 0)) * time.Second,
+		Request:        time.Duration(utils.GetOrDefault("relay_timeout", 600)) * time.Second,
+	}, GetHTTPPool())
+}
+
+func newHTTPClient(timeouts httpTimeouts, pool HTTPPool) *http.Client {
 	trans := &http.Transport{
-		DialContext: utils.Socks5ProxyFunc,
-		Proxy:       utils.ProxyFunc,
+		DialContext:           utils.Socks5ProxyFunc,
+		Proxy:                 utils.ProxyFunc,
+		TLSHandshakeTimeout:   timeouts.TLSHandshake,
+		ResponseHeaderTimeout: timeouts.ResponseHeader,
 	}
+	pool.apply(trans)
 
-	HTTPClient = &http.Client{
+	return &http.Client{
 		Transport: trans,
+		Timeout:   timeouts.Request,
 	}
+}
+
+func (pool HTTPPool) apply(trans *http.Transport) {
+	trans.MaxIdleConns = pool.MaxIdleConns
+	trans.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	trans.IdleConnTimeout = pool.IdleConnTimeout
+}
 
-	relayTimeout := utils.GetOrDefault("relay_timeout", 600)
-	if relayTimeout != 0 {
-		HTTPClient.Timeout = time.Duration(relayTimeout) * time.Second
+// 复制全局 HTTPClient 并使用自定义 TLS 配置，代理和超时设置保持一致
+func NewTLSHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return CloneHTTPClient(tlsConfig, nil)
+}
+
+// 复制全局 HTTPClient，tlsConfig、pool 不为空时替换对应设置，代理和超时设置保持一致
+func CloneHTTPClient(tlsConfig *tls.Config, pool *HTTPPool) *http.Client {
+	trans, ok := HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		trans = http.DefaultTransport.(*http.Transport)
+	}
+	trans = trans.Clone()
+	if tlsConfig != nil {
+		trans.TLSClientConfig = tlsConfig
 	}
+	if pool != nil {
+		pool.apply(trans)
+	}
+
+	return &http.Client{
+		Transport: trans,
+		Timeout:   HTTPClient.Timeout,
+	}
+}
+
+// 请求上游时使用的 User-Agent，默认为 one-hub/<version>
+func GetUserAgent() string {
+	userAgent := utils.GetOrDefault("user_agent", "")
+	if userAgent == "" {
+		userAgent = "one-hub/" + config.Version
+	}
+
+	return userAgent
 }
@@ -0,0 +1,137 @@
+package requester
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientResponseHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-header" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		// 响应头之后的慢响应体不受响应头超时限制
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := newHTTPClient(httpTimeouts{ResponseHeader: 100 * time.Millisecond}, HTTPPool{})
+
+	_, err := client.Get(server.URL + "/slow-header")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+
+	resp, err := client.Get(server.URL + "/slow-body")
+	assert.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", string(body))
+
+	// 总时长仍然受限
+	client = newHTTPClient(httpTimeouts{ResponseHeader: 100 * time.Millisecond, Request: 100 * time.Millisecond}, HTTPPool{})
+	resp, err = client.Get(server.URL + "/slow-body")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Client.Timeout")
+}
+
+func TestHTTPClientTLSHandshakeTimeout(t *testing.T) {
+	// 接受连接但不进行 TLS 握手
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := newHTTPClient(httpTimeouts{TLSHandshake: 100 * time.Millisecond}, HTTPPool{})
+	start := time.Now()
+	_, err = client.Get("https://" + listener.Addr().String())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "TLS handshake timeout")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// 并发请求多轮，统计服务端新建的连接数
+func countNewConns(t testing.TB, pool HTTPPool, rounds, concurrency int) int64 {
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := newHTTPClient(httpTimeouts{}, pool)
+	defer client.CloseIdleConnections()
+
+	for round := 0; round < rounds; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(server.URL)
+				if !assert.Nil(t, err) {
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+	}
+
+	return conns.Load()
+}
+
+func TestHTTPClientPool(t *testing.T) {
+	// Go 默认每个 host 只保留 2 个空闲连接，之后每轮都要重新建立连接
+	small := countNewConns(t, HTTPPool{MaxIdleConnsPerHost: 2}, 5, 20)
+	large := countNewConns(t, HTTPPool{MaxIdleConns: 100, MaxIdleConnsPerHost: 20}, 5, 20)
+
+	assert.Greater(t, small, int64(20*4))
+	assert.LessOrEqual(t, large, int64(20*2))
+	assert.Less(t, large, small)
+}
+
+func BenchmarkHTTPClientPool(b *testing.B) {
+	for _, perHost := range []int{2, 50} {
+		b.Run(fmt.Sprintf("max_idle_conns_per_host=%d", perHost), func(b *testing.B) {
+			var conns int64
+			for i := 0; i < b.N; i++ {
+				conns += countNewConns(b, HTTPPool{MaxIdleConnsPerHost: perHost}, 5, 50)
+			}
+			b.ReportMetric(float64(conns)/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -3,6 +3,8 @@ package requester
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -19,6 +21,11 @@ import (
 
 type HttpErrorHandler func(*http.Response) *types.OpenAIError
 
+// HTTPDoer 发送 HTTP 请求，默认使用全局 HTTPClient，测试时可替换
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
 type HTTPRequester struct {
 	// requestBuilder    utils.RequestBuilder
 	CreateFormBuilder func(io.Writer) FormBuilder
@@ -26,6 +33,7 @@ type HTTPRequester struct {
 	proxyAddr         string
 	Context           context.Context
 	IsOpenAI          bool
+	Doer              HTTPDoer
 }
 
 // NewHTTPRequester 创建一个新的 HTTPRequester 实例。
@@ -69,16 +77,34 @@ func (r *HTTPRequester) NewRequest(method, url string, setters ...requestOption)
 		return nil, err
 	}
 
+	// 渠道自定义 header 中的 User-Agent 优先
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", GetUserAgent())
+	}
+
 	return req, nil
 }
 
+func (r *HTTPRequester) getDoer() HTTPDoer {
+	if r.Doer != nil {
+		return r.Doer
+	}
+
+	return HTTPClient
+}
+
 // 发送请求
 func (r *HTTPRequester) SendRequest(req *http.Request, response any, outputResp bool) (*http.Response, *types.OpenAIErrorWithStatusCode) {
-	resp, err := HTTPClient.Do(req)
+	resp, err := r.getDoer().Do(req)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
 	}
 
+	if err = DecompressResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, common.ErrorWrapper(err, "decompress_response_failed", http.StatusInternalServerError)
+	}
+
 	if !outputResp {
 		defer resp.Body.Close()
 	}
@@ -114,11 +140,16 @@ func (r *HTTPRequester) SendRequest(req *http.Request, response any, outputResp
 // 发送请求 RAW
 func (r *HTTPRequester) SendRequestRaw(req *http.Request) (*http.Response, *types.OpenAIErrorWithStatusCode) {
 	// 发送请求
-	resp, err := HTTPClient.Do(req)
+	resp, err := r.getDoer().Do(req)
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "http_request_failed", http.StatusInternalServerError)
 	}
 
+	if err = DecompressResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, common.ErrorWrapper(err, "decompress_response_failed", http.StatusInternalServerError)
+	}
+
 	// 处理响应
 	if r.IsFailureStatusCode(resp) {
 		return nil, HandleErrorResp(resp, r.ErrorHandler, r.IsOpenAI)
@@ -127,6 +158,49 @@ func (r *HTTPRequester) SendRequestRaw(req *http.Request) (*http.Response, *type
 	return resp, nil
 }
 
+type decompressReadCloser struct {
+	io.Reader
+	decompressor io.Closer
+	body         io.Closer
+}
+
+func (r *decompressReadCloser) Close() error {
+	r.decompressor.Close()
+	return r.body.Close()
+}
+
+// 解压响应体
+// 手动设置 Accept-Encoding 后 http.Transport 不会自动解压，需要在这里处理
+func DecompressResponse(resp *http.Response) error {
+	var decompressor io.ReadCloser
+	var err error
+
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip":
+		decompressor, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		decompressor, err = zlib.NewReader(resp.Body)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body = &decompressReadCloser{
+		Reader:       decompressor,
+		decompressor: decompressor,
+		body:         resp.Body,
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return nil
+}
+
 // 获取流式响应
 func RequestStream[T streamable](requester *HTTPRequester, resp *http.Response, handlerPrefix HandlerPrefix[T]) (*streamReader[T], *types.OpenAIErrorWithStatusCode) {
 	// 如果返回的头是json格式 说明有错误
@@ -232,6 +306,13 @@ func SetEventStreamHeaders(c *gin.Context) {
 	c.Writer.Header().Set("X-Accel-Buffering", "no")
 }
 
+// 流式响应未发送内容前出错时清除 SSE 响应头，以便返回 JSON 错误
+func ResetEventStreamHeaders(c *gin.Context) {
+	for _, key := range []string{"Content-Type", "Cache-Control", "Connection", "Transfer-Encoding", "X-Accel-Buffering"} {
+		c.Writer.Header().Del(key)
+	}
+}
+
 func GetJsonHeaders() map[string]string {
 	return map[string]string{
 		"Content-type": "application/json",
@@ -0,0 +1,83 @@
+package requester
+
+import (
+	"sync"
+	"time"
+)
+
+// SSE 注释行，符合规范的客户端会忽略，用于保持空闲连接
+const KeepAliveComment = ": keep-alive"
+
+type keepAliveStreamReader struct {
+	stream   StreamReaderInterface[string]
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+// 在收到第一个数据之前按间隔发送 KeepAliveComment，避免代理因连接空闲断开
+func WithKeepAlive(stream StreamReaderInterface[string], interval time.Duration) StreamReaderInterface[string] {
+	if interval <= 0 {
+		return stream
+	}
+
+	return &keepAliveStreamReader{
+		stream:   stream,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (r *keepAliveStreamReader) Recv() (<-chan string, <-chan error) {
+	dataChan, errChan := r.stream.Recv()
+	keepAliveDataChan := make(chan string)
+	keepAliveErrChan := make(chan error)
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		// 收到数据后不再发送心跳
+		started := false
+		for {
+			select {
+			case data := <-dataChan:
+				started = true
+				ticker.Stop()
+				if !r.send(keepAliveDataChan, data) {
+					return
+				}
+			case err := <-errChan:
+				select {
+				case keepAliveErrChan <- err:
+				case <-r.done:
+				}
+				return
+			case <-ticker.C:
+				if !started && !r.send(keepAliveDataChan, KeepAliveComment) {
+					return
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	return keepAliveDataChan, keepAliveErrChan
+}
+
+func (r *keepAliveStreamReader) send(dataChan chan string, data string) bool {
+	select {
+	case dataChan <- data:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *keepAliveStreamReader) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.stream.Close()
+}
@@ -0,0 +1,99 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"one-api/common/logger"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ctx, cancel = context.WithCancel(context.Background())
+	tasks       sync.WaitGroup
+)
+
+// 关闭时等待超时后取消，正在进行的上游操作（如预测轮询、流式输出）应停止并在上游取消
+func Context() context.Context {
+	return ctx
+}
+
+// 在后台执行任务，关闭时等待任务完成，用于结算用量等不能丢失的异步操作
+func Go(task func()) {
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		task()
+	}()
+}
+
+// 启动 HTTP 服务，收到 SIGINT 或 SIGTERM 后优雅关闭
+func ListenAndServe(server *http.Server, timeout, cancelTimeout time.Duration) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errChan:
+		return err
+	case sig := <-signals:
+		logger.SysLog("received signal " + sig.String() + ", shutting down")
+	}
+
+	Shutdown(server, timeout, cancelTimeout)
+	return nil
+}
+
+// 停止接收新请求，等待进行中的请求在 timeout 内完成；
+// 超时后取消 Context，进行中的预测在上游取消并结算已产生的用量，再最多等待 cancelTimeout；
+// 最后等待 Go 启动的后台任务完成
+func Shutdown(server *http.Server, timeout, cancelTimeout time.Duration) {
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), timeout)
+	err := server.Shutdown(drainCtx)
+	drainCancel()
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.SysLog("in-flight requests not finished, canceling upstream operations")
+		cancel()
+
+		cancelCtx, cancelCancel := context.WithTimeout(context.Background(), cancelTimeout)
+		err = server.Shutdown(cancelCtx)
+		cancelCancel()
+	}
+	if err != nil {
+		logger.SysError("server shutdown: " + err.Error())
+		server.Close()
+	}
+
+	if !waitTasks(cancelTimeout) {
+		logger.SysError("background tasks not finished before shutdown")
+	}
+	logger.SysLog("server stopped")
+}
+
+func waitTasks(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package shutdown
+
+import (
+	"net"
+	"net/http"
+	"one-api/common/logger"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestShutdown(t *testing.T) {
+	logger.Logger = zap.NewNop()
+
+	started := make(chan struct{})
+	var canceled, settled atomic.Bool
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		// 模拟一直轮询的预测，只有关闭超时后才会取消
+		<-Context().Done()
+		canceled.Store(true)
+
+		Go(func() {
+			time.Sleep(10 * time.Millisecond)
+			settled.Store(true)
+		})
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Serve(listener)
+
+	responseChan := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		responseChan <- resp
+	}()
+	<-started
+
+	Shutdown(server, 20*time.Millisecond, time.Second)
+	assert.True(t, canceled.Load())
+	assert.True(t, settled.Load())
+
+	resp := <-responseChan
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// 关闭后不再接收新请求
+	_, err = http.Get("http://" + listener.Addr().String())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,151 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxEventSize = 10 * 1024 * 1024
+
+// SSE 事件
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry int
+}
+
+// 编码为 text/event-stream 格式，多行 data 拆分为多个 data 字段
+func (e *Event) String() string {
+	var builder strings.Builder
+	if e.ID != "" {
+		builder.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		builder.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		builder.WriteString("retry: " + strconv.Itoa(e.Retry) + "\n")
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+
+	return builder.String()
+}
+
+// SSEScanner 按 text/event-stream 规范读取完整事件
+// 支持 LF、CRLF、CR 换行，多行 data，注释，event、id、retry 字段
+type SSEScanner struct {
+	scanner *bufio.Scanner
+	event   *Event
+	err     error
+}
+
+func NewSSEScanner(reader io.Reader) *SSEScanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	scanner.Split(scanLines)
+
+	return &SSEScanner{scanner: scanner}
+}
+
+// 读取下一个事件，没有更多事件或出错时返回 false
+func (s *SSEScanner) Scan() bool {
+	event := &Event{}
+	var data []string
+	hasField := false
+
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+
+		// 空行表示一个事件结束
+		if line == "" {
+			if !hasField {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			s.event = event
+			return true
+		}
+
+		// 注释
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event.Event = value
+		case "id":
+			event.ID = value
+		case "retry":
+			if retry, err := strconv.Atoi(value); err == nil {
+				event.Retry = retry
+			}
+		default:
+			continue
+		}
+		hasField = true
+	}
+
+	s.err = s.scanner.Err()
+
+	// 上游可能没有以空行结束最后一个事件
+	if hasField && s.err == nil {
+		event.Data = strings.Join(data, "\n")
+		s.event = event
+		return true
+	}
+
+	return false
+}
+
+// 当前事件
+func (s *SSEScanner) Event() *Event {
+	return s.event
+}
+
+// 读取出错时返回错误，正常结束返回 nil
+func (s *SSEScanner) Err() error {
+	return s.err
+}
+
+// 按 LF、CRLF、CR 分割行
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+
+		// CR 后面可能是 LF，需要更多数据才能判断
+		if i+1 == len(data) && !atEOF {
+			return 0, nil, nil
+		}
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
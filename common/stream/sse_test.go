@@ -0,0 +1,87 @@
+package stream_test
+
+import (
+	"errors"
+	"one-api/common/stream"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scanAll(t *testing.T, scanner *stream.SSEScanner) []stream.Event {
+	var events []stream.Event
+	for scanner.Scan() {
+		events = append(events, *scanner.Event())
+	}
+	assert.Nil(t, scanner.Err())
+
+	return events
+}
+
+func TestSSEScannerFields(t *testing.T) {
+	body := ": ping\n\nid: 1\nevent: output\nretry: 3000\ndata: hello\n\nevent: done\ndata: {}\n\n"
+	events := scanAll(t, stream.NewSSEScanner(strings.NewReader(body)))
+
+	assert.Equal(t, []stream.Event{
+		{ID: "1", Event: "output", Data: "hello", Retry: 3000},
+		{Event: "done", Data: "{}"},
+	}, events)
+}
+
+func TestSSEScannerMultiLineData(t *testing.T) {
+	body := "event: output\ndata: first\ndata:second\ndata: \ndata:  indented\n\n"
+	events := scanAll(t, stream.NewSSEScanner(strings.NewReader(body)))
+
+	assert.Len(t, events, 1)
+	// 只去掉冒号后的一个空格
+	assert.Equal(t, "first\nsecond\n\n indented", events[0].Data)
+}
+
+func TestSSEScannerLineEndings(t *testing.T) {
+	for name, body := range map[string]string{
+		"crlf": "event: output\r\ndata: a\r\n\r\nevent: output\r\ndata: b\r\n\r\n",
+		"cr":   "event: output\rdata: a\r\revent: output\rdata: b\r\r",
+		"mix":  "event: output\r\ndata: a\n\revent: output\rdata: b\r\n\n",
+	} {
+		events := scanAll(t, stream.NewSSEScanner(strings.NewReader(body)))
+		assert.Len(t, events, 2, name)
+		for index, data := range []string{"a", "b"} {
+			assert.Equal(t, "output", events[index].Event, name)
+			assert.Equal(t, data, events[index].Data, name)
+		}
+	}
+}
+
+func TestSSEScannerChunkedBoundaries(t *testing.T) {
+	body := "event: output\r\ndata: hello\r\ndata: world\r\n\r\nevent: done\r\ndata: {}\r\n\r\n"
+	events := scanAll(t, stream.NewSSEScanner(iotest.OneByteReader(strings.NewReader(body))))
+
+	assert.Equal(t, []stream.Event{
+		{Event: "output", Data: "hello\nworld"},
+		{Event: "done", Data: "{}"},
+	}, events)
+}
+
+func TestSSEScannerTrailingEvent(t *testing.T) {
+	events := scanAll(t, stream.NewSSEScanner(strings.NewReader("\n\ndata: last")))
+
+	assert.Equal(t, []stream.Event{{Data: "last"}}, events)
+}
+
+func TestSSEScannerReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	scanner := stream.NewSSEScanner(iotest.ErrReader(readErr))
+
+	assert.False(t, scanner.Scan())
+	assert.Equal(t, readErr, scanner.Err())
+}
+
+func TestEventString(t *testing.T) {
+	event := stream.Event{ID: "1", Event: "output", Data: "hello\nworld"}
+	assert.Equal(t, "id: 1\nevent: output\ndata: hello\ndata: world\n\n", event.String())
+
+	events := scanAll(t, stream.NewSSEScanner(strings.NewReader(event.String())))
+	assert.Equal(t, []stream.Event{event}, events)
+}
@@ -88,7 +88,7 @@ func CountTokenMessages(messages []types.ChatCompletionMessage, model string, pr
 		return 0
 	}
 
-	tokenEncoder := GetTokenEncoder(model)
+	tokenizer := GetTokenizer(model)
 	// Reference:
 	// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
 	// https://github.com/pkoukk/tiktoken-go/issues/6
@@ -108,13 +108,13 @@ func CountTokenMessages(messages []types.ChatCompletionMessage, model string, pr
 		tokenNum += tokensPerMessage
 		switch v := message.Content.(type) {
 		case string:
-			tokenNum += GetTokenNum(tokenEncoder, v)
+			tokenNum += tokenizer.CountTokens(v)
 		case []any:
 			for _, it := range v {
 				m := it.(map[string]any)
 				switch m["type"] {
 				case "text":
-					tokenNum += GetTokenNum(tokenEncoder, m["text"].(string))
+					tokenNum += tokenizer.CountTokens(m["text"].(string))
 				case "image_url":
 					if preCostType == config.PreCostNotImage {
 						continue
@@ -139,10 +139,10 @@ func CountTokenMessages(messages []types.ChatCompletionMessage, model string, pr
 				}
 			}
 		}
-		tokenNum += GetTokenNum(tokenEncoder, message.Role)
+		tokenNum += tokenizer.CountTokens(message.Role)
 		if message.Name != nil {
 			tokenNum += tokensPerName
-			tokenNum += GetTokenNum(tokenEncoder, *message.Name)
+			tokenNum += tokenizer.CountTokens(*message.Name)
 		}
 	}
 	tokenNum += 3 // Every reply is primed with <|start|>assistant<|message|>
@@ -154,13 +154,13 @@ func CountTokenRerankMessages(messages types.RerankRequest, model string, preCos
 		return 0
 	}
 
-	tokenEncoder := GetTokenEncoder(model)
+	tokenizer := GetTokenizer(model)
 	tokenNum := 0
 
-	tokenNum += GetTokenNum(tokenEncoder, messages.Query)
+	tokenNum += tokenizer.CountTokens(messages.Query)
 
 	for _, document := range messages.Documents {
-		tokenNum += GetTokenNum(tokenEncoder, document)
+		tokenNum += tokenizer.CountTokens(document)
 	}
 
 	return tokenNum
@@ -301,8 +301,7 @@ func CountTokenInput(input any, model string) int {
 }
 
 func CountTokenText(text string, model string) int {
-	tokenEncoder := GetTokenEncoder(model)
-	return GetTokenNum(tokenEncoder, text)
+	return GetTokenizer(model).CountTokens(text)
 }
 
 func CountTokenImage(input interface{}) (int, error) {
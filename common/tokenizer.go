@@ -0,0 +1,129 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"one-api/common/logger"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// 估算用量时使用的分词器，不同模型系列的词表不同，同一段文本的 token 数也不同
+type Tokenizer interface {
+	Name() string
+	CountTokens(text string) int
+}
+
+// 使用 tiktoken 编码计数，编码器被禁用时按字符数估算
+type tiktokenTokenizer struct {
+	name    string
+	encoder func() *tiktoken.Tiktoken
+}
+
+func (t *tiktokenTokenizer) Name() string {
+	return t.name
+}
+
+func (t *tiktokenTokenizer) CountTokens(text string) int {
+	return GetTokenNum(t.encoder(), text)
+}
+
+// 没有对应词表时，按与已有分词器的平均比例换算
+type scaledTokenizer struct {
+	name  string
+	base  Tokenizer
+	ratio float64
+}
+
+func (t *scaledTokenizer) Name() string {
+	return t.name
+}
+
+func (t *scaledTokenizer) CountTokens(text string) int {
+	return int(math.Ceil(float64(t.base.CountTokens(text)) * t.ratio))
+}
+
+var cl100kTokenizer = &tiktokenTokenizer{
+	name:    "cl100k_base",
+	encoder: func() *tiktoken.Tiktoken { return gpt4TokenEncoder },
+}
+
+type tokenizerFamily struct {
+	family    string
+	keywords  []string
+	tokenizer Tokenizer
+}
+
+var (
+	// 模型名（不区分大小写）包含 keywords 之一时使用该系列的分词器，按顺序匹配
+	// Llama 3 的词表基于 cl100k_base 扩展，Llama 2、Mistral 使用 32k 的 SentencePiece 词表，
+	// 对同一段文本平均比 cl100k_base 多约 25% 的 token
+	tokenizerFamilies = []*tokenizerFamily{
+		{
+			family:    "llama-3",
+			keywords:  []string{"llama-3", "llama3"},
+			tokenizer: &tiktokenTokenizer{name: "llama-3 (cl100k_base)", encoder: cl100kTokenizer.encoder},
+		},
+		{
+			family:    "llama-2",
+			keywords:  []string{"llama-2", "llama2", "codellama", "mistral", "mixtral"},
+			tokenizer: &scaledTokenizer{name: "sentencepiece-32k (cl100k_base x1.25)", base: cl100kTokenizer, ratio: 1.25},
+		},
+	}
+	modelTokenizers = map[string]Tokenizer{}
+	tokenizerMu     sync.RWMutex
+)
+
+// 注册模型系列的分词器，family 已存在时替换
+func RegisterTokenizer(family string, keywords []string, tokenizer Tokenizer) {
+	tokenizerMu.Lock()
+	defer tokenizerMu.Unlock()
+
+	// 已解析的模型重新匹配
+	modelTokenizers = map[string]Tokenizer{}
+	for i, item := range tokenizerFamilies {
+		if item.family == family {
+			tokenizerFamilies[i] = &tokenizerFamily{family: family, keywords: keywords, tokenizer: tokenizer}
+			return
+		}
+	}
+
+	tokenizerFamilies = append(tokenizerFamilies, &tokenizerFamily{family: family, keywords: keywords, tokenizer: tokenizer})
+}
+
+// 获取模型使用的分词器，未匹配任何系列时使用该模型的 tiktoken 编码
+func GetTokenizer(model string) Tokenizer {
+	tokenizerMu.RLock()
+	tokenizer, ok := modelTokenizers[model]
+	tokenizerMu.RUnlock()
+	if ok {
+		return tokenizer
+	}
+
+	tokenizerMu.Lock()
+	defer tokenizerMu.Unlock()
+
+	tokenizer = matchTokenizer(model)
+	modelTokenizers[model] = tokenizer
+	logger.SysDebug(fmt.Sprintf("model %s uses tokenizer %s", model, tokenizer.Name()))
+
+	return tokenizer
+}
+
+func matchTokenizer(model string) Tokenizer {
+	name := strings.ToLower(model)
+	for _, item := range tokenizerFamilies {
+		for _, keyword := range item.keywords {
+			if strings.Contains(name, keyword) {
+				return item.tokenizer
+			}
+		}
+	}
+
+	return &tiktokenTokenizer{
+		name:    "tiktoken",
+		encoder: func() *tiktoken.Tiktoken { return GetTokenEncoder(model) },
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"one-api/common/config"
+	"one-api/common/logger"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testTokenizer struct{}
+
+func (testTokenizer) Name() string {
+	return "test"
+}
+
+func (testTokenizer) CountTokens(text string) int {
+	return len([]rune(text))
+}
+
+func TestGetTokenizer(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	config.DisableTokenEncoders = true
+	defer func() { config.DisableTokenEncoders = false }()
+
+	text := "The quick brown fox jumps over the lazy dog. 敏捷的棕色狐狸跳过了懒狗。"
+
+	llama3 := GetTokenizer("meta/meta-llama-3-70b-instruct")
+	llama2 := GetTokenizer("meta/llama-2-70b-chat")
+	assert.Equal(t, "llama-3 (cl100k_base)", llama3.Name())
+	assert.Equal(t, "sentencepiece-32k (cl100k_base x1.25)", llama2.Name())
+	assert.Equal(t, llama2, GetTokenizer("mistralai/mixtral-8x7b-instruct-v0.1"))
+	assert.Equal(t, llama3, GetTokenizer("llama3-70b-8192"))
+	assert.Equal(t, "tiktoken", GetTokenizer("gpt-4o").Name())
+
+	// 同一段文本，SentencePiece 词表的 token 数多于 cl100k_base
+	assert.Greater(t, llama2.CountTokens(text), llama3.CountTokens(text))
+	assert.Equal(t, llama2.CountTokens(text), CountTokenText(text, "meta/llama-2-70b-chat"))
+	assert.Equal(t, llama3.CountTokens(text), CountTokenText(text, "meta/meta-llama-3-70b-instruct"))
+}
+
+func TestRegisterTokenizer(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	config.DisableTokenEncoders = true
+	defer func() { config.DisableTokenEncoders = false }()
+
+	assert.Equal(t, "tiktoken", GetTokenizer("acme/phi-3").Name())
+
+	RegisterTokenizer("phi", []string{"phi-"}, testTokenizer{})
+	assert.Equal(t, "test", GetTokenizer("acme/phi-3").Name())
+	assert.Equal(t, 5, CountTokenText("hello", "acme/phi-3"))
+	// 已有系列不受影响
+	assert.Equal(t, "llama-3 (cl100k_base)", GetTokenizer("meta/meta-llama-3-8b").Name())
+}
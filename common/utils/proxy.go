@@ -61,6 +61,30 @@ func Socks5ProxyFunc(ctx context.Context, network, addr string) (net.Conn, error
 	return proxyDialer.Dial(network, addr)
 }
 
+// 校验代理地址，支持 http、https、socks5、socks5h，为空时不使用代理
+func ValidateProxyAddr(proxyAddr string) error {
+	if proxyAddr == "" {
+		return nil
+	}
+
+	proxyURL, err := url.Parse(proxyAddr)
+	if err != nil {
+		return fmt.Errorf("error parsing proxy address: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+
+	if proxyURL.Host == "" {
+		return fmt.Errorf("proxy address is missing host: %s", proxyAddr)
+	}
+
+	return nil
+}
+
 func SetProxy(proxyAddr string, ctx context.Context) context.Context {
 	if ctx == nil {
 		ctx = context.Background()
@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/config"
+	"one-api/common/utils"
+	"one-api/model"
+	"one-api/providers"
+	providers_base "one-api/providers/base"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	channelHealthy     = "healthy"
+	channelSlow        = "slow"
+	channelUnhealthy   = "unhealthy"
+	channelUnsupported = "unsupported"
+)
+
+type ChannelHealth struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Type      int    `json:"type"`
+	Status    string `json:"status"`
+	Latency   int64  `json:"latency"`
+	LastError string `json:"last_error,omitempty"`
+	CheckedAt int64  `json:"checked_at"`
+}
+
+var (
+	// 只保护缓存的读写，探测上游时不持有
+	channelHealthLock      sync.Mutex
+	channelHealthCache     []*ChannelHealth
+	channelHealthExpiredAt time.Time
+	// 缓存过期时同时到达的请求共用一次探测
+	channelHealthGroup singleflight.Group
+
+	// 测试时可替换
+	connectionTester = testChannelConnection
+)
+
+// 检查所有启用渠道的上游连接状态，结果会缓存一段时间
+func GetChannelsHealth(c *gin.Context) {
+	results, err := getChannelsHealth()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	healthy := true
+	for _, health := range results {
+		if health.Status == channelUnhealthy {
+			healthy = false
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": healthy,
+		"message": "",
+		"data":    results,
+	})
+}
+
+func getChannelsHealth() ([]*ChannelHealth, error) {
+	channelHealthLock.Lock()
+	if channelHealthCache != nil && time.Now().Before(channelHealthExpiredAt) {
+		results := channelHealthCache
+		channelHealthLock.Unlock()
+		return results, nil
+	}
+	channelHealthLock.Unlock()
+
+	results, err, _ := channelHealthGroup.Do("channels", func() (any, error) {
+		channels, err := model.GetAllChannels()
+		if err != nil {
+			return nil, err
+		}
+
+		results := checkChannelsHealth(channels)
+
+		channelHealthLock.Lock()
+		channelHealthCache = results
+		channelHealthExpiredAt = time.Now().Add(time.Duration(utils.GetOrDefault("health.cache_ttl", 30)) * time.Second)
+		channelHealthLock.Unlock()
+
+		return results, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results.([]*ChannelHealth), nil
+}
+
+func checkChannelsHealth(channels []*model.Channel) []*ChannelHealth {
+	timeout := time.Duration(utils.GetOrDefault("health.timeout", 10)) * time.Second
+	sla := int64(config.ChannelDisableThreshold * 1000)
+
+	var enabledChannels []*model.Channel
+	for _, channel := range channels {
+		if channel.Status == config.ChannelStatusEnabled {
+			enabledChannels = append(enabledChannels, channel)
+		}
+	}
+
+	results := make([]*ChannelHealth, len(enabledChannels))
+	var wg sync.WaitGroup
+	for index, channel := range enabledChannels {
+		wg.Add(1)
+		go func(index int, channel *model.Channel) {
+			defer wg.Done()
+			results[index] = checkChannelHealth(channel, timeout, sla)
+		}(index, channel)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func checkChannelHealth(channel *model.Channel, timeout time.Duration, sla int64) *ChannelHealth {
+	health := &ChannelHealth{
+		Id:        channel.Id,
+		Name:      channel.Name,
+		Type:      channel.Type,
+		CheckedAt: utils.GetTimestamp(),
+	}
+
+	type testResult struct {
+		result *providers_base.ConnectionTestResult
+		err    error
+	}
+
+	// 超时后取消探测请求，不继续占用连接
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan testResult, 1)
+	go func() {
+		result, err := connectionTester(ctx, channel)
+		done <- testResult{result, err}
+	}()
+
+	select {
+	case res := <-done:
+		if ctx.Err() != nil {
+			return timeoutHealth(health, timeout)
+		}
+		if res.result == nil {
+			health.Status = channelUnsupported
+			if res.err != nil {
+				health.LastError = res.err.Error()
+			}
+			return health
+		}
+
+		health.Latency = res.result.Latency
+		switch {
+		case res.err != nil:
+			health.Status = channelUnhealthy
+			health.LastError = res.err.Error()
+		case sla > 0 && health.Latency > sla:
+			health.Status = channelSlow
+		default:
+			health.Status = channelHealthy
+		}
+	case <-ctx.Done():
+		return timeoutHealth(health, timeout)
+	}
+
+	return health
+}
+
+func timeoutHealth(health *ChannelHealth, timeout time.Duration) *ChannelHealth {
+	health.Status = channelUnhealthy
+	health.Latency = timeout.Milliseconds()
+	health.LastError = "connection test timed out"
+
+	return health
+}
+
+// 使用渠道的连接测试检查上游，不支持连接测试的渠道返回 nil 结果
+func testChannelConnection(ctx context.Context, channel *model.Channel) (*providers_base.ConnectionTestResult, error) {
+	if channel.TestModel == "" {
+		return nil, errors.New("请填写测速模型后再试")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/health/channels", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.Request = req
+
+	provider := providers.GetProvider(channel, c)
+	if provider == nil {
+		return nil, errors.New("channel not implemented")
+	}
+	if httpRequester := provider.GetRequester(); httpRequester != nil {
+		httpRequester.Context = ctx
+	}
+
+	connectionProvider, ok := provider.(providers_base.ConnectionTestInterface)
+	if !ok {
+		return nil, errors.New("channel does not support connection test")
+	}
+
+	modelName, err := provider.ModelMappingHandler(channel.TestModel)
+	if err != nil {
+		return nil, err
+	}
+
+	result, errWithCode := connectionProvider.TestConnection(strings.TrimPrefix(modelName, "+"))
+	if errWithCode != nil {
+		return result, errors.New(errWithCode.Message)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"one-api/common/config"
+	"one-api/model"
+	providers_base "one-api/providers/base"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckChannelsHealth(t *testing.T) {
+	viper.Set("health.timeout", 1)
+	defer viper.Set("health.timeout", nil)
+
+	canceled := make(chan struct{})
+	connectionTester = func(ctx context.Context, channel *model.Channel) (*providers_base.ConnectionTestResult, error) {
+		switch channel.Name {
+		case "healthy":
+			return &providers_base.ConnectionTestResult{AuthOK: true, ModelExists: true, Latency: 120}, nil
+		case "bad-key":
+			return &providers_base.ConnectionTestResult{Latency: 80}, errors.New("Unauthenticated")
+		case "slow":
+			return &providers_base.ConnectionTestResult{AuthOK: true, ModelExists: true, Latency: int64(config.ChannelDisableThreshold*1000) + 1}, nil
+		case "hang":
+			<-ctx.Done()
+			close(canceled)
+			return &providers_base.ConnectionTestResult{}, ctx.Err()
+		default:
+			return nil, errors.New("channel does not support connection test")
+		}
+	}
+	defer func() { connectionTester = testChannelConnection }()
+
+	channels := []*model.Channel{
+		{Id: 1, Name: "healthy", Status: config.ChannelStatusEnabled},
+		{Id: 2, Name: "bad-key", Status: config.ChannelStatusEnabled},
+		{Id: 3, Name: "slow", Status: config.ChannelStatusEnabled},
+		{Id: 4, Name: "hang", Status: config.ChannelStatusEnabled},
+		{Id: 5, Name: "other", Status: config.ChannelStatusEnabled},
+		{Id: 6, Name: "disabled", Status: config.ChannelStatusManuallyDisabled},
+	}
+
+	results := checkChannelsHealth(channels)
+	assert.Len(t, results, 5)
+
+	statuses := make(map[string]*ChannelHealth)
+	for _, health := range results {
+		statuses[health.Name] = health
+	}
+
+	assert.Equal(t, channelHealthy, statuses["healthy"].Status)
+	assert.Equal(t, int64(120), statuses["healthy"].Latency)
+	assert.Empty(t, statuses["healthy"].LastError)
+
+	assert.Equal(t, channelUnhealthy, statuses["bad-key"].Status)
+	assert.Equal(t, "Unauthenticated", statuses["bad-key"].LastError)
+
+	assert.Equal(t, channelSlow, statuses["slow"].Status)
+
+	assert.Equal(t, channelUnhealthy, statuses["hang"].Status)
+	assert.Contains(t, statuses["hang"].LastError, "timed out")
+	// 超时后取消探测
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("hanging probe was not canceled")
+	}
+
+	assert.Equal(t, channelUnsupported, statuses["other"].Status)
+}
+
+func TestGetChannelsHealthCache(t *testing.T) {
+	channelHealthLock.Lock()
+	channelHealthCache = []*ChannelHealth{{Id: 1, Status: channelHealthy}}
+	channelHealthExpiredAt = time.Now().Add(time.Minute)
+	channelHealthLock.Unlock()
+	defer func() {
+		channelHealthLock.Lock()
+		channelHealthCache = nil
+		channelHealthExpiredAt = time.Time{}
+		channelHealthLock.Unlock()
+	}()
+
+	// 缓存未过期时直接返回，不探测上游
+	connectionTester = func(ctx context.Context, channel *model.Channel) (*providers_base.ConnectionTestResult, error) {
+		t.Error("unexpected connection test")
+		return nil, nil
+	}
+	defer func() { connectionTester = testChannelConnection }()
+
+	results, err := getChannelsHealth()
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+}
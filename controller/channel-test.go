@@ -77,6 +77,17 @@ func testChannel(channel *model.Channel, testModel string) (openaiErr *types.Ope
 	usage := &types.Usage{}
 	provider.SetUsage(usage)
 
+	// 支持连接测试的渠道只校验密钥和模型，不产生实际费用
+	if connectionProvider, ok := provider.(providers_base.ConnectionTestInterface); ok {
+		result, openAIErrorWithStatusCode := connectionProvider.TestConnection(newModelName)
+		logger.SysLog(fmt.Sprintf("测试渠道 %s : %s 连接结果：auth_ok=%t model_exists=%t latency=%dms", channel.Name, newModelName, result.AuthOK, result.ModelExists, result.Latency))
+		if openAIErrorWithStatusCode != nil {
+			return openAIErrorWithStatusCode, errors.New(openAIErrorWithStatusCode.Message)
+		}
+
+		return nil, nil
+	}
+
 	// 执行测试请求
 	var response any
 	var openAIErrorWithStatusCode *types.OpenAIErrorWithStatusCode
@@ -65,6 +65,15 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Proxy != nil {
+		if err := utils.ValidateProxyAddr(*channel.Proxy); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	channel.CreatedTime = utils.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
 
@@ -164,6 +173,15 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Proxy != nil {
+		if err := utils.ValidateProxyAddr(*channel.Proxy); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	if channel.Models == "" {
 		err = channel.Update(false)
 	} else {
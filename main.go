@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"fmt"
+	"net/http"
 	"one-api/cli"
 	"one-api/common"
 	"one-api/common/cache"
@@ -12,12 +13,15 @@ import (
 	"one-api/common/oidc"
 	"one-api/common/redis"
 	"one-api/common/requester"
+	"one-api/common/shutdown"
 	"one-api/common/storage"
 	"one-api/common/telegram"
+	"one-api/common/utils"
 	"one-api/controller"
 	"one-api/cron"
 	"one-api/middleware"
 	"one-api/model"
+	"one-api/relay/relay_util"
 	"one-api/relay/task"
 	"one-api/router"
 	"time"
@@ -68,6 +72,9 @@ func main() {
 
 	common.InitTokenEncoders()
 	requester.InitHttpClient()
+	relay_util.InitUsageReporter()
+	relay_util.InitChannelRoutes()
+	relay_util.InitModelCapabilities()
 	// Initialize Telegram bot
 	telegram.InitTelegramBot()
 
@@ -124,7 +131,14 @@ func initHttpServer() {
 	router.SetRouter(server, buildFS, indexPage)
 	port := viper.GetString("port")
 
-	err := server.Run(":" + port)
+	httpServer := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	timeout := time.Duration(utils.GetOrDefault("shutdown.timeout", 30)) * time.Second
+	cancelTimeout := time.Duration(utils.GetOrDefault("shutdown.cancel_timeout", 10)) * time.Second
+
+	err := shutdown.ListenAndServe(httpServer, timeout, cancelTimeout)
 	if err != nil {
 		logger.FatalLog("failed to start HTTP server: " + err.Error())
 	}
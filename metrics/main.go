@@ -14,6 +14,15 @@ var (
 	httpRequestDuration *prometheus.HistogramVec
 	providerCounter     *prometheus.CounterVec
 	panicCounter        *prometheus.CounterVec
+
+	upstreamRequestsTotal   *prometheus.CounterVec
+	upstreamRequestDuration *prometheus.HistogramVec
+	upstreamPollCount       *prometheus.HistogramVec
+	upstreamStreamDuration  *prometheus.HistogramVec
+	upstreamFirstToken      *prometheus.HistogramVec
+
+	channelQueueDepth *prometheus.GaugeVec
+	channelQueueShed  *prometheus.CounterVec
 )
 
 func init() {
@@ -43,7 +52,64 @@ func init() {
 		[]string{"channel_type", "channel_id", "model", "type"},
 	)
 
-	// 3. 监控 panic
+	// 3. 监控上游请求
+	upstreamRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "provider_upstream_requests_total",
+			Help: "Total number of upstream requests sent by providers.",
+		},
+		[]string{"provider", "model", "code"},
+	)
+	upstreamRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "provider_upstream_request_duration_seconds",
+			Help:    "Duration of upstream requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"provider", "model", "code"},
+	)
+	upstreamPollCount = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "provider_upstream_polls",
+			Help:    "Number of poll iterations before an upstream prediction finished",
+			Buckets: []float64{0, 1, 2, 3, 5, 8, 10, 15, 30, 60},
+		},
+		[]string{"provider", "model"},
+	)
+	upstreamStreamDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "provider_upstream_stream_duration_seconds",
+			Help:    "Duration of upstream streams in seconds",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"provider", "model"},
+	)
+	upstreamFirstToken = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "provider_upstream_first_token_seconds",
+			Help:    "Time from upstream prediction creation to the first streamed token in seconds",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+		},
+		[]string{"provider", "model"},
+	)
+
+	// 4. 监控渠道请求队列
+	channelQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "channel_queue_depth",
+			Help: "Number of requests waiting in the channel request queue.",
+		},
+		[]string{"channel_id"},
+	)
+	channelQueueShed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "channel_queue_shed_total",
+			Help: "Total number of requests rejected because the channel request queue was full.",
+		},
+		[]string{"channel_id"},
+	)
+
+	// 5. 监控 panic
 	panicCounter = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "app_panics_total",
@@ -94,6 +160,50 @@ func RecordProvider(c *gin.Context, statusCode int) {
 	})
 }
 
+// 记录上游请求的状态码和耗时
+func RecordUpstreamRequest(provider, model string, statusCode int, duration time.Duration) {
+	SafelyRecordMetric(func() {
+		code := strconv.Itoa(statusCode)
+		upstreamRequestsTotal.WithLabelValues(provider, model, code).Inc()
+		upstreamRequestDuration.WithLabelValues(provider, model, code).Observe(duration.Seconds())
+	})
+}
+
+// 记录等待上游结果的轮询次数
+func RecordUpstreamPolls(provider, model string, polls int) {
+	SafelyRecordMetric(func() {
+		upstreamPollCount.WithLabelValues(provider, model).Observe(float64(polls))
+	})
+}
+
+// 记录上游流式响应的持续时间
+func RecordUpstreamStream(provider, model string, duration time.Duration) {
+	SafelyRecordMetric(func() {
+		upstreamStreamDuration.WithLabelValues(provider, model).Observe(duration.Seconds())
+	})
+}
+
+// 记录上游首字时间
+func RecordUpstreamFirstToken(provider, model string, duration time.Duration) {
+	SafelyRecordMetric(func() {
+		upstreamFirstToken.WithLabelValues(provider, model).Observe(duration.Seconds())
+	})
+}
+
+// 记录渠道排队等待的请求数
+func RecordChannelQueueDepth(channelId int, depth int) {
+	SafelyRecordMetric(func() {
+		channelQueueDepth.WithLabelValues(strconv.Itoa(channelId)).Set(float64(depth))
+	})
+}
+
+// 记录排队已满被拒绝的请求
+func RecordChannelQueueShed(channelId int) {
+	SafelyRecordMetric(func() {
+		channelQueueShed.WithLabelValues(strconv.Itoa(channelId)).Inc()
+	})
+}
+
 // 记录 panic
 func RecordPanic(panicType string) {
 	panicCounter.WithLabelValues(panicType).Inc()
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"one-api/common/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限制请求体大小，避免超大请求占用内存，单位为 MB，0 表示不限制
+func RequestBodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := int64(utils.GetOrDefault("request_body_limit", 32)) << 20
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			abortWithMessage(c, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
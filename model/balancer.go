@@ -3,7 +3,6 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"one-api/common/config"
 	"one-api/common/logger"
 	"one-api/common/utils"
@@ -25,6 +24,8 @@ type ChannelsChooser struct {
 	Rule      map[string]map[string][][]int // group -> model -> priority -> channelIds
 	Match     []string
 	Cooldowns sync.Map
+	Health    sync.Map // channelId -> *ChannelHealth
+	smooth    sync.Map // modelName:channelIds -> *smoothWeights
 
 	ModelGroup map[string]map[string]bool
 }
@@ -37,6 +38,13 @@ func FilterChannelId(skipChannelIds []int) ChannelsFilterFunc {
 	}
 }
 
+// 只保留 channelIds 中的渠道
+func FilterAllowedChannelIds(channelIds []int) ChannelsFilterFunc {
+	return func(channelId int, _ *ChannelChoice) bool {
+		return !utils.Contains(channelId, channelIds)
+	}
+}
+
 func FilterChannelTypes(channelTypes []int) ChannelsFilterFunc {
 	return func(_ int, choice *ChannelChoice) bool {
 		return !utils.Contains(choice.Channel.Type, channelTypes)
@@ -126,8 +134,6 @@ func (cc *ChannelsChooser) ChangeStatus(channelId int, status bool) {
 }
 
 func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFunc, modelName string) *Channel {
-	totalWeight := 0
-
 	validChannels := make([]*ChannelChoice, 0, len(channelIds))
 	for _, channelId := range channelIds {
 		choice, ok := cc.Channels[channelId]
@@ -135,7 +141,7 @@ func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFu
 			continue
 		}
 
-		if cc.IsInCooldown(channelId, modelName) {
+		if cc.IsInCooldown(channelId, modelName) || cc.IsTripped(channelId) {
 			continue
 		}
 
@@ -150,8 +156,6 @@ func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFu
 			continue
 		}
 
-		weight := int(*choice.Channel.Weight)
-		totalWeight += weight
 		validChannels = append(validChannels, choice)
 	}
 
@@ -163,16 +167,7 @@ func (cc *ChannelsChooser) balancer(channelIds []int, filters []ChannelsFilterFu
 		return validChannels[0].Channel
 	}
 
-	choiceWeight := rand.Intn(totalWeight)
-	for _, choice := range validChannels {
-		weight := int(*choice.Channel.Weight)
-		choiceWeight -= weight
-		if choiceWeight < 0 {
-			return choice.Channel
-		}
-	}
-
-	return nil
+	return cc.getSmoothWeights(fmt.Sprintf("%s:%v", modelName, channelIds)).next(validChannels)
 }
 
 func (cc *ChannelsChooser) Next(group, modelName string, filters ...ChannelsFilterFunc) (*Channel, error) {
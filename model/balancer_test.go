@@ -0,0 +1,82 @@
+package model
+
+import (
+	"one-api/common/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChooser(weights map[int]uint) *ChannelsChooser {
+	cc := &ChannelsChooser{
+		Channels: make(map[int]*ChannelChoice),
+		Rule:     map[string]map[string][][]int{"default": {"flux-dev": {{}}}},
+	}
+
+	for id := 1; id <= len(weights); id++ {
+		weight := weights[id]
+		cc.Channels[id] = &ChannelChoice{Channel: &Channel{Id: id, Weight: &weight}}
+		cc.Rule["default"]["flux-dev"][0] = append(cc.Rule["default"]["flux-dev"][0], id)
+	}
+
+	return cc
+}
+
+func TestBalancerWeightDistribution(t *testing.T) {
+	cc := newTestChooser(map[int]uint{1: 5, 2: 1, 3: 1})
+
+	counts := make(map[int]int)
+	var sequence []int
+	for i := 0; i < 70; i++ {
+		channel, err := cc.Next("default", "flux-dev")
+		assert.Nil(t, err)
+		counts[channel.Id]++
+		if i < 7 {
+			sequence = append(sequence, channel.Id)
+		}
+	}
+
+	assert.Equal(t, map[int]int{1: 50, 2: 10, 3: 10}, counts)
+	// 平滑加权轮询不会连续选中同一个渠道太多次
+	assert.Equal(t, []int{1, 1, 2, 1, 3, 1, 1}, sequence)
+}
+
+func TestBalancerSkipTripped(t *testing.T) {
+	threshold := config.ChannelBreakerThreshold
+	config.ChannelBreakerThreshold = 2
+	defer func() { config.ChannelBreakerThreshold = threshold }()
+
+	cc := newTestChooser(map[int]uint{1: 1, 2: 1})
+
+	cc.RecordResult(1, false)
+	assert.False(t, cc.IsTripped(1))
+	cc.RecordResult(1, false)
+	assert.True(t, cc.IsTripped(1))
+
+	for i := 0; i < 5; i++ {
+		channel, err := cc.Next("default", "flux-dev")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, channel.Id)
+	}
+
+	rate, total := cc.GetSuccessRate(1)
+	assert.Equal(t, float64(0), rate)
+	assert.Equal(t, int64(2), total)
+
+	// 全部熔断时没有可用渠道
+	cc.RecordResult(2, false)
+	cc.RecordResult(2, false)
+	_, err := cc.Next("default", "flux-dev")
+	assert.NotNil(t, err)
+
+	// 成功后恢复
+	cc.RecordResult(1, true)
+	assert.False(t, cc.IsTripped(1))
+	rate, total = cc.GetSuccessRate(1)
+	assert.InDelta(t, 1.0/3, rate, 0.001)
+	assert.Equal(t, int64(3), total)
+
+	rate, total = cc.GetSuccessRate(3)
+	assert.Equal(t, float64(1), rate)
+	assert.Equal(t, int64(0), total)
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"one-api/common/config"
+	"sync"
+	"time"
+)
+
+// 渠道请求统计与熔断状态
+type ChannelHealth struct {
+	sync.Mutex
+	Successes           int64
+	Failures            int64
+	ConsecutiveFailures int
+	// 熔断到期时间（unix 秒），0 为未熔断
+	TrippedUntil int64
+}
+
+func (cc *ChannelsChooser) getHealth(channelId int) *ChannelHealth {
+	health, _ := cc.Health.LoadOrStore(channelId, &ChannelHealth{})
+	return health.(*ChannelHealth)
+}
+
+// 记录渠道请求结果，连续失败达到 ChannelBreakerThreshold 次后熔断 ChannelBreakerSeconds 秒
+func (cc *ChannelsChooser) RecordResult(channelId int, success bool) {
+	if channelId == 0 {
+		return
+	}
+
+	health := cc.getHealth(channelId)
+	health.Lock()
+	defer health.Unlock()
+
+	if success {
+		health.Successes++
+		health.ConsecutiveFailures = 0
+		health.TrippedUntil = 0
+		return
+	}
+
+	health.Failures++
+	health.ConsecutiveFailures++
+	if config.ChannelBreakerThreshold > 0 && health.ConsecutiveFailures >= config.ChannelBreakerThreshold {
+		health.TrippedUntil = time.Now().Unix() + int64(config.ChannelBreakerSeconds)
+		// 半开状态下再次失败会重新熔断
+		health.ConsecutiveFailures = config.ChannelBreakerThreshold - 1
+	}
+}
+
+// 渠道是否处于熔断中，熔断到期后放行请求试探渠道是否恢复
+func (cc *ChannelsChooser) IsTripped(channelId int) bool {
+	health, ok := cc.Health.Load(channelId)
+	if !ok {
+		return false
+	}
+
+	channelHealth := health.(*ChannelHealth)
+	channelHealth.Lock()
+	defer channelHealth.Unlock()
+
+	return time.Now().Unix() < channelHealth.TrippedUntil
+}
+
+// 获取渠道成功率及请求总数，没有请求记录时成功率为 1
+func (cc *ChannelsChooser) GetSuccessRate(channelId int) (rate float64, total int64) {
+	health, ok := cc.Health.Load(channelId)
+	if !ok {
+		return 1, 0
+	}
+
+	channelHealth := health.(*ChannelHealth)
+	channelHealth.Lock()
+	defer channelHealth.Unlock()
+
+	total = channelHealth.Successes + channelHealth.Failures
+	if total == 0 {
+		return 1, 0
+	}
+
+	return float64(channelHealth.Successes) / float64(total), total
+}
+
+// 平滑加权轮询的当前权重，按同一优先级的渠道列表区分
+type smoothWeights struct {
+	sync.Mutex
+	current map[int]int
+}
+
+func (cc *ChannelsChooser) getSmoothWeights(key string) *smoothWeights {
+	weights, _ := cc.smooth.LoadOrStore(key, &smoothWeights{current: make(map[int]int)})
+	return weights.(*smoothWeights)
+}
+
+// 平滑加权轮询：每轮所有渠道加上自身权重，选出当前权重最大的渠道并减去总权重
+func (w *smoothWeights) next(choices []*ChannelChoice) *Channel {
+	w.Lock()
+	defer w.Unlock()
+
+	totalWeight := 0
+	var best *ChannelChoice
+	for _, choice := range choices {
+		weight := int(*choice.Channel.Weight)
+		totalWeight += weight
+		w.current[choice.Channel.Id] += weight
+
+		if best == nil || w.current[choice.Channel.Id] > w.current[best.Channel.Id] {
+			best = choice
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	w.current[best.Channel.Id] -= totalWeight
+	return best.Channel
+}
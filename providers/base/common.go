@@ -202,6 +202,15 @@ func (p *BaseProvider) GetSupportedAPIUri(relayMode int) (url string, err *types
 	return
 }
 
+// 供应商未实现的接口统一返回 501，错误信息包含供应商及接口名称
+func ErrorEndpointNotSupported(provider, endpoint string) *types.OpenAIErrorWithStatusCode {
+	errWithCode := common.StringErrorWrapperLocal(fmt.Sprintf("The endpoint '%s' is not supported by provider '%s'", endpoint, provider), "endpoint_not_supported", http.StatusNotImplemented)
+	errWithCode.Type = "endpoint_not_supported_by_provider"
+	errWithCode.Param = endpoint
+
+	return errWithCode
+}
+
 func (p *BaseProvider) GetRequester() *requester.HTTPRequester {
 	return p.Requester
 }
@@ -0,0 +1,24 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 统一的 system_fingerprint 格式：<provider>_v_<version>_seed_<seed>，缺少的部分省略，
+// 相同的提供方、模型版本和 seed 得到相同的结果；没有版本和 seed 时返回空
+func FormatSystemFingerprint(provider, version string, seed *int) string {
+	if version == "" && seed == nil {
+		return ""
+	}
+
+	parts := []string{strings.ToLower(provider)}
+	if version != "" {
+		parts = append(parts, "v_"+version)
+	}
+	if seed != nil {
+		parts = append(parts, fmt.Sprintf("seed_%d", *seed))
+	}
+
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,21 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSystemFingerprint(t *testing.T) {
+	seed := 42
+	fingerprint := FormatSystemFingerprint("Replicate", "5c7854e8", &seed)
+	assert.Equal(t, "replicate_v_5c7854e8_seed_42", fingerprint)
+
+	// 相同输入得到相同结果
+	sameSeed := 42
+	assert.Equal(t, fingerprint, FormatSystemFingerprint("replicate", "5c7854e8", &sameSeed))
+
+	assert.Equal(t, "replicate_seed_42", FormatSystemFingerprint("replicate", "", &seed))
+	assert.Equal(t, "replicate_v_5c7854e8", FormatSystemFingerprint("replicate", "5c7854e8", nil))
+	assert.Empty(t, FormatSystemFingerprint("replicate", "", nil))
+}
@@ -112,6 +112,17 @@ type ModelListInterface interface {
 	GetModelList() ([]string, error)
 }
 
+// 连接测试接口，只校验密钥和模型，不产生实际费用
+type ConnectionTestInterface interface {
+	ProviderInterface
+	TestConnection(modelName string) (*ConnectionTestResult, *types.OpenAIErrorWithStatusCode)
+}
+
+// 非流式响应中额外返回的字段，如调试信息，relay 写入响应体顶层，不覆盖已有字段
+type ResponseExtraFieldsInterface interface {
+	GetResponseExtraFields() map[string]any
+}
+
 // 余额接口
 type BalanceInterface interface {
 	Balance() (float64, error)
@@ -7,3 +7,10 @@ type BalanceResponse struct {
 	TotalRemaining float64 `json:"total_remaining"`
 	TotalAvailable float64 `json:"total_available"`
 }
+
+type ConnectionTestResult struct {
+	AuthOK      bool   `json:"auth_ok"`
+	ModelExists bool   `json:"model_exists"`
+	Latency     int64  `json:"latency"`
+	Message     string `json:"message,omitempty"`
+}
@@ -37,6 +37,8 @@ import (
 	"one-api/providers/vertexai"
 	"one-api/providers/xunfei"
 	"one-api/providers/zhipu"
+	"path"
+	"reflect"
 
 	"github.com/gin-gonic/gin"
 )
@@ -88,6 +90,17 @@ func init() {
 	}
 }
 
+// 获取渠道类型对应的供应商名称，即供应商的包名（如 openai、claude、replicate），
+// 没有对应工厂的渠道按 OpenAI 兼容接口处理，返回 openai
+func GetProviderName(channelType int) string {
+	factory, ok := providerFactories[channelType]
+	if !ok {
+		return "openai"
+	}
+
+	return path.Base(reflect.TypeOf(factory).PkgPath())
+}
+
 // 获取供应商
 func GetProvider(channel *model.Channel, c *gin.Context) base.ProviderInterface {
 	factory, ok := providerFactories[channel.Type]
@@ -0,0 +1,138 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/types"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// 同一 Replicate 账号下所有渠道共用的限流器，上游返回 429 时整个账号暂停到 Retry-After 之后
+type accountLimiter struct {
+	mu          sync.Mutex
+	limiter     *rate.Limiter
+	pausedUntil time.Time
+}
+
+var (
+	accountLimiters   = make(map[string]*accountLimiter)
+	accountLimitersMu sync.Mutex
+)
+
+// 渠道插件 account_limit.account_id 相同的渠道共用限流器，rate 为每秒请求数，0 表示只在 429 后暂停；
+// 同一账号的渠道应使用相同的 rate 和 burst，以最后一次请求的配置为准
+func (p *ReplicateProvider) getAccountLimiter() *accountLimiter {
+	plugin := p.getPlugin("account_limit")
+	accountId := pluginString(plugin, "account_id")
+	if accountId == "" {
+		return nil
+	}
+
+	limit := pluginFloat(plugin, "rate", 0)
+	burst := pluginInt(plugin, "burst", int(math.Max(1, math.Ceil(limit))))
+
+	accountLimitersMu.Lock()
+	defer accountLimitersMu.Unlock()
+
+	limiter, ok := accountLimiters[accountId]
+	if !ok {
+		limiter = &accountLimiter{}
+		accountLimiters[accountId] = limiter
+	}
+	limiter.configure(limit, burst)
+
+	return limiter
+}
+
+func (l *accountLimiter) configure(limit float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case limit <= 0:
+		l.limiter = nil
+	case l.limiter == nil:
+		l.limiter = rate.NewLimiter(rate.Limit(limit), burst)
+	default:
+		l.limiter.SetLimit(rate.Limit(limit))
+		l.limiter.SetBurst(burst)
+	}
+}
+
+// 等待暂停结束并获取请求名额
+func (l *accountLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		paused := time.Until(l.pausedUntil)
+		limiter := l.limiter
+		l.mu.Unlock()
+
+		if paused <= 0 {
+			if limiter == nil {
+				return nil
+			}
+			return limiter.Wait(ctx)
+		}
+
+		timer := time.NewTimer(paused)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// 暂停到指定时间，已有更晚的暂停时保持不变
+func (l *accountLimiter) pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// 发送上游请求前按账号限流
+func (p *ReplicateProvider) waitAccountLimit(req *http.Request) *types.OpenAIErrorWithStatusCode {
+	limiter := p.getAccountLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	if err := limiter.wait(req.Context()); err != nil {
+		return common.ErrorWrapperLocal(err, "request_canceled", http.StatusRequestTimeout)
+	}
+
+	return nil
+}
+
+// 上游返回 429 且带有 Retry-After 时暂停整个账号
+func (p *ReplicateProvider) pauseAccountOnRateLimit(errWithCode *types.OpenAIErrorWithStatusCode) {
+	if errWithCode == nil || errWithCode.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	// rateLimitErrorHandle 将等待的秒数写入 param
+	retryAfter, err := strconv.Atoi(errWithCode.Param)
+	if err != nil || retryAfter <= 0 {
+		return
+	}
+
+	limiter := p.getAccountLimiter()
+	if limiter == nil {
+		return
+	}
+
+	logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate account %s rate limited, paused for %d seconds", pluginString(p.getPlugin("account_limit"), "account_id"), retryAfter))
+	limiter.pause(time.Now().Add(time.Duration(retryAfter) * time.Second))
+}
@@ -0,0 +1,71 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getAccountProviders(accountId string, params map[string]interface{}, handler func(req *http.Request) *http.Response) []*ReplicateProvider {
+	params["account_id"] = accountId
+	var providers []*ReplicateProvider
+	for channelId := 1; channelId <= 2; channelId++ {
+		provider, _ := getMockProvider(model.PluginType{"account_limit": params}, handler)
+		provider.Channel.Id = channelId
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+func TestAccountLimitShared(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	}
+
+	// 两个渠道共用每秒 20 个请求的限额，交替发送 5 个请求至少需要 200ms
+	providers := getAccountProviders("account-shared", map[string]interface{}{"rate": "20", "burst": "1"}, handler)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, errWithCode := providers[i%2].CreateChatCompletion(getTestChatRequest("hi"))
+		assert.Nil(t, errWithCode)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 180*time.Millisecond)
+
+	// 其他账号不受影响
+	providers = getAccountProviders("account-other", map[string]interface{}{"rate": "20", "burst": "5"}, handler)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		_, errWithCode := providers[i%2].CreateChatCompletion(getTestChatRequest("hi"))
+		assert.Nil(t, errWithCode)
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestAccountLimitRetryAfter(t *testing.T) {
+	limited := true
+	handler := func(req *http.Request) *http.Response {
+		if limited {
+			limited = false
+			response := jsonResponse(http.StatusTooManyRequests, `{"detail":"Request was throttled","status":429}`)
+			response.Header.Set("Retry-After", "1")
+			return response
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	}
+
+	// 第一个渠道收到 429 后，同一账号的其他渠道等待 Retry-After 之后再发送
+	providers := getAccountProviders("account-retry-after", map[string]interface{}{}, handler)
+	_, errWithCode := providers[0].CreateChatCompletion(getTestChatRequest("hi"))
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusTooManyRequests, errWithCode.StatusCode)
+	}
+
+	start := time.Now()
+	_, errWithCode = providers[1].CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
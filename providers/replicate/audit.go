@@ -0,0 +1,171 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common/logger"
+	"one-api/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 审计记录，只包含模型、参数、用量及渠道信息，不记录提示词、消息内容和密钥
+type AuditRecord struct {
+	Time             int64          `json:"time"`
+	RequestId        string         `json:"request_id,omitempty"`
+	ChannelId        int            `json:"channel_id"`
+	UserId           int            `json:"user_id,omitempty"`
+	TokenId          int            `json:"token_id,omitempty"`
+	EndUser          string         `json:"end_user,omitempty"`
+	Model            string         `json:"model"`
+	ReplicateModel   string         `json:"replicate_model,omitempty"`
+	PredictionId     string         `json:"prediction_id,omitempty"`
+	Stream           bool           `json:"stream"`
+	Status           string         `json:"status"`
+	Params           map[string]any `json:"params,omitempty"`
+	PromptTokens     int            `json:"prompt_tokens"`
+	CompletionTokens int            `json:"completion_tokens"`
+	TotalTokens      int            `json:"total_tokens"`
+}
+
+// 审计记录的输出位置，默认写入日志
+type AuditSink interface {
+	WriteAudit(record *AuditRecord)
+}
+
+type AuditSinkFunc func(record *AuditRecord)
+
+func (f AuditSinkFunc) WriteAudit(record *AuditRecord) {
+	f(record)
+}
+
+type logAuditSink struct{}
+
+func (logAuditSink) WriteAudit(record *AuditRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	logger.SysLog("replicate audit: " + string(data))
+}
+
+var (
+	auditSink   AuditSink = logAuditSink{}
+	auditSinkMu sync.RWMutex
+)
+
+// 替换审计记录的输出位置，传入 nil 时恢复为写入日志
+func SetAuditSink(sink AuditSink) {
+	auditSinkMu.Lock()
+	defer auditSinkMu.Unlock()
+
+	if sink == nil {
+		sink = logAuditSink{}
+	}
+	auditSink = sink
+}
+
+func getAuditSink() AuditSink {
+	auditSinkMu.RLock()
+	defer auditSinkMu.RUnlock()
+
+	return auditSink
+}
+
+// 渠道插件 audit.enable 开启后，每次补全结束（包括流式）写入一条审计记录
+func (p *ReplicateProvider) audit(record *AuditRecord, input *ReplicateChatRequest) {
+	if !pluginBool(p.getPlugin("audit"), "enable") {
+		return
+	}
+
+	record.Time = time.Now().Unix()
+	record.RequestId = p.getRequestId()
+	record.ChannelId = p.Channel.Id
+	record.ReplicateModel = p.modelName
+	if p.Context != nil {
+		record.UserId = p.Context.GetInt("id")
+		record.TokenId = p.Context.GetInt("token_id")
+		// 请求中的 user，Replicate 的预测不支持附加元数据，只记录在审计记录中
+		record.EndUser = p.Context.GetString("end_user")
+	}
+	if input != nil {
+		record.Params = getAuditParams(input)
+	}
+	if p.Usage != nil {
+		record.PromptTokens = p.Usage.PromptTokens
+		record.CompletionTokens = p.Usage.CompletionTokens
+		record.TotalTokens = p.Usage.TotalTokens
+	}
+
+	getAuditSink().WriteAudit(record)
+}
+
+// 只记录数值参数，stop、图片及透传参数可能包含用户内容，只记录是否设置、数量或字段名
+func getAuditParams(input *ReplicateChatRequest) map[string]any {
+	params := make(map[string]any)
+	if input.Temperature != nil {
+		params["temperature"] = *input.Temperature
+	}
+	if input.TopP != nil {
+		params["top_p"] = *input.TopP
+	}
+	if input.TopK > 0 {
+		params["top_k"] = input.TopK
+	}
+	if input.MaxTokens > 0 {
+		params["max_tokens"] = input.MaxTokens
+	}
+	if input.MinTokens > 0 {
+		params["min_tokens"] = input.MinTokens
+	}
+	if input.PresencePenalty != nil {
+		params["presence_penalty"] = *input.PresencePenalty
+	}
+	if input.FrequencyPenalty != nil {
+		params["frequency_penalty"] = *input.FrequencyPenalty
+	}
+	if input.Seed != nil {
+		params["seed"] = *input.Seed
+	}
+	if input.StopSequences != "" {
+		params["stop"] = true
+	}
+	if len(input.Images) > 0 {
+		params["image_count"] = len(input.Images)
+	}
+	if len(input.Extra) > 0 {
+		keys := make([]string, 0, len(input.Extra))
+		for key := range input.Extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		params["extra_keys"] = keys
+	}
+
+	return params
+}
+
+// 流式请求结束时写入审计记录
+func (h *ReplicateStreamHandler) audit(status string) {
+	if h.Usage != nil && h.Usage.Canceled {
+		status = "canceled"
+	}
+
+	h.Provider.audit(&AuditRecord{
+		Model:        h.ModelName,
+		PredictionId: h.ID,
+		Stream:       true,
+		Status:       status,
+	}, h.Input)
+}
+
+// 非流式请求的结束状态，失败时为 error，否则为第一个 choice 的 finish_reason
+func getAuditStatus(finishReason any, errWithCode *types.OpenAIErrorWithStatusCode) string {
+	if errWithCode != nil {
+		return "error"
+	}
+
+	return fmt.Sprint(finishReason)
+}
@@ -0,0 +1,89 @@
+package replicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/model"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudit(t *testing.T) {
+	var mu sync.Mutex
+	var records []*AuditRecord
+	SetAuditSink(AuditSinkFunc(func(record *AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+	}))
+	defer SetAuditSink(nil)
+
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: top secret answer\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["top secret answer"],"metrics":{"input_token_count":7,"output_token_count":3}}`)
+		}
+	}
+
+	// 未开启时不记录
+	provider, _ := getMockProvider(nil, handler)
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("my private prompt"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, records)
+
+	plugin := model.PluginType{
+		"audit": {"enable": true},
+	}
+	provider, _ = getMockProvider(plugin, handler)
+	provider.Channel.Id = 42
+	provider.Context.Set("end_user", "end-user-1")
+	request := getTestChatRequest("my private prompt")
+	temperature := 0.3
+	request.Temperature = &temperature
+	request.Stop = []string{"secret stop"}
+	_, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+
+	provider, _ = getMockProvider(plugin, handler)
+	request = getTestChatRequest("my private prompt")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	_, err := readStream(t, stream)
+	assert.Nil(t, err)
+
+	if !assert.Len(t, records, 2) {
+		return
+	}
+
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		assert.Nil(t, err)
+		assert.NotContains(t, string(data), "my private prompt")
+		assert.NotContains(t, string(data), "top secret answer")
+		assert.NotContains(t, string(data), "secret stop")
+		assert.NotContains(t, string(data), provider.Channel.Key)
+
+		assert.Equal(t, "meta/meta-llama-3-70b-instruct", record.Model)
+		assert.Equal(t, "meta/meta-llama-3-70b-instruct", record.ReplicateModel)
+		assert.Equal(t, "p1", record.PredictionId)
+		assert.Equal(t, "stop", record.Status)
+		assert.Greater(t, record.TotalTokens, 0)
+		assert.NotZero(t, record.Time)
+	}
+
+	assert.False(t, records[0].Stream)
+	assert.Equal(t, 42, records[0].ChannelId)
+	assert.Equal(t, "end-user-1", records[0].EndUser)
+	assert.Empty(t, records[1].EndUser)
+	assert.Equal(t, 0.3, records[0].Params["temperature"])
+	assert.Equal(t, true, records[0].Params["stop"])
+	assert.Equal(t, 10, records[0].TotalTokens)
+	assert.True(t, records[1].Stream)
+}
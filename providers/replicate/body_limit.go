@@ -0,0 +1,49 @@
+package replicate
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"one-api/common/utils"
+	"strings"
+)
+
+var errResponseTooLarge = errors.New("upstream response body too large")
+
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// 已读满限制，再读一个字节判断是否超出
+		var b [1]byte
+		n, err := r.ReadCloser.Read(b[:])
+		if n > 0 {
+			return 0, errResponseTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+
+	return n, err
+}
+
+// 限制上游非流式响应体大小，避免返回超大数据占用内存，单位为 MB，0 表示不限制
+func limitResponseBody(resp *http.Response) {
+	limit := int64(utils.GetOrDefault("response_body_limit", 16)) << 20
+	if limit <= 0 || strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+
+	resp.Body = &limitedReadCloser{
+		ReadCloser: resp.Body,
+		remaining:  limit,
+	}
+}
@@ -0,0 +1,129 @@
+package replicate
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"one-api/common/cache"
+	"one-api/common/image"
+	"one-api/common/logger"
+	"one-api/types"
+	"strings"
+	"time"
+)
+
+const defaultResponseCacheTTL = 3600
+
+// 获取响应缓存的 key，只有确定性的请求才会缓存
+func (p *ReplicateProvider) getResponseCacheKey(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) string {
+	if !pluginBool(p.getPlugin("response_cache"), "enable") {
+		return ""
+	}
+
+	hash := getDeterministicRequestHash(replicateRequest, replicateModel)
+	if hash == "" {
+		return ""
+	}
+
+	return "replicate:response:" + hash
+}
+
+// 确定性请求（指定 seed 且 temperature 为 0）的哈希，非确定性请求返回空
+// 哈希包含所有映射后的参数，图片使用内容摘要，相同图片每次上传得到不同地址时哈希不变
+func getDeterministicRequestHash(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) string {
+	input := replicateRequest.Input
+	if input.Temperature == nil || *input.Temperature > 0 {
+		return ""
+	}
+	if input.Seed == nil && input.Extra["seed"] == nil {
+		return ""
+	}
+
+	hashRequest := *replicateRequest
+	if images := getImageDigests(input); len(images) > 0 {
+		hashRequest.Input.Image = strings.Join(images, ",")
+	}
+
+	body, err := json.Marshal(hashRequest)
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.Sum256(append([]byte(replicateModel.Slug()+"\n"), body...))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// base64 图片使用解码后内容的 sha256，图片地址保持不变
+func getImageDigests(input ReplicateChatRequest) []string {
+	images := input.SourceImages
+	if images == nil {
+		images = input.Images
+	}
+
+	digests := make([]string, len(images))
+	for index, imageUrl := range images {
+		digests[index] = imageUrl
+		if !strings.HasPrefix(imageUrl, "data:") {
+			continue
+		}
+
+		data := []byte(imageUrl)
+		if _, encoded, err := image.ParseBase64File(imageUrl); err == nil {
+			if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				data = decoded
+			}
+		}
+		hash := sha256.Sum256(data)
+		digests[index] = "sha256:" + hex.EncodeToString(hash[:])
+	}
+
+	return digests
+}
+
+// 命中缓存时返回缓存的响应，用量按 cost_ratio 折算，默认不计费
+func (p *ReplicateProvider) getCachedResponse(key string) *types.ChatCompletionResponse {
+	if key == "" {
+		return nil
+	}
+
+	body, err := cache.GetCache[string](key)
+	if err != nil {
+		return nil
+	}
+
+	response := &types.ChatCompletionResponse{}
+	if err := json.Unmarshal([]byte(body), response); err != nil || response.Usage == nil {
+		return nil
+	}
+
+	costRatio := pluginFloat(p.getPlugin("response_cache"), "cost_ratio", 0)
+	p.Usage.PromptTokens = int(math.Ceil(float64(response.Usage.PromptTokens) * costRatio))
+	p.Usage.CompletionTokens = int(math.Ceil(float64(response.Usage.CompletionTokens) * costRatio))
+	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+	p.Usage.Cached = true
+	response.Usage = p.Usage
+
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate response cache hit: %s", key))
+
+	return response
+}
+
+func (p *ReplicateProvider) setCachedResponse(key string, response *types.ChatCompletionResponse) {
+	if key == "" {
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	ttl := pluginInt(p.getPlugin("response_cache"), "ttl", defaultResponseCacheTTL)
+	if err := cache.SetCache(key, string(body), time.Duration(ttl)*time.Second); err != nil {
+		logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate response cache set failed: %s", err.Error()))
+	}
+}
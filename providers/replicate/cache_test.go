@@ -0,0 +1,153 @@
+package replicate
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	plugin := model.PluginType{
+		"response_cache": {"enable": true},
+	}
+	handler := func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["cached answer"],"metrics":{"input_token_count":5,"output_token_count":3}}`)
+	}
+
+	seed := 42
+	temperature := 0.0
+	// 每次运行使用不同的内容，避免命中上次运行的缓存
+	request := getTestChatRequest(fmt.Sprintf("response cache hit %d", time.Now().UnixNano()))
+	request.Seed = &seed
+	request.Temperature = &temperature
+
+	// 未命中时请求上游
+	provider, doer := getMockProvider(plugin, handler)
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "cached answer", response.Choices[0].Message.Content)
+	assert.Equal(t, 8, response.Usage.TotalTokens)
+	assert.False(t, response.Usage.Cached)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+
+	// 命中时不请求上游，默认不计费
+	provider, doer = getMockProvider(plugin, handler)
+	response, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "cached answer", response.Choices[0].Message.Content)
+	assert.Equal(t, 0, response.Usage.TotalTokens)
+	assert.True(t, provider.Usage.Cached)
+	assert.Equal(t, 0, doer.count(http.MethodPost))
+
+	// 按倍率计费
+	plugin["response_cache"]["cost_ratio"] = "0.5"
+	provider, _ = getMockProvider(plugin, handler)
+	response, _ = provider.CreateChatCompletion(request)
+	assert.Equal(t, 3, response.Usage.PromptTokens)
+	assert.Equal(t, 2, response.Usage.CompletionTokens)
+}
+
+func TestResponseCacheBypass(t *testing.T) {
+	plugin := model.PluginType{
+		"response_cache": {"enable": true},
+	}
+	handler := func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["fresh"]}`)
+	}
+
+	seed := 42
+	temperature := 0.7
+	zero := 0.0
+
+	cases := []struct {
+		name        string
+		temperature *float64
+		seed        *int
+	}{
+		{"temperature", &temperature, &seed},
+		{"no seed", &zero, nil},
+		{"default temperature", nil, &seed},
+	}
+
+	for _, testCase := range cases {
+		name := testCase.name
+		request := getTestChatRequest("response cache bypass " + name)
+		request.Temperature = testCase.temperature
+		request.Seed = testCase.seed
+
+		provider, doer := getMockProvider(plugin, handler)
+		_, errWithCode := provider.CreateChatCompletion(request)
+		assert.Nil(t, errWithCode, name)
+		_, errWithCode = provider.CreateChatCompletion(request)
+		assert.Nil(t, errWithCode, name)
+		assert.Equal(t, 2, doer.count(http.MethodPost), name)
+		assert.False(t, provider.Usage.Cached, name)
+	}
+}
+
+func TestDeterministicRequestHashImages(t *testing.T) {
+	seed := 42
+	temperature := 0.0
+	getHash := func(request *types.ChatCompletionRequest, plugin model.PluginType, handler func(req *http.Request) *http.Response) string {
+		request.Seed = &seed
+		request.Temperature = &temperature
+		provider, _ := getMockProvider(plugin, handler)
+		replicateRequest, replicateModel, errWithCode := provider.getReplicateChatRequest(request)
+		assert.Nil(t, errWithCode)
+		return getDeterministicRequestHash(replicateRequest, replicateModel)
+	}
+
+	textOnly := getHash(getTestChatRequest("describe"), nil, nil)
+	cat := getHash(getTestImageChatRequest("https://example.com/cat.png"), nil, nil)
+	dog := getHash(getTestImageChatRequest("https://example.com/dog.png"), nil, nil)
+	catData := getHash(getTestImageChatRequest("data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("cat"))), nil, nil)
+	dogData := getHash(getTestImageChatRequest("data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("dog"))), nil, nil)
+
+	// 只有图片不同的请求哈希不同，也不会命中纯文本请求的缓存
+	hashes := map[string]bool{textOnly: true, cat: true, dog: true, catData: true, dogData: true}
+	assert.Len(t, hashes, 5)
+	assert.Equal(t, cat, getHash(getTestImageChatRequest("https://example.com/cat.png"), nil, nil))
+
+	// 相同图片每次上传得到不同的地址，哈希不变
+	plugin := model.PluginType{
+		"image_upload": {"models": "*"},
+	}
+	uploads := 0
+	handler := func(req *http.Request) *http.Response {
+		uploads++
+		return jsonResponse(http.StatusCreated, fmt.Sprintf(`{"id":"f%d","urls":{"get":"https://api.replicate.com/v1/files/f%d"}}`, uploads, uploads))
+	}
+	uploaded := getHash(getTestImageChatRequest("data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("cat"))), plugin, handler)
+	assert.Equal(t, uploaded, getHash(getTestImageChatRequest("data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("cat"))), plugin, handler))
+	assert.NotEqual(t, uploaded, getHash(getTestImageChatRequest("data:image/png;base64,"+base64.StdEncoding.EncodeToString([]byte("dog"))), plugin, handler))
+	assert.Equal(t, 3, uploads)
+}
+
+func TestResponseCacheImages(t *testing.T) {
+	plugin := model.PluginType{
+		"response_cache": {"enable": true},
+	}
+	seed := 42
+	temperature := 0.0
+	prefix := fmt.Sprintf("https://example.com/%d/", time.Now().UnixNano())
+
+	for _, imageUrl := range []string{prefix + "cat.png", prefix + "dog.png"} {
+		provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["an animal"]}`)
+		})
+		request := getTestImageChatRequest(imageUrl)
+		request.Seed = &seed
+		request.Temperature = &temperature
+
+		response, errWithCode := provider.CreateChatCompletion(request)
+		assert.Nil(t, errWithCode)
+		assert.False(t, response.Usage.Cached)
+		assert.Equal(t, 1, doer.count(http.MethodPost))
+	}
+}
@@ -2,84 +2,451 @@ package replicate
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"one-api/common"
 	"one-api/common/config"
+	"one-api/common/logger"
 	"one-api/common/requester"
-	"one-api/common/utils"
+	"one-api/common/stream"
+	"one-api/metrics"
 	"one-api/types"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxN              = 4
+	defaultFanOutConcurrency = 2
+	defaultPacingMaxDelay    = 1000
+
+	streamDeltaCumulative = "cumulative"
+	streamDeltaAuto       = "auto"
 )
 
 type ReplicateStreamHandler struct {
-	Usage     *types.Usage
-	ModelName string
-	ID        string
-	Provider  *ReplicateProvider
+	Usage         *types.Usage
+	ModelName     string
+	ID            string
+	Provider      *ReplicateProvider
+	ResponseText  string
+	StopSequences []string
+	// 已完成的预测，不需要再获取用量
+	Prediction *ReplicateResponse[ReplicateChatOutput]
+	// 预测创建时间，首字时间和流式总耗时都从这里开始计算
+	StartTime      time.Time
+	FirstTokenTime time.Time
+	// 分块的 created 使用预测创建时间
+	Created int64
+	// legacy completions 流式请求
+	TextCompletion bool
+	// 流式输出的最长时间，超过后取消预测并以 length 结束
+	MaxDuration time.Duration
+	// 相邻分块的最小间隔及累计延迟上限，间隔为 0 时不限速
+	PacingInterval time.Duration
+	PacingMaxDelay time.Duration
+	// 合并相邻的小分块，缓冲内容达到 CoalesceSize 字节或距缓冲开始超过 CoalesceWindow 时发送，都为 0 时不合并
+	CoalesceSize   int
+	CoalesceWindow time.Duration
+	// 上游输出的形式：delta（默认）为增量，cumulative 为累计全文，auto 自动识别
+	DeltaMode string
+	// 发送给 Replicate 的参数，用于审计记录
+	Input *ReplicateChatRequest
+	// 只返回用量，不发送输出内容，结束分块带上用量
+	UsageOnly bool
+	// 每个分块的 system_fingerprint，创建预测时确定
+	SystemFingerprint string
+	// 不缓冲帧末尾不完整的 UTF-8 序列，原样转发
+	UnicodePassthrough bool
+
+	// 可能是 stop 开头的内容，暂缓发送
+	pending string
+	// 已收到的上游全文，用于从累计输出中计算增量
+	received string
+	// 上一帧末尾不完整的 UTF-8 序列，与下一帧拼接后再发送
+	partialRune string
+	// 上一个分块的发送时间及累计的限速延迟
+	lastSent    time.Time
+	pacingDelay time.Duration
+	// 流即将结束，剩余内容立即发送
+	finishing bool
+	// 等待合并的内容及开始缓冲的时间
+	buffered      string
+	bufferedSince time.Time
+	// 输出中的工具调用转换为 delta.tool_calls，未开启时为 nil
+	toolCalls *toolCallAssembler
+	// 费用上限，未配置时为 nil
+	costCeiling *costCeiling
+	// 流已结束或预测已取消，流在其他 goroutine 中关闭时读取
+	ended    atomic.Bool
+	canceled atomic.Bool
 }
 
 func (p *ReplicateProvider) CreateChatCompletion(request *types.ChatCompletionRequest) (response *types.ChatCompletionResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
-	url, errWithCode := p.GetSupportedAPIUri(config.RelayModeChatCompletions)
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if p.usageOnlyRequested() {
+		return p.createUsageOnlyChatCompletion(request)
+	}
+
+	idempotencyKey := p.getIdempotencyKey()
+	requestHash := ""
+	if idempotencyKey != "" {
+		requestHash = p.getIdempotencyRequestHash()
+		response, errWithCode := getIdempotentResponse[types.ChatCompletionResponse](p, idempotencyKey, requestHash)
+		if errWithCode != nil || response != nil {
+			return response, errWithCode
+		}
+	}
+
+	response, errWithCode = p.createChatCompletion(request)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
+	p.setIdempotentResponse(idempotencyKey, requestHash, response)
+
+	return response, nil
+}
+
+func (p *ReplicateProvider) createChatCompletion(request *types.ChatCompletionRequest) (response *types.ChatCompletionResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
+	replicateRequest, replicateModel, errWithCode := p.getReplicateChatRequest(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	// 使用流式接口时由流式处理写入审计记录
+	streamed := false
+	defer func() {
+		if streamed {
+			return
+		}
+		record := &AuditRecord{Model: request.Model}
+		var finishReason any
+		if response != nil {
+			record.PredictionId = response.ID
+			if len(response.Choices) > 0 {
+				finishReason = response.Choices[0].FinishReason
+			}
+		}
+		record.Status = getAuditStatus(finishReason, errWithCode)
+		p.audit(record, &replicateRequest.Input)
+	}()
+
+	n := 1
+	if request.N != nil && *request.N > 1 {
+		n = *request.N
+	}
 
+	fanOut := p.getPlugin("fan_out")
+	if maxN := pluginInt(fanOut, "max_n", defaultMaxN); n > maxN {
+		return nil, common.StringErrorWrapperLocal(fmt.Sprintf("n must be less than or equal to %d", maxN), "invalid_n", http.StatusBadRequest)
+	}
+
+	if n == 1 {
+		cacheKey := p.getResponseCacheKey(replicateRequest, replicateModel)
+		if response := p.getCachedResponse(cacheKey); response != nil {
+			response.Model = request.Model
+			return response, nil
+		}
+
+		// 流式输出不带 logprobs，请求 logprobs 时使用轮询
+		if p.useStreamAdapter(request.Model) && !logprobsRequested(request) {
+			stream, chatHandler, errWithCode := p.createAdapterStream(replicateRequest, replicateModel, request)
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			streamed = true
+
+			response, errWithCode = p.getStreamedChatCompletion(stream, chatHandler, request)
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			p.setCachedResponse(cacheKey, response)
+
+			return response, nil
+		}
+
+		replicateResponse, errWithCode := p.createNonEmptyChatPrediction(replicateRequest, replicateModel)
+		servedModel := ""
+		if errWithCode != nil {
+			replicateResponse, servedModel, errWithCode = withModelFallback(p, request.Model, errWithCode, func(modelName string) (*ReplicateResponse[ReplicateChatOutput], *types.OpenAIErrorWithStatusCode) {
+				fallbackRequest, fallbackModel, errWithCode := p.getFallbackChatRequest(request, modelName)
+				if errWithCode != nil {
+					return nil, errWithCode
+				}
+				return p.createNonEmptyChatPrediction(fallbackRequest, fallbackModel)
+			})
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+		}
+
+		response, errWithCode = p.convertToChatOpenai(replicateResponse, request)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+
+		// 备用模型的结果不缓存到主模型的缓存键下，响应中的 model 为实际提供服务的模型
+		if servedModel != "" {
+			response.Model = servedModel
+			return response, nil
+		}
+		p.setCachedResponse(cacheKey, response)
+
+		return response, nil
+	}
+
+	return p.createChatPredictions(replicateRequest, replicateModel, request, n, pluginInt(fanOut, "concurrency", defaultFanOutConcurrency))
+}
+
+// 创建一次预测并等待结果
+func (p *ReplicateProvider) createChatPrediction(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) (*ReplicateResponse[ReplicateChatOutput], *types.OpenAIErrorWithStatusCode) {
+	req, errWithCode := p.getChatRequest(replicateRequest, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	defer req.Body.Close()
+
+	replicateResponse := &ReplicateResponse[ReplicateChatOutput]{}
+
+	// 发送请求
+	errWithCode = p.sendRequest(req, replicateResponse)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.logPrediction(replicateResponse.ID)
+
+	replicateResponse, err := getPrediction(p, replicateResponse)
+	if err != nil {
+		return nil, predictionErrorWrapper(err)
+	}
+
+	return replicateResponse, nil
+}
+
+// 预测成功但输出为空时重新提交，渠道插件 empty_output.retries 配置次数
+// 确定性请求重试的结果相同，只有开启 empty_output.force 时才重试
+func (p *ReplicateProvider) createNonEmptyChatPrediction(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) (*ReplicateResponse[ReplicateChatOutput], *types.OpenAIErrorWithStatusCode) {
+	plugin := p.getPlugin("empty_output")
+	retries := pluginInt(plugin, "retries", 0)
+	if !pluginBool(plugin, "force") && getDeterministicRequestHash(replicateRequest, replicateModel) != "" {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		response, errWithCode := p.createSharedChatPrediction(replicateRequest, replicateModel)
+		if errWithCode != nil || attempt >= retries || response.Status != predictionSucceeded || strings.Join(response.Output, "") != "" {
+			return response, errWithCode
+		}
+
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate prediction %s succeeded with empty output, retrying (%d/%d)", response.ID, attempt+1, retries))
+	}
+}
+
+// Replicate 不支持 n，并发创建 n 个预测后合并为多个 choice，用量累加
+func (p *ReplicateProvider) createChatPredictions(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel, request *types.ChatCompletionRequest, n, concurrency int) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	responses := make([]*ReplicateResponse[ReplicateChatOutput], n)
+	errs := make([]*types.OpenAIErrorWithStatusCode, n)
+
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+
+			responses[index], errs[index] = p.createChatPrediction(replicateRequest, replicateModel)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, errWithCode := range errs {
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+	}
+
+	var openaiResponse *types.ChatCompletionResponse
+	usage := types.Usage{}
+	for index, replicateResponse := range responses {
+		response, errWithCode := p.convertToChatOpenai(replicateResponse, request)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+
+		usage.PromptTokens += p.Usage.PromptTokens
+		usage.CompletionTokens += p.Usage.CompletionTokens
+		usage.Estimated = usage.Estimated || p.Usage.Estimated
+
+		choice := response.Choices[0]
+		choice.Index = index
+		if openaiResponse == nil {
+			openaiResponse = response
+			openaiResponse.Choices = nil
+		}
+		openaiResponse.Choices = append(openaiResponse.Choices, choice)
+	}
+
+	p.Usage.PromptTokens = usage.PromptTokens
+	p.Usage.CompletionTokens = usage.CompletionTokens
+	p.Usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	p.Usage.Estimated = usage.Estimated
+	openaiResponse.Usage = p.Usage
+
+	return openaiResponse, nil
+}
+
+func (p *ReplicateProvider) getChatRequest(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) (*http.Request, *types.OpenAIErrorWithStatusCode) {
 	// 获取请求地址
-	fullRequestURL := p.GetFullRequestURL(url, request.Model)
+	fullRequestURL, errWithCode := p.getPredictionURL(config.RelayModeChatCompletions, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
 	if fullRequestURL == "" {
-		return nil, common.ErrorWrapper(nil, "invalid_recraft_config", http.StatusInternalServerError)
+		return nil, common.ErrorWrapperLocal(nil, "invalid_replicate_config", http.StatusInternalServerError)
 	}
 
 	// 获取请求头
 	headers := p.GetRequestHeaders()
+	if !replicateRequest.Stream {
+		p.setPreferWait(headers)
+	}
 
-	replicateRequest := convertFromChatOpenai(request)
 	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(replicateRequest), p.Requester.WithHeader(headers))
-
 	if err != nil {
-		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
 	}
 
-	replicateResponse := &ReplicateResponse[[]string]{}
+	return req, nil
+}
 
-	// 发送请求
-	_, errWithCode = p.Requester.SendRequest(req, replicateResponse, false)
+// 解析模型并转换为 Replicate 请求
+func (p *ReplicateProvider) getReplicateChatRequest(request *types.ChatCompletionRequest) (*ReplicateRequest[ReplicateChatRequest], *ReplicateModel, *types.OpenAIErrorWithStatusCode) {
+	replicateModel, errWithCode := p.resolveModel(request.Model)
+	if errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	if errWithCode := p.validateParamCombinations(request, replicateModel); errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	replicateRequest, errWithCode := p.convertFromChatOpenai(request)
+	if errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+	replicateRequest.Version = replicateModel.Version
+
+	if errWithCode := p.applyReasoningTokens(&replicateRequest.Input, request, replicateModel); errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	if errWithCode := p.applyLogprobs(&replicateRequest.Input, request, replicateModel); errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	if errWithCode := p.prepareChatInput(&replicateRequest.Input, replicateModel, request.Model, request.LogitBias); errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	return replicateRequest, replicateModel, nil
+}
+
+// 按模型配置调整参数并检查上下文长度，chat 和 completions 共用
+func (p *ReplicateProvider) prepareChatInput(input *ReplicateChatRequest, replicateModel *ReplicateModel, modelName string, logitBias any) *types.OpenAIErrorWithStatusCode {
+	if errWithCode := scrubChatInput(input, modelName); errWithCode != nil {
+		return errWithCode
+	}
+
+	if errWithCode := p.clampParams(input, replicateModel); errWithCode != nil {
+		return errWithCode
+	}
+
+	if errWithCode := p.applyZeroTemperature(input, replicateModel); errWithCode != nil {
+		return errWithCode
+	}
+
+	if errWithCode := p.applyMaxTokensFloor(input, replicateModel); errWithCode != nil {
+		return errWithCode
+	}
+	p.applyMaxOutputTokens(input, replicateModel)
+
+	if errWithCode := p.checkContextLength(input, replicateModel, modelName); errWithCode != nil {
+		return errWithCode
+	}
+
+	if errWithCode := p.applyLogitBias(input, logitBias, replicateModel); errWithCode != nil {
+		return errWithCode
+	}
+
+	return p.resolveImages(input, replicateModel)
+}
+
+func (p *ReplicateProvider) convertFromChatOpenai(request *types.ChatCompletionRequest) (*ReplicateRequest[ReplicateChatRequest], *types.OpenAIErrorWithStatusCode) {
+	extraInput, errWithCode := p.getExtraInput()
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
 
-	replicateResponse, err = getPrediction(p, replicateResponse)
-	if err != nil {
-		return nil, common.ErrorWrapper(err, "prediction_failed", http.StatusInternalServerError)
+	messages := request.Messages
+	if p.useMergeMessages(request.Model) {
+		request.Messages = mergeMessages(messages)
 	}
+	replicateRequest := convertFromChatOpenai(request, p.useMessageNames(request.Model), p.useAssistantPrefill(request.Model))
+	request.Messages = messages
+	replicateRequest.Input.Extra = extraInput
+	p.applyChannelDefaults(&replicateRequest.Input)
 
-	return p.convertToChatOpenai(replicateResponse)
+	return replicateRequest, nil
 }
 
-func convertFromChatOpenai(request *types.ChatCompletionRequest) *ReplicateRequest[ReplicateChatRequest] {
+// messageNames 为 true 时消息的 name 写入角色标签，如 user (alice):
+// prefill 为 true 且最后一条消息是 assistant 时不再追加 assistant 标签，模型接着这条消息继续输出
+func convertFromChatOpenai(request *types.ChatCompletionRequest, messageNames, prefill bool) *ReplicateRequest[ReplicateChatRequest] {
 	systemPrompt := ""
 	prompt := ""
 	var imageUrls []string
 
-	// 设置最小 MaxTokens 为 1024
-	if request.MaxTokens == 0 && request.MaxCompletionTokens > 0 {
-		request.MaxTokens = request.MaxCompletionTokens
-	}
-	// 确保最小 token 不小于 1024
-	if request.MaxTokens < 1024 {
-		request.MaxTokens = 1024
-	}
-
+	lastRole := ""
 	for _, msg := range request.Messages {
-		if msg.Role == "system" {
-			systemPrompt += msg.StringContent() + "\n"
+		if msg.IsSystemRole() {
+			// system 消息只保留文本部分，忽略图片等其他内容
+			for _, content := range msg.ParseContent() {
+				if content.Type == types.ContentTypeText {
+					systemPrompt += content.Text
+				}
+			}
+			systemPrompt += "\n"
+			continue
+		}
+
+		role := getTranscriptRole(msg.Role)
+		if role == "" {
 			continue
 		}
+		label := role
+		if messageNames && msg.Name != nil && strings.TrimSpace(*msg.Name) != "" {
+			label = fmt.Sprintf("%s (%s)", role, strings.TrimSpace(*msg.Name))
+		}
+
+		prompt += label + ": \n"
+		lastRole = role
+
+		if msg.Role == types.ChatMessageRoleTool || msg.Role == types.ChatMessageRoleFunction {
+			prompt += "Observation: "
+		}
 
-		prompt += msg.Role + ": \n"
-		openaiContent := msg.ParseContent()
-		for _, content := range openaiContent {
+		for _, content := range msg.ParseContent() {
 			switch content.Type {
 			case types.ContentTypeText:
 				prompt += content.Text
@@ -87,11 +454,19 @@ func convertFromChatOpenai(request *types.ChatCompletionRequest) *ReplicateReque
 				imageUrls = append(imageUrls, content.ImageURL.URL)
 			}
 		}
-		prompt += "\n"
+		// 只有工具调用、没有文本内容时不输出空行
+		actions := getTranscriptActions(msg)
+		if msg.Content != nil || actions == "" {
+			prompt += "\n"
+		}
+		prompt += actions
 	}
 
-	prompt += "assistant: \n"
-
+	if prefill && lastRole == types.ChatMessageRoleAssistant {
+		prompt = strings.TrimSuffix(prompt, "\n")
+	} else {
+		prompt += "assistant: \n"
+	}
 	// 合并多个图片 URL，使用逗号分隔
 	imageStr := ""
 	if len(imageUrls) > 0 {
@@ -102,7 +477,7 @@ func convertFromChatOpenai(request *types.ChatCompletionRequest) *ReplicateReque
 		Stream: request.Stream,
 		Input: ReplicateChatRequest{
 			TopP:             request.TopP,
-			MaxTokens:        request.MaxTokens,
+			MaxTokens:        getChatMaxTokens(request),
 			MinTokens:        0,
 			Temperature:      request.Temperature,
 			SystemPrompt:     systemPrompt,
@@ -110,11 +485,127 @@ func convertFromChatOpenai(request *types.ChatCompletionRequest) *ReplicateReque
 			PresencePenalty:  request.PresencePenalty,
 			FrequencyPenalty: request.FrequencyPenalty,
 			Image:            imageStr,
+			Images:           imageUrls,
+			Seed:             request.Seed,
+			StopSequences:    strings.Join(getStopSequences(request.Stop), ","),
 		},
 	}
 }
 
-func (p *ReplicateProvider) convertToChatOpenai(response *ReplicateResponse[[]string]) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+// 渠道插件 message_name.models 中的模型在角色标签中带上消息的 name，* 表示所有模型
+func (p *ReplicateProvider) useMessageNames(modelName string) bool {
+	for _, model := range pluginList(p.getPlugin("message_name"), "models") {
+		if model == "*" || model == modelName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 渠道插件 assistant_prefill.models 中的模型以最后一条 assistant 消息作为输出的开头，* 表示所有模型
+func (p *ReplicateProvider) useAssistantPrefill(modelName string) bool {
+	for _, model := range pluginList(p.getPlugin("assistant_prefill"), "models") {
+		if model == "*" || model == modelName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 渠道插件 merge_messages.models 中的模型在构建提示词前合并连续相同角色的消息，* 表示所有模型
+func (p *ReplicateProvider) useMergeMessages(modelName string) bool {
+	for _, model := range pluginList(p.getPlugin("merge_messages"), "models") {
+		if model == "*" || model == modelName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 合并连续的相同角色消息，文本之间空一行，图片按原顺序保留；
+// name 不同时丢弃 name，工具调用及工具结果保持独立
+func mergeMessages(messages []types.ChatCompletionMessage) []types.ChatCompletionMessage {
+	merged := make([]types.ChatCompletionMessage, 0, len(messages))
+	for _, msg := range messages {
+		last := len(merged) - 1
+		if last < 0 || !canMergeMessage(merged[last]) || !canMergeMessage(msg) || merged[last].Role != msg.Role {
+			merged = append(merged, msg)
+			continue
+		}
+
+		parts, next := toContentList(merged[last]), toContentList(msg)
+		if len(parts) > 0 && len(next) > 0 {
+			parts = append(parts, map[string]any{"type": types.ContentTypeText, "text": "\n\n"})
+		}
+		merged[last].Content = append(parts, next...)
+		if merged[last].Name == nil || msg.Name == nil || *merged[last].Name != *msg.Name {
+			merged[last].Name = nil
+		}
+	}
+
+	return merged
+}
+
+func canMergeMessage(msg types.ChatCompletionMessage) bool {
+	if msg.Role == types.ChatMessageRoleTool || msg.Role == types.ChatMessageRoleFunction {
+		return false
+	}
+	return msg.FunctionCall == nil && len(msg.ToolCalls) == 0
+}
+
+// 转换为 ParseContent 可解析的内容列表
+func toContentList(msg types.ChatCompletionMessage) []any {
+	var parts []any
+	for _, content := range msg.ParseContent() {
+		switch content.Type {
+		case types.ContentTypeText:
+			if content.Text != "" {
+				parts = append(parts, map[string]any{"type": types.ContentTypeText, "text": content.Text})
+			}
+		case types.ContentTypeImageURL:
+			parts = append(parts, map[string]any{"type": types.ContentTypeImageURL, "image_url": map[string]any{"url": content.ImageURL.URL}})
+		}
+	}
+
+	return parts
+}
+
+// 转换为提示词中的角色，tool、function 的结果作为 user 的观察结果，未知角色返回空
+func getTranscriptRole(role string) string {
+	switch role {
+	case types.ChatMessageRoleUser, types.ChatMessageRoleTool, types.ChatMessageRoleFunction:
+		return types.ChatMessageRoleUser
+	case types.ChatMessageRoleAssistant:
+		return types.ChatMessageRoleAssistant
+	default:
+		return ""
+	}
+}
+
+// assistant 发起的工具调用，以 Action 形式写入提示词
+func getTranscriptActions(msg types.ChatCompletionMessage) string {
+	var functions []*types.ChatCompletionToolCallsFunction
+	if msg.FunctionCall != nil {
+		functions = append(functions, msg.FunctionCall)
+	}
+	for _, toolCall := range msg.ToolCalls {
+		if toolCall != nil && toolCall.Function != nil {
+			functions = append(functions, toolCall.Function)
+		}
+	}
+
+	actions := ""
+	for _, function := range functions {
+		actions += fmt.Sprintf("Action: %s(%s)\n", function.Name, function.Arguments)
+	}
+
+	return actions
+}
+
+func (p *ReplicateProvider) convertToChatOpenai(response *ReplicateResponse[ReplicateChatOutput], request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
 
 	responseText := ""
 	if response.Output != nil {
@@ -122,6 +613,22 @@ func (p *ReplicateProvider) convertToChatOpenai(response *ReplicateResponse[[]st
 			responseText += text
 		}
 	}
+	// 上游可能不支持 stop_sequences，本地再截断一次
+	responseText, _ = truncateAtStop(responseText, getStopSequences(request.Stop))
+
+	modelName := p.modelName
+	if modelName == "" {
+		modelName = request.Model
+	}
+	responseText = p.sanitizeOutput(responseText, modelName)
+
+	// 预测成功但没有输出，可能是上游模型异常
+	if responseText == "" && response.Status == predictionSucceeded {
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate prediction %s succeeded with empty output", response.ID))
+		if pluginBool(p.getPlugin("empty_output"), "error") {
+			return nil, common.StringErrorWrapper("Replicate returned an empty completion", "empty_completion", http.StatusBadGateway)
+		}
+	}
 
 	choice := types.ChatCompletionChoice{
 		Index: 0,
@@ -131,13 +638,18 @@ func (p *ReplicateProvider) convertToChatOpenai(response *ReplicateResponse[[]st
 		},
 		FinishReason: types.FinishReasonStop,
 	}
+	if logprobsRequested(request) && response.Logprobs != nil {
+		choice.LogProbs = convertLogprobs(response.Logprobs, request.TopLogProbs)
+	}
 
 	openaiResponse := &types.ChatCompletionResponse{
 		ID:      response.ID,
 		Object:  "chat.completion",
-		Created: utils.GetTimestamp(),
+		Created: response.getCreated(),
 		Choices: []types.ChatCompletionChoice{choice},
-		Model:   response.Model,
+		Model:   request.Model,
+		// 回显实际使用的 seed，便于复现
+		SystemFingerprint: getSystemFingerprint(response.Version, response.Input, response.Logs),
 		Usage: &types.Usage{
 			CompletionTokens: 0,
 			PromptTokens:     0,
@@ -145,107 +657,627 @@ func (p *ReplicateProvider) convertToChatOpenai(response *ReplicateResponse[[]st
 		},
 	}
 
-	p.Usage.PromptTokens = response.Metrics.InputTokenCount
-	p.Usage.CompletionTokens = response.Metrics.OutputTokenCount
-	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+	p.setUsage(response, request.Model, p.getInputPrompt(response.Input, request), responseText)
 	openaiResponse.Usage = p.Usage
 
 	return openaiResponse, nil
 }
 
-func (p *ReplicateProvider) CreateChatCompletionStream(request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
-	url, errWithCode := p.GetSupportedAPIUri(config.RelayModeChatCompletions)
+// 设置用量，上游没有返回 metrics 时使用实际发送的提示词和输出内容估算
+func (p *ReplicateProvider) setUsage(response *ReplicateResponse[ReplicateChatOutput], modelName, inputPrompt, responseText string) {
+	p.Usage.Estimated = false
+
+	p.Usage.PromptTokens, p.Usage.Estimated = p.getPromptTokens(response.ID, response.Metrics.InputTokenCount, inputPrompt, modelName)
+
+	p.Usage.CompletionTokens = response.Metrics.OutputTokenCount
+	if p.Usage.CompletionTokens == 0 && responseText != "" {
+		p.Usage.CompletionTokens = common.CountTokenText(responseText, modelName)
+		p.Usage.Estimated = true
+	}
+
+	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+	if response.Coalesced {
+		p.setCoalescedUsage(response.ID)
+	}
+}
+
+// 获取实际发送给 Replicate 的提示词，优先使用上游回显的 input，否则按相同的方式重新构建
+func (p *ReplicateProvider) getInputPrompt(input map[string]any, request *types.ChatCompletionRequest) string {
+	prompt, _ := input["prompt"].(string)
+	systemPrompt, _ := input["system_prompt"].(string)
+	if prompt == "" {
+		replicateRequest, errWithCode := p.convertFromChatOpenai(request)
+		if errWithCode == nil {
+			return getSentPrompt(&replicateRequest.Input)
+		}
+	}
+
+	return systemPrompt + prompt
+}
+
+func (p *ReplicateProvider) CreateChatCompletionStream(request *types.ChatCompletionRequest) (stream requester.StreamReaderInterface[string], errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateRequest, replicateModel, errWithCode := p.getReplicateChatRequest(request)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
 
-	// 获取请求地址
-	fullRequestURL := p.GetFullRequestURL(url, request.Model)
-	if fullRequestURL == "" {
-		return nil, common.ErrorWrapper(nil, "invalid_recraft_config", http.StatusInternalServerError)
+	chatHandler := &ReplicateStreamHandler{
+		Usage:         p.Usage,
+		ModelName:     request.Model,
+		Provider:      p,
+		StopSequences: getStopSequences(request.Stop),
+		UsageOnly:     p.usageOnlyRequested(),
+		toolCalls:     p.getToolCallAssembler(request),
+	}
+	if chatHandler.UsageOnly && p.usageOnlyEstimate() {
+		return p.createUsageOnlyEstimateStream(replicateRequest, chatHandler)
 	}
 
-	// 获取请求头
-	headers := p.GetRequestHeaders()
+	stream, errWithCode = p.createPredictionStream(replicateRequest, replicateModel, chatHandler)
+	if errWithCode != nil {
+		// 只在开始输出前切换备用模型，流式分块中的 model 为实际提供服务的模型
+		stream, _, errWithCode = withModelFallback(p, request.Model, errWithCode, func(modelName string) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+			fallbackRequest, fallbackModel, errWithCode := p.getFallbackChatRequest(request, modelName)
+			if errWithCode != nil {
+				return nil, errWithCode
+			}
+			chatHandler.ModelName = modelName
+			return p.createPredictionStream(fallbackRequest, fallbackModel, chatHandler)
+		})
+	}
 
-	replicateRequest := convertFromChatOpenai(request)
-	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(replicateRequest), p.Requester.WithHeader(headers))
+	return stream, errWithCode
+}
 
-	if err != nil {
-		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+// 使用备用模型构造请求，不修改原始请求
+func (p *ReplicateProvider) getFallbackChatRequest(request *types.ChatCompletionRequest, modelName string) (*ReplicateRequest[ReplicateChatRequest], *ReplicateModel, *types.OpenAIErrorWithStatusCode) {
+	fallbackRequest := *request
+	fallbackRequest.Model = modelName
+
+	return p.getReplicateChatRequest(&fallbackRequest)
+}
+
+// 创建预测并连接流式输出，chatHandler 的 ID 和开始时间在预测创建后设置
+func (p *ReplicateProvider) createPredictionStream(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel, chatHandler *ReplicateStreamHandler) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	req, errWithCode := p.getChatRequest(replicateRequest, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
 	}
+	defer req.Body.Close()
 
-	replicateResponse := &ReplicateResponse[[]string]{}
+	replicateResponse := &ReplicateResponse[ReplicateChatOutput]{}
 
 	// 发送请求
-	_, errWithCode = p.Requester.SendRequest(req, replicateResponse, false)
+	errWithCode = p.sendRequest(req, replicateResponse)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
+	p.logPrediction(replicateResponse.ID)
+
+	chatHandler.ID = replicateResponse.ID
+	p.recordTraceStream(chatHandler.ID)
+	chatHandler.Created = replicateResponse.getCreated()
+	chatHandler.StartTime = time.Now()
+	chatHandler.MaxDuration = p.getMaxStreamDuration()
+	chatHandler.costCeiling = p.getCostCeiling(chatHandler.ModelName)
+	chatHandler.PacingInterval, chatHandler.PacingMaxDelay = p.getStreamPacing()
+	chatHandler.CoalesceSize, chatHandler.CoalesceWindow = p.getStreamCoalesce()
+	chatHandler.DeltaMode = pluginString(p.getPlugin("stream_delta"), "mode")
+	chatHandler.UnicodePassthrough = pluginBool(p.getPlugin("stream_unicode"), "passthrough")
+	chatHandler.Input = &replicateRequest.Input
+	chatHandler.SystemFingerprint = getStreamFingerprint(replicateResponse, chatHandler.Input)
+	// 提示词用量按实际发送的提示词计算，替换 relay 层按原始消息的估算
+	chatHandler.Usage.PromptTokens = common.CountTokenText(getSentPrompt(chatHandler.Input), chatHandler.ModelName)
+
+	streamUrl, latestResponse, errWithCode := p.getStreamUrl(replicateResponse)
+	if errWithCode != nil {
+		return p.fallbackStream(replicateResponse, chatHandler, errWithCode)
+	}
+
+	if streamUrl == "" {
+		chatHandler.Prediction = latestResponse
+		return requester.RequestEventStream(p.Requester, getCompletedStreamResponse(latestResponse.Output), chatHandler.HandlerChatStream)
+	}
 
+	headers := p.GetRequestHeaders()
 	headers["Accept"] = "text/event-stream"
-	req, err = p.Requester.NewRequest(http.MethodGet, replicateResponse.Urls.Stream, p.Requester.WithHeader(headers))
+	// SSE 不压缩，避免解压时缓冲导致延迟
+	headers["Accept-Encoding"] = "identity"
+	req, err := p.Requester.NewRequest(http.MethodGet, streamUrl, p.Requester.WithHeader(headers))
 
 	if err != nil {
 		return nil, common.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
 	}
 
 	// 发送请求
-	resp, errWithCode := p.Requester.SendRequestRaw(req)
+	resp, errWithCode := p.sendRequestRaw(req)
+	if errWithCode != nil {
+		return p.fallbackStream(replicateResponse, chatHandler, errWithCode)
+	}
+
+	eventStream, errWithCode := requester.RequestEventStream(p.Requester, resp, chatHandler.HandlerChatStream)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
 
-	chatHandler := ReplicateStreamHandler{
-		Usage:     p.Usage,
-		ModelName: request.Model,
-		ID:        replicateResponse.ID,
-		Provider:  p,
+	return &predictionStream{
+		StreamReaderInterface: requester.WithKeepAlive(eventStream, p.getKeepAliveInterval()),
+		handler:               chatHandler,
+	}, nil
+}
+
+// 客户端断开后 relay 关闭流，此时上游可能没有新的事件，预测尚未结束时在关闭时取消
+type predictionStream struct {
+	requester.StreamReaderInterface[string]
+	handler *ReplicateStreamHandler
+}
+
+func (s *predictionStream) Close() {
+	s.StreamReaderInterface.Close()
+	if !s.handler.ended.Load() && s.handler.Provider.isCanceled() {
+		s.handler.cancelPrediction()
+	}
+}
+
+// 流式输出的最长时间，渠道插件 stream_limit.max_duration 配置，单位为秒，0 表示不限制
+func (p *ReplicateProvider) getMaxStreamDuration() time.Duration {
+	seconds := pluginFloat(p.getPlugin("stream_limit"), "max_duration", 0)
+	if seconds <= 0 {
+		return 0
 	}
 
-	return requester.RequestStream(p.Requester, resp, chatHandler.HandlerChatStream)
+	return time.Duration(seconds * float64(time.Second))
 }
 
-func (h *ReplicateStreamHandler) HandlerChatStream(rawLine *[]byte, dataChan chan string, errChan chan error) {
-	if strings.HasPrefix(string(*rawLine), "event: done") {
+// 流式输出限速，渠道插件 stream_pacing.interval 配置相邻分块的最小间隔，max_delay 为累计延迟上限，单位均为毫秒
+func (p *ReplicateProvider) getStreamPacing() (interval, maxDelay time.Duration) {
+	plugin := p.getPlugin("stream_pacing")
+	interval = time.Duration(pluginInt(plugin, "interval", 0)) * time.Millisecond
+	if interval <= 0 {
+		return 0, 0
+	}
+
+	return interval, time.Duration(pluginInt(plugin, "max_delay", defaultPacingMaxDelay)) * time.Millisecond
+}
+
+// 合并流式小分块，渠道插件 stream_coalesce 配置，size 单位为字节，window 单位为毫秒，窗口在收到上游事件时检查
+func (p *ReplicateProvider) getStreamCoalesce() (size int, window time.Duration) {
+	plugin := p.getPlugin("stream_coalesce")
+
+	return pluginInt(plugin, "size", 0), time.Duration(pluginInt(plugin, "window", 0)) * time.Millisecond
+}
+
+// 等待第一个输出时的心跳间隔，渠道插件 stream_keep_alive.enable 开启，interval 单位为秒
+func (p *ReplicateProvider) getKeepAliveInterval() time.Duration {
+	plugin := p.getPlugin("stream_keep_alive")
+	if !pluginBool(plugin, "enable") {
+		return 0
+	}
+
+	if interval := pluginInt(plugin, "interval", 0); interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+
+	return p.KeepAliveInterval
+}
+
+// 流式连接建立失败时，轮询已创建的预测，将完整结果作为一个分块返回
+// 渠道插件 stream_fallback.enable 开启，预测本身失败或客户端断开时不降级
+func (p *ReplicateProvider) fallbackStream(response *ReplicateResponse[ReplicateChatOutput], chatHandler *ReplicateStreamHandler, streamErr *types.OpenAIErrorWithStatusCode) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	if !pluginBool(p.getPlugin("stream_fallback"), "enable") || streamErr.Code == "prediction_failed" || streamErr.Code == "request_canceled" {
+		return nil, streamErr
+	}
+
+	logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate prediction %s stream failed: %s, falling back to polling", response.ID, streamErr.Message))
+
+	predictionResponse, err := getPrediction(p, response)
+	if err != nil || predictionResponse.Status != predictionSucceeded {
+		return nil, streamErr
+	}
+
+	chatHandler.Prediction = predictionResponse
+	output := []string{strings.Join(predictionResponse.Output, "")}
+
+	return requester.RequestEventStream(p.Requester, getCompletedStreamResponse(output), chatHandler.HandlerChatStream)
+}
+
+// 获取流式地址，创建预测时已返回则直接使用，预测还在启动时短暂轮询等待
+// 预测已完成仍没有流式地址，说明模型不支持流式，返回完成的预测
+func (p *ReplicateProvider) getStreamUrl(response *ReplicateResponse[ReplicateChatOutput]) (string, *ReplicateResponse[ReplicateChatOutput], *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL := p.GetFullRequestURL(p.FetchPredictionUrl, response.ID)
+	headers := p.GetRequestHeaders()
+	deadline := time.Now().Add(p.StreamUrlTimeout)
+
+	for {
+		if response.Urls.Stream != "" {
+			return response.Urls.Stream, response, nil
+		}
+
+		switch response.Status {
+		case predictionSucceeded:
+			return "", response, nil
+		case predictionFailed:
+			return "", nil, common.StringErrorWrapper(response.Error, "prediction_failed", http.StatusInternalServerError)
+		case predictionCanceled:
+			return "", nil, common.StringErrorWrapper("prediction was canceled", "prediction_failed", http.StatusInternalServerError)
+		}
+
+		if !time.Now().Before(deadline) {
+			return "", nil, common.StringErrorWrapperLocal("replicate stream is not ready yet, please retry later or without stream", "stream_not_ready", http.StatusServiceUnavailable)
+		}
+
+		if !p.waitPoll() {
+			p.CancelPrediction(response.ID)
+			return "", nil, common.StringErrorWrapperLocal("client closed request", "request_canceled", http.StatusRequestTimeout)
+		}
+
+		req, err := p.Requester.NewRequest(http.MethodGet, fullRequestURL, p.Requester.WithHeader(headers))
+		if err != nil {
+			return "", nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+		}
+
+		predictionResponse := &ReplicateResponse[ReplicateChatOutput]{}
+		if errWithCode := p.sendRequest(req, predictionResponse); errWithCode != nil {
+			return "", nil, errWithCode
+		}
+		response = predictionResponse
+	}
+}
+
+// 不支持流式的模型，将完成的预测输出转换为事件流返回
+func getCompletedStreamResponse(outputs []string) *http.Response {
+	body := ""
+	for _, output := range outputs {
+		event := stream.Event{Event: "output", Data: output}
+		body += event.String()
+	}
+	event := stream.Event{Event: "done", Data: "{}"}
+	body += event.String()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func (h *ReplicateStreamHandler) HandlerChatStream(event *stream.Event, dataChan chan string, errChan chan error) bool {
+	// 客户端已断开或服务正在关闭，取消预测，只计费已返回的内容
+	if h.Prediction == nil && h.Provider.isCanceled() {
+		h.cancelPrediction()
+		h.setPartialUsage()
+		h.finish(dataChan, errChan)
+		return false
+	}
+
+	// 收到上游事件时检查，超过最长时间后不再转发
+	if h.Prediction == nil && h.MaxDuration > 0 && time.Since(h.StartTime) >= h.MaxDuration {
+		h.finishing = true
+		h.sendContent(h.pending+h.takePartialRune(), dataChan)
+		h.pending = ""
+
+		logger.LogWarn(h.Provider.getRequestContext(), fmt.Sprintf("replicate prediction %s exceeded max stream duration %s", h.ID, h.MaxDuration))
+		h.cancelPrediction()
+		h.setUsage(nil)
+		h.finishWithReason(types.FinishReasonLength, dataChan, errChan)
+		return false
+	}
+
+	switch event.Event {
+	case "done":
+		h.finishing = true
+		h.sendContent(h.pending+h.takePartialRune(), dataChan)
+		h.pending = ""
 
 		// 获取用量
-		replicateResponse := getPredictionResponse[[]string](h.Provider, h.ID)
+		replicateResponse := h.Prediction
+		if replicateResponse == nil {
+			replicateResponse = getPredictionResponse[ReplicateChatOutput](h.Provider, h.ID)
+		}
+		h.setUsage(replicateResponse)
+		h.finish(dataChan, errChan)
 
-		h.Usage.PromptTokens = replicateResponse.Metrics.InputTokenCount
-		h.Usage.CompletionTokens = replicateResponse.Metrics.OutputTokenCount
-		h.Usage.TotalTokens = h.Usage.PromptTokens + h.Usage.CompletionTokens
+		return false
+	case "error":
+		h.ended.Store(true)
+		h.flush(dataChan)
+		h.setPartialUsage()
+		h.recordStream()
+		h.Provider.logTrace(h.ID)
+		h.audit("error")
+		errChan <- normalizeError(common.StringErrorWrapper(event.Data, "prediction_failed", http.StatusInternalServerError))
+		return false
+	case "output":
+	default:
+		return true
+	}
+
+	h.Provider.recordTraceBytes(h.ID, len(event.Data))
+	content := h.completeRunes(h.getDelta(event.Data))
+	if content == "" {
+		return true
+	}
+
+	if len(h.StopSequences) == 0 {
+		h.sendContent(content, dataChan)
+		return !h.checkCostCeiling(dataChan, errChan)
+	}
+
+	h.pending += content
+	if text, found := truncateAtStop(h.pending, h.StopSequences); found {
+		h.finishing = true
+		h.sendContent(text, dataChan)
+		h.pending = ""
+
+		// 命中 stop 后不再等待上游结束，用量按已输出内容计算
+		h.setUsage(nil)
+		h.finish(dataChan, errChan)
+		return false
+	}
+
+	size := len(h.pending) - stopPrefixLength(h.pending, h.StopSequences)
+	h.sendContent(h.pending[:size], dataChan)
+	h.pending = h.pending[size:]
+
+	return !h.checkCostCeiling(dataChan, errChan)
+}
+
+func (h *ReplicateStreamHandler) sendContent(content string, dataChan chan string) {
+	if content == "" {
+		return
+	}
+
+	h.ResponseText += content
+	if h.FirstTokenTime.IsZero() {
+		h.recordFirstToken()
+	}
+
+	// 边输出边累计用量，流中断时按已输出的内容计费，正常结束时由 setUsage 修正
+	h.Usage.CompletionTokens += common.CountTokenText(content, h.ModelName)
+	h.Usage.TotalTokens = h.Usage.PromptTokens + h.Usage.CompletionTokens
+	h.Usage.Estimated = true
+	h.Usage.Partial = true
+
+	if h.UsageOnly {
+		return
+	}
+	if h.toolCalls != nil {
+		h.emitPieces(h.toolCalls.feed(content), dataChan)
+		return
+	}
+	h.emit(content, dataChan)
+}
+
+// 发送内容分块，开启合并时先缓冲；第一个分块和流结束前的内容立即发送
+func (h *ReplicateStreamHandler) emit(content string, dataChan chan string) {
+	if h.CoalesceSize <= 0 && h.CoalesceWindow <= 0 {
+		h.pace()
+		dataChan <- h.getStreamChunk(content, nil)
+		return
+	}
+
+	if h.buffered == "" {
+		h.bufferedSince = time.Now()
+	}
+	h.buffered += content
+
+	if h.lastSent.IsZero() || h.finishing ||
+		(h.CoalesceSize > 0 && len(h.buffered) >= h.CoalesceSize) ||
+		(h.CoalesceWindow > 0 && time.Since(h.bufferedSince) >= h.CoalesceWindow) {
+		h.flush(dataChan)
+	}
+}
+
+// 发送缓冲的内容
+func (h *ReplicateStreamHandler) flush(dataChan chan string) {
+	if h.buffered == "" {
+		return
+	}
+
+	content := h.buffered
+	h.buffered = ""
+	h.pace()
+	dataChan <- h.getStreamChunk(content, nil)
+}
+
+// 流式分块的 system_fingerprint，创建预测的响应中没有 seed 时使用发送的 seed
+func getStreamFingerprint(response *ReplicateResponse[ReplicateChatOutput], input *ReplicateChatRequest) string {
+	predictionInput := response.Input
+	if _, ok := getPredictionSeed(predictionInput, ""); !ok && input.Seed != nil {
+		predictionInput = map[string]any{"seed": float64(*input.Seed)}
+	}
+
+	return getSystemFingerprint(response.Version, predictionInput, response.Logs)
+}
+
+// 只发送完整的字符，末尾不完整的 UTF-8 序列留到下一帧，避免分块中出现半个字符
+func (h *ReplicateStreamHandler) completeRunes(content string) string {
+	content = h.takePartialRune() + content
+	if h.UnicodePassthrough {
+		return content
+	}
+
+	size := len(content) - incompleteRuneLength(content)
+	h.partialRune = content[size:]
+
+	return content[:size]
+}
+
+// 流结束时取出剩余的不完整序列，按原样发送
+func (h *ReplicateStreamHandler) takePartialRune() string {
+	partial := h.partialRune
+	h.partialRune = ""
 
-		// 需要有一个stop
-		choice := types.ChatCompletionStreamChoice{
-			Index: 0,
-			Delta: types.ChatCompletionStreamChoiceDelta{
-				Role: types.ChatMessageRoleAssistant,
-			},
-			FinishReason: types.FinishReasonStop,
+	return partial
+}
+
+// 末尾不完整的 UTF-8 序列的字节数，最多检查一个字符的长度
+func incompleteRuneLength(content string) int {
+	for size := 1; size <= min(len(content), utf8.UTFMax-1); size++ {
+		start := len(content) - size
+		if !utf8.RuneStart(content[start]) {
+			continue
+		}
+		if utf8.FullRuneInString(content[start:]) {
+			return 0
+		}
+		return size
+	}
+
+	return 0
+}
+
+// 只返回上游新追加的内容，避免累计输出时重复发送
+func (h *ReplicateStreamHandler) getDelta(content string) string {
+	delta := content
+	switch h.DeltaMode {
+	case streamDeltaCumulative:
+		switch {
+		case strings.HasPrefix(content, h.received):
+			delta = content[len(h.received):]
+		case strings.HasPrefix(h.received, content):
+			// 过期的累计输出
+			delta = ""
+		default:
+			// 与已收到内容的结尾重叠的片段
+			delta = content[getOverlapLength(h.received, content):]
+		}
+	case streamDeltaAuto:
+		if h.received != "" && len(content) > len(h.received) && strings.HasPrefix(content, h.received) {
+			delta = content[len(h.received):]
+		}
+	}
+
+	h.received += delta
+	return delta
+}
+
+// received 的结尾与 content 开头重叠的最大长度
+func getOverlapLength(received, content string) int {
+	for size := min(len(received), len(content)); size > 0; size-- {
+		if strings.HasSuffix(received, content[:size]) {
+			return size
 		}
+	}
 
-		dataChan <- getStreamResponse(h.ID, choice, h.ModelName)
+	return 0
+}
 
-		errChan <- io.EOF
-		*rawLine = requester.StreamClosed
+// 距上一个分块不足 PacingInterval 时等待，累计延迟不超过 PacingMaxDelay，流结束或客户端断开时不等待
+func (h *ReplicateStreamHandler) pace() {
+	defer func() {
+		h.lastSent = time.Now()
+	}()
 
+	if h.PacingInterval <= 0 || h.lastSent.IsZero() || h.finishing {
 		return
 	}
 
-	// 如果rawLine 前缀不为data:，则直接返回
-	if !strings.HasPrefix(string(*rawLine), "data: ") {
-		*rawLine = nil
+	wait := h.PacingInterval - time.Since(h.lastSent)
+	if remaining := h.PacingMaxDelay - h.pacingDelay; wait > remaining {
+		wait = remaining
+	}
+	if wait <= 0 {
 		return
 	}
 
-	// 去除前缀并处理内容
-	*rawLine = (*rawLine)[6:]
-	content := strings.TrimSpace(string(*rawLine))
-	
-	// 处理空内容换行问题
-	if content == "" {
-		content = "\n"
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		h.pacingDelay += wait
+	case <-h.Provider.getRequestContext().Done():
+	case <-shutdownContext().Done():
+	}
+}
+
+// 发送结束标记并关闭流
+func (h *ReplicateStreamHandler) finish(dataChan chan string, errChan chan error) {
+	// 需要有一个stop
+	h.finishWithReason(types.FinishReasonStop, dataChan, errChan)
+}
+
+func (h *ReplicateStreamHandler) finishWithReason(finishReason string, dataChan chan string, errChan chan error) {
+	h.ended.Store(true)
+	if h.toolCalls != nil && !h.UsageOnly {
+		pieces, called := h.toolCalls.finish()
+		h.emitPieces(pieces, dataChan)
+		if called && finishReason == types.FinishReasonStop {
+			finishReason = types.FinishReasonToolCalls
+		}
+	}
+	h.flush(dataChan)
+	if comment := h.Provider.getDebugComment(h.ID); comment != "" {
+		dataChan <- comment
+	}
+	if comment := h.Provider.getTraceComment(h.ID); comment != "" {
+		dataChan <- comment
+	}
+	if h.UsageOnly {
+		dataChan <- h.getUsageOnlyChunk(finishReason)
+	} else {
+		dataChan <- h.getStreamChunk("", finishReason)
+	}
+	h.recordStream()
+	h.Provider.logTrace(h.ID)
+	h.audit(finishReason)
+
+	errChan <- io.EOF
+}
+
+// 取消流式输出的预测，流的处理和关闭都可能触发，只取消一次
+func (h *ReplicateStreamHandler) cancelPrediction() {
+	if h.canceled.CompareAndSwap(false, true) {
+		h.Provider.CancelPrediction(h.ID)
+	}
+}
+
+func (h *ReplicateStreamHandler) recordFirstToken() {
+	h.FirstTokenTime = time.Now()
+	ttft := h.FirstTokenTime.Sub(h.StartTime)
+	metrics.RecordUpstreamFirstToken(metricsProvider, h.Provider.modelName, ttft)
+	h.Provider.recordTraceFirstToken(h.ID, ttft)
+	logger.LogInfo(h.Provider.getRequestContext(), fmt.Sprintf("replicate prediction %s first token after %dms", h.ID, ttft.Milliseconds()))
+}
+
+func (h *ReplicateStreamHandler) recordStream() {
+	metrics.RecordUpstreamStream(metricsProvider, h.Provider.modelName, time.Since(h.StartTime))
+}
+
+// 设置流式用量，上游没有返回 metrics 时使用已输出的内容计算
+func (h *ReplicateStreamHandler) setUsage(response *ReplicateResponse[ReplicateChatOutput]) {
+	// 覆盖输出过程中累计的估算用量
+	h.Usage.Estimated = false
+	h.Usage.Partial = false
+	if response != nil {
+		h.Usage.PromptTokens, h.Usage.Estimated = h.Provider.getPromptTokens(response.ID, response.Metrics.InputTokenCount, getSentPrompt(h.Input), h.ModelName)
+	} else {
+		// 保留创建预测时按实际发送的提示词计算的用量
+		h.Usage.Estimated = true
+	}
+
+	if response != nil && response.Metrics.OutputTokenCount > 0 {
+		h.Usage.CompletionTokens = response.Metrics.OutputTokenCount
+	} else {
+		h.Usage.CompletionTokens = common.CountTokenText(h.ResponseText, h.ModelName)
+		h.Usage.Estimated = true
+	}
+
+	h.Usage.TotalTokens = h.Usage.PromptTokens + h.Usage.CompletionTokens
+}
+
+// completions 返回 text_completion 格式的分块，否则返回 chat.completion.chunk
+func (h *ReplicateStreamHandler) getStreamChunk(content string, finishReason any) string {
+	if h.TextCompletion {
+		reason, _ := finishReason.(string)
+		return getCompletionStreamResponse(h.ID, h.Created, content, reason, h.ModelName)
 	}
 
 	choice := types.ChatCompletionStreamChoice{
@@ -254,18 +1286,26 @@ func (h *ReplicateStreamHandler) HandlerChatStream(rawLine *[]byte, dataChan cha
 			Role:    types.ChatMessageRoleAssistant,
 			Content: content,
 		},
+		FinishReason: finishReason,
 	}
 
-	dataChan <- getStreamResponse(h.ID, choice, h.ModelName)
+	return getStreamResponse(h.ID, h.Created, choice, h.ModelName, h.SystemFingerprint)
+}
+
+// 流中断时按已输出的内容计算用量，提示词用量保持不变
+func (h *ReplicateStreamHandler) setPartialUsage() {
+	h.setUsage(nil)
+	h.Usage.Partial = true
 }
 
-func getStreamResponse(id string, choice types.ChatCompletionStreamChoice, modelName string) string {
+func getStreamResponse(id string, created int64, choice types.ChatCompletionStreamChoice, modelName, systemFingerprint string) string {
 	chatCompletion := types.ChatCompletionStreamResponse{
-		ID:      id,
-		Object:  "chat.completion.chunk",
-		Created: utils.GetTimestamp(),
-		Model:   modelName,
-		Choices: []types.ChatCompletionStreamChoice{choice},
+		ID:                id,
+		Object:            "chat.completion.chunk",
+		Created:           created,
+		Model:             modelName,
+		SystemFingerprint: systemFingerprint,
+		Choices:           []types.ChatCompletionStreamChoice{choice},
 	}
 
 	responseBody, _ := json.Marshal(chatCompletion)
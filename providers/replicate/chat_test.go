@@ -0,0 +1,491 @@
+package replicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestChatRequest(content string) *types.ChatCompletionRequest {
+	return &types.ChatCompletionRequest{
+		Model: "meta/meta-llama-3-70b-instruct",
+		Messages: []types.ChatCompletionMessage{
+			{Role: types.ChatMessageRoleUser, Content: content},
+		},
+	}
+}
+
+func marshalInput(t *testing.T, request *ReplicateRequest[ReplicateChatRequest]) map[string]any {
+	body, err := json.Marshal(request)
+	assert.Nil(t, err)
+
+	result := struct {
+		Input map[string]any `json:"input"`
+	}{}
+	assert.Nil(t, json.Unmarshal(body, &result))
+
+	return result.Input
+}
+
+func TestConvertFromChatOpenaiPassthroughSeed(t *testing.T) {
+	plugin := model.PluginType{
+		"passthrough": {"defaults": `{"seed":42}`},
+	}
+	body := `{"model":"meta/meta-llama-3-70b-instruct","messages":[{"role":"user","content":"hi"}],"repetition_penalty":1.1}`
+	provider := getReplicateProvider("", plugin, strings.NewReader(body))
+
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+
+	input := marshalInput(t, replicateRequest)
+	assert.Equal(t, float64(42), input["seed"])
+	assert.Equal(t, 1.1, input["repetition_penalty"])
+	assert.NotContains(t, input, "messages")
+	assert.NotContains(t, input, "model")
+}
+
+func TestConvertFromChatOpenaiPassthroughCollision(t *testing.T) {
+	plugin := model.PluginType{
+		"passthrough": {"defaults": `{"prompt":"overridden","top_k":50}`},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+
+	input := marshalInput(t, replicateRequest)
+	assert.Equal(t, "user: \nhi\nassistant: \n", input["prompt"])
+	assert.Equal(t, float64(50), input["top_k"])
+}
+
+func TestConvertFromChatOpenaiPassthroughStrict(t *testing.T) {
+	plugin := model.PluginType{
+		"passthrough": {"strict": true, "allowed_keys": "top_k"},
+	}
+
+	body := `{"top_k":50,"foo":"bar"}`
+	provider := getReplicateProvider("", plugin, strings.NewReader(body))
+	_, errWithCode := provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+
+	body = `{"top_k":{"value":50}}`
+	provider = getReplicateProvider("", nil, strings.NewReader(body))
+	_, errWithCode = provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+}
+
+func TestConvertFromChatOpenaiSeed(t *testing.T) {
+	seed := 1234
+	request := getTestChatRequest("hi")
+	request.Seed = &seed
+
+	input := marshalInput(t, convertFromChatOpenai(request, false, false))
+	assert.Equal(t, float64(1234), input["seed"])
+
+	input = marshalInput(t, convertFromChatOpenai(getTestChatRequest("hi"), false, false))
+	assert.NotContains(t, input, "seed")
+}
+
+func TestConvertFromChatOpenaiStructuredSystem(t *testing.T) {
+	request := getTestChatRequest("hi")
+	request.Messages = append([]types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleSystem, Content: []any{
+			map[string]any{"type": "text", "text": "You are helpful."},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/cat.png"}},
+			map[string]any{"type": "text", "text": " Be brief."},
+		}},
+	}, request.Messages...)
+
+	input := marshalInput(t, convertFromChatOpenai(request, false, false))
+	assert.Equal(t, "You are helpful. Be brief.\n", input["system_prompt"])
+	assert.NotContains(t, input, "image")
+	assert.Equal(t, "user: \nhi\nassistant: \n", input["prompt"])
+}
+
+func TestConvertToChatOpenaiSeedFingerprint(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+
+	response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		ID:     "prediction-id",
+		Status: "succeeded",
+		Output: []string{"hello"},
+		Input:  map[string]any{"seed": float64(1234)},
+	}, getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "replicate_seed_1234", response.SystemFingerprint)
+
+	response, _ = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: []string{"hello"},
+		Logs:   "Using seed: 5678\nprompt processed",
+	}, getTestChatRequest("hi"))
+	assert.Equal(t, "replicate_seed_5678", response.SystemFingerprint)
+
+	response, _ = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: []string{"hello"},
+	}, getTestChatRequest("hi"))
+	assert.Empty(t, response.SystemFingerprint)
+}
+
+func TestConvertToChatOpenaiEmptyOutput(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+
+	for _, output := range [][]string{nil, {}} {
+		response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+			ID:     "prediction-id",
+			Status: "succeeded",
+			Output: output,
+		}, getTestChatRequest("hi"))
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, "", response.Choices[0].Message.Content)
+	}
+
+	plugin := model.PluginType{
+		"empty_output": {"error": true},
+	}
+	provider = getReplicateProvider("", plugin, nil)
+
+	for _, output := range [][]string{nil, {}} {
+		_, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+			ID:     "prediction-id",
+			Status: "succeeded",
+			Output: output,
+		}, getTestChatRequest("hi"))
+		assert.NotNil(t, errWithCode)
+		assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+		assert.Equal(t, "empty_completion", errWithCode.Code)
+	}
+
+	response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		ID:     "prediction-id",
+		Status: "succeeded",
+		Output: []string{"hello"},
+	}, getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "hello", response.Choices[0].Message.Content)
+}
+
+func TestConvertToChatOpenaiUsage(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+	request := getTestChatRequest("hello, how are you today?")
+	output := []string{"I am fine, ", "thank you for asking."}
+
+	response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status:  "succeeded",
+		Output:  output,
+		Metrics: ReplicateMetrics{InputTokenCount: 12, OutputTokenCount: 8},
+	}, request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 12, response.Usage.PromptTokens)
+	assert.Equal(t, 8, response.Usage.CompletionTokens)
+	assert.Equal(t, 20, response.Usage.TotalTokens)
+	assert.False(t, response.Usage.Estimated)
+
+	response, errWithCode = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: output,
+	}, request)
+	assert.Nil(t, errWithCode)
+	prompt := convertFromChatOpenai(request, false, false).Input.Prompt
+	assert.Equal(t, common.CountTokenText(prompt, request.Model), response.Usage.PromptTokens)
+	assert.Equal(t, common.CountTokenText(strings.Join(output, ""), request.Model), response.Usage.CompletionTokens)
+	assert.Equal(t, response.Usage.PromptTokens+response.Usage.CompletionTokens, response.Usage.TotalTokens)
+	assert.Greater(t, response.Usage.CompletionTokens, 0)
+	assert.True(t, response.Usage.Estimated)
+
+	// 优先使用上游回显的提示词
+	response, _ = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: output,
+		Input:  map[string]any{"prompt": "short"},
+	}, request)
+	assert.Equal(t, common.CountTokenText("short", request.Model), response.Usage.PromptTokens)
+	assert.True(t, response.Usage.Estimated)
+}
+
+func TestReplicateChatOutputUnmarshal(t *testing.T) {
+	testCases := []struct {
+		name   string
+		body   string
+		output string
+	}{
+		{"string array", `{"output":["Hello"," world"]}`, "Hello world"},
+		{"string", `{"output":"Hello world"}`, "Hello world"},
+		{"object", `{"output":{"text":"Hello world","tokens":["Hello"," world"]}}`, "Hello world"},
+		{"null", `{"output":null}`, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			response := &ReplicateResponse[ReplicateChatOutput]{}
+			assert.Nil(t, json.Unmarshal([]byte(testCase.body), response))
+			assert.Equal(t, testCase.output, strings.Join(response.Output, ""))
+		})
+	}
+
+	response := &ReplicateResponse[ReplicateChatOutput]{}
+	assert.NotNil(t, json.Unmarshal([]byte(`{"output":42}`), response))
+}
+
+func TestCreateChatCompletionStructuredOutput(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":{"text":"structured answer","tokens":["structured"," answer"]}}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "structured answer", response.Choices[0].Message.Content)
+}
+
+func TestConvertFromChatOpenaiToolTranscript(t *testing.T) {
+	name := "get_weather"
+	request := &types.ChatCompletionRequest{
+		Model: "meta/meta-llama-3-70b-instruct",
+		Messages: []types.ChatCompletionMessage{
+			{Role: types.ChatMessageRoleDeveloper, Content: "be brief"},
+			{Role: types.ChatMessageRoleUser, Content: "weather in Paris?"},
+			{Role: types.ChatMessageRoleAssistant, ToolCalls: []*types.ChatCompletionToolCalls{
+				{Id: "call_1", Type: "function", Function: &types.ChatCompletionToolCallsFunction{Name: name, Arguments: `{"city":"Paris"}`}},
+			}},
+			{Role: types.ChatMessageRoleTool, Name: &name, ToolCallID: "call_1", Content: "sunny, 20C"},
+			{Role: types.ChatMessageRoleUser, Content: "and tomorrow?"},
+			{Role: "unknown", Content: "ignored"},
+		},
+	}
+
+	replicateRequest := convertFromChatOpenai(request, false, false)
+	assert.Equal(t, "be brief\n", replicateRequest.Input.SystemPrompt)
+	assert.Equal(t, "user: \nweather in Paris?\n"+
+		"assistant: \nAction: get_weather({\"city\":\"Paris\"})\n"+
+		"user: \nObservation: sunny, 20C\n"+
+		"user: \nand tomorrow?\n"+
+		"assistant: \n", replicateRequest.Input.Prompt)
+}
+
+func TestConvertFromChatOpenaiMessageNames(t *testing.T) {
+	alice, bob, blank := "alice", "bob", " "
+	request := getTestChatRequest("hi")
+	request.Messages = []types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleUser, Name: &alice, Content: "hi"},
+		{Role: types.ChatMessageRoleUser, Name: &bob, Content: "hello"},
+		{Role: types.ChatMessageRoleUser, Content: "anyone?"},
+		{Role: types.ChatMessageRoleUser, Name: &blank, Content: "me"},
+		{Role: types.ChatMessageRoleAssistant, Name: &alice, Content: "Hi"},
+	}
+
+	// 未开启时保持原有的角色标签
+	provider := getReplicateProvider("", nil, nil)
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "user: \nhi\nuser: \nhello\nuser: \nanyone?\nuser: \nme\nassistant: \nHi\nassistant: \n", replicateRequest.Input.Prompt)
+
+	plugin := model.PluginType{
+		"message_name": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider = getReplicateProvider("", plugin, nil)
+	replicateRequest, errWithCode = provider.convertFromChatOpenai(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "user (alice): \nhi\nuser (bob): \nhello\nuser: \nanyone?\nuser: \nme\nassistant (alice): \nHi\nassistant: \n", replicateRequest.Input.Prompt)
+
+	// 其他模型不受影响
+	request.Model = "meta/llama-2-70b-chat"
+	replicateRequest, _ = provider.convertFromChatOpenai(request)
+	assert.Equal(t, "user: \nhi\nuser: \nhello\nuser: \nanyone?\nuser: \nme\nassistant: \nHi\nassistant: \n", replicateRequest.Input.Prompt)
+}
+
+func TestConvertFromChatOpenaiMergeMessages(t *testing.T) {
+	request := getTestChatRequest("")
+	request.Messages = []types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleUser, Content: "first"},
+		{Role: types.ChatMessageRoleUser, Content: []any{
+			map[string]any{"type": "text", "text": "second"},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png"}},
+		}},
+		{Role: types.ChatMessageRoleUser, Content: []any{
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/b.png"}},
+			map[string]any{"type": "text", "text": "third"},
+		}},
+	}
+
+	plugin := model.PluginType{
+		"merge_messages": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(request)
+	assert.Nil(t, errWithCode)
+	// 三条消息合并为一个 user 块
+	assert.Equal(t, "user: \nfirst\n\nsecond\n\nthird\nassistant: \n", replicateRequest.Input.Prompt)
+	assert.Equal(t, 1, strings.Count(replicateRequest.Input.Prompt, "user: \n"))
+	assert.Equal(t, []string{"https://example.com/a.png", "https://example.com/b.png"}, replicateRequest.Input.Images)
+	// 不修改原始请求
+	assert.Len(t, request.Messages, 3)
+
+	// 其他模型每条消息一个 user 块
+	request.Model = "meta/llama-2-70b-chat"
+	replicateRequest, _ = provider.convertFromChatOpenai(request)
+	assert.Equal(t, "user: \nfirst\nuser: \nsecond\nuser: \nthird\nassistant: \n", replicateRequest.Input.Prompt)
+	assert.Equal(t, 3, strings.Count(replicateRequest.Input.Prompt, "user: \n"))
+	assert.Equal(t, []string{"https://example.com/a.png", "https://example.com/b.png"}, replicateRequest.Input.Images)
+
+	// 工具结果不合并，每条单独作为观察结果
+	request.Model = "meta/meta-llama-3-70b-instruct"
+	request.Messages = []types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleUser, Content: "weather?"},
+		{Role: types.ChatMessageRoleTool, ToolCallID: "call_1", Content: "sunny"},
+		{Role: types.ChatMessageRoleTool, ToolCallID: "call_2", Content: "20C"},
+	}
+	replicateRequest, _ = provider.convertFromChatOpenai(request)
+	assert.Equal(t, "user: \nweather?\nuser: \nObservation: sunny\nuser: \nObservation: 20C\nassistant: \n", replicateRequest.Input.Prompt)
+}
+
+func TestMergeMessagesKeepsToolMessages(t *testing.T) {
+	alice, bob := "alice", "bob"
+	messages := mergeMessages([]types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleUser, Name: &alice, Content: "hi"},
+		{Role: types.ChatMessageRoleUser, Name: &bob, Content: "hello"},
+		{Role: types.ChatMessageRoleAssistant, ToolCalls: []*types.ChatCompletionToolCalls{{Id: "call_1", Type: "function"}}},
+		{Role: types.ChatMessageRoleTool, ToolCallID: "call_1", Content: "1"},
+		{Role: types.ChatMessageRoleTool, ToolCallID: "call_2", Content: "2"},
+	})
+
+	assert.Len(t, messages, 4)
+	assert.Nil(t, messages[0].Name)
+	assert.Equal(t, "hi\n\nhello", messages[0].StringContent())
+}
+
+func TestConvertFromChatOpenaiAssistantPrefill(t *testing.T) {
+	request := getTestChatRequest("hi")
+	request.Messages = append(request.Messages, types.ChatCompletionMessage{Role: types.ChatMessageRoleAssistant, Content: "Hello"})
+
+	// 默认追加 assistant 标签，开启后接着最后一条 assistant 消息继续输出
+	replicateRequest := convertFromChatOpenai(request, false, false)
+	assert.Equal(t, "user: \nhi\nassistant: \nHello\nassistant: \n", replicateRequest.Input.Prompt)
+
+	plugin := model.PluginType{
+		"assistant_prefill": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "user: \nhi\nassistant: \nHello", replicateRequest.Input.Prompt)
+
+	// 最后一条不是 assistant 时不受影响
+	replicateRequest, _ = provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.Equal(t, "user: \nhi\nassistant: \n", replicateRequest.Input.Prompt)
+}
+
+func TestConvertToChatOpenaiSanitizeOutput(t *testing.T) {
+	plugin := model.PluginType{
+		"output_sanitize": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	tests := map[string]string{
+		"assistant: \nHello there.\n":       "Hello there.",
+		"  Assistant:Hello":                 "Hello",
+		"\n  Hello  \n":                     "Hello",
+		"assistant is a word I like.":       "assistant is a word I like.",
+		"Assistants: they help.":            "Assistants: they help.",
+		"The assistant: a helpful program.": "The assistant: a helpful program.",
+	}
+
+	for output, expected := range tests {
+		response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+			ID:     "prediction-id",
+			Status: "succeeded",
+			Output: []string{output},
+		}, getTestChatRequest("hi"))
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, expected, response.Choices[0].Message.Content, output)
+	}
+
+	// 未配置的模型保持原样
+	provider = getReplicateProvider("", nil, nil)
+	response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		ID:     "prediction-id",
+		Status: "succeeded",
+		Output: []string{"assistant: Hello "},
+	}, getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "assistant: Hello ", response.Choices[0].Message.Content)
+}
+
+func TestCreateChatCompletionCreated(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","created_at":"2024-05-01T12:00:00.123Z","output":["hi"]}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix(), response.Created)
+
+	// 缺少 created_at 时回退为当前时间
+	response, errWithCode = getReplicateProvider("", nil, nil).convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{ID: "p1", Status: "succeeded", Output: []string{"hi"}}, getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.InDelta(t, time.Now().Unix(), response.Created, 5)
+}
+
+func TestCreateChatCompletionVersionFingerprint(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","version":"5c7854e8","status":"succeeded","input":{"seed":42},"output":["hi"]}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "replicate_v_5c7854e8_seed_42", response.SystemFingerprint)
+
+	response, errWithCode = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Version: "5c7854e8",
+		Status:  "succeeded",
+		Output:  []string{"hello"},
+	}, getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "replicate_v_5c7854e8", response.SystemFingerprint)
+}
+
+func TestCreateChatCompletionStreamFingerprint(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","version":"5c7854e8","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","version":"5c7854e8","status":"succeeded","output":["Hello"]}`)
+		}
+	}
+
+	getFingerprints := func() []string {
+		seed := 42
+		request := getTestChatRequest("hi")
+		request.Stream = true
+		request.Seed = &seed
+
+		provider, _ := getMockProvider(nil, handler)
+		stream, errWithCode := provider.CreateChatCompletionStream(request)
+		assert.Nil(t, errWithCode)
+		chunks, err := readStream(t, stream)
+		assert.Nil(t, err)
+
+		var fingerprints []string
+		for _, chunk := range chunks {
+			fingerprints = append(fingerprints, chunk.SystemFingerprint)
+		}
+		return fingerprints
+	}
+
+	// 每个分块都带有相同的 system_fingerprint，相同输入的结果一致
+	fingerprints := getFingerprints()
+	assert.Equal(t, []string{"replicate_v_5c7854e8_seed_42", "replicate_v_5c7854e8_seed_42"}, fingerprints)
+	assert.Equal(t, fingerprints, getFingerprints())
+}
@@ -0,0 +1,64 @@
+package replicate
+
+import (
+	"fmt"
+	"math"
+	"one-api/common/logger"
+	"one-api/types"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var predictionGroup singleflight.Group
+
+type sharedPrediction struct {
+	response    *ReplicateResponse[ReplicateChatOutput]
+	errWithCode *types.OpenAIErrorWithStatusCode
+}
+
+// 同一渠道上相同的确定性请求同时进行时共享一个上游预测
+func (p *ReplicateProvider) createSharedChatPrediction(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel) (*ReplicateResponse[ReplicateChatOutput], *types.OpenAIErrorWithStatusCode) {
+	hash := getDeterministicRequestHash(replicateRequest, replicateModel)
+	if hash == "" {
+		return p.createChatPrediction(replicateRequest, replicateModel)
+	}
+
+	key := fmt.Sprintf("%d:%s", p.Channel.Id, hash)
+	leader := false
+	value, _, shared := predictionGroup.Do(key, func() (any, error) {
+		leader = true
+		response, errWithCode := p.createChatPrediction(replicateRequest, replicateModel)
+		return &sharedPrediction{response: response, errWithCode: errWithCode}, nil
+	})
+
+	result := value.(*sharedPrediction)
+	if result.errWithCode == nil {
+		if leader {
+			return result.response, nil
+		}
+
+		// 只有发起预测的请求按实际用量计费，其他请求按 setCoalescedUsage 计费
+		response := *result.response
+		response.Coalesced = true
+		return &response, nil
+	}
+
+	// 发起预测的客户端断开导致取消时，其他请求重新创建自己的预测
+	if shared && result.errWithCode.Code == "request_canceled" && p.getRequestContext().Err() == nil {
+		return p.createChatPrediction(replicateRequest, replicateModel)
+	}
+
+	errWithCode := *result.errWithCode
+	return nil, &errWithCode
+}
+
+// 共享预测的请求没有产生上游费用，与命中响应缓存相同，按渠道插件 coalesce.cost_ratio（默认 0）折算用量
+func (p *ReplicateProvider) setCoalescedUsage(predictionId string) {
+	costRatio := pluginFloat(p.getPlugin("coalesce"), "cost_ratio", 0)
+	p.Usage.PromptTokens = int(math.Ceil(float64(p.Usage.PromptTokens) * costRatio))
+	p.Usage.CompletionTokens = int(math.Ceil(float64(p.Usage.CompletionTokens) * costRatio))
+	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+	p.Usage.Cached = true
+
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate prediction %s shared by coalesced request", predictionId))
+}
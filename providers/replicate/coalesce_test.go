@@ -0,0 +1,108 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/model"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionCoalesce(t *testing.T) {
+	var created atomic.Int32
+	release := make(chan struct{})
+	doer := &testDoer{handler: func(req *http.Request) *http.Response {
+		created.Add(1)
+		<-release
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["shared"],"metrics":{"input_token_count":5,"output_token_count":1}}`)
+	}}
+
+	temperature := 0.0
+	seed := 42
+
+	const total = 10
+	var wg sync.WaitGroup
+	results := make([]string, total)
+	usages := make([]int, total)
+	cached := make([]bool, total)
+	for i := 0; i < total; i++ {
+		provider, _ := getMockProvider(nil, nil)
+		provider.Requester.Doer = doer
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			request := getTestChatRequest("coalesce")
+			request.Temperature = &temperature
+			request.Seed = &seed
+
+			response, errWithCode := provider.CreateChatCompletion(request)
+			assert.Nil(t, errWithCode)
+			results[index] = response.Choices[0].Message.Content.(string)
+			usages[index] = provider.Usage.TotalTokens
+			cached[index] = provider.Usage.Cached
+		}(i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), created.Load())
+	// 只有发起预测的请求按实际用量计费，共享结果的请求与命中缓存相同，默认不计费
+	billed := 0
+	for i := 0; i < total; i++ {
+		assert.Equal(t, "shared", results[i])
+		if cached[i] {
+			assert.Equal(t, 0, usages[i])
+			continue
+		}
+		billed++
+		assert.Equal(t, 6, usages[i])
+	}
+	assert.Equal(t, 1, billed)
+}
+
+func TestCreateChatCompletionCoalesceCostRatio(t *testing.T) {
+	provider, _ := getMockProvider(model.PluginType{"coalesce": {"cost_ratio": 0.5}}, nil)
+	provider.Usage.PromptTokens = 5
+	provider.Usage.CompletionTokens = 1
+
+	provider.setCoalescedUsage("p1")
+	assert.Equal(t, 3, provider.Usage.PromptTokens)
+	assert.Equal(t, 1, provider.Usage.CompletionTokens)
+	assert.Equal(t, 4, provider.Usage.TotalTokens)
+	assert.True(t, provider.Usage.Cached)
+}
+
+func TestCreateChatCompletionCoalesceNonDeterministic(t *testing.T) {
+	var created atomic.Int32
+	release := make(chan struct{})
+	doer := &testDoer{handler: func(req *http.Request) *http.Response {
+		created.Add(1)
+		<-release
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		provider, _ := getMockProvider(nil, nil)
+		provider.Requester.Doer = doer
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("coalesce"))
+			assert.Nil(t, errWithCode)
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(3), created.Load())
+}
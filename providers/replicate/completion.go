@@ -0,0 +1,203 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"strings"
+)
+
+// legacy completions，prompt 直接作为模型输入，不拼接对话格式
+func (p *ReplicateProvider) CreateCompletion(request *types.CompletionRequest) (response *types.CompletionResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	idempotencyKey := p.getIdempotencyKey()
+	requestHash := ""
+	if idempotencyKey != "" {
+		requestHash = p.getIdempotencyRequestHash()
+		response, errWithCode := getIdempotentResponse[types.CompletionResponse](p, idempotencyKey, requestHash)
+		if errWithCode != nil || response != nil {
+			return response, errWithCode
+		}
+	}
+
+	response, errWithCode = p.createCompletion(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.setIdempotentResponse(idempotencyKey, requestHash, response)
+
+	return response, nil
+}
+
+func (p *ReplicateProvider) createCompletion(request *types.CompletionRequest) (*types.CompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	replicateRequest, replicateModel, errWithCode := p.getReplicateCompletionRequest(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateResponse, errWithCode := p.createSharedChatPrediction(replicateRequest, replicateModel)
+	if errWithCode != nil {
+		p.audit(&AuditRecord{Model: request.Model, Status: getAuditStatus(nil, errWithCode)}, &replicateRequest.Input)
+		return nil, errWithCode
+	}
+
+	response := p.convertToCompletionOpenai(replicateResponse, request, replicateRequest.Input.Prompt)
+	p.audit(&AuditRecord{Model: request.Model, PredictionId: replicateResponse.ID, Status: getAuditStatus(response.Choices[0].FinishReason, nil)}, &replicateRequest.Input)
+
+	return response, nil
+}
+
+func (p *ReplicateProvider) CreateCompletionStream(request *types.CompletionRequest) (stream requester.StreamReaderInterface[string], errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateRequest, replicateModel, errWithCode := p.getReplicateCompletionRequest(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	chatHandler := &ReplicateStreamHandler{
+		Usage:          p.Usage,
+		ModelName:      request.Model,
+		Provider:       p,
+		StopSequences:  getStopSequences(request.Stop),
+		TextCompletion: true,
+	}
+
+	return p.createPredictionStream(replicateRequest, replicateModel, chatHandler)
+}
+
+func (p *ReplicateProvider) getReplicateCompletionRequest(request *types.CompletionRequest) (*ReplicateRequest[ReplicateChatRequest], *ReplicateModel, *types.OpenAIErrorWithStatusCode) {
+	if request.N > 1 || request.BestOf > 1 {
+		return nil, nil, common.StringErrorWrapperLocal("n and best_of are not supported for replicate completions", "invalid_n", http.StatusBadRequest)
+	}
+
+	prompt, err := getCompletionPrompt(request.Prompt)
+	if err != nil {
+		return nil, nil, common.ErrorWrapperLocal(err, "invalid_prompt", http.StatusBadRequest)
+	}
+
+	replicateModel, errWithCode := p.resolveModel(request.Model)
+	if errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	extraInput, errWithCode := p.getExtraInput()
+	if errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	replicateRequest := convertFromCompletionOpenai(request, prompt)
+	replicateRequest.Input.Extra = extraInput
+	p.applyChannelDefaults(&replicateRequest.Input)
+	replicateRequest.Version = replicateModel.Version
+
+	if errWithCode := p.prepareChatInput(&replicateRequest.Input, replicateModel, request.Model, request.LogitBias); errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	return replicateRequest, replicateModel, nil
+}
+
+// prompt 支持字符串或只有一个元素的字符串数组
+func getCompletionPrompt(prompt any) (string, error) {
+	switch value := prompt.(type) {
+	case string:
+		return value, nil
+	case []any:
+		if len(value) == 1 {
+			if text, ok := value[0].(string); ok {
+				return text, nil
+			}
+		}
+	}
+
+	return "", errors.New("prompt must be a string or an array with a single string")
+}
+
+func convertFromCompletionOpenai(request *types.CompletionRequest, prompt string) *ReplicateRequest[ReplicateChatRequest] {
+	input := ReplicateChatRequest{
+		Prompt:        prompt,
+		MaxTokens:     request.MaxTokens,
+		StopSequences: strings.Join(getStopSequences(request.Stop), ","),
+	}
+
+	// float32 字段未设置时为 0，只转发显式设置的值
+	for _, param := range []struct {
+		value  float32
+		target **float64
+	}{
+		{request.Temperature, &input.Temperature},
+		{request.TopP, &input.TopP},
+		{request.PresencePenalty, &input.PresencePenalty},
+		{request.FrequencyPenalty, &input.FrequencyPenalty},
+	} {
+		if param.value != 0 {
+			value := float64(param.value)
+			*param.target = &value
+		}
+	}
+
+	return &ReplicateRequest[ReplicateChatRequest]{
+		Stream: request.Stream,
+		Input:  input,
+	}
+}
+
+func (p *ReplicateProvider) convertToCompletionOpenai(response *ReplicateResponse[ReplicateChatOutput], request *types.CompletionRequest, prompt string) *types.CompletionResponse {
+	responseText := strings.Join(response.Output, "")
+	// 上游可能不支持 stop_sequences，本地再截断一次
+	responseText, _ = truncateAtStop(responseText, getStopSequences(request.Stop))
+
+	inputPrompt, _ := response.Input["prompt"].(string)
+	if inputPrompt == "" {
+		inputPrompt = prompt
+	}
+	p.setUsage(response, request.Model, inputPrompt, responseText)
+
+	return &types.CompletionResponse{
+		ID:      response.ID,
+		Object:  "text_completion",
+		Created: response.getCreated(),
+		Model:   request.Model,
+		Choices: []types.CompletionChoice{
+			{
+				Text:         responseText,
+				Index:        0,
+				FinishReason: types.FinishReasonStop,
+			},
+		},
+		Usage: p.Usage,
+	}
+}
+
+func getCompletionStreamResponse(id string, created int64, text, finishReason, modelName string) string {
+	completion := types.CompletionResponse{
+		ID:      id,
+		Object:  "text_completion",
+		Created: created,
+		Model:   modelName,
+		Choices: []types.CompletionChoice{
+			{
+				Text:         text,
+				Index:        0,
+				FinishReason: finishReason,
+			},
+		},
+	}
+
+	responseBody, _ := json.Marshal(completion)
+
+	return string(responseBody)
+}
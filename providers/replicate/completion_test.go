@@ -0,0 +1,93 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestCompletionRequest(prompt any) *types.CompletionRequest {
+	return &types.CompletionRequest{
+		Model:     "meta/meta-llama-3-70b-instruct",
+		Prompt:    prompt,
+		MaxTokens: 2000,
+		Stop:      []string{"\n\n"},
+	}
+}
+
+func TestCreateCompletion(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["Paris","\n\nNext"],"metrics":{"input_token_count":5,"output_token_count":2}}`)
+	})
+
+	request := getTestCompletionRequest("The capital of France is")
+	request.Temperature = 0.5
+	response, errWithCode := provider.CreateCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "text_completion", response.Object)
+	assert.Equal(t, "Paris", response.Choices[0].Text)
+	assert.Equal(t, types.FinishReasonStop, response.Choices[0].FinishReason)
+	assert.Equal(t, 7, response.Usage.TotalTokens)
+
+	// prompt 不拼接对话格式
+	body, _ := io.ReadAll(doer.requests[0].Body)
+	input := struct {
+		Input map[string]any `json:"input"`
+	}{}
+	assert.Nil(t, json.Unmarshal(body, &input))
+	assert.Equal(t, "The capital of France is", input.Input["prompt"])
+	assert.Equal(t, 0.5, input.Input["temperature"])
+	assert.Equal(t, "\n\n", input.Input["stop_sequences"])
+	assert.NotContains(t, input.Input, "top_p")
+
+	_, errWithCode = provider.CreateCompletion(getTestCompletionRequest([]any{"a", "b"}))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+}
+
+func TestCreateCompletionStream(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		if req.URL.Host == "stream.replicate.com" {
+			return sseResponse("event: output\ndata: Par\n\nevent: output\ndata: is\n\nevent: done\ndata: {}\n\n")
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","metrics":{"input_token_count":5,"output_token_count":2}}`)
+	})
+
+	request := getTestCompletionRequest([]any{"The capital of France is"})
+	request.Stream = true
+	stream, errWithCode := provider.CreateCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	var chunks []types.CompletionResponse
+	for done := false; !done; {
+		select {
+		case data := <-dataChan:
+			chunk := types.CompletionResponse{}
+			assert.Nil(t, json.Unmarshal([]byte(data), &chunk))
+			chunks = append(chunks, chunk)
+		case err := <-errChan:
+			assert.True(t, errors.Is(err, io.EOF))
+			done = true
+		}
+	}
+
+	assert.Len(t, chunks, 3)
+	text := ""
+	for _, chunk := range chunks {
+		assert.Equal(t, "text_completion", chunk.Object)
+		text += chunk.Choices[0].Text
+	}
+	assert.Equal(t, "Paris", text)
+	assert.Equal(t, types.FinishReasonStop, chunks[2].Choices[0].FinishReason)
+	assert.Equal(t, 7, provider.Usage.TotalTokens)
+}
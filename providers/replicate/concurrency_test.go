@@ -0,0 +1,121 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/model"
+	"one-api/types"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用 go test -race 运行，检查同一请求内的并发预测及跨请求共享的状态
+
+func TestProviderConcurrentFanOutCancel(t *testing.T) {
+	plugin := model.PluginType{
+		"fan_out": {"max_n": "8", "concurrency": "8"},
+	}
+	// 第一次轮询时客户端断开，所有预测同时取消
+	ctx, cancel := context.WithCancel(context.Background())
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		switch req.Method {
+		case http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		default:
+			cancel()
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing"}`)
+		}
+	})
+	provider.Context.Request = provider.Context.Request.WithContext(ctx)
+
+	request := getTestChatRequest("hi")
+	n := 8
+	request.N = &n
+	_, errWithCode := provider.CreateChatCompletion(request)
+	assert.NotNil(t, errWithCode)
+	assert.True(t, provider.Usage.Canceled)
+
+	// 所有预测使用同一个请求 ID
+	requestIds := make(map[string]bool)
+	for _, req := range doer.requests {
+		requestIds[req.Header.Get(requestIdHeader)] = true
+	}
+	assert.Len(t, requestIds, 1)
+}
+
+func TestProviderConcurrentModeration(t *testing.T) {
+	plugin := model.PluginType{
+		"moderation": {"concurrency": "16"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":{"toxicity":0.02}}`)
+	})
+
+	inputs := make([]any, 32)
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("input %d", i)
+	}
+
+	response, errWithCode := provider.CreateModeration(&types.ModerationRequest{Model: "acme/toxicity", Input: inputs})
+	assert.Nil(t, errWithCode)
+	assert.Len(t, response.Results, 32)
+	assert.Equal(t, 32, doer.count(http.MethodPost))
+}
+
+func TestProviderConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	audits := 0
+	SetAuditSink(AuditSinkFunc(func(record *AuditRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		audits++
+	}))
+	defer SetAuditSink(nil)
+
+	plugin := model.PluginType{
+		"audit": {"enable": true},
+	}
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello"],"metrics":{"input_token_count":3,"output_token_count":1}}`)
+		}
+	}
+
+	// 每个请求使用独立的 provider，共享包级别的合并请求及审计输出
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+
+			provider, _ := getMockProvider(plugin, handler)
+			request := getTestChatRequest("hi")
+			if index%2 == 0 {
+				response, errWithCode := provider.CreateChatCompletion(request)
+				if assert.Nil(t, errWithCode) {
+					assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+				}
+				return
+			}
+
+			request.Stream = true
+			stream, errWithCode := provider.CreateChatCompletionStream(request)
+			if assert.Nil(t, errWithCode) {
+				chunks, err := readStream(t, stream)
+				assert.Nil(t, err)
+				assert.Equal(t, "Hello", streamContent(chunks))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 32, audits)
+}
@@ -0,0 +1,67 @@
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/providers/base"
+	"one-api/types"
+	"time"
+)
+
+// 测试渠道连接，只请求账户和模型信息，不创建预测
+func (p *ReplicateProvider) TestConnection(modelName string) (result *base.ConnectionTestResult, errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	result = &base.ConnectionTestResult{}
+	start := time.Now()
+	defer func() {
+		result.Latency = time.Since(start).Milliseconds()
+	}()
+
+	if errWithCode := p.validateAPIKey(); errWithCode != nil {
+		result.Message = errWithCode.Message
+		return result, errWithCode
+	}
+
+	if errWithCode := p.fetchMetadata(p.AccountUrl); errWithCode != nil {
+		result.Message = errWithCode.Message
+		return result, errWithCode
+	}
+	result.AuthOK = true
+
+	replicateModel, errWithCode := p.resolveModel(modelName)
+	if errWithCode != nil {
+		result.Message = errWithCode.Message
+		return result, errWithCode
+	}
+
+	modelUrl := fmt.Sprintf("/v1/models/%s/%s", replicateModel.Owner, replicateModel.Name)
+	if replicateModel.Deployment {
+		modelUrl = fmt.Sprintf("/v1/deployments/%s/%s", replicateModel.Owner, replicateModel.Name)
+	} else if replicateModel.Version != "" {
+		modelUrl += "/versions/" + replicateModel.Version
+	}
+
+	if errWithCode := p.fetchMetadata(modelUrl); errWithCode != nil {
+		if errWithCode.StatusCode == http.StatusNotFound {
+			errWithCode = common.StringErrorWrapperLocal(fmt.Sprintf("model %s not found on replicate", replicateModel.Slug()), "model_not_found", http.StatusNotFound)
+		}
+		result.Message = errWithCode.Message
+		return result, errWithCode
+	}
+	result.ModelExists = true
+
+	return result, nil
+}
+
+func (p *ReplicateProvider) fetchMetadata(requestURL string) *types.OpenAIErrorWithStatusCode {
+	fullRequestURL := p.GetFullRequestURL(requestURL, "")
+	req, err := p.Requester.NewRequest(http.MethodGet, fullRequestURL, p.Requester.WithHeader(p.GetRequestHeaders()))
+	if err != nil {
+		return common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	response := make(map[string]any)
+	return p.sendRequest(req, &response)
+}
@@ -0,0 +1,59 @@
+package replicate
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getConnectionTestHandler(key string) func(req *http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		if req.Header.Get("Authorization") != "Bearer "+key {
+			return jsonResponse(http.StatusUnauthorized, `{"title":"Unauthenticated","detail":"You did not pass a valid authentication token","status":401}`)
+		}
+
+		switch req.URL.Path {
+		case "/v1/account":
+			return jsonResponse(http.StatusOK, `{"type":"user","username":"one-hub"}`)
+		case "/v1/models/meta/meta-llama-3-70b-instruct":
+			return jsonResponse(http.StatusOK, `{"owner":"meta","name":"meta-llama-3-70b-instruct"}`)
+		default:
+			return jsonResponse(http.StatusNotFound, `{"title":"Not found","detail":"Not found.","status":404}`)
+		}
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	provider, doer := getMockProvider(nil, nil)
+	doer.handler = getConnectionTestHandler(provider.Channel.Key)
+
+	result, errWithCode := provider.TestConnection("meta/meta-llama-3-70b-instruct")
+	assert.Nil(t, errWithCode)
+	assert.True(t, result.AuthOK)
+	assert.True(t, result.ModelExists)
+	assert.Equal(t, 0, doer.count(http.MethodPost))
+}
+
+func TestTestConnectionBadKey(t *testing.T) {
+	provider, doer := getMockProvider(nil, nil)
+	doer.handler = getConnectionTestHandler("another-key")
+
+	result, errWithCode := provider.TestConnection("meta/meta-llama-3-70b-instruct")
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusUnauthorized, errWithCode.StatusCode)
+	assert.False(t, result.AuthOK)
+	assert.False(t, result.ModelExists)
+}
+
+func TestTestConnectionUnknownModel(t *testing.T) {
+	provider, doer := getMockProvider(nil, nil)
+	doer.handler = getConnectionTestHandler(provider.Channel.Key)
+
+	result, errWithCode := provider.TestConnection("meta/unknown-model")
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusNotFound, errWithCode.StatusCode)
+	assert.Equal(t, "model_not_found", errWithCode.Code)
+	assert.True(t, result.AuthOK)
+	assert.False(t, result.ModelExists)
+}
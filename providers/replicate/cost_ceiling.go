@@ -0,0 +1,64 @@
+package replicate
+
+import (
+	"fmt"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/model"
+	"one-api/types"
+)
+
+// 流式输出的费用上限，按系统定价和分组倍率计算实时费用，单位与计费的额度相同
+type costCeiling struct {
+	maxQuota    float64
+	inputRatio  float64
+	outputRatio float64
+}
+
+// 渠道插件 cost_ceiling.max_cost（美元）配置单个请求的费用上限，按次计费的模型不限制
+func (p *ReplicateProvider) getCostCeiling(modelName string) *costCeiling {
+	maxCost := pluginFloat(p.getPlugin("cost_ceiling"), "max_cost", 0)
+	if maxCost <= 0 || model.PricingInstance == nil {
+		return nil
+	}
+
+	price := model.PricingInstance.GetPrice(modelName)
+	if price.Type == model.TimesPriceType {
+		return nil
+	}
+
+	groupRatio := 1.0
+	if p.Context != nil {
+		if _, ok := p.Context.Get("group_ratio"); ok {
+			groupRatio = p.Context.GetFloat64("group_ratio")
+		}
+	}
+
+	return &costCeiling{
+		maxQuota:    maxCost * config.QuotaPerUnit,
+		inputRatio:  price.GetInput() * groupRatio,
+		outputRatio: price.GetOutput() * groupRatio,
+	}
+}
+
+func (c *costCeiling) exceeded(usage *types.Usage) bool {
+	quota := float64(usage.PromptTokens)*c.inputRatio + float64(usage.CompletionTokens)*c.outputRatio
+	return quota > c.maxQuota
+}
+
+// 累计费用超过上限时取消预测，以 length 结束流，用量按已输出的内容计算
+func (h *ReplicateStreamHandler) checkCostCeiling(dataChan chan string, errChan chan error) bool {
+	if h.costCeiling == nil || !h.costCeiling.exceeded(h.Usage) {
+		return false
+	}
+
+	h.finishing = true
+	h.pending = ""
+
+	logger.LogWarn(h.Provider.getRequestContext(), fmt.Sprintf("replicate prediction %s exceeded cost ceiling after %d completion tokens", h.ID, h.Usage.CompletionTokens))
+	h.cancelPrediction()
+	h.setUsage(nil)
+	h.finishWithReason(types.FinishReasonLength, dataChan, errChan)
+
+	return true
+}
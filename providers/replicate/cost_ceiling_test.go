@@ -0,0 +1,70 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestPricing(t *testing.T, prices map[string]*model.Price) {
+	original := model.PricingInstance
+	model.PricingInstance = &model.Pricing{Prices: prices}
+	t.Cleanup(func() { model.PricingInstance = original })
+}
+
+func TestCreateChatCompletionStreamCostCeiling(t *testing.T) {
+	// 每个输出 token 为 100000 额度，即 0.2 美元，每个分块约 4 个 token
+	setTestPricing(t, map[string]*model.Price{
+		"meta/meta-llama-3-70b-instruct": {Type: model.TokensPriceType, Input: 0, Output: 100000},
+	})
+
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/cancel"):
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: lorem ipsum \n\nevent: output\ndata: dolor sit am\n\nevent: output\ndata: et consectet\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["lorem ipsum ","dolor sit am","et consectet"]}`)
+		}
+	}
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+
+	// 第二个分块后超过 1 美元，取消预测并以 length 结束
+	provider, doer := getMockProvider(model.PluginType{"cost_ceiling": {"max_cost": "1"}}, handler)
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "lorem ipsum dolor sit am", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonLength, chunks[len(chunks)-1].Choices[0].FinishReason)
+	assert.Equal(t, common.CountTokenText("lorem ipsum dolor sit am", request.Model), provider.Usage.CompletionTokens)
+	assert.True(t, provider.Usage.Canceled)
+
+	canceled := 0
+	for _, req := range doer.requests {
+		if strings.HasSuffix(req.URL.Path, "/v1/predictions/p1/cancel") {
+			canceled++
+		}
+	}
+	assert.Equal(t, 1, canceled)
+
+	// 未超过上限时正常结束
+	provider, _ = getMockProvider(model.PluginType{"cost_ceiling": {"max_cost": "10"}}, handler)
+	stream, errWithCode = provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	chunks, err = readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "lorem ipsum dolor sit amet consectet", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+	assert.False(t, provider.Usage.Canceled)
+}
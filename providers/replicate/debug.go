@@ -0,0 +1,120 @@
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"one-api/model"
+	"strconv"
+	"strings"
+)
+
+const debugHeader = "X-Replicate-Debug"
+
+// 判断令牌所属用户是否为管理员，测试时替换
+var isAdminUser = model.IsAdmin
+
+// 渠道插件 debug.raw_response 开启且请求头 X-Replicate-Debug: true 时请求调试信息
+func (p *ReplicateProvider) debugRequested() bool {
+	if !pluginBool(p.getPlugin("debug"), "raw_response") {
+		return false
+	}
+	if p.Context == nil || p.Context.Request == nil {
+		return false
+	}
+
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(p.Context.GetHeader(debugHeader)))
+	return enabled
+}
+
+// 只有管理员的令牌才返回原始响应，普通用户请求时忽略
+func (p *ReplicateProvider) debugEnabled() bool {
+	if !p.debugRequested() || p.Context == nil {
+		return false
+	}
+
+	return isAdminUser(p.Context.GetInt("id"))
+}
+
+// 记录预测的原始响应，同一预测多次轮询时保留最后一次；不返回调试信息时不记录
+func (p *ReplicateProvider) recordDebugResponse(body []byte) {
+	if !p.debugEnabled() {
+		return
+	}
+
+	var prediction struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &prediction); err != nil || prediction.ID == "" {
+		return
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := json.Compact(buffer, body); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.debugResponses == nil {
+		p.debugResponses = make(map[string]json.RawMessage)
+	}
+	if _, ok := p.debugResponses[prediction.ID]; !ok {
+		p.debugIds = append(p.debugIds, prediction.ID)
+	}
+	p.debugResponses[prediction.ID] = buffer.Bytes()
+}
+
+// 非流式响应的调试信息，单个预测为原始响应，多个预测（n > 1）为原始响应数组
+func (p *ReplicateProvider) getDebugResponse() any {
+	if !p.debugEnabled() {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.debugIds) == 0 {
+		return nil
+	}
+	if len(p.debugIds) == 1 {
+		return p.debugResponses[p.debugIds[0]]
+	}
+
+	responses := make([]json.RawMessage, 0, len(p.debugIds))
+	for _, id := range p.debugIds {
+		responses = append(responses, p.debugResponses[id])
+	}
+
+	return responses
+}
+
+// 非流式响应的调试信息和预测时间线，由 relay 写入响应体的 x_replicate_debug 和 x_replicate_trace
+func (p *ReplicateProvider) GetResponseExtraFields() map[string]any {
+	fields := make(map[string]any)
+	if debug := p.getDebugResponse(); debug != nil {
+		fields["x_replicate_debug"] = debug
+	}
+	if trace := p.getTraceResponse(); trace != nil {
+		fields["x_replicate_trace"] = trace
+	}
+
+	return fields
+}
+
+// 流式响应以 SSE 注释返回原始响应，客户端按规范会忽略注释行
+func (p *ReplicateProvider) getDebugComment(predictionID string) string {
+	if !p.debugEnabled() {
+		return ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	response, ok := p.debugResponses[predictionID]
+	if !ok {
+		return ""
+	}
+
+	return ": x_replicate_debug " + string(response)
+}
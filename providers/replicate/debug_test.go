@@ -0,0 +1,140 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getDebugHandler() func(req *http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{
+  "id": "p1",
+  "status": "succeeded",
+  "output": ["Hello"],
+  "logs": "Using seed: 42",
+  "metrics": {"input_token_count": 3, "output_token_count": 1}
+}`)
+		}
+	}
+}
+
+var debugPlugin = model.PluginType{
+	"debug": {"raw_response": true},
+}
+
+func setAdminUser(t *testing.T, admin bool) {
+	original := isAdminUser
+	isAdminUser = func(userId int) bool { return admin }
+	t.Cleanup(func() { isAdminUser = original })
+}
+
+func TestCreateChatCompletionDebug(t *testing.T) {
+	setAdminUser(t, true)
+
+	provider, _ := getMockProvider(debugPlugin, getDebugHandler())
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+
+	// 原始响应由 relay 写入响应体的 x_replicate_debug，OpenAI 格式的响应不变
+	data, _ := json.Marshal(response)
+	assert.NotContains(t, string(data), "Using seed")
+
+	var debug map[string]any
+	data, _ = json.Marshal(provider.GetResponseExtraFields()["x_replicate_debug"])
+	if assert.Nil(t, json.Unmarshal(data, &debug)) {
+		assert.Equal(t, "p1", debug["id"])
+		assert.Equal(t, "Using seed: 42", debug["logs"])
+	}
+}
+
+func TestCreateChatCompletionDebugDisabled(t *testing.T) {
+	// 未请求调试信息
+	setAdminUser(t, true)
+	provider, _ := getMockProvider(debugPlugin, getDebugHandler())
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, provider.GetResponseExtraFields())
+	assert.Empty(t, provider.debugResponses)
+
+	// 渠道未开启插件时忽略请求头
+	provider, _ = getMockProvider(nil, getDebugHandler())
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, provider.GetResponseExtraFields())
+	assert.Empty(t, provider.debugResponses)
+
+	// 普通用户请求时忽略
+	setAdminUser(t, false)
+	provider, _ = getMockProvider(debugPlugin, getDebugHandler())
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, provider.GetResponseExtraFields())
+	assert.Empty(t, provider.debugResponses)
+}
+
+func readStreamData(t *testing.T, provider *ReplicateProvider) []string {
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	if !assert.Nil(t, errWithCode) {
+		return nil
+	}
+	defer stream.Close()
+
+	var items []string
+	dataChan, errChan := stream.Recv()
+	for {
+		select {
+		case data := <-dataChan:
+			items = append(items, data)
+		case err := <-errChan:
+			assert.True(t, errors.Is(err, io.EOF))
+			return items
+		}
+	}
+}
+
+func TestCreateChatCompletionStreamDebug(t *testing.T) {
+	setAdminUser(t, true)
+
+	provider, _ := getMockProvider(debugPlugin, getDebugHandler())
+	provider.Context.Request.Header.Set(debugHeader, "true")
+
+	// 同时输出的预测时间线见 TestCreateChatCompletionStreamTrace
+	var comments []string
+	for _, item := range readStreamData(t, provider) {
+		if strings.HasPrefix(item, ":") && !strings.HasPrefix(item, ": x_replicate_trace ") {
+			comments = append(comments, item)
+		}
+	}
+	if assert.Len(t, comments, 1) {
+		assert.True(t, strings.HasPrefix(comments[0], ": x_replicate_debug {"))
+		assert.NotContains(t, comments[0], "\n")
+		assert.Contains(t, comments[0], `"logs":"Using seed: 42"`)
+	}
+
+	// 普通用户请求时不输出注释
+	setAdminUser(t, false)
+	provider, _ = getMockProvider(debugPlugin, getDebugHandler())
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	for _, item := range readStreamData(t, provider) {
+		assert.False(t, strings.HasPrefix(item, ":"))
+	}
+}
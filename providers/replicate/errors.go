@@ -0,0 +1,53 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/types"
+)
+
+// 返回给客户端的错误类型，与 OpenAI 的错误类型一致，具体原因保留在 code 中
+const (
+	errorTypeInvalidRequest    = "invalid_request_error"
+	errorTypeAuthentication    = "authentication_error"
+	errorTypePermission        = "permission_error"
+	errorTypeInsufficientQuota = "insufficient_quota"
+	errorTypeRateLimit         = "rate_limit_exceeded"
+	errorTypeTimeout           = "timeout_error"
+	errorTypeAPI               = "api_error"
+)
+
+// 按状态码归类错误类型，其余 4xx 为请求错误，5xx 和未知状态码为上游或服务错误
+func getErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return errorTypeAuthentication
+	case http.StatusPaymentRequired:
+		return errorTypeInsufficientQuota
+	case http.StatusForbidden:
+		return errorTypePermission
+	case http.StatusTooManyRequests:
+		return errorTypeRateLimit
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return errorTypeTimeout
+	case http.StatusNotImplemented:
+		// 不支持的接口属于请求错误，不是上游异常
+		return errorTypeInvalidRequest
+	}
+
+	if statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError {
+		return errorTypeInvalidRequest
+	}
+
+	return errorTypeAPI
+}
+
+// 统一错误类型，code、param 和状态码不变
+func normalizeError(errWithCode *types.OpenAIErrorWithStatusCode) *types.OpenAIErrorWithStatusCode {
+	if errWithCode == nil {
+		return nil
+	}
+
+	errWithCode.Type = getErrorType(errWithCode.StatusCode)
+
+	return errWithCode
+}
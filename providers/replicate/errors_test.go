@@ -0,0 +1,139 @@
+package replicate
+
+import (
+	"errors"
+	"net/http"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetErrorType(t *testing.T) {
+	tests := map[int]string{
+		http.StatusBadRequest:          errorTypeInvalidRequest,
+		http.StatusUnauthorized:        errorTypeAuthentication,
+		http.StatusPaymentRequired:     errorTypeInsufficientQuota,
+		http.StatusForbidden:           errorTypePermission,
+		http.StatusNotFound:            errorTypeInvalidRequest,
+		http.StatusRequestTimeout:      errorTypeTimeout,
+		http.StatusUnprocessableEntity: errorTypeInvalidRequest,
+		http.StatusTooManyRequests:     errorTypeRateLimit,
+		http.StatusInternalServerError: errorTypeAPI,
+		http.StatusNotImplemented:      errorTypeInvalidRequest,
+		http.StatusBadGateway:          errorTypeAPI,
+		http.StatusServiceUnavailable:  errorTypeAPI,
+		http.StatusGatewayTimeout:      errorTypeTimeout,
+		0:                              errorTypeAPI,
+	}
+
+	for statusCode, errorType := range tests {
+		assert.Equal(t, errorType, getErrorType(statusCode), statusCode)
+	}
+	assert.Nil(t, normalizeError(nil))
+}
+
+func TestCreateChatCompletionUpstreamErrorTypes(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		errorType string
+		code      any
+	}{
+		{http.StatusUnauthorized, `{"title":"Unauthenticated","detail":"You did not pass a valid authentication token","status":401}`, errorTypeAuthentication, http.StatusUnauthorized},
+		{http.StatusPaymentRequired, `{"title":"Insufficient credit","detail":"You have insufficient credit to run this model.","status":402}`, errorTypeInsufficientQuota, http.StatusPaymentRequired},
+		{http.StatusForbidden, `{"title":"Forbidden","detail":"You do not have permission to run this model.","status":403}`, errorTypePermission, http.StatusForbidden},
+		{http.StatusNotFound, `{"title":"Not found","detail":"The requested resource could not be found.","status":404}`, errorTypeInvalidRequest, http.StatusNotFound},
+		{http.StatusUnprocessableEntity, `{"title":"Input validation failed","status":422,"invalid_fields":[{"type":"required","field":"input.prompt","description":"prompt is required"}]}`, errorTypeInvalidRequest, "required"},
+		{http.StatusTooManyRequests, `{"title":"Request was throttled.","detail":"Request was throttled.","status":429}`, errorTypeRateLimit, "rate_limit_exceeded"},
+		{http.StatusInternalServerError, `{"title":"Internal server error","status":500}`, errorTypeAPI, http.StatusInternalServerError},
+		{http.StatusBadGateway, `<html>502 Bad Gateway</html>`, errorTypeAPI, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+			return jsonResponse(tt.status, tt.body)
+		})
+
+		_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+		if assert.NotNil(t, errWithCode, tt.status) {
+			assert.Equal(t, tt.status, errWithCode.StatusCode)
+			assert.Equal(t, tt.errorType, errWithCode.Type, tt.status)
+			assert.Equal(t, tt.code, errWithCode.Code, tt.status)
+		}
+	}
+}
+
+func TestCreateChatCompletionLocalErrorTypes(t *testing.T) {
+	// 参数错误
+	provider, _ := getMockProvider(nil, nil)
+	request := getTestChatRequest("hi")
+	n := 100
+	request.N = &n
+	_, errWithCode := provider.CreateChatCompletion(request)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, errorTypeInvalidRequest, errWithCode.Type)
+		assert.Equal(t, "invalid_n", errWithCode.Code)
+	}
+
+	// 渠道密钥错误
+	provider, _ = getMockProvider(nil, nil)
+	provider.Channel.Key = ""
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, errorTypeAuthentication, errWithCode.Type)
+		assert.Equal(t, "invalid_api_key", errWithCode.Code)
+	}
+
+	// 预测失败
+	provider, _ = getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"failed","error":"model crashed"}`)
+	})
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, errorTypeAPI, errWithCode.Type)
+		assert.Equal(t, "prediction_failed", errWithCode.Code)
+	}
+
+	// 不支持的接口
+	_, errWithCode = provider.CreateEmbeddings(&types.EmbeddingRequest{})
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, errorTypeInvalidRequest, errWithCode.Type)
+		assert.Equal(t, "endpoint_not_supported", errWithCode.Code)
+	}
+}
+
+func TestCreateImageGenerationsErrorType(t *testing.T) {
+	provider, _ := getMockProvider(nil, getImageHandler(http.StatusNotFound, nil))
+	_, errWithCode := provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: "b64_json"})
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, errorTypeAPI, errWithCode.Type)
+		assert.Equal(t, "image_download_failed", errWithCode.Code)
+	}
+}
+
+func TestCreateChatCompletionStreamErrorType(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: error\ndata: model crashed\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"failed","error":"model crashed"}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	_, err := readStream(t, stream)
+	var streamErr *types.OpenAIErrorWithStatusCode
+	if assert.True(t, errors.As(err, &streamErr)) {
+		assert.Equal(t, errorTypeAPI, streamErr.Type)
+		assert.Equal(t, "prediction_failed", streamErr.Code)
+		assert.Equal(t, "model crashed", streamErr.Message)
+	}
+}
@@ -0,0 +1,216 @@
+package replicate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"one-api/common"
+	"one-api/common/cache"
+	"one-api/common/image"
+	"one-api/common/logger"
+	"one-api/common/storage"
+	"one-api/common/utils"
+	"one-api/types"
+	"strings"
+	"time"
+)
+
+const defaultFileUploadRetries = 2
+
+// 上传文件到 Replicate，返回可以在预测输入中使用的地址
+// 渠道插件 file_upload.retries 配置上游 5xx、429 时的重试次数，
+// file_upload.ttl 大于 0 时相同内容在 ttl 秒内（不超过文件的过期时间）复用已上传的地址
+func (p *ReplicateProvider) uploadFile(data []byte, filename string) (string, *types.OpenAIErrorWithStatusCode) {
+	plugin := p.getPlugin("file_upload")
+	ttl := pluginInt(plugin, "ttl", 0)
+	cacheKey := ""
+	if ttl > 0 {
+		cacheKey = p.getFileCacheKey(data)
+		if url, err := cache.GetCache[string](cacheKey); err == nil && url != "" {
+			return url, nil
+		}
+	}
+
+	retries := pluginInt(plugin, "retries", defaultFileUploadRetries)
+	for attempt := 0; ; attempt++ {
+		replicateFile, errWithCode := p.sendFile(data, filename)
+		if errWithCode == nil {
+			p.setFileCache(cacheKey, replicateFile, ttl)
+			return replicateFile.Urls.Get, nil
+		}
+
+		if attempt >= retries || !isRetryableUpload(errWithCode) {
+			return "", errWithCode
+		}
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate file upload failed: %s, retrying (%d/%d)", errWithCode.Message, attempt+1, retries))
+		if !p.waitUploadRetry(attempt) {
+			return "", errWithCode
+		}
+	}
+}
+
+func (p *ReplicateProvider) sendFile(data []byte, filename string) (*ReplicateFile, *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL := p.GetFullRequestURL(p.FilesUrl, "")
+
+	var formBody bytes.Buffer
+	builder := p.Requester.CreateFormBuilder(&formBody)
+	if err := builder.CreateFormFileReader("content", bytes.NewReader(data), filename); err != nil {
+		return nil, common.ErrorWrapperLocal(err, "upload_file_failed", http.StatusInternalServerError)
+	}
+	builder.Close()
+
+	headers := p.GetRequestHeaders()
+	req, err := p.Requester.NewRequest(
+		http.MethodPost,
+		fullRequestURL,
+		p.Requester.WithBody(&formBody),
+		p.Requester.WithHeader(headers),
+		p.Requester.WithContentType(builder.FormDataContentType()))
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	req.ContentLength = int64(formBody.Len())
+
+	replicateFile := &ReplicateFile{}
+	if errWithCode := p.sendRequest(req, replicateFile); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	if replicateFile.Urls.Get == "" {
+		return nil, common.StringErrorWrapper("replicate file upload returned no url", "upload_file_failed", http.StatusBadGateway)
+	}
+
+	return replicateFile, nil
+}
+
+// 上游错误（5xx）和限流可以重试，本地错误不重试
+func isRetryableUpload(errWithCode *types.OpenAIErrorWithStatusCode) bool {
+	if errWithCode.LocalError {
+		return false
+	}
+
+	return errWithCode.StatusCode >= http.StatusInternalServerError || errWithCode.StatusCode == http.StatusTooManyRequests
+}
+
+// 按重试次数递增等待，客户端断开或服务关闭时不再重试
+func (p *ReplicateProvider) waitUploadRetry(attempt int) bool {
+	timer := time.NewTimer(p.FileUploadRetryDelay * time.Duration(attempt+1))
+	defer timer.Stop()
+
+	select {
+	case <-p.getRequestContext().Done():
+		return false
+	case <-shutdownContext().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 上传的文件属于 API Key 对应的账号，按渠道和内容哈希缓存
+func (p *ReplicateProvider) getFileCacheKey(data []byte) string {
+	hash := sha256.Sum256(data)
+	return fmt.Sprintf("replicate:file:%d:%s", p.Channel.Id, hex.EncodeToString(hash[:]))
+}
+
+func (p *ReplicateProvider) setFileCache(key string, replicateFile *ReplicateFile, ttl int) {
+	if key == "" {
+		return
+	}
+
+	expiration := time.Duration(ttl) * time.Second
+	if expiresAt, err := time.Parse(time.RFC3339, replicateFile.ExpiresAt); err == nil {
+		expiration = min(expiration, time.Until(expiresAt))
+	}
+	if expiration <= 0 {
+		return
+	}
+
+	if err := cache.SetCache(key, replicateFile.Urls.Get, expiration); err != nil {
+		logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate file cache set failed: %s", err.Error()))
+	}
+}
+
+// 模型需要图片地址时，将 base64 图片上传后替换为地址
+// 渠道插件 image_upload.models 配置需要上传的模型，* 表示所有模型；
+// max_inline_size 配置内联的上限（KB），超过时所有模型都上传，避免预测输入过大
+func (p *ReplicateProvider) resolveImages(input *ReplicateChatRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	if len(input.Images) == 0 {
+		return nil
+	}
+
+	uploadAll := p.requireImageUpload(replicateModel)
+	maxInlineSize := pluginInt(p.getPlugin("image_upload"), "max_inline_size", 0) * 1024
+	if !uploadAll && maxInlineSize <= 0 {
+		return nil
+	}
+
+	uploaded := false
+	images := make([]string, len(input.Images))
+	for index, imageUrl := range input.Images {
+		images[index] = imageUrl
+		if !strings.HasPrefix(imageUrl, "data:") || (!uploadAll && len(imageUrl) <= maxInlineSize) {
+			continue
+		}
+
+		url, errWithCode := p.uploadDataURI(imageUrl)
+		if errWithCode != nil {
+			return errWithCode
+		}
+		images[index] = url
+		uploaded = true
+	}
+
+	if uploaded {
+		input.SourceImages = input.Images
+		input.Images = images
+		input.Image = strings.Join(images, ",")
+	}
+
+	return nil
+}
+
+func (p *ReplicateProvider) requireImageUpload(replicateModel *ReplicateModel) bool {
+	for _, model := range pluginList(p.getPlugin("image_upload"), "models") {
+		if model == "*" || model == replicateModel.Slug() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *ReplicateProvider) uploadDataURI(dataURI string) (string, *types.OpenAIErrorWithStatusCode) {
+	mimeType, encoded, err := image.ParseBase64File(dataURI)
+	if err != nil {
+		return "", common.ErrorWrapperLocal(err, "invalid_image", http.StatusBadRequest)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", common.ErrorWrapperLocal(err, "invalid_image", http.StatusBadRequest)
+	}
+
+	filename := utils.GetUUID()
+	if extensions, _ := mime.ExtensionsByType(mimeType); len(extensions) > 0 {
+		filename += extensions[0]
+	}
+
+	return p.stageFile(data, filename, pluginString(p.getPlugin("image_upload"), "target"))
+}
+
+// 将文件暂存到 Replicate 可以访问的地址，target 为 storage 时上传到存储，否则上传到 Replicate
+func (p *ReplicateProvider) stageFile(data []byte, filename, target string) (string, *types.OpenAIErrorWithStatusCode) {
+	if target == "storage" {
+		if url := storage.Upload(data, filename); url != "" {
+			return url, nil
+		}
+		return "", common.StringErrorWrapperLocal("upload file to storage failed", "upload_file_failed", http.StatusInternalServerError)
+	}
+
+	return p.uploadFile(data, filename)
+}
@@ -0,0 +1,185 @@
+package replicate
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common/cache"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDataURI = "data:image/png;base64,iVBORw0KGgo="
+
+func getTestImageChatRequest(imageUrl string) *types.ChatCompletionRequest {
+	request := getTestChatRequest("")
+	request.Messages[0].Content = []any{
+		map[string]any{"type": "text", "text": "describe"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": imageUrl}},
+	}
+
+	return request
+}
+
+func getPredictionInput(t *testing.T, req *http.Request) map[string]any {
+	body, err := io.ReadAll(req.Body)
+	assert.Nil(t, err)
+
+	result := struct {
+		Input map[string]any `json:"input"`
+	}{}
+	assert.Nil(t, json.Unmarshal(body, &result))
+
+	return result.Input
+}
+
+func TestCreateChatCompletionDataURIPassthrough(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["a cat"]}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestImageChatRequest(testDataURI))
+	assert.Nil(t, errWithCode)
+	assert.Len(t, doer.requests, 1)
+	assert.Equal(t, testDataURI, getPredictionInput(t, doer.requests[0])["image"])
+}
+
+func TestCreateChatCompletionDataURIUpload(t *testing.T) {
+	plugin := model.PluginType{
+		"image_upload": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.URL.Path == "/v1/files" {
+			assert.Nil(t, req.ParseMultipartForm(1<<20))
+			file, header, err := req.FormFile("content")
+			assert.Nil(t, err)
+			assert.True(t, strings.HasSuffix(header.Filename, ".png"))
+			data, _ := io.ReadAll(file)
+			assert.Equal(t, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}, data)
+			return jsonResponse(http.StatusCreated, `{"id":"f1","urls":{"get":"https://api.replicate.com/v1/files/f1"}}`)
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["a cat"]}`)
+	})
+
+	request := getTestImageChatRequest(testDataURI)
+	request.Messages[0].Content = append(request.Messages[0].Content.([]any),
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/dog.png"}})
+
+	_, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Len(t, doer.requests, 2)
+	assert.Equal(t, "/v1/files", doer.requests[0].URL.Path)
+	assert.Equal(t, "https://api.replicate.com/v1/files/f1,https://example.com/dog.png", getPredictionInput(t, doer.requests[1])["image"])
+}
+
+func TestCreateChatCompletionDataURIUploadFailed(t *testing.T) {
+	plugin := model.PluginType{
+		"image_upload": {"models": "*"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusInternalServerError, `{"detail":"upload failed"}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestImageChatRequest(testDataURI))
+	assert.NotNil(t, errWithCode)
+	// 默认重试 2 次，不会创建预测
+	assert.Equal(t, 1+defaultFileUploadRetries, doer.count(http.MethodPost))
+	for _, req := range doer.requests {
+		assert.Equal(t, "/v1/files", req.URL.Path)
+	}
+}
+
+func TestCreateChatCompletionDataURIUploadRetry(t *testing.T) {
+	plugin := model.PluginType{
+		"image_upload": {"models": "*"},
+	}
+	uploads := 0
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.URL.Path == "/v1/files" {
+			uploads++
+			// 每次重试都发送完整的文件
+			assert.Nil(t, req.ParseMultipartForm(1<<20))
+			_, _, err := req.FormFile("content")
+			assert.Nil(t, err)
+			if uploads == 1 {
+				return jsonResponse(http.StatusServiceUnavailable, `{"detail":"try again"}`)
+			}
+			return jsonResponse(http.StatusCreated, `{"id":"f1","urls":{"get":"https://api.replicate.com/v1/files/f1"}}`)
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["a cat"]}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestImageChatRequest(testDataURI))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 2, uploads)
+	assert.Equal(t, "/v1/models/meta/meta-llama-3-70b-instruct/predictions", doer.requests[2].URL.Path)
+	assert.Equal(t, "https://api.replicate.com/v1/files/f1", getPredictionInput(t, doer.requests[2])["image"])
+
+	// 客户端错误不重试
+	provider, doer = getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusBadRequest, `{"detail":"invalid file"}`)
+	})
+	_, errWithCode = provider.CreateChatCompletion(getTestImageChatRequest(testDataURI))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+}
+
+func TestCreateChatCompletionDataURIUploadTTL(t *testing.T) {
+	plugin := model.PluginType{
+		"image_upload": {"models": "*"},
+		"file_upload":  {"ttl": "600"},
+	}
+	handler := func(req *http.Request) *http.Response {
+		if req.URL.Path == "/v1/files" {
+			return jsonResponse(http.StatusCreated, `{"id":"f1","urls":{"get":"https://api.replicate.com/v1/files/f1"},"expires_at":"`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"}`)
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["a cat"]}`)
+	}
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("ttl image"))
+
+	provider, doer := getMockProvider(plugin, handler)
+	cache.DeleteCache(provider.getFileCacheKey([]byte("ttl image")))
+	_, errWithCode := provider.CreateChatCompletion(getTestImageChatRequest(dataURI))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 2, doer.count(http.MethodPost))
+
+	// ttl 内相同内容复用已上传的地址
+	provider, doer = getMockProvider(plugin, handler)
+	_, errWithCode = provider.CreateChatCompletion(getTestImageChatRequest(dataURI))
+	assert.Nil(t, errWithCode)
+	assert.Len(t, doer.requests, 1)
+	assert.Equal(t, "https://api.replicate.com/v1/files/f1", getPredictionInput(t, doer.requests[0])["image"])
+}
+
+func TestCreateChatCompletionDataURIMaxInlineSize(t *testing.T) {
+	plugin := model.PluginType{
+		"image_upload": {"max_inline_size": "1"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.URL.Path == "/v1/files" {
+			return jsonResponse(http.StatusCreated, `{"id":"f1","urls":{"get":"https://api.replicate.com/v1/files/f1"}}`)
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["a cat"]}`)
+	})
+
+	// 小图片保持内联
+	_, errWithCode := provider.CreateChatCompletion(getTestImageChatRequest(testDataURI))
+	assert.Nil(t, errWithCode)
+	assert.Len(t, doer.requests, 1)
+	assert.Equal(t, testDataURI, getPredictionInput(t, doer.requests[0])["image"])
+
+	// 超过上限的图片上传后使用地址
+	largeDataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0}, 2048))
+	_, errWithCode = provider.CreateChatCompletion(getTestImageChatRequest(largeDataURI))
+	assert.Nil(t, errWithCode)
+	assert.Len(t, doer.requests, 3)
+	assert.Equal(t, "/v1/files", doer.requests[1].URL.Path)
+	assert.Equal(t, "https://api.replicate.com/v1/files/f1", getPredictionInput(t, doer.requests[2])["image"])
+}
@@ -0,0 +1,111 @@
+package replicate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/cache"
+	"one-api/common/logger"
+	"one-api/types"
+	"strings"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	defaultIdempotencyTTL = 86400
+)
+
+// 幂等请求的记录，保存首次请求的哈希及响应
+type idempotencyRecord struct {
+	RequestHash string          `json:"request_hash"`
+	Response    json.RawMessage `json:"response"`
+}
+
+// 获取幂等请求的缓存 key，按令牌区分，未携带 Idempotency-Key 时返回空
+func (p *ReplicateProvider) getIdempotencyKey() string {
+	if p.Context == nil || p.Context.Request == nil {
+		return ""
+	}
+
+	key := strings.TrimSpace(p.Context.GetHeader(idempotencyKeyHeader))
+	tokenId := p.Context.GetInt("token_id")
+	if key == "" || tokenId == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("replicate:idempotency:%d:%s", tokenId, hex.EncodeToString(hash[:]))
+}
+
+// 原始请求体的哈希，用于判断相同的 key 是否用于不同的请求
+func (p *ReplicateProvider) getIdempotencyRequestHash() string {
+	body, err := p.getRequestBody()
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+// 相同的 key 已有响应时直接返回，不再创建预测，重放的响应不计费
+// key 用于不同的请求参数时返回 422
+func getIdempotentResponse[T any](p *ReplicateProvider, key, requestHash string) (*T, *types.OpenAIErrorWithStatusCode) {
+	if key == "" {
+		return nil, nil
+	}
+
+	body, err := cache.GetCache[string](key)
+	if err != nil {
+		return nil, nil
+	}
+
+	record := &idempotencyRecord{}
+	if err := json.Unmarshal([]byte(body), record); err != nil {
+		return nil, nil
+	}
+
+	if record.RequestHash != requestHash {
+		return nil, common.StringErrorWrapperLocal("Keys for idempotent requests can only be used with the same parameters they were first used with.", "idempotency_key_reused", http.StatusUnprocessableEntity)
+	}
+
+	response := new(T)
+	if err := json.Unmarshal(record.Response, response); err != nil {
+		return nil, nil
+	}
+
+	p.Usage.PromptTokens = 0
+	p.Usage.CompletionTokens = 0
+	p.Usage.TotalTokens = 0
+	p.Usage.Cached = true
+
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate idempotent response replayed: %s", key))
+
+	return response, nil
+}
+
+// 保存响应，渠道插件 idempotency.ttl 配置保存时间（秒）
+func (p *ReplicateProvider) setIdempotentResponse(key, requestHash string, response any) {
+	if key == "" {
+		return
+	}
+
+	responseBody, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+
+	body, err := json.Marshal(&idempotencyRecord{RequestHash: requestHash, Response: responseBody})
+	if err != nil {
+		return
+	}
+
+	ttl := pluginInt(p.getPlugin("idempotency"), "ttl", defaultIdempotencyTTL)
+	if err := cache.SetCache(key, string(body), time.Duration(ttl)*time.Second); err != nil {
+		logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate idempotency cache set failed: %s", err.Error()))
+	}
+}
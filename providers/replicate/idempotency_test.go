@@ -0,0 +1,94 @@
+package replicate
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getIdempotentProvider(plugin model.PluginType, key, body string) (*ReplicateProvider, *testDoer) {
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["first answer"],"metrics":{"input_token_count":5,"output_token_count":3}}`)
+	})
+	provider.Context.Request.Header.Set(idempotencyKeyHeader, key)
+	provider.Context.Request.Body = io.NopCloser(strings.NewReader(body))
+	provider.Context.Set("token_id", 1)
+
+	return provider, doer
+}
+
+func TestCreateChatCompletionIdempotencyKey(t *testing.T) {
+	// 每次运行使用不同的 key，避免命中上次运行的缓存
+	key := fmt.Sprintf("idempotency-%d", time.Now().UnixNano())
+	body := `{"model":"meta/meta-llama-3-70b-instruct","messages":[{"role":"user","content":"hi"}]}`
+
+	provider, doer := getIdempotentProvider(nil, key, body)
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "first answer", response.Choices[0].Message.Content)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+	assert.False(t, provider.Usage.Cached)
+
+	// 相同 key 返回保存的响应，不创建预测也不计费
+	provider, doer = getIdempotentProvider(nil, key, body)
+	replayed, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, response.ID, replayed.ID)
+	assert.Equal(t, "first answer", replayed.Choices[0].Message.Content)
+	assert.Equal(t, 0, doer.count(http.MethodPost))
+	assert.True(t, provider.Usage.Cached)
+	assert.Equal(t, 0, provider.Usage.TotalTokens)
+
+	// 其他令牌使用相同的 key 不受影响
+	provider, doer = getIdempotentProvider(nil, key, body)
+	provider.Context.Set("token_id", 2)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+
+	// 相同 key 用于不同的请求参数
+	provider, doer = getIdempotentProvider(nil, key, strings.Replace(body, "hi", "hello", 1))
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hello"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, errWithCode.StatusCode)
+	assert.Equal(t, "idempotency_key_reused", errWithCode.Code)
+	assert.Equal(t, 0, doer.count(http.MethodPost))
+}
+
+func TestCreateChatCompletionIdempotencyKeyExpired(t *testing.T) {
+	plugin := model.PluginType{"idempotency": {"ttl": float64(1)}}
+	key := fmt.Sprintf("idempotency-expired-%d", time.Now().UnixNano())
+	body := `{"model":"meta/meta-llama-3-70b-instruct","messages":[{"role":"user","content":"hi"}]}`
+
+	provider, doer := getIdempotentProvider(plugin, key, body)
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+
+	time.Sleep(2 * time.Second)
+
+	provider, doer = getIdempotentProvider(plugin, key, body)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+	assert.False(t, provider.Usage.Cached)
+}
+
+func TestCreateCompletionIdempotencyKey(t *testing.T) {
+	key := fmt.Sprintf("idempotency-completion-%d", time.Now().UnixNano())
+	body := `{"model":"meta/meta-llama-3-70b-instruct","prompt":"hi"}`
+
+	for i, posts := range []int{1, 0} {
+		provider, doer := getIdempotentProvider(nil, key, body)
+		response, errWithCode := provider.CreateCompletion(getTestCompletionRequest("hi"))
+		assert.Nil(t, errWithCode, i)
+		assert.Equal(t, "first answer", response.Choices[0].Text)
+		assert.Equal(t, posts, doer.count(http.MethodPost))
+	}
+}
@@ -1,29 +1,52 @@
 package replicate
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"one-api/common"
 	"one-api/common/config"
+	"one-api/common/logger"
 	"one-api/types"
-	"time"
+	"strings"
 )
 
-func (p *ReplicateProvider) CreateImageGenerations(request *types.ImageRequest) (*types.ImageResponse, *types.OpenAIErrorWithStatusCode) {
-	url, errWithCode := p.GetSupportedAPIUri(config.RelayModeImagesGenerations)
+const (
+	imageFormatURL = "url"
+	imageFormatB64 = "b64_json"
+)
+
+func (p *ReplicateProvider) CreateImageGenerations(request *types.ImageRequest) (response *types.ImageResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateModel, errWithCode := p.resolveModel(request.Model)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
 
 	// 获取请求地址
-	fullRequestURL := p.GetFullRequestURL(url, request.Model)
+	fullRequestURL, errWithCode := p.getPredictionURL(config.RelayModeImagesGenerations, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
 	if fullRequestURL == "" {
-		return nil, common.ErrorWrapper(nil, "invalid_recraft_config", http.StatusInternalServerError)
+		return nil, common.ErrorWrapper(nil, "invalid_replicate_config", http.StatusInternalServerError)
 	}
 
 	// 获取请求头
 	headers := p.GetRequestHeaders()
+	p.setPreferWait(headers)
 
+	returnURL, returnB64, outputFormat := parseImageResponseFormat(request.ResponseFormat)
 	replicateRequest := convertFromIamgeOpenai(request)
+	replicateRequest.Input.OutputFormat = outputFormat
+	replicateRequest.Version = replicateModel.Version
 	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(replicateRequest), p.Requester.WithHeader(headers))
 
 	if err != nil {
@@ -33,14 +56,15 @@ func (p *ReplicateProvider) CreateImageGenerations(request *types.ImageRequest)
 	replicateResponse := &ReplicateResponse[string]{}
 
 	// 发送请求
-	_, errWithCode = p.Requester.SendRequest(req, replicateResponse, false)
+	errWithCode = p.sendRequest(req, replicateResponse)
 	if errWithCode != nil {
 		return nil, errWithCode
 	}
+	p.logPrediction(replicateResponse.ID)
 
 	replicateResponse, err = getPrediction(p, replicateResponse)
 	if err != nil {
-		return nil, common.ErrorWrapper(err, "prediction_failed", http.StatusInternalServerError)
+		return nil, predictionErrorWrapper(err)
 	}
 
 	if replicateResponse.Output == "" {
@@ -49,14 +73,34 @@ func (p *ReplicateProvider) CreateImageGenerations(request *types.ImageRequest)
 
 	p.Usage.TotalTokens = p.Usage.PromptTokens
 
-	return p.convertToImageOpenai(replicateResponse)
+	return p.convertToImageOpenai(replicateResponse, returnURL, returnB64)
+}
+
+// response_format 为 url、b64_json 或同时返回两者的 url,b64_json，
+// 其他值（如 webp、png）作为 Replicate 的 output_format，并返回 url
+func parseImageResponseFormat(responseFormat string) (returnURL, returnB64 bool, outputFormat string) {
+	if responseFormat == "" {
+		return true, false, ""
+	}
+
+	for _, format := range strings.Split(responseFormat, ",") {
+		switch strings.TrimSpace(format) {
+		case imageFormatURL:
+			returnURL = true
+		case imageFormatB64:
+			returnB64 = true
+		default:
+			return true, false, responseFormat
+		}
+	}
+
+	return returnURL, returnB64, ""
 }
 
 func convertFromIamgeOpenai(request *types.ImageRequest) *ReplicateRequest[ReplicateImageRequest] {
 	replicateRequest := &ReplicateRequest[ReplicateImageRequest]{
 		Input: ReplicateImageRequest{
 			Prompt:           request.Prompt,
-			OutputFormat:     request.ResponseFormat,
 			Size:             request.Size,
 			AspectRatio:      request.AspectRatio,
 			OutputQuality:    request.OutputQuality,
@@ -67,15 +111,61 @@ func convertFromIamgeOpenai(request *types.ImageRequest) *ReplicateRequest[Repli
 	return replicateRequest
 }
 
-func (p *ReplicateProvider) convertToImageOpenai(response *ReplicateResponse[string]) (*types.ImageResponse, *types.OpenAIErrorWithStatusCode) {
+func (p *ReplicateProvider) convertToImageOpenai(response *ReplicateResponse[string], returnURL, returnB64 bool) (*types.ImageResponse, *types.OpenAIErrorWithStatusCode) {
+	data := types.ImageResponseDataInner{}
+	if returnURL {
+		data.URL = response.Output
+	}
+
+	// Replicate 的输出地址会过期，需要 base64 时在请求内下载
+	if returnB64 {
+		image, err := p.downloadImage(response.Output)
+		if err != nil {
+			logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate prediction %s image download failed: %s", response.ID, err.Error()))
+			// 图片已生成，下载失败不重试其他渠道
+			return nil, common.ErrorWrapperLocal(fmt.Errorf("prediction %s succeeded but the image could not be downloaded: %w", response.ID, err), "image_download_failed", http.StatusBadGateway)
+		}
+		data.B64JSON = base64.StdEncoding.EncodeToString(image)
+	}
+
 	openaiResponse := &types.ImageResponse{
-		Created: time.Now().Unix(),
-		Data: []types.ImageResponseDataInner{
-			{
-				URL: response.Output,
-			},
-		},
+		Created: response.getCreated(),
+		Data:    []types.ImageResponseDataInner{data},
 	}
 
 	return openaiResponse, nil
 }
+
+// 下载生成的图片，Replicate 文件接口的地址需要认证
+func (p *ReplicateProvider) downloadImage(imageUrl string) ([]byte, error) {
+	if imageUrl == "" {
+		return nil, errors.New("prediction output is empty")
+	}
+
+	headers := map[string]string{}
+	if strings.HasPrefix(imageUrl, strings.TrimSuffix(p.GetBaseURL(), "/")+"/") {
+		headers = p.GetRequestHeaders()
+	}
+
+	req, err := p.Requester.NewRequest(http.MethodGet, imageUrl, p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, errWithCode := p.Requester.SendRequestRaw(req)
+	if errWithCode != nil {
+		return nil, errors.New(errWithCode.Message)
+	}
+	defer resp.Body.Close()
+
+	limitResponseBody(resp)
+	image, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(image) == 0 {
+		return nil, errors.New("image is empty")
+	}
+
+	return image, nil
+}
@@ -0,0 +1,86 @@
+package replicate
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testImageUrl = "https://replicate.delivery/pbxt/p1/out-0.webp"
+
+func getImageHandler(downloadStatus int, body *string) func(req *http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Host == "replicate.delivery":
+			return &http.Response{
+				StatusCode: downloadStatus,
+				Header:     http.Header{"Content-Type": []string{"image/webp"}},
+				Body:       io.NopCloser(strings.NewReader("image-bytes")),
+			}
+		case req.Method == http.MethodPost:
+			if body != nil {
+				data, _ := io.ReadAll(req.Body)
+				*body = string(data)
+			}
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":"`+testImageUrl+`"}`)
+		}
+	}
+}
+
+func TestCreateImageGenerationsURL(t *testing.T) {
+	var body string
+	for _, responseFormat := range []string{"", "url"} {
+		provider, doer := getMockProvider(nil, getImageHandler(http.StatusOK, &body))
+		response, errWithCode := provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: responseFormat})
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, testImageUrl, response.Data[0].URL)
+		assert.Empty(t, response.Data[0].B64JSON)
+
+		// 不下载图片，response_format 不作为 output_format 发送
+		for _, req := range doer.requests {
+			assert.NotEqual(t, "replicate.delivery", req.URL.Host)
+		}
+		assert.NotContains(t, body, "output_format")
+	}
+
+	// 其他值作为 Replicate 的 output_format
+	provider, _ := getMockProvider(nil, getImageHandler(http.StatusOK, &body))
+	response, errWithCode := provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: "webp"})
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, testImageUrl, response.Data[0].URL)
+	assert.Contains(t, body, `"output_format":"webp"`)
+}
+
+func TestCreateImageGenerationsB64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("image-bytes"))
+
+	provider, _ := getMockProvider(nil, getImageHandler(http.StatusOK, nil))
+	response, errWithCode := provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: "b64_json"})
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, response.Data[0].URL)
+	assert.Equal(t, encoded, response.Data[0].B64JSON)
+
+	// 同时返回 url 和 base64
+	provider, _ = getMockProvider(nil, getImageHandler(http.StatusOK, nil))
+	response, errWithCode = provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: "url,b64_json"})
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, testImageUrl, response.Data[0].URL)
+	assert.Equal(t, encoded, response.Data[0].B64JSON)
+}
+
+func TestCreateImageGenerationsDownloadFailed(t *testing.T) {
+	provider, _ := getMockProvider(nil, getImageHandler(http.StatusNotFound, nil))
+	_, errWithCode := provider.CreateImageGenerations(&types.ImageRequest{Model: "black-forest-labs/flux-schnell", Prompt: "a cat", ResponseFormat: "b64_json"})
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "image_download_failed", errWithCode.Code)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+	assert.True(t, errWithCode.LocalError)
+	assert.Contains(t, errWithCode.Message, "p1")
+}
@@ -0,0 +1,141 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"sort"
+)
+
+// 支持 logprobs 的模型输出 {"text": "...", "logprobs": [...]}，每个元素为一个 token 的概率，
+// top_logprobs 兼容 token 到 logprob 的对象以及 token/logprob 数组
+type ReplicateTokenLogprob struct {
+	Token       string               `json:"token"`
+	Logprob     float64              `json:"logprob"`
+	TopLogprobs ReplicateTopLogprobs `json:"top_logprobs,omitempty"`
+}
+
+type ReplicateTopLogprobs []ReplicateTokenLogprob
+
+func (l *ReplicateTopLogprobs) UnmarshalJSON(data []byte) error {
+	var list []ReplicateTokenLogprob
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+
+	var object map[string]float64
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	*l = make(ReplicateTopLogprobs, 0, len(object))
+	for token, logprob := range object {
+		*l = append(*l, ReplicateTokenLogprob{Token: token, Logprob: logprob})
+	}
+	// 对象没有顺序，按概率从高到低排列
+	sort.Slice(*l, func(i, j int) bool {
+		if (*l)[i].Logprob != (*l)[j].Logprob {
+			return (*l)[i].Logprob > (*l)[j].Logprob
+		}
+		return (*l)[i].Token < (*l)[j].Token
+	})
+
+	return nil
+}
+
+// OpenAI 格式的 logprobs
+type ChatLogprobs struct {
+	Content []ChatTokenLogprob `json:"content"`
+}
+
+type ChatTokenLogprob struct {
+	Token       string             `json:"token"`
+	Logprob     float64            `json:"logprob"`
+	Bytes       []int              `json:"bytes"`
+	TopLogprobs []ChatTokenLogprob `json:"top_logprobs"`
+}
+
+// OpenAI 允许的 top_logprobs 上限
+const maxTopLogprobs = 20
+
+// 请求 logprobs 时，只有渠道插件 logprobs.models 中的模型支持，* 表示所有模型，其他模型返回 400；
+// 流式输出不支持 logprobs
+func (p *ReplicateProvider) applyLogprobs(input *ReplicateChatRequest, request *types.ChatCompletionRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	if !logprobsRequested(request) {
+		return nil
+	}
+
+	if request.TopLogProbs < 0 || request.TopLogProbs > maxTopLogprobs {
+		return logprobsErrorWrapper(fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs), "invalid_parameter", "top_logprobs")
+	}
+
+	supported := false
+	for _, model := range pluginList(p.getPlugin("logprobs"), "models") {
+		if model == "*" || model == replicateModel.Slug() {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return logprobsErrorWrapper(fmt.Sprintf("logprobs is not supported for model %s", replicateModel.Slug()), "logprobs_not_supported", "logprobs")
+	}
+	if request.Stream {
+		return logprobsErrorWrapper(fmt.Sprintf("logprobs is not supported when stream is true for model %s", replicateModel.Slug()), "logprobs_not_supported", "logprobs")
+	}
+
+	if input.Extra == nil {
+		input.Extra = make(map[string]any)
+	}
+	input.Extra["logprobs"] = true
+	if request.TopLogProbs > 0 {
+		input.Extra["top_logprobs"] = request.TopLogProbs
+	}
+
+	return nil
+}
+
+func logprobsRequested(request *types.ChatCompletionRequest) bool {
+	return request.LogProbs != nil && *request.LogProbs
+}
+
+func logprobsErrorWrapper(message, code, param string) *types.OpenAIErrorWithStatusCode {
+	errWithCode := common.StringErrorWrapperLocal(message, code, http.StatusBadRequest)
+	errWithCode.Type = errorTypeInvalidRequest
+	errWithCode.Param = param
+
+	return errWithCode
+}
+
+// 转换为 OpenAI 格式，top_logprobs 最多保留请求的数量
+func convertLogprobs(logprobs []ReplicateTokenLogprob, topLogprobs int) *ChatLogprobs {
+	result := &ChatLogprobs{Content: make([]ChatTokenLogprob, 0, len(logprobs))}
+	for _, logprob := range logprobs {
+		item := convertTokenLogprob(logprob)
+		item.TopLogprobs = []ChatTokenLogprob{}
+		for i, top := range logprob.TopLogprobs {
+			if i >= topLogprobs {
+				break
+			}
+			item.TopLogprobs = append(item.TopLogprobs, convertTokenLogprob(top))
+		}
+		result.Content = append(result.Content, item)
+	}
+
+	return result
+}
+
+func convertTokenLogprob(logprob ReplicateTokenLogprob) ChatTokenLogprob {
+	bytes := make([]int, 0, len(logprob.Token))
+	for _, b := range []byte(logprob.Token) {
+		bytes = append(bytes, int(b))
+	}
+
+	return ChatTokenLogprob{
+		Token:   logprob.Token,
+		Logprob: logprob.Logprob,
+		Bytes:   bytes,
+	}
+}
@@ -0,0 +1,92 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionLogprobs(t *testing.T) {
+	var input map[string]any
+	plugin := model.PluginType{
+		"logprobs": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider, _ := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			input = getPredictionInput(t, req)
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":{"text":"Hi!","logprobs":[
+			{"token":"Hi","logprob":-0.1,"top_logprobs":{"Hello":-2.5,"Hi":-0.1,"Hey":-3}},
+			{"token":"!","logprob":-0.5,"top_logprobs":[{"token":"!","logprob":-0.5},{"token":".","logprob":-1.2}]}
+		]}}`)
+	})
+
+	logprobs := true
+	request := getTestChatRequest("hi")
+	request.LogProbs = &logprobs
+	request.TopLogProbs = 2
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, true, input["logprobs"])
+	assert.Equal(t, float64(2), input["top_logprobs"])
+	assert.Equal(t, "Hi!", response.Choices[0].Message.Content)
+
+	assert.Equal(t, &ChatLogprobs{Content: []ChatTokenLogprob{
+		{
+			Token:   "Hi",
+			Logprob: -0.1,
+			Bytes:   []int{72, 105},
+			TopLogprobs: []ChatTokenLogprob{
+				{Token: "Hi", Logprob: -0.1, Bytes: []int{72, 105}},
+				{Token: "Hello", Logprob: -2.5, Bytes: []int{72, 101, 108, 108, 111}},
+			},
+		},
+		{
+			Token:   "!",
+			Logprob: -0.5,
+			Bytes:   []int{33},
+			TopLogprobs: []ChatTokenLogprob{
+				{Token: "!", Logprob: -0.5, Bytes: []int{33}},
+				{Token: ".", Logprob: -1.2, Bytes: []int{46}},
+			},
+		},
+	}}, response.Choices[0].LogProbs)
+}
+
+func TestApplyLogprobsUnsupported(t *testing.T) {
+	logprobs := true
+	request := getTestChatRequest("hi")
+	request.LogProbs = &logprobs
+
+	// 未声明支持的模型返回 400
+	_, _, errWithCode := getReplicateProvider("", nil, nil).getReplicateChatRequest(request)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+		assert.Equal(t, "logprobs_not_supported", errWithCode.Code)
+		assert.Equal(t, "logprobs", errWithCode.Param)
+	}
+
+	plugin := model.PluginType{
+		"logprobs": {"models": "*"},
+	}
+	request.Stream = true
+	_, _, errWithCode = getReplicateProvider("", plugin, nil).getReplicateChatRequest(request)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, "logprobs_not_supported", errWithCode.Code)
+	}
+
+	request.Stream = false
+	request.TopLogProbs = 21
+	_, _, errWithCode = getReplicateProvider("", plugin, nil).getReplicateChatRequest(request)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, "top_logprobs", errWithCode.Param)
+	}
+
+	// 未请求 logprobs 时不发送
+	replicateRequest, _, errWithCode := getReplicateProvider("", nil, nil).getReplicateChatRequest(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.NotContains(t, marshalInput(t, replicateRequest), "logprobs")
+}
@@ -1,66 +1,234 @@
 package replicate
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
 	"one-api/common/requester"
+	"one-api/common/shutdown"
+	"one-api/common/utils"
+	"one-api/metrics"
 	"one-api/model"
 	"one-api/providers/base"
 	"one-api/types"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 type ReplicateProviderFactory struct{}
 
 // 创建 ReplicateProvider
 func (f ReplicateProviderFactory) Create(channel *model.Channel) base.ProviderInterface {
-	return &ReplicateProvider{
+	provider := &ReplicateProvider{
 		BaseProvider: base.BaseProvider{
 			Config:    getConfig(),
 			Channel:   channel,
 			Requester: requester.NewHTTPRequester(*channel.Proxy, requestErrorHandle),
 		},
-		FetchPredictionUrl: "/v1/predictions/%s",
+		CreatePredictionUrl:     "/v1/predictions",
+		FetchPredictionUrl:      "/v1/predictions/%s",
+		CancelPredictionUrl:     "/v1/predictions/%s/cancel",
+		DeploymentPredictionUrl: "/v1/deployments/%s/predictions",
+		AccountUrl:              "/v1/account",
+		FilesUrl:                "/v1/files",
+		PollInterval:            2 * time.Second,
+		StreamUrlTimeout:        10 * time.Second,
+		KeepAliveInterval:       15 * time.Second,
+		FileUploadRetryDelay:    time.Second,
 	}
+
+	if doer := provider.getHTTPDoer(); doer != nil {
+		provider.Requester.Doer = doer
+	}
+
+	return provider
 }
 
+// ReplicateProvider 的并发约定：
+//   - 每个请求创建一个 provider，Context、Usage 属于该请求，不要在多个请求间复用同一个实例
+//   - 同一请求内的并发操作（n > 1 的多个预测、审核的多个输入、流式取消预测）可以安全地共用 provider，
+//     这些操作会写入的状态（请求 ID、Usage.Canceled、调试用的原始响应、时间线）由 mu 保护，其余字段在并发开始前设置后只读
+//   - 跨请求共享的状态均为包级别且并发安全：合并请求（singleflight）、缓存、TLS 客户端（sync.Map）、审计输出
 type ReplicateProvider struct {
 	base.BaseProvider
-	FetchPredictionUrl string
+	CreatePredictionUrl     string
+	FetchPredictionUrl      string
+	CancelPredictionUrl     string
+	DeploymentPredictionUrl string
+	AccountUrl              string
+	FilesUrl                string
+	PollInterval            time.Duration
+	StreamUrlTimeout        time.Duration
+	KeepAliveInterval       time.Duration
+	FileUploadRetryDelay    time.Duration
+
+	// 当前请求的模型，用于监控指标
+	modelName string
+
+	// 保护同一请求内并发预测共用的状态
+	mu        sync.Mutex
+	requestId string
+	// 按预测 ID 记录的最后一次原始响应，只在请求调试信息时记录
+	debugResponses map[string]json.RawMessage
+	debugIds       []string
+	// 按预测 ID 记录的时间线
+	traces   map[string]*PredictionTrace
+	traceIds []string
 }
 
 func getConfig() base.ProviderConfig {
 	return base.ProviderConfig{
-		BaseURL:           "https://api.replicate.com",
-		ImagesGenerations: "/v1/models/%s/predictions",
-		ChatCompletions:   "/v1/models/%s/predictions",
+		BaseURL:             "https://api.replicate.com",
+		ImagesGenerations:   "/v1/models/%s/predictions",
+		Completions:         "/v1/models/%s/predictions",
+		ChatCompletions:     "/v1/models/%s/predictions",
+		Moderation:          "/v1/models/%s/predictions",
+		AudioTranscriptions: "/v1/models/%s/predictions",
+		ModelList:           "/v1/collections/%s",
 	}
 }
 
 // 请求错误处理
 func requestErrorHandle(resp *http.Response) *types.OpenAIError {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+
 	replicateError := &ReplicateError{}
-	err := json.NewDecoder(resp.Body).Decode(replicateError)
+	err = json.Unmarshal(body, replicateError)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitErrorHandle(resp, replicateError)
+	}
+
+	// 非 JSON 的错误（例如代理返回的页面）直接使用原始内容
 	if err != nil {
+		return rawErrorHandle(resp.StatusCode, body)
+	}
+
+	if replicateError.Status == 0 {
+		replicateError.Status = resp.StatusCode
+	}
+
+	openaiError := errorHandle(replicateError)
+	if openaiError != nil {
+		openaiError.Type = getErrorType(resp.StatusCode)
+	}
+
+	return openaiError
+}
+
+const maxRawErrorLength = 512
+
+func rawErrorHandle(statusCode int, body []byte) *types.OpenAIError {
+	message := truncateBody(body)
+	if message == "" {
 		return nil
 	}
 
-	return errorHandle(replicateError)
+	return &types.OpenAIError{
+		Message: message,
+		Type:    getErrorType(statusCode),
+		Code:    statusCode,
+	}
+}
+
+// 截断响应体用于错误信息
+func truncateBody(body []byte) string {
+	message := strings.TrimSpace(string(body))
+	if runes := []rune(message); len(runes) > maxRawErrorLength {
+		message = string(runes[:maxRawErrorLength]) + "..."
+	}
+
+	return message
+}
+
+// 限流错误，附带需要等待的秒数
+func rateLimitErrorHandle(resp *http.Response, replicateError *ReplicateError) *types.OpenAIError {
+	message := replicateError.Detail
+	if message == "" {
+		message = "Replicate rate limit exceeded"
+	}
+
+	retryAfter := getRetryAfter(resp.Header)
+	if retryAfter > 0 {
+		message = fmt.Sprintf("%s, please retry after %d seconds", message, retryAfter)
+	}
+
+	return &types.OpenAIError{
+		Message: message,
+		Type:    errorTypeRateLimit,
+		Code:    "rate_limit_exceeded",
+		Param:   strconv.Itoa(retryAfter),
+	}
+}
+
+// 解析 Retry-After，支持秒数和 HTTP 日期，缺失时使用 X-RateLimit-Reset
+func getRetryAfter(header http.Header) int {
+	retryAfter := strings.TrimSpace(header.Get("Retry-After"))
+	if retryAfter == "" {
+		retryAfter = strings.TrimSpace(header.Get("X-RateLimit-Reset"))
+	}
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(retryAfter, 64); err == nil {
+		return int(math.Ceil(seconds))
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if seconds := int(math.Ceil(time.Until(date).Seconds())); seconds > 0 {
+			return seconds
+		}
+	}
+
+	return 0
 }
 
 // 错误处理
+// 转换 Replicate 的错误，参数校验失败时使用第一个字段作为 param 和 code
 func errorHandle(replicateError *ReplicateError) *types.OpenAIError {
 	if replicateError.Status == 0 {
 		return nil
 	}
-	return &types.OpenAIError{
-		Message: replicateError.Detail,
-		Type:    "replicate_error",
+
+	openaiError := &types.OpenAIError{
+		Message: strings.TrimSpace(replicateError.Detail),
+		Type:    getErrorType(replicateError.Status),
 		Code:    replicateError.Status,
 	}
+
+	if len(replicateError.InvalidFields) > 0 {
+		messages := make([]string, 0, len(replicateError.InvalidFields))
+		for _, field := range replicateError.InvalidFields {
+			messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Description))
+		}
+		openaiError.Message = strings.Join(messages, "; ")
+
+		field := replicateError.InvalidFields[0]
+		openaiError.Param = strings.TrimPrefix(field.Field, "input.")
+		if field.Type != "" {
+			openaiError.Code = field.Type
+		}
+	}
+
+	if openaiError.Message == "" {
+		openaiError.Message = replicateError.Title
+	}
+
+	return openaiError
 }
 
 // 获取请求头
@@ -68,21 +236,186 @@ func (p *ReplicateProvider) GetRequestHeaders() (headers map[string]string) {
 	headers = make(map[string]string)
 	p.CommonRequestHeaders(headers)
 	headers["Authorization"] = fmt.Sprintf("Bearer %s", p.Channel.Key)
+	headers["Accept-Encoding"] = "gzip, deflate"
+	if requestId := p.getRequestId(); requestId != "" {
+		headers[requestIdHeader] = requestId
+	}
+	p.setExtraHeaders(headers)
 
 	return headers
 }
 
+// Replicate 的 API token 为 r8_ 加 37 位字母数字
+var apiKeyRegex = regexp.MustCompile(`^r8_[A-Za-z0-9]{37}$`)
+
+// 发送请求前检查渠道密钥，避免空密钥或格式错误的密钥请求上游后才返回 401
+// 以 r8_ 开头的密钥按 Replicate token 格式校验，其他密钥（如自建代理）只检查不含空白字符
+// 插件 extra_headers 覆盖了 Authorization 时不检查
+func (p *ReplicateProvider) validateAPIKey() *types.OpenAIErrorWithStatusCode {
+	key := p.Channel.Key
+	if headers := p.GetRequestHeaders(); headers["Authorization"] != "Bearer "+key {
+		return nil
+	}
+
+	var message string
+	switch {
+	case strings.TrimSpace(key) == "":
+		message = "replicate api key is empty"
+	case strings.HasPrefix(key, "r8_") && !apiKeyRegex.MatchString(key), strings.IndexFunc(key, unicode.IsSpace) >= 0:
+		message = "replicate api key is malformed"
+	default:
+		return nil
+	}
+
+	errWithCode := common.StringErrorWrapper(message, "invalid_api_key", http.StatusUnauthorized)
+	errWithCode.Type = errorTypeAuthentication
+
+	return errWithCode
+}
+
+// 创建预测前检查渠道密钥和请求头
+func (p *ReplicateProvider) validateRequest() *types.OpenAIErrorWithStatusCode {
+	if errWithCode := p.validateAPIKey(); errWithCode != nil {
+		return errWithCode
+	}
+
+	_, errWithCode := p.getPollTimeout()
+	return errWithCode
+}
+
+// 合并渠道插件 extra_headers.headers 配置的请求头，用于自建代理
+// 未开启 extra_headers.allow_authorization 时不允许覆盖 Authorization
+func (p *ReplicateProvider) setExtraHeaders(headers map[string]string) {
+	plugin := p.getPlugin("extra_headers")
+	extraHeaders := pluginString(plugin, "headers")
+	if extraHeaders == "" {
+		return
+	}
+
+	customHeaders := make(map[string]string)
+	if err := json.Unmarshal([]byte(extraHeaders), &customHeaders); err != nil {
+		logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate extra headers config invalid: %s", err.Error()))
+		return
+	}
+
+	allowAuthorization := pluginBool(plugin, "allow_authorization")
+	for key, value := range customHeaders {
+		key = http.CanonicalHeaderKey(key)
+		if key == "Authorization" && !allowAuthorization {
+			continue
+		}
+		headers[key] = value
+	}
+}
+
+const requestIdHeader = "X-Request-ID"
+
+// 获取转发给 Replicate 的请求 ID，优先使用客户端传入的，其次使用网关生成的
+func (p *ReplicateProvider) getRequestId() string {
+	if p.Context == nil {
+		return ""
+	}
+
+	if p.Context.Request != nil {
+		if requestId := strings.TrimSpace(p.Context.Request.Header.Get(requestIdHeader)); requestId != "" {
+			return requestId
+		}
+	}
+
+	// 并发预测时只生成一次，保证同一请求的预测使用相同的 ID
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.requestId != "" {
+		return p.requestId
+	}
+
+	p.requestId = p.Context.GetString(logger.RequestIdKey)
+	if p.requestId == "" {
+		p.requestId = utils.GetTimeString() + utils.GetRandomString(8)
+		p.Context.Set(logger.RequestIdKey, p.requestId)
+	}
+
+	return p.requestId
+}
+
+// 记录请求 ID 与预测 ID 的对应关系，便于排查上游问题
+func (p *ReplicateProvider) logPrediction(predictionID string) {
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate prediction created: request_id=%s prediction_id=%s", p.getRequestId(), predictionID))
+}
+
+// 获取请求上下文
+func (p *ReplicateProvider) getRequestContext() context.Context {
+	if p.Context != nil && p.Context.Request != nil {
+		return p.Context.Request.Context()
+	}
+
+	return context.Background()
+}
+
+// 服务关闭时等待超时后取消，测试时替换
+var shutdownContext = shutdown.Context
+
+// 客户端已断开或服务关闭等待超时，进行中的预测应在上游取消
+func (p *ReplicateProvider) isCanceled() bool {
+	return p.getRequestContext().Err() != nil || shutdownContext().Err() != nil
+}
+
 // 获取完整请求 URL
 func (p *ReplicateProvider) GetFullRequestURL(requestURL string, model string) string {
 	baseURL := strings.TrimSuffix(p.GetBaseURL(), "/")
-	requestURL = fmt.Sprintf(requestURL, model)
+	if strings.Contains(requestURL, "%s") {
+		requestURL = fmt.Sprintf(requestURL, model)
+	}
 
 	return fmt.Sprintf("%s%s", baseURL, requestURL)
 }
 
+var (
+	errPredictionCanceled = errors.New("prediction was canceled")
+	errServerShuttingDown = errors.New("server is shutting down, prediction was canceled")
+)
+
+// 客户端断开或服务关闭导致的取消返回本地错误，避免重试其他渠道
+func predictionErrorWrapper(err error) *types.OpenAIErrorWithStatusCode {
+	if errors.Is(err, errPredictionCanceled) {
+		return common.ErrorWrapperLocal(err, "request_canceled", http.StatusRequestTimeout)
+	}
+	if errors.Is(err, errServerShuttingDown) {
+		return common.ErrorWrapperLocal(err, "server_shutting_down", http.StatusServiceUnavailable)
+	}
+
+	return common.ErrorWrapper(err, "prediction_failed", http.StatusInternalServerError)
+}
+
+const (
+	predictionStarting   = "starting"
+	predictionProcessing = "processing"
+	predictionSucceeded  = "succeeded"
+	predictionFailed     = "failed"
+	predictionCanceled   = "canceled"
+)
+
+// 预测已结束，不需要继续轮询
+func isPredictionFinished(status string) bool {
+	return status == predictionSucceeded || status == predictionFailed || status == predictionCanceled
+}
+
 func getPrediction[T any](p *ReplicateProvider, response *ReplicateResponse[T]) (*ReplicateResponse[T], error) {
-	if response.Status == "succeeded" {
+	defer p.logPredictionTrace(response.ID)
+
+	// 同步等待模式下创建预测时可能已经结束，不再轮询；
+	// processing 时 output 只是部分输出，需要轮询到结束后使用最终结果
+	switch response.Status {
+	case predictionSucceeded:
 		return response, nil
+	case predictionFailed:
+		return nil, errors.New(response.Error)
+	case predictionCanceled:
+		return nil, errPredictionCanceled
+	case predictionStarting, predictionProcessing, "":
+	default:
+		return nil, fmt.Errorf("unknown prediction status: %s", response.Status)
 	}
 
 	predictionResponse := getPredictionResponse[T](p, response.ID)
@@ -90,9 +423,16 @@ func getPrediction[T any](p *ReplicateProvider, response *ReplicateResponse[T])
 		return response, errors.New("prediction response is nil")
 	}
 
-	if predictionResponse.Status == "failed" {
+	if predictionResponse.Status == predictionFailed {
 		return nil, errors.New(predictionResponse.Error)
 	}
+	if predictionResponse.Status == predictionCanceled {
+		if shutdownContext().Err() != nil {
+			return nil, errServerShuttingDown
+		}
+		return nil, errPredictionCanceled
+	}
+	predictionResponse.Metrics = response.Metrics.merge(predictionResponse.Metrics)
 
 	return predictionResponse, nil
 }
@@ -106,20 +446,187 @@ func getPredictionResponse[T any](p *ReplicateProvider, predictionID string) *Re
 	headers := p.GetRequestHeaders()
 
 	retry := 0
-	for retry < 15 {
-		time.Sleep(time.Second * 2)
+	defer func() {
+		metrics.RecordUpstreamPolls(metricsProvider, p.modelName, retry)
+		p.recordTracePolls(predictionID, retry)
+	}()
+
+	// 中间轮询返回的用量在最终响应缺失时沿用
+	var metrics ReplicateMetrics
+	attempts := p.getPollAttempts()
+	for retry < attempts {
+		// 客户端断开时取消预测，不再等待结果
+		if !p.waitPoll() {
+			p.CancelPrediction(predictionID)
+			return &ReplicateResponse[T]{ID: predictionID, Status: predictionCanceled, Metrics: metrics}
+		}
+		retry++
 
 		replicateResponse := &ReplicateResponse[T]{}
 		req, err := p.Requester.NewRequest(http.MethodGet, fullRequestURL, p.Requester.WithHeader(headers))
 		if err != nil {
 			return nil
 		}
-		p.Requester.SendRequest(req, replicateResponse, false)
-		if replicateResponse.Status == "succeeded" || replicateResponse.Status == "failed" {
+		// 单次轮询失败时继续重试，记录错误便于排查
+		if errWithCode := p.sendRequest(req, replicateResponse); errWithCode != nil {
+			logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate poll prediction %s failed: %s", predictionID, errWithCode.Message))
+		}
+		metrics = metrics.merge(replicateResponse.Metrics)
+		if isPredictionFinished(replicateResponse.Status) {
+			replicateResponse.Metrics = metrics
+			if replicateResponse.ID == "" {
+				replicateResponse.ID = predictionID
+			}
 			return replicateResponse
 		}
-		retry++
 	}
 
 	return nil
 }
+
+const (
+	pollTimeoutHeader = "X-Replicate-Poll-Timeout"
+	// 默认的轮询次数，轮询预算为 defaultPollAttempts * PollInterval
+	defaultPollAttempts = 15
+)
+
+// 本次请求的轮询预算，请求头 X-Replicate-Poll-Timeout（秒）可以覆盖默认值，
+// 不能超过渠道插件 poll.max_timeout（秒），未配置时不能超过默认预算，超过时返回 400
+func (p *ReplicateProvider) getPollTimeout() (time.Duration, *types.OpenAIErrorWithStatusCode) {
+	defaultTimeout := defaultPollAttempts * p.PollInterval
+	if p.Context == nil || p.Context.Request == nil {
+		return defaultTimeout, nil
+	}
+
+	value := strings.TrimSpace(p.Context.GetHeader(pollTimeoutHeader))
+	if value == "" {
+		return defaultTimeout, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 || math.IsInf(seconds, 0) {
+		return 0, common.StringErrorWrapperLocal(pollTimeoutHeader+" must be a positive number of seconds", "invalid_poll_timeout", http.StatusBadRequest)
+	}
+
+	maxTimeout := defaultTimeout
+	if maxSeconds := pluginFloat(p.getPlugin("poll"), "max_timeout", 0); maxSeconds > 0 {
+		maxTimeout = time.Duration(maxSeconds * float64(time.Second))
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > maxTimeout {
+		return 0, common.StringErrorWrapperLocal(fmt.Sprintf("%s must be at most %g seconds", pollTimeoutHeader, maxTimeout.Seconds()), "invalid_poll_timeout", http.StatusBadRequest)
+	}
+
+	return timeout, nil
+}
+
+// 按轮询预算和间隔计算最多轮询的次数，请求头已在 validateRequest 中校验
+func (p *ReplicateProvider) getPollAttempts() int {
+	timeout, errWithCode := p.getPollTimeout()
+	if errWithCode != nil || p.PollInterval <= 0 {
+		return defaultPollAttempts
+	}
+
+	return max(1, int(math.Ceil(float64(timeout)/float64(p.PollInterval))))
+}
+
+const (
+	pollModeWait       = "wait"
+	defaultPollWait    = 60
+	maxPollWaitSeconds = 60
+)
+
+// 渠道插件 poll.mode 为 wait 时，创建预测使用 Replicate 的同步模式（Prefer: wait），
+// 预测在 poll.wait 秒（默认 60，最大 60）内结束时直接返回结果，否则回退到按间隔轮询
+func (p *ReplicateProvider) setPreferWait(headers map[string]string) {
+	plugin := p.getPlugin("poll")
+	if pluginString(plugin, "mode") != pollModeWait {
+		return
+	}
+
+	wait := pluginInt(plugin, "wait", defaultPollWait)
+	if wait > maxPollWaitSeconds {
+		wait = maxPollWaitSeconds
+	}
+	headers["Prefer"] = fmt.Sprintf("wait=%d", wait)
+}
+
+// 等待下一次轮询，客户端断开或服务关闭等待超时时返回 false
+func (p *ReplicateProvider) waitPoll() bool {
+	timer := time.NewTimer(p.PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-p.getRequestContext().Done():
+		return false
+	case <-shutdownContext().Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 取消正在运行的预测，避免客户端断开后预测继续运行产生费用
+func (p *ReplicateProvider) CancelPrediction(predictionID string) (errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	p.mu.Lock()
+	if p.Usage != nil {
+		p.Usage.Canceled = true
+	}
+	p.mu.Unlock()
+
+	fullRequestURL := p.GetFullRequestURL(p.CancelPredictionUrl, predictionID)
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithHeader(p.GetRequestHeaders()))
+	if err != nil {
+		return common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	// 通常在客户端断开后调用，不随请求的 context 取消
+	req = req.WithContext(context.WithoutCancel(req.Context()))
+
+	response := &ReplicateResponse[any]{}
+	if errWithCode := p.sendRequest(req, response); errWithCode != nil {
+		logger.LogError(p.getRequestContext(), fmt.Sprintf("replicate prediction %s cancel failed: %s", predictionID, errWithCode.Message))
+		return errWithCode
+	}
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate prediction canceled: request_id=%s prediction_id=%s", p.getRequestId(), predictionID))
+
+	return nil
+}
+
+var seedLogRegex = regexp.MustCompile(`(?i)using seed:?\s*(\d+)`)
+
+// 获取预测实际使用的 seed，优先读取 input，其次从日志中解析
+func getPredictionSeed(input map[string]any, logs string) (int64, bool) {
+	switch seed := input["seed"].(type) {
+	case float64:
+		return int64(seed), true
+	case string:
+		if value, err := strconv.ParseInt(seed, 10, 64); err == nil {
+			return value, true
+		}
+	}
+
+	matches := seedLogRegex.FindStringSubmatch(logs)
+	if len(matches) < 2 {
+		return 0, false
+	}
+
+	value, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return value, true
+}
+
+// 由模型版本及实际使用的 seed 组成，用于复现结果，如 replicate_v_<version>_seed_<seed>
+func getSystemFingerprint(version string, input map[string]any, logs string) string {
+	var seed *int
+	if value, ok := getPredictionSeed(input, logs); ok {
+		seedValue := int(value)
+		seed = &seedValue
+	}
+
+	return base.FormatSystemFingerprint(metricsProvider, version, seed)
+}
@@ -0,0 +1,766 @@
+package replicate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/cache"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/common/requester"
+	"one-api/common/test"
+	"one-api/common/utils"
+	"one-api/model"
+	"one-api/types"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logger.Logger = zap.NewNop()
+	config.DisableTokenEncoders = true
+	requester.InitHttpClient()
+	cache.InitCacheManager()
+	os.Exit(m.Run())
+}
+
+func setupReplicateTestServer() (baseUrl string, server *test.ServerTest, teardown func()) {
+	server = test.NewTestServer()
+	ts := server.TestServer(func(w http.ResponseWriter, r *http.Request) bool {
+		return test.OpenAICheck(w, r)
+	})
+	ts.Start()
+	teardown = ts.Close
+
+	baseUrl = ts.URL
+	return
+}
+
+func getReplicateProvider(baseUrl string, plugin model.PluginType, body io.Reader) *ReplicateProvider {
+	channel := test.GetChannel(config.ChannelTypeReplicate, baseUrl, "", "", "")
+	if plugin != nil {
+		pluginData := datatypes.NewJSONType(plugin)
+		channel.Plugin = &pluginData
+	}
+
+	context, _ := test.GetContext(http.MethodPost, "/v1/chat/completions", test.RequestJSONConfig(), body)
+	provider := ReplicateProviderFactory{}.Create(&channel).(*ReplicateProvider)
+	provider.SetContext(context)
+	provider.SetUsage(&types.Usage{})
+
+	return provider
+}
+
+// 替换 HTTP 请求，不依赖真实服务器
+type testDoer struct {
+	mu       sync.Mutex
+	handler  func(req *http.Request) *http.Response
+	requests []*http.Request
+}
+
+func (d *testDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.requests = append(d.requests, req)
+	d.mu.Unlock()
+
+	return d.handler(req), nil
+}
+
+func (d *testDoer) count(method string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count := 0
+	for _, req := range d.requests {
+		if req.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+func jsonResponse(statusCode int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func getMockProvider(plugin model.PluginType, handler func(req *http.Request) *http.Response) (*ReplicateProvider, *testDoer) {
+	doer := &testDoer{handler: handler}
+	provider := getReplicateProvider("https://api.replicate.com", plugin, nil)
+	provider.Requester.Doer = doer
+	provider.PollInterval = time.Millisecond
+	provider.StreamUrlTimeout = 20 * time.Millisecond
+	provider.FileUploadRetryDelay = time.Millisecond
+
+	return provider, doer
+}
+
+func TestCreateChatCompletionSucceeded(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","model":"meta/llama","status":"succeeded","output":["Hello"," world"],"metrics":{"input_token_count":5,"output_token_count":2}}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello world", response.Choices[0].Message.Content)
+	assert.Equal(t, 7, response.Usage.TotalTokens)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+	assert.Equal(t, 0, doer.count(http.MethodGet))
+}
+
+func TestCreateChatCompletionFailed(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"failed","error":"CUDA out of memory"}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Contains(t, errWithCode.Message, "CUDA out of memory")
+}
+
+func TestCreateChatCompletionProcessing(t *testing.T) {
+	polls := 0
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			// 创建时已在运行，output 只是部分输出
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"processing","output":"Hel","metrics":{"input_token_count":5}}`)
+		}
+		polls++
+		if polls == 1 {
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","output":["Hel","lo"]}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hel","lo"," world"],"metrics":{"output_token_count":3}}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello world", response.Choices[0].Message.Content)
+	assert.Equal(t, 8, response.Usage.TotalTokens)
+	assert.Equal(t, 2, doer.count(http.MethodGet))
+}
+
+func TestCreateChatCompletionPollTimeout(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing"}`)
+	}
+	plugin := model.PluginType{
+		"poll": {"max_timeout": "0.1"},
+	}
+
+	// 默认轮询 defaultPollAttempts 次
+	provider, doer := getMockProvider(plugin, handler)
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, defaultPollAttempts, doer.count(http.MethodGet))
+
+	// 请求头覆盖轮询预算，按轮询间隔换算为次数
+	provider, doer = getMockProvider(plugin, handler)
+	provider.Context.Request.Header.Set(pollTimeoutHeader, "0.04")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, 40, doer.count(http.MethodGet))
+
+	// 超过上限时返回 400，不创建预测
+	provider, doer = getMockProvider(plugin, handler)
+	provider.Context.Request.Header.Set(pollTimeoutHeader, "1")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "invalid_poll_timeout", errWithCode.Code)
+	assert.Empty(t, doer.requests)
+
+	// 未配置上限时不能超过默认预算
+	provider, doer = getMockProvider(nil, handler)
+	provider.Context.Request.Header.Set(pollTimeoutHeader, "0.04")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Empty(t, doer.requests)
+
+	provider, _ = getMockProvider(plugin, handler)
+	provider.Context.Request.Header.Set(pollTimeoutHeader, "abc")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Equal(t, "invalid_poll_timeout", errWithCode.Code)
+}
+
+func TestCreateChatCompletionPredictionStatus(t *testing.T) {
+	// 上游取消后不再轮询
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+	})
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "request_canceled", errWithCode.Code)
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+
+	// 未知状态直接返回错误
+	provider, doer = getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"aborted"}`)
+	})
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Contains(t, errWithCode.Message, "aborted")
+	assert.Equal(t, 0, doer.count(http.MethodGet))
+}
+
+func TestCreateChatCompletionEmptyOutputRetry(t *testing.T) {
+	handler := func() func(req *http.Request) *http.Response {
+		attempts := 0
+		return func(req *http.Request) *http.Response {
+			attempts++
+			if attempts == 1 {
+				return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":[]}`)
+			}
+			return jsonResponse(http.StatusCreated, `{"id":"p2","status":"succeeded","output":["Hello"]}`)
+		}
+	}
+
+	plugin := model.PluginType{
+		"empty_output": {"retries": "2"},
+	}
+	provider, doer := getMockProvider(plugin, handler())
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+	assert.Equal(t, 2, doer.count(http.MethodPost))
+
+	// 确定性请求默认不重试
+	request := getTestChatRequest("hi")
+	temperature, seed := 0.0, 42
+	request.Temperature = &temperature
+	request.Seed = &seed
+	provider, doer = getMockProvider(plugin, handler())
+	response, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "", response.Choices[0].Message.Content)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+
+	plugin = model.PluginType{
+		"empty_output": {"retries": "2", "force": true},
+	}
+	provider, doer = getMockProvider(plugin, handler())
+	response, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+	assert.Equal(t, 2, doer.count(http.MethodPost))
+}
+
+func TestCreateChatCompletionPolling(t *testing.T) {
+	polls := 0
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+
+		assert.Equal(t, "/v1/predictions/p1", req.URL.Path)
+		polls++
+		if polls < 3 {
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["done"],"metrics":{"input_token_count":3,"output_token_count":1}}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "done", response.Choices[0].Message.Content)
+	assert.Equal(t, 4, response.Usage.TotalTokens)
+	assert.Equal(t, 3, doer.count(http.MethodGet))
+}
+
+func TestCreateChatCompletionPollWait(t *testing.T) {
+	// 模拟 Replicate 同步模式：带 Prefer: wait 时等待预测结束后返回
+	handler := func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			if req.Header.Get("Prefer") == "wait=30" {
+				return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["done"]}`)
+			}
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["done"]}`)
+	}
+
+	provider, doer := getMockProvider(nil, handler)
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "done", response.Choices[0].Message.Content)
+	assert.Empty(t, doer.requests[0].Header.Get("Prefer"))
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+
+	plugin := model.PluginType{
+		"poll": {"mode": "wait", "wait": "30"},
+	}
+	provider, doer = getMockProvider(plugin, handler)
+	response, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "done", response.Choices[0].Message.Content)
+	assert.Equal(t, 0, doer.count(http.MethodGet))
+
+	// 同步等待超时未结束时回退到轮询
+	plugin["poll"]["wait"] = "120"
+	provider, doer = getMockProvider(plugin, handler)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "wait=60", doer.requests[0].Header.Get("Prefer"))
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+
+	// 同步等待返回失败时不再轮询
+	provider, doer = getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"failed","error":"CUDA out of memory"}`)
+	})
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	if assert.NotNil(t, errWithCode) {
+		assert.Contains(t, errWithCode.Message, "CUDA out of memory")
+	}
+	assert.Equal(t, 0, doer.count(http.MethodGet))
+}
+
+func TestCreateChatCompletionPollingMetrics(t *testing.T) {
+	polls := 0
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+
+		polls++
+		switch polls {
+		case 1:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","metrics":{"input_token_count":8}}`)
+		case 2:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","metrics":{"input_token_count":9,"output_token_count":2}}`)
+		default:
+			return jsonResponse(http.StatusOK, `{"status":"succeeded","output":["done"],"metrics":{"output_token_count":3}}`)
+		}
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "p1", response.ID)
+	assert.Equal(t, 9, response.Usage.PromptTokens)
+	assert.Equal(t, 3, response.Usage.CompletionTokens)
+	assert.False(t, response.Usage.Estimated)
+}
+
+func gzipResponse(statusCode int, body string) *http.Response {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(body))
+	writer.Close()
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Header: http.Header{
+			"Content-Type":     []string{"application/json"},
+			"Content-Encoding": []string{"gzip"},
+		},
+		Body: io.NopCloser(&buf),
+	}
+}
+
+func TestCreateChatCompletionGzip(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return gzipResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["compressed"]}`)
+	})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "compressed", response.Choices[0].Message.Content)
+	assert.Contains(t, doer.requests[0].Header.Get("Accept-Encoding"), "gzip")
+}
+
+func TestCreateChatCompletionModelAlias(t *testing.T) {
+	plugin := model.PluginType{
+		"model_alias": {"mapping": `{"llama-3-70b":"meta/meta-llama-3-70b-instruct","llama-pinned":"meta/llama-2-70b:abc123"}`},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","model":"meta/meta-llama-3-70b-instruct","status":"succeeded","output":["ok"]}`)
+	})
+
+	request := getTestChatRequest("hi")
+	request.Model = "llama-3-70b"
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "llama-3-70b", response.Model)
+	assert.Equal(t, "/v1/models/meta/meta-llama-3-70b-instruct/predictions", doer.requests[0].URL.Path)
+
+	request.Model = "llama-pinned"
+	_, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "/v1/predictions", doer.requests[1].URL.Path)
+	body, _ := io.ReadAll(doer.requests[1].Body)
+	assert.Contains(t, string(body), `"version":"abc123"`)
+
+	request.Model = "gpt-4o"
+	_, errWithCode = provider.CreateChatCompletion(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "model_not_found", errWithCode.Code)
+	assert.Equal(t, 2, doer.count(http.MethodPost))
+}
+
+func TestCreateChatCompletionFanOut(t *testing.T) {
+	var mu sync.Mutex
+	created := 0
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		mu.Lock()
+		created++
+		id := created
+		mu.Unlock()
+		return jsonResponse(http.StatusCreated, fmt.Sprintf(`{"id":"p%d","status":"succeeded","output":["answer %d"],"metrics":{"input_token_count":5,"output_token_count":2}}`, id, id))
+	})
+
+	request := getTestChatRequest("hi")
+	n := 3
+	request.N = &n
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Len(t, response.Choices, 3)
+	for index, choice := range response.Choices {
+		assert.Equal(t, index, choice.Index)
+		assert.Contains(t, choice.Message.Content, "answer")
+	}
+	assert.Equal(t, 15, response.Usage.PromptTokens)
+	assert.Equal(t, 6, response.Usage.CompletionTokens)
+	assert.Equal(t, 21, response.Usage.TotalTokens)
+	assert.Equal(t, 3, doer.count(http.MethodPost))
+
+	n = 5
+	_, errWithCode = provider.CreateChatCompletion(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, 3, doer.count(http.MethodPost))
+}
+
+func TestCreateChatCompletionRequestId(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	})
+	provider.Context.Set(logger.RequestIdKey, "gateway-request-id")
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	for _, req := range doer.requests {
+		assert.Equal(t, "gateway-request-id", req.Header.Get("X-Request-ID"))
+	}
+
+	// 客户端传入的请求 ID 优先
+	provider.Context.Request.Header.Set("X-Request-ID", "client-request-id")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "client-request-id", doer.requests[len(doer.requests)-1].Header.Get("X-Request-ID"))
+}
+
+func TestCreateChatCompletionUserAgent(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "one-hub/"+config.Version, doer.requests[0].Header.Get("User-Agent"))
+
+	// 渠道自定义 header 覆盖默认值
+	modelHeaders := `{"User-Agent":"custom-agent/1.0"}`
+	provider.Channel.ModelHeaders = &modelHeaders
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "custom-agent/1.0", doer.requests[1].Header.Get("User-Agent"))
+}
+
+func TestCreateChatCompletionExtraHeaders(t *testing.T) {
+	plugin := model.PluginType{
+		"extra_headers": {"headers": `{"x-proxy-token":"secret","X-Route":"gpu-east","authorization":"Bearer proxy"}`},
+	}
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: ok\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+		}
+	}
+	provider, doer := getMockProvider(plugin, handler)
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	_, err := readStream(t, stream)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "stream.replicate.com", doer.requests[1].URL.Host)
+	for _, req := range doer.requests[:2] {
+		assert.Equal(t, "secret", req.Header.Get("X-Proxy-Token"))
+		assert.Equal(t, "gpu-east", req.Header.Get("X-Route"))
+		assert.Equal(t, "Bearer "+provider.Channel.Key, req.Header.Get("Authorization"))
+	}
+
+	// 显式允许时才覆盖 Authorization
+	plugin["extra_headers"]["allow_authorization"] = true
+	provider, doer = getMockProvider(plugin, handler)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Bearer proxy", doer.requests[0].Header.Get("Authorization"))
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		message string
+	}{
+		{"empty", "", "replicate api key is empty"},
+		{"blank", "   ", "replicate api key is empty"},
+		{"short token", "r8_abc", "replicate api key is malformed"},
+		{"invalid token", "r8_" + strings.Repeat("a", 36) + "!", "replicate api key is malformed"},
+		{"whitespace", "proxy key\n", "replicate api key is malformed"},
+		{"token", "r8_" + strings.Repeat("a", 37), ""},
+		{"proxy key", "proxy-key", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+				return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+			})
+			provider.Channel.Key = tt.key
+
+			_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+			if tt.message == "" {
+				assert.Nil(t, errWithCode)
+				return
+			}
+
+			if assert.NotNil(t, errWithCode) {
+				assert.Equal(t, http.StatusUnauthorized, errWithCode.StatusCode)
+				assert.Equal(t, "invalid_api_key", errWithCode.Code)
+				assert.Equal(t, tt.message, errWithCode.Message)
+				if tt.key != "" {
+					assert.NotContains(t, errWithCode.Message, tt.key)
+				}
+			}
+			assert.Equal(t, 0, len(doer.requests))
+		})
+	}
+
+	// 插件覆盖 Authorization 时不检查渠道密钥
+	plugin := model.PluginType{
+		"extra_headers": {"headers": `{"authorization":"Bearer proxy"}`, "allow_authorization": true},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	})
+	provider.Channel.Key = ""
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Bearer proxy", doer.requests[0].Header.Get("Authorization"))
+}
+
+func TestCreateChatCompletionRateLimited(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		resp := jsonResponse(http.StatusTooManyRequests, `{"title":"Request was throttled.","detail":"Request was throttled. Expected available in 7 seconds.","status":429}`)
+		resp.Header.Set("Retry-After", "7")
+		return resp
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusTooManyRequests, errWithCode.StatusCode)
+	assert.Equal(t, "rate_limit_exceeded", errWithCode.Type)
+	assert.Equal(t, "7", errWithCode.Param)
+	assert.Contains(t, errWithCode.Message, "retry after 7 seconds")
+
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, 30, getRetryAfter(header), 1)
+}
+
+func TestCreateChatCompletionValidationError(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusUnprocessableEntity, `{"title":"Input validation failed","detail":"- input.temperature: Must be less than or equal to 5\n","status":422,"invalid_fields":[{"type":"less_than_equal","field":"input.temperature","description":"Must be less than or equal to 5"}]}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, errWithCode.StatusCode)
+	assert.Equal(t, "Provider API error: input.temperature: Must be less than or equal to 5", errWithCode.Message)
+	assert.Equal(t, "temperature", errWithCode.Param)
+	assert.Equal(t, "less_than_equal", errWithCode.Code)
+	assert.Equal(t, "invalid_request_error", errWithCode.Type)
+}
+
+func TestCreateChatCompletionRawError(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Header:     http.Header{"Content-Type": []string{"text/html"}},
+			Body:       io.NopCloser(strings.NewReader("  <html>502 Bad Gateway</html>\n")),
+		}
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+	assert.Equal(t, "Provider API error: <html>502 Bad Gateway</html>", errWithCode.Message)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.Code)
+
+	// 缺少 invalid_fields 时使用 detail，detail 为空时使用 title
+	openaiError := errorHandle(&ReplicateError{Title: "Unauthenticated", Status: 401})
+	assert.Equal(t, "Unauthenticated", openaiError.Message)
+	assert.Equal(t, 401, openaiError.Code)
+}
+
+func TestCreateChatCompletionResponseTooLarge(t *testing.T) {
+	viper.Set("response_body_limit", 1)
+	defer viper.Set("response_body_limit", nil)
+
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		output := strings.Repeat("a", 2<<20)
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":["`+output+`"]}`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadGateway, errWithCode.StatusCode)
+	assert.Equal(t, "response_too_large", errWithCode.Code)
+}
+
+func TestCreateChatCompletionMalformedResponse(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":[`)
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "decode_response_failed", errWithCode.Code)
+	assert.Contains(t, errWithCode.Message, "status 201")
+	assert.Contains(t, errWithCode.Message, `body: {"id":"p1","status":"succeeded","output":[`)
+}
+
+func TestCreateChatCompletionProxy(t *testing.T) {
+	var proxiedHosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHosts = append(proxiedHosts, r.URL.Host)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"p1","status":"succeeded","output":["Hello"]}`))
+	}))
+	defer proxy.Close()
+
+	channel := test.GetChannel(config.ChannelTypeReplicate, "http://replicate.invalid", "", proxy.URL, "")
+	context, _ := test.GetContext(http.MethodPost, "/v1/chat/completions", test.RequestJSONConfig(), nil)
+	provider := ReplicateProviderFactory{}.Create(&channel).(*ReplicateProvider)
+	provider.SetContext(context)
+	provider.SetUsage(&types.Usage{})
+
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+	assert.Equal(t, []string{"replicate.invalid"}, proxiedHosts)
+
+	assert.Nil(t, utils.ValidateProxyAddr(""))
+	assert.Nil(t, utils.ValidateProxyAddr("socks5://127.0.0.1:1080"))
+	assert.NotNil(t, utils.ValidateProxyAddr("ftp://127.0.0.1:21"))
+	assert.NotNil(t, utils.ValidateProxyAddr("http://"))
+}
+
+func TestCreateChatCompletionCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		default:
+			cancel()
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing"}`)
+		}
+	})
+	provider.Context.Request = provider.Context.Request.WithContext(ctx)
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "request_canceled", errWithCode.Code)
+	assert.True(t, errWithCode.LocalError)
+	assert.True(t, provider.Usage.Canceled)
+
+	last := doer.requests[len(doer.requests)-1]
+	assert.Equal(t, http.MethodPost, last.Method)
+	assert.Equal(t, "/v1/predictions/p1/cancel", last.URL.Path)
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+}
+
+func setShutdownContext(t *testing.T) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	original := shutdownContext
+	shutdownContext = func() context.Context { return ctx }
+	t.Cleanup(func() {
+		cancel()
+		shutdownContext = original
+	})
+
+	return cancel
+}
+
+func TestCreateChatCompletionShutdown(t *testing.T) {
+	shutdownCancel := setShutdownContext(t)
+
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		default:
+			// 轮询过程中服务关闭等待超时
+			shutdownCancel()
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","metrics":{"input_token_count":5}}`)
+		}
+	})
+
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "server_shutting_down", errWithCode.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, errWithCode.StatusCode)
+	assert.True(t, errWithCode.LocalError)
+	assert.True(t, provider.Usage.Canceled)
+
+	last := doer.requests[len(doer.requests)-1]
+	assert.Equal(t, http.MethodPost, last.Method)
+	assert.Equal(t, "/v1/predictions/p1/cancel", last.URL.Path)
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+}
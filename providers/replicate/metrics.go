@@ -0,0 +1,71 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/metrics"
+	"one-api/types"
+	"time"
+)
+
+const metricsProvider = "replicate"
+
+// 发送请求并记录上游状态码和耗时
+// 解析失败时错误信息中附带状态码和截断后的响应体，便于排查上游格式变化
+func (p *ReplicateProvider) sendRequest(req *http.Request, response any) *types.OpenAIErrorWithStatusCode {
+	resp, errWithCode := p.sendRequestRaw(req)
+	if errWithCode != nil {
+		return errWithCode
+	}
+	defer resp.Body.Close()
+
+	limitResponseBody(resp)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if errors.Is(err, errResponseTooLarge) {
+			return common.ErrorWrapper(err, "response_too_large", http.StatusBadGateway)
+		}
+		return common.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+
+	if err := json.Unmarshal(body, response); err != nil {
+		return common.ErrorWrapper(fmt.Errorf("%w (status %d, body: %s)", err, resp.StatusCode, truncateBody(body)), "decode_response_failed", http.StatusInternalServerError)
+	}
+	p.recordDebugResponse(body)
+	p.recordTraceResponse(body)
+
+	return nil
+}
+
+func (p *ReplicateProvider) sendRequestRaw(req *http.Request) (*http.Response, *types.OpenAIErrorWithStatusCode) {
+	if errWithCode := p.waitAccountLimit(req); errWithCode != nil {
+		return nil, errWithCode
+	}
+	// 客户端断开时只中止轮询和流式读取（GET），创建预测的请求继续完成，拿到预测 ID 后才能在上游取消
+	if req.Method != http.MethodGet {
+		req = req.WithContext(context.WithoutCancel(req.Context()))
+	}
+
+	start := time.Now()
+	resp, errWithCode := p.Requester.SendRequestRaw(req)
+	p.recordRequest(resp, errWithCode, start)
+	p.pauseAccountOnRateLimit(errWithCode)
+
+	return resp, errWithCode
+}
+
+func (p *ReplicateProvider) recordRequest(resp *http.Response, errWithCode *types.OpenAIErrorWithStatusCode, start time.Time) {
+	statusCode := 0
+	if resp != nil {
+		statusCode = resp.StatusCode
+	} else if errWithCode != nil {
+		statusCode = errWithCode.StatusCode
+	}
+
+	metrics.RecordUpstreamRequest(metricsProvider, p.modelName, statusCode, time.Since(start))
+}
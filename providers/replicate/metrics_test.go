@@ -0,0 +1,134 @@
+package replicate
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// 从默认注册表读取指标，counter 返回值，histogram 返回样本数
+func getMetricValue(t *testing.T, name string, labels map[string]string) float64 {
+	metric := getMetric(t, name, labels)
+	if metric == nil {
+		return 0
+	}
+
+	if metric.GetCounter() != nil {
+		return metric.GetCounter().GetValue()
+	}
+	return float64(metric.GetHistogram().GetSampleCount())
+}
+
+// histogram 的样本总和
+func getMetricSum(t *testing.T, name string, labels map[string]string) float64 {
+	metric := getMetric(t, name, labels)
+	if metric == nil {
+		return 0
+	}
+
+	return metric.GetHistogram().GetSampleSum()
+}
+
+func getMetric(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metricLoop:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value != label.GetValue() {
+					continue metricLoop
+				}
+			}
+
+			return metric
+		}
+	}
+
+	return nil
+}
+
+func TestMetricsRecorded(t *testing.T) {
+	polls := 0
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		polls++
+		if polls < 2 {
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["ok"]}`)
+	})
+
+	request := getTestChatRequest("hi")
+	request.Model = "metrics/test-model"
+	labels := map[string]string{"provider": "replicate", "model": "metrics/test-model"}
+	createdLabels := map[string]string{"provider": "replicate", "model": "metrics/test-model", "code": "201"}
+	okLabels := map[string]string{"provider": "replicate", "model": "metrics/test-model", "code": "200"}
+
+	created := getMetricValue(t, "provider_upstream_requests_total", createdLabels)
+	fetched := getMetricValue(t, "provider_upstream_requests_total", okLabels)
+	latency := getMetricValue(t, "provider_upstream_request_duration_seconds", createdLabels)
+	pollCount := getMetricValue(t, "provider_upstream_polls", labels)
+
+	_, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+
+	assert.Equal(t, created+1, getMetricValue(t, "provider_upstream_requests_total", createdLabels))
+	assert.Equal(t, fetched+2, getMetricValue(t, "provider_upstream_requests_total", okLabels))
+	assert.Equal(t, latency+1, getMetricValue(t, "provider_upstream_request_duration_seconds", createdLabels))
+	assert.Equal(t, pollCount+1, getMetricValue(t, "provider_upstream_polls", labels))
+}
+
+func TestMetricsFirstToken(t *testing.T) {
+	reader, writer := io.Pipe()
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       reader,
+		}
+	})
+
+	labels := map[string]string{"provider": "replicate", "model": "metrics/ttft-model"}
+	firstTokenCount := getMetricValue(t, "provider_upstream_first_token_seconds", labels)
+	firstTokenSum := getMetricSum(t, "provider_upstream_first_token_seconds", labels)
+	streamSum := getMetricSum(t, "provider_upstream_stream_duration_seconds", labels)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writer.Write([]byte("event: output\ndata: Hello\n\n"))
+		time.Sleep(50 * time.Millisecond)
+		writer.Write([]byte("event: output\ndata:  world\n\nevent: done\ndata: {}\n\n"))
+		writer.Close()
+	}()
+
+	request := getTestChatRequest("hi")
+	request.Model = "metrics/ttft-model"
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	_, err := readStream(t, stream)
+	assert.Nil(t, err)
+
+	assert.Equal(t, firstTokenCount+1, getMetricValue(t, "provider_upstream_first_token_seconds", labels))
+	ttft := getMetricSum(t, "provider_upstream_first_token_seconds", labels) - firstTokenSum
+	total := getMetricSum(t, "provider_upstream_stream_duration_seconds", labels) - streamSum
+	assert.GreaterOrEqual(t, ttft, 0.05)
+	assert.GreaterOrEqual(t, total, 0.1)
+	assert.Less(t, ttft, total)
+}
@@ -0,0 +1,179 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/types"
+	"strings"
+)
+
+type ReplicateModel struct {
+	Owner   string
+	Name    string
+	Version string
+	// 是否为 Replicate 部署，部署使用 /v1/deployments 接口且不指定版本
+	Deployment bool
+}
+
+func (m *ReplicateModel) Slug() string {
+	return m.Owner + "/" + m.Name
+}
+
+// 解析模型名称，支持 owner/name 和 owner/name:version
+func parseReplicateModel(modelName string) (*ReplicateModel, bool) {
+	slug, version, _ := strings.Cut(strings.TrimSpace(modelName), ":")
+	owner, name, ok := strings.Cut(slug, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, false
+	}
+
+	return &ReplicateModel{
+		Owner:   owner,
+		Name:    name,
+		Version: version,
+	}, true
+}
+
+// 获取渠道配置的模型别名
+func (p *ReplicateProvider) getModelAlias() (map[string]string, error) {
+	mapping := pluginString(p.getPlugin("model_alias"), "mapping")
+	if mapping == "" {
+		return nil, nil
+	}
+
+	alias := make(map[string]string)
+	if err := json.Unmarshal([]byte(mapping), &alias); err != nil {
+		return nil, err
+	}
+
+	return alias, nil
+}
+
+// 获取渠道配置的部署，渠道插件 deployment.enable 开启时所有预测都发送到该部署
+func (p *ReplicateProvider) getDeployment() (*ReplicateModel, *types.OpenAIErrorWithStatusCode) {
+	plugin := p.getPlugin("deployment")
+	if !pluginBool(plugin, "enable") {
+		return nil, nil
+	}
+
+	owner := pluginString(plugin, "owner")
+	name := pluginString(plugin, "name")
+	if owner == "" || name == "" || strings.Contains(owner, "/") || strings.Contains(name, "/") {
+		return nil, common.StringErrorWrapperLocal("replicate deployment owner and name are required", "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	return &ReplicateModel{
+		Owner:      owner,
+		Name:       name,
+		Deployment: true,
+	}, nil
+}
+
+// 将客户端的模型名称解析为 Replicate 模型
+func (p *ReplicateProvider) resolveModel(modelName string) (*ReplicateModel, *types.OpenAIErrorWithStatusCode) {
+	deployment, errWithCode := p.getDeployment()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	if deployment != nil {
+		p.modelName = deployment.Slug()
+		return deployment, nil
+	}
+
+	alias, err := p.getModelAlias()
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	target := modelName
+	if value, ok := alias[modelName]; ok {
+		target = value
+	}
+
+	replicateModel, ok := parseReplicateModel(target)
+	if !ok {
+		return nil, common.StringErrorWrapperLocal(fmt.Sprintf("model %s not found for this channel", modelName), "model_not_found", http.StatusBadRequest)
+	}
+	p.modelName = replicateModel.Slug()
+
+	return replicateModel, nil
+}
+
+// 获取创建预测的地址，部署使用 /v1/deployments，指定版本时使用 /v1/predictions
+func (p *ReplicateProvider) getPredictionURL(relayMode int, replicateModel *ReplicateModel) (string, *types.OpenAIErrorWithStatusCode) {
+	if replicateModel.Deployment {
+		return p.GetFullRequestURL(p.DeploymentPredictionUrl, replicateModel.Slug()), nil
+	}
+
+	if replicateModel.Version != "" {
+		return p.GetFullRequestURL(p.CreatePredictionUrl, ""), nil
+	}
+
+	url, errWithCode := p.GetSupportedAPIUri(relayMode)
+	if errWithCode != nil {
+		return "", errWithCode
+	}
+
+	return p.GetFullRequestURL(url, replicateModel.Slug()), nil
+}
+
+// 渠道插件 model_fallback.mapping 配置的备用模型，JSON 格式为 {"请求的模型或别名": ["备用模型", ...]}，
+// 按顺序尝试；备用模型同样会经过 model_alias 映射。使用部署时所有模型都发送到同一部署，不使用备用模型
+func (p *ReplicateProvider) getFallbackModels(modelName string) ([]string, error) {
+	if pluginBool(p.getPlugin("deployment"), "enable") {
+		return nil, nil
+	}
+
+	mapping := pluginString(p.getPlugin("model_fallback"), "mapping")
+	if mapping == "" {
+		return nil, nil
+	}
+
+	fallback := make(map[string][]string)
+	if err := json.Unmarshal([]byte(mapping), &fallback); err != nil {
+		return nil, err
+	}
+
+	return fallback[modelName], nil
+}
+
+// 上游或预测失败时才切换到备用模型，客户端错误（4xx）和本地错误（如请求取消）不切换
+func isFallbackError(errWithCode *types.OpenAIErrorWithStatusCode) bool {
+	if errWithCode == nil || errWithCode.LocalError {
+		return false
+	}
+
+	return errWithCode.StatusCode >= http.StatusInternalServerError || errWithCode.StatusCode == http.StatusTooManyRequests
+}
+
+// 主模型失败时依次使用备用模型重试，返回实际提供服务的模型，没有备用模型成功时返回最后一次的错误
+func withModelFallback[T any](p *ReplicateProvider, modelName string, errWithCode *types.OpenAIErrorWithStatusCode, attempt func(modelName string) (T, *types.OpenAIErrorWithStatusCode)) (T, string, *types.OpenAIErrorWithStatusCode) {
+	var result T
+	if !isFallbackError(errWithCode) {
+		return result, "", errWithCode
+	}
+
+	fallbackModels, err := p.getFallbackModels(modelName)
+	if err != nil {
+		return result, "", common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	failedModel := modelName
+	for _, fallbackModel := range fallbackModels {
+		if !isFallbackError(errWithCode) {
+			break
+		}
+
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate model %s failed: %s, falling back to %s", failedModel, errWithCode.Message, fallbackModel))
+		result, errWithCode = attempt(fallbackModel)
+		if errWithCode == nil {
+			return result, fallbackModel, nil
+		}
+		failedModel = fallbackModel
+	}
+
+	return result, "", errWithCode
+}
@@ -0,0 +1,159 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common/cache"
+	"one-api/common/logger"
+	"sort"
+	"time"
+)
+
+const (
+	defaultModelListCollections = "language-models"
+	defaultModelListTTL         = 3600
+)
+
+// Replicate collection 对应的能力
+var collectionCapabilities = map[string]string{
+	"language-models": "chat",
+	"vision-models":   "vision",
+	"text-to-image":   "image",
+	"speech-to-text":  "audio",
+}
+
+func (p *ReplicateProvider) GetModelList() ([]string, error) {
+	response, err := p.ListModels()
+	if err != nil {
+		return nil, err
+	}
+
+	modelList := make([]string, 0, len(response.Data))
+	for _, item := range response.Data {
+		modelList = append(modelList, item.Id)
+	}
+
+	return modelList, nil
+}
+
+// 获取渠道可用的模型，配置了模型别名时返回别名，否则查询 Replicate collections
+// 渠道插件 model_list.collections 配置查询的 collection，结果按渠道缓存 model_list.ttl 秒
+func (p *ReplicateProvider) ListModels() (*ReplicateModelListResponse, error) {
+	alias, err := p.getModelAlias()
+	if err != nil {
+		return nil, err
+	}
+	if len(alias) > 0 {
+		return getAliasModelList(alias), nil
+	}
+
+	cacheKey := ""
+	if p.Channel != nil && p.Channel.Id > 0 {
+		cacheKey = fmt.Sprintf("replicate:models:%d", p.Channel.Id)
+		if body, err := cache.GetCache[string](cacheKey); err == nil {
+			response := &ReplicateModelListResponse{}
+			if json.Unmarshal([]byte(body), response) == nil {
+				return response, nil
+			}
+		}
+	}
+
+	response, err := p.queryModelList()
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheKey != "" {
+		body, _ := json.Marshal(response)
+		ttl := pluginInt(p.getPlugin("model_list"), "ttl", defaultModelListTTL)
+		if err := cache.SetCache(cacheKey, string(body), time.Duration(ttl)*time.Second); err != nil {
+			logger.SysError(fmt.Sprintf("replicate model list cache set failed: %s", err.Error()))
+		}
+	}
+
+	return response, nil
+}
+
+func getAliasModelList(alias map[string]string) *ReplicateModelListResponse {
+	response := &ReplicateModelListResponse{Object: "list"}
+	for name, target := range alias {
+		ownedBy := "replicate"
+		if replicateModel, ok := parseReplicateModel(target); ok {
+			ownedBy = replicateModel.Owner
+		}
+
+		response.Data = append(response.Data, ReplicateModelListItem{
+			Id:      name,
+			Object:  "model",
+			OwnedBy: ownedBy,
+		})
+	}
+
+	sort.Slice(response.Data, func(i, j int) bool {
+		return response.Data[i].Id < response.Data[j].Id
+	})
+
+	return response
+}
+
+// 依次查询 collection，同一模型出现在多个 collection 时合并能力
+func (p *ReplicateProvider) queryModelList() (*ReplicateModelListResponse, error) {
+	collections := pluginList(p.getPlugin("model_list"), "collections")
+	if len(collections) == 0 {
+		collections = []string{defaultModelListCollections}
+	}
+
+	headers := p.GetRequestHeaders()
+	items := make(map[string]*ReplicateModelListItem)
+	var ids []string
+	for _, slug := range collections {
+		req, err := p.Requester.NewRequest(http.MethodGet, p.GetFullRequestURL(p.Config.ModelList, slug), p.Requester.WithHeader(headers))
+		if err != nil {
+			return nil, errors.New("new_request_failed")
+		}
+
+		collection := &ReplicateCollection{}
+		if errWithCode := p.sendRequest(req, collection); errWithCode != nil {
+			return nil, errors.New(errWithCode.Message)
+		}
+
+		capability, ok := collectionCapabilities[slug]
+		if !ok {
+			capability = slug
+		}
+
+		for _, info := range collection.Models {
+			id := info.Owner + "/" + info.Name
+			item, ok := items[id]
+			if !ok {
+				item = &ReplicateModelListItem{
+					Id:      id,
+					Object:  "model",
+					OwnedBy: info.Owner,
+				}
+				items[id] = item
+				ids = append(ids, id)
+			}
+			item.Capabilities = appendCapability(item.Capabilities, capability)
+		}
+	}
+
+	response := &ReplicateModelListResponse{Object: "list", Data: make([]ReplicateModelListItem, 0, len(ids))}
+	for _, id := range ids {
+		response.Data = append(response.Data, *items[id])
+	}
+
+	return response, nil
+}
+
+func appendCapability(capabilities []string, capability string) []string {
+	for _, item := range capabilities {
+		if item == capability {
+			return capabilities
+		}
+	}
+
+	return append(capabilities, capability)
+}
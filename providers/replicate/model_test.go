@@ -0,0 +1,161 @@
+package replicate
+
+import (
+	"io"
+	"net/http"
+	"one-api/common/config"
+	"one-api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPredictionURLDeployment(t *testing.T) {
+	plugin := model.PluginType{
+		"deployment": {"enable": true, "owner": "acme", "name": "llama-prod"},
+	}
+	provider, _ := getMockProvider(plugin, nil)
+
+	replicateModel, errWithCode := provider.resolveModel("any-model")
+	assert.Nil(t, errWithCode)
+	assert.True(t, replicateModel.Deployment)
+	assert.Equal(t, "acme/llama-prod", replicateModel.Slug())
+
+	url, errWithCode := provider.getPredictionURL(config.RelayModeChatCompletions, replicateModel)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "https://api.replicate.com/v1/deployments/acme/llama-prod/predictions", url)
+
+	for _, params := range []map[string]interface{}{
+		{"enable": true, "owner": "acme"},
+		{"enable": true, "name": "llama-prod"},
+		{"enable": true, "owner": "acme/team", "name": "llama-prod"},
+	} {
+		provider, _ = getMockProvider(model.PluginType{"deployment": params}, nil)
+		_, errWithCode = provider.resolveModel("any-model")
+		assert.NotNil(t, errWithCode)
+		assert.Equal(t, "invalid_replicate_config", errWithCode.Code)
+	}
+}
+
+func TestCreateChatCompletionDeployment(t *testing.T) {
+	plugin := model.PluginType{
+		"deployment": {"enable": true, "owner": "acme", "name": "llama-prod"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["from deployment"],"metrics":{"input_token_count":4,"output_token_count":2}}`)
+	})
+
+	request := getTestChatRequest("hi")
+	request.Model = "llama-prod"
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "from deployment", response.Choices[0].Message.Content)
+	assert.Equal(t, 6, response.Usage.TotalTokens)
+
+	assert.Equal(t, "/v1/deployments/acme/llama-prod/predictions", doer.requests[0].URL.Path)
+	body, _ := io.ReadAll(doer.requests[0].Body)
+	assert.NotContains(t, string(body), `"version"`)
+	assert.Equal(t, "/v1/predictions/p1", doer.requests[1].URL.Path)
+}
+
+func TestListModelsAlias(t *testing.T) {
+	plugin := model.PluginType{
+		"model_alias": {"mapping": `{"llama-3":"meta/meta-llama-3-70b-instruct","flux":"black-forest-labs/flux-schnell"}`},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusOK, `{}`)
+	})
+
+	response, err := provider.ListModels()
+	assert.Nil(t, err)
+	assert.Equal(t, "list", response.Object)
+	assert.Equal(t, []ReplicateModelListItem{
+		{Id: "flux", Object: "model", OwnedBy: "black-forest-labs"},
+		{Id: "llama-3", Object: "model", OwnedBy: "meta"},
+	}, response.Data)
+	assert.Empty(t, doer.requests)
+}
+
+func TestListModelsLive(t *testing.T) {
+	plugin := model.PluginType{
+		"model_list": {"collections": "language-models,vision-models"},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		switch req.URL.Path {
+		case "/v1/collections/language-models":
+			return jsonResponse(http.StatusOK, `{"slug":"language-models","models":[{"owner":"meta","name":"meta-llama-3-70b-instruct"},{"owner":"yorickvp","name":"llava-13b"}]}`)
+		case "/v1/collections/vision-models":
+			return jsonResponse(http.StatusOK, `{"slug":"vision-models","models":[{"owner":"yorickvp","name":"llava-13b"}]}`)
+		}
+		return jsonResponse(http.StatusNotFound, `{"detail":"not found"}`)
+	})
+	provider.Channel.Id = 331
+
+	response, err := provider.ListModels()
+	assert.Nil(t, err)
+	assert.Equal(t, []ReplicateModelListItem{
+		{Id: "meta/meta-llama-3-70b-instruct", Object: "model", OwnedBy: "meta", Capabilities: []string{"chat"}},
+		{Id: "yorickvp/llava-13b", Object: "model", OwnedBy: "yorickvp", Capabilities: []string{"chat", "vision"}},
+	}, response.Data)
+	assert.Equal(t, 2, doer.count(http.MethodGet))
+
+	// 按渠道缓存
+	modelList, err := provider.GetModelList()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"meta/meta-llama-3-70b-instruct", "yorickvp/llava-13b"}, modelList)
+	assert.Equal(t, 2, doer.count(http.MethodGet))
+
+	plugin = model.PluginType{
+		"model_list": {"collections": "missing"},
+	}
+	provider, _ = getMockProvider(plugin, doer.handler)
+	_, err = provider.ListModels()
+	assert.NotNil(t, err)
+}
+
+func TestCreateChatCompletionModelFallback(t *testing.T) {
+	plugin := model.PluginType{
+		"model_fallback": {"mapping": `{"meta/meta-llama-3-70b-instruct":["meta/meta-llama-3-8b-instruct"]}`},
+	}
+	handler := func(primaryStatus int) func(req *http.Request) *http.Response {
+		return func(req *http.Request) *http.Response {
+			switch {
+			case req.URL.Path == "/v1/models/meta/meta-llama-3-70b-instruct/predictions":
+				return jsonResponse(primaryStatus, `{"detail":"primary failed"}`)
+			case req.Method == http.MethodPost:
+				return jsonResponse(http.StatusCreated, `{"id":"p2","status":"starting"}`)
+			default:
+				return jsonResponse(http.StatusOK, `{"id":"p2","status":"succeeded","output":["from fallback"]}`)
+			}
+		}
+	}
+
+	// 主模型上游错误时使用备用模型，响应中记录实际提供服务的模型
+	provider, doer := getMockProvider(plugin, handler(http.StatusInternalServerError))
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "from fallback", response.Choices[0].Message.Content)
+	assert.Equal(t, "meta/meta-llama-3-8b-instruct", response.Model)
+	assert.Equal(t, "/v1/models/meta/meta-llama-3-8b-instruct/predictions", doer.requests[1].URL.Path)
+
+	// 流式输出同样切换
+	provider, _ = getMockProvider(plugin, handler(http.StatusInternalServerError))
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "from fallback", streamContent(chunks))
+	assert.Equal(t, "meta/meta-llama-3-8b-instruct", chunks[0].Model)
+
+	// 客户端错误不切换
+	provider, doer = getMockProvider(plugin, handler(http.StatusUnprocessableEntity))
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, errWithCode.StatusCode)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+}
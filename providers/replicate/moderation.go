@@ -0,0 +1,232 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/utils"
+	"one-api/types"
+	"strings"
+	"sync"
+)
+
+const defaultModerationThreshold = 0.5
+
+// OpenAI 审核接口的分类
+var moderationCategories = []string{
+	"hate",
+	"hate/threatening",
+	"harassment",
+	"harassment/threatening",
+	"self-harm",
+	"self-harm/intent",
+	"self-harm/instructions",
+	"sexual",
+	"sexual/minors",
+	"violence",
+	"violence/graphic",
+}
+
+// 常见毒性分类模型的标签与 OpenAI 分类的对应关系
+var defaultModerationMapping = map[string]string{
+	"toxic":           "harassment",
+	"toxicity":        "harassment",
+	"severe_toxic":    "harassment/threatening",
+	"severe_toxicity": "harassment/threatening",
+	"insult":          "harassment",
+	"threat":          "violence",
+	"obscene":         "sexual",
+	"sexual_explicit": "sexual",
+	"identity_hate":   "hate",
+	"identity_attack": "hate",
+}
+
+// 使用 Replicate 上的分类模型实现审核接口，每个输入创建一个预测
+func (p *ReplicateProvider) CreateModeration(request *types.ModerationRequest) (response *types.ModerationResponse, errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	inputs, errWithCode := getModerationInputs(request.Input)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateModel, errWithCode := p.resolveModel(request.Model)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	plugin := p.getPlugin("moderation")
+	mapping, thresholds, errWithCode := p.getModerationConfig()
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	inputKey := pluginString(plugin, "input_key")
+	if inputKey == "" {
+		inputKey = "text"
+	}
+
+	outputs := make([]ReplicateModerationOutput, len(inputs))
+	errs := make([]*types.OpenAIErrorWithStatusCode, len(inputs))
+
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, pluginInt(plugin, "concurrency", defaultFanOutConcurrency))
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(index int, input string) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+
+			replicateRequest := &ReplicateRequest[map[string]any]{
+				Version: replicateModel.Version,
+				Input:   map[string]any{inputKey: input},
+			}
+			outputs[index], errs[index] = p.createModerationPrediction(replicateRequest, replicateModel)
+		}(i, input)
+	}
+	wg.Wait()
+
+	for _, errWithCode := range errs {
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+	}
+
+	results := make([]ReplicateModerationResult, len(outputs))
+	for index, output := range outputs {
+		results[index] = convertToModerationResult(output, mapping, thresholds)
+	}
+
+	p.Usage.TotalTokens = p.Usage.PromptTokens
+
+	return &types.ModerationResponse{
+		ID:      fmt.Sprintf("modr-%s", utils.GetUUID()),
+		Model:   request.Model,
+		Results: results,
+	}, nil
+}
+
+func (p *ReplicateProvider) createModerationPrediction(replicateRequest *ReplicateRequest[map[string]any], replicateModel *ReplicateModel) (ReplicateModerationOutput, *types.OpenAIErrorWithStatusCode) {
+	fullRequestURL, errWithCode := p.getPredictionURL(config.RelayModeModerations, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	headers := p.GetRequestHeaders()
+	p.setPreferWait(headers)
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(replicateRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	replicateResponse := &ReplicateResponse[ReplicateModerationOutput]{}
+	if errWithCode := p.sendRequest(req, replicateResponse); errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.logPrediction(replicateResponse.ID)
+
+	replicateResponse, err = getPrediction(p, replicateResponse)
+	if err != nil {
+		return nil, predictionErrorWrapper(err)
+	}
+
+	return replicateResponse.Output, nil
+}
+
+// 审核输入支持字符串和字符串数组
+func getModerationInputs(input any) ([]string, *types.OpenAIErrorWithStatusCode) {
+	switch value := input.(type) {
+	case string:
+		return []string{value}, nil
+	case []string:
+		if len(value) > 0 {
+			return value, nil
+		}
+	case []any:
+		inputs := make([]string, 0, len(value))
+		for _, item := range value {
+			text, ok := item.(string)
+			if !ok {
+				return nil, common.StringErrorWrapperLocal("input must be a string or an array of strings", "invalid_input", http.StatusBadRequest)
+			}
+			inputs = append(inputs, text)
+		}
+		if len(inputs) > 0 {
+			return inputs, nil
+		}
+	}
+
+	return nil, common.StringErrorWrapperLocal("input must be a string or an array of strings", "invalid_input", http.StatusBadRequest)
+}
+
+// 获取标签映射和分类阈值
+// 渠道插件 moderation.mapping 为模型标签到 OpenAI 分类的映射，moderation.thresholds 为分类阈值，* 为默认阈值
+func (p *ReplicateProvider) getModerationConfig() (map[string]string, map[string]float64, *types.OpenAIErrorWithStatusCode) {
+	plugin := p.getPlugin("moderation")
+
+	mapping := defaultModerationMapping
+	if value := pluginString(plugin, "mapping"); value != "" {
+		mapping = make(map[string]string)
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			return nil, nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+		}
+	}
+
+	thresholds := make(map[string]float64)
+	if value := pluginString(plugin, "thresholds"); value != "" {
+		if err := json.Unmarshal([]byte(value), &thresholds); err != nil {
+			return nil, nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+		}
+	}
+
+	return mapping, thresholds, nil
+}
+
+// 将模型的标签分数转换为 OpenAI 的分类结果，多个标签对应同一分类时取最大值
+func convertToModerationResult(output ReplicateModerationOutput, mapping map[string]string, thresholds map[string]float64) ReplicateModerationResult {
+	result := ReplicateModerationResult{
+		Categories:     make(map[string]bool, len(moderationCategories)),
+		CategoryScores: make(map[string]float64, len(moderationCategories)),
+	}
+	for _, category := range moderationCategories {
+		result.Categories[category] = false
+		result.CategoryScores[category] = 0
+	}
+
+	for label, score := range output {
+		category, ok := mapping[strings.ToLower(label)]
+		if !ok {
+			continue
+		}
+		if score > result.CategoryScores[category] {
+			result.CategoryScores[category] = score
+		}
+	}
+
+	defaultThreshold, ok := thresholds["*"]
+	if !ok {
+		defaultThreshold = defaultModerationThreshold
+	}
+
+	for category, score := range result.CategoryScores {
+		threshold, ok := thresholds[category]
+		if !ok {
+			threshold = defaultThreshold
+		}
+
+		if score >= threshold && score > 0 {
+			result.Categories[category] = true
+			result.Flagged = true
+		}
+	}
+
+	return result
+}
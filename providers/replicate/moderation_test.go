@@ -0,0 +1,81 @@
+package replicate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertToModerationResult(t *testing.T) {
+	output := ReplicateModerationOutput{
+		"toxicity":        0.92,
+		"insult":          0.97,
+		"threat":          0.3,
+		"identity_attack": 0.01,
+		"unknown_label":   0.99,
+	}
+
+	result := convertToModerationResult(output, defaultModerationMapping, map[string]float64{"violence": 0.2})
+	assert.True(t, result.Flagged)
+	assert.Len(t, result.Categories, len(moderationCategories))
+	assert.Equal(t, 0.97, result.CategoryScores["harassment"])
+	assert.True(t, result.Categories["harassment"])
+	assert.Equal(t, 0.3, result.CategoryScores["violence"])
+	assert.True(t, result.Categories["violence"])
+	assert.Equal(t, 0.01, result.CategoryScores["hate"])
+	assert.False(t, result.Categories["hate"])
+	assert.False(t, result.Categories["sexual"])
+
+	result = convertToModerationResult(ReplicateModerationOutput{"toxicity": 0.4}, defaultModerationMapping, nil)
+	assert.False(t, result.Flagged)
+}
+
+func TestReplicateModerationOutputUnmarshal(t *testing.T) {
+	output := ReplicateModerationOutput{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"toxicity":0.8,"threat":0.1}`), &output))
+	assert.Equal(t, 0.8, output["toxicity"])
+
+	output = ReplicateModerationOutput{}
+	assert.Nil(t, json.Unmarshal([]byte(`[{"label":"toxic","score":0.7},{"label":"obscene","score":0.2}]`), &output))
+	assert.Equal(t, 0.7, output["toxic"])
+	assert.Equal(t, 0.2, output["obscene"])
+}
+
+func TestCreateModerationBatch(t *testing.T) {
+	plugin := model.PluginType{
+		"model_alias": {"mapping": `{"text-moderation-stable":"acme/toxicity"}`},
+		"moderation":  {"thresholds": `{"*":0.6}`},
+	}
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		body, _ := io.ReadAll(req.Body)
+		if strings.Contains(string(body), "you idiot") {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"succeeded","output":{"toxicity":0.95,"insult":0.9}}`)
+		}
+		return jsonResponse(http.StatusCreated, `{"id":"p2","status":"succeeded","output":{"toxicity":0.02,"insult":0.01}}`)
+	})
+
+	response, errWithCode := provider.CreateModeration(&types.ModerationRequest{
+		Model: "text-moderation-stable",
+		Input: []any{"you idiot", "have a nice day"},
+	})
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "text-moderation-stable", response.Model)
+	assert.Equal(t, 2, doer.count(http.MethodPost))
+	assert.Equal(t, "/v1/models/acme/toxicity/predictions", doer.requests[0].URL.Path)
+
+	results := response.Results.([]ReplicateModerationResult)
+	assert.Len(t, results, 2)
+	assert.True(t, results[0].Flagged)
+	assert.Equal(t, 0.95, results[0].CategoryScores["harassment"])
+	assert.False(t, results[1].Flagged)
+
+	_, errWithCode = provider.CreateModeration(&types.ModerationRequest{Model: "text-moderation-stable", Input: []any{1}})
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+}
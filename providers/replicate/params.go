@@ -0,0 +1,511 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/types"
+	"strconv"
+)
+
+// 参数取值范围 [min, max]
+type paramRange [2]float64
+
+// 未配置时使用 OpenAI 的取值范围
+var defaultParamRanges = map[string]paramRange{
+	"temperature": {0, 2},
+	"top_p":       {0, 1},
+}
+
+// 获取模型的参数取值范围，渠道插件 param_range.ranges 按模型配置，* 对所有模型生效
+func (p *ReplicateProvider) getParamRanges(replicateModel *ReplicateModel) (map[string]paramRange, *types.OpenAIErrorWithStatusCode) {
+	ranges := make(map[string]paramRange)
+	for name, value := range defaultParamRanges {
+		ranges[name] = value
+	}
+
+	config := pluginString(p.getPlugin("param_range"), "ranges")
+	if config == "" {
+		return ranges, nil
+	}
+
+	modelRanges := make(map[string]map[string]paramRange)
+	if err := json.Unmarshal([]byte(config), &modelRanges); err != nil {
+		return nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	for _, key := range []string{"*", replicateModel.Owner + "/" + replicateModel.Name} {
+		for name, value := range modelRanges[key] {
+			ranges[name] = value
+		}
+	}
+
+	return ranges, nil
+}
+
+// 检查 temperature、top_p 是否在模型允许的范围内，超出时截断，严格模式下返回 400
+func (p *ReplicateProvider) clampParams(input *ReplicateChatRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	ranges, errWithCode := p.getParamRanges(replicateModel)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	strict := pluginBool(p.getPlugin("param_range"), "strict")
+	// 指针与原始请求共用，截断时替换为新值，避免影响重试到其他渠道
+	params := map[string]**float64{
+		"temperature": &input.Temperature,
+		"top_p":       &input.TopP,
+	}
+
+	for name, param := range params {
+		value := *param
+		if value == nil {
+			continue
+		}
+
+		valueRange := ranges[name]
+		if *value >= valueRange[0] && *value <= valueRange[1] {
+			continue
+		}
+
+		if strict {
+			return common.StringErrorWrapperLocal(fmt.Sprintf("%s must be between %g and %g for model %s", name, valueRange[0], valueRange[1], replicateModel.Slug()), "invalid_parameter", http.StatusBadRequest)
+		}
+
+		clamped := min(max(*value, valueRange[0]), valueRange[1])
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate %s %g out of range for model %s, clamped to %g", name, *value, replicateModel.Slug(), clamped))
+		*param = &clamped
+	}
+
+	return nil
+}
+
+const (
+	zeroTemperatureOmit = "omit"
+	zeroTemperatureKeep = "keep"
+)
+
+// temperature 为 0 时的处理方式：数值表示替换为该值，omit 表示不发送（使用模型默认值）
+// 已知 meta/llama-2-*-chat 的 temperature 最小为 0.01，传 0 会返回 422，默认替换为 0.01 以保持贪心解码
+// meta/meta-llama-3-*、mistralai/* 等模型接受 0，未配置的模型原样发送
+var defaultZeroTemperature = map[string]any{
+	"meta/llama-2-70b-chat": 0.01,
+	"meta/llama-2-13b-chat": 0.01,
+	"meta/llama-2-7b-chat":  0.01,
+}
+
+// 获取模型 temperature 为 0 时的处理方式，渠道插件 zero_temperature.models 按模型配置，* 对所有模型生效，
+// 值为 keep 时原样发送，返回 nil 表示原样发送
+func (p *ReplicateProvider) getZeroTemperature(replicateModel *ReplicateModel) (any, *types.OpenAIErrorWithStatusCode) {
+	treatments := make(map[string]any)
+	for key, value := range defaultZeroTemperature {
+		treatments[key] = value
+	}
+
+	if config := pluginString(p.getPlugin("zero_temperature"), "models"); config != "" {
+		modelTreatments := make(map[string]any)
+		if err := json.Unmarshal([]byte(config), &modelTreatments); err != nil {
+			return nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+		}
+
+		for key, value := range modelTreatments {
+			if epsilon, ok := value.(float64); !(ok && epsilon > 0) && value != zeroTemperatureOmit && value != zeroTemperatureKeep {
+				return nil, common.StringErrorWrapperLocal(fmt.Sprintf("zero temperature for %s must be a positive number, omit or keep", key), "invalid_replicate_config", http.StatusInternalServerError)
+			}
+			treatments[key] = value
+		}
+	}
+
+	treatment, ok := treatments[replicateModel.Slug()]
+	if !ok {
+		treatment = treatments["*"]
+	}
+	if treatment == zeroTemperatureKeep {
+		return nil, nil
+	}
+
+	return treatment, nil
+}
+
+// temperature 为 0（贪心解码）时按模型替换为极小值或不发送，避免模型拒绝 0
+func (p *ReplicateProvider) applyZeroTemperature(input *ReplicateChatRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	if input.Temperature == nil || *input.Temperature != 0 {
+		return nil
+	}
+
+	treatment, errWithCode := p.getZeroTemperature(replicateModel)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	switch treatment := treatment.(type) {
+	case float64:
+		// 替换指针，避免影响重试到其他渠道时的原始请求
+		input.Temperature = &treatment
+	case string:
+		input.Temperature = nil
+	}
+
+	return nil
+}
+
+// 未配置时 max_tokens 不小于 1024，避免部分模型默认输出过短
+const defaultMaxTokensFloor = 1024
+
+// 获取模型的最小 max_tokens，渠道插件 max_tokens_floor.floors 按模型配置，* 对所有模型生效，0 表示不设下限
+func (p *ReplicateProvider) getMaxTokensFloor(replicateModel *ReplicateModel) (int, *types.OpenAIErrorWithStatusCode) {
+	config := pluginString(p.getPlugin("max_tokens_floor"), "floors")
+	if config == "" {
+		return defaultMaxTokensFloor, nil
+	}
+
+	floors := make(map[string]int)
+	if err := json.Unmarshal([]byte(config), &floors); err != nil {
+		return 0, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	for key, floor := range floors {
+		if floor < 0 {
+			return 0, common.StringErrorWrapperLocal(fmt.Sprintf("max_tokens floor for %s must be non-negative", key), "invalid_replicate_config", http.StatusInternalServerError)
+		}
+	}
+
+	if floor, ok := floors[replicateModel.Slug()]; ok {
+		return floor, nil
+	}
+	if floor, ok := floors["*"]; ok {
+		return floor, nil
+	}
+
+	return defaultMaxTokensFloor, nil
+}
+
+// max_tokens 只限制输出内容，max_completion_tokens 限制包含推理在内的全部输出，
+// 同时设置时取较小值；最小 MaxTokens 按模型配置，见 applyMaxTokensFloor
+func getChatMaxTokens(request *types.ChatCompletionRequest) int {
+	if request.MaxCompletionTokens > 0 && (request.MaxTokens <= 0 || request.MaxCompletionTokens < request.MaxTokens) {
+		return request.MaxCompletionTokens
+	}
+
+	return request.MaxTokens
+}
+
+// 获取模型为推理预留的 token 数，渠道插件 reasoning_tokens.reserve 按模型配置，* 对所有模型生效，未配置时为 0
+func (p *ReplicateProvider) getReasoningReserve(replicateModel *ReplicateModel) (int, *types.OpenAIErrorWithStatusCode) {
+	config := pluginString(p.getPlugin("reasoning_tokens"), "reserve")
+	if config == "" {
+		return 0, nil
+	}
+
+	reserves := make(map[string]int)
+	if err := json.Unmarshal([]byte(config), &reserves); err != nil {
+		return 0, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	for key, reserve := range reserves {
+		if reserve < 0 {
+			return 0, common.StringErrorWrapperLocal(fmt.Sprintf("reasoning tokens reserve for %s must be non-negative", key), "invalid_replicate_config", http.StatusInternalServerError)
+		}
+	}
+
+	if reserve, ok := reserves[replicateModel.Slug()]; ok {
+		return reserve, nil
+	}
+
+	return reserves["*"], nil
+}
+
+// 推理模型的 max_tokens 包含推理 token，客户端的 max_tokens 只计输出内容，
+// 发送时加上预留的推理 token；同时设置 max_completion_tokens 时不超过 max_completion_tokens
+func (p *ReplicateProvider) applyReasoningTokens(input *ReplicateChatRequest, request *types.ChatCompletionRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	if request.MaxTokens <= 0 {
+		return nil
+	}
+
+	reserve, errWithCode := p.getReasoningReserve(replicateModel)
+	if errWithCode != nil || reserve == 0 {
+		return errWithCode
+	}
+
+	input.MaxTokens = request.MaxTokens + reserve
+	if request.MaxCompletionTokens > 0 && request.MaxCompletionTokens < input.MaxTokens {
+		input.MaxTokens = request.MaxCompletionTokens
+	}
+
+	return nil
+}
+
+// max_tokens 小于模型的下限时提高到下限
+func (p *ReplicateProvider) applyMaxTokensFloor(input *ReplicateChatRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	floor, errWithCode := p.getMaxTokensFloor(replicateModel)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	if input.MaxTokens < floor {
+		input.MaxTokens = floor
+	}
+
+	return nil
+}
+
+// 部分模型读取的输出长度参数，可能通过透传参数传入，同样受 max_output_tokens.limit 限制
+var maxTokensAliases = []string{"max_new_tokens", "max_length", "max_output_tokens"}
+
+// 渠道插件 max_output_tokens.limit 限制 max_tokens 的上限，超出或未设置时降到上限，0 表示不限制
+// 在最小输出长度之后执行，上限始终生效
+func (p *ReplicateProvider) applyMaxOutputTokens(input *ReplicateChatRequest, replicateModel *ReplicateModel) {
+	limit := pluginInt(p.getPlugin("max_output_tokens"), "limit", 0)
+	if limit <= 0 {
+		return
+	}
+
+	if input.MaxTokens <= 0 || input.MaxTokens > limit {
+		logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate model %s max_tokens %d clamped to channel limit %d", replicateModel.Slug(), input.MaxTokens, limit))
+		input.MaxTokens = limit
+	}
+
+	// 透传的同类参数超出上限时降到上限，不是数字时丢弃
+	for _, key := range maxTokensAliases {
+		value, exists := input.Extra[key]
+		if !exists {
+			continue
+		}
+
+		if number, ok := value.(float64); ok && number <= float64(limit) {
+			continue
+		}
+
+		logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate model %s %s %v clamped to channel limit %d", replicateModel.Slug(), key, value, limit))
+		if _, ok := value.(float64); ok {
+			input.Extra[key] = limit
+		} else {
+			delete(input.Extra, key)
+		}
+	}
+}
+
+// 转发 logit_bias，只有渠道插件 logit_bias.models 中的模型支持，* 表示所有模型，其他模型忽略
+func (p *ReplicateProvider) applyLogitBias(input *ReplicateChatRequest, logitBias any, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	if logitBias == nil {
+		return nil
+	}
+
+	plugin := p.getPlugin("logit_bias")
+	supported := false
+	for _, model := range pluginList(plugin, "models") {
+		if model == "*" || model == replicateModel.Slug() {
+			supported = true
+			break
+		}
+	}
+
+	if !supported {
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate model %s does not support logit_bias, ignored", replicateModel.Slug()))
+		return nil
+	}
+
+	bias, err := parseLogitBias(logitBias)
+	if err != nil {
+		return common.ErrorWrapperLocal(err, "invalid_logit_bias", http.StatusBadRequest)
+	}
+
+	inputKey := pluginString(plugin, "input_key")
+	if inputKey == "" {
+		inputKey = "logit_bias"
+	}
+
+	if input.Extra == nil {
+		input.Extra = make(map[string]any)
+	}
+	input.Extra[inputKey] = bias
+
+	return nil
+}
+
+// 校验 logit_bias，key 为非负的 token id，取值范围为 [-100, 100]
+func parseLogitBias(logitBias any) (map[string]float64, error) {
+	values, ok := logitBias.(map[string]any)
+	if !ok {
+		return nil, errors.New("logit_bias must be an object mapping token ids to bias values")
+	}
+
+	bias := make(map[string]float64, len(values))
+	for key, value := range values {
+		tokenId, err := strconv.Atoi(key)
+		if err != nil || tokenId < 0 {
+			return nil, fmt.Errorf("invalid token id in logit_bias: %s", key)
+		}
+
+		number, ok := value.(float64)
+		if !ok || number < -100 || number > 100 {
+			return nil, fmt.Errorf("logit_bias value for token %s must be a number between -100 and 100", key)
+		}
+
+		bias[strconv.Itoa(tokenId)] = number
+	}
+
+	return bias, nil
+}
+
+// 获取模型的上下文长度，渠道插件 context_limit.limits 按模型配置，* 对所有模型生效，未配置时不限制
+func (p *ReplicateProvider) getContextLimit(replicateModel *ReplicateModel) (int, *types.OpenAIErrorWithStatusCode) {
+	config := pluginString(p.getPlugin("context_limit"), "limits")
+	if config == "" {
+		return 0, nil
+	}
+
+	limits := make(map[string]int)
+	if err := json.Unmarshal([]byte(config), &limits); err != nil {
+		return 0, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	if limit, ok := limits[replicateModel.Slug()]; ok {
+		return limit, nil
+	}
+
+	return limits["*"], nil
+}
+
+// 发送前检查提示词与 max_tokens 之和是否超出模型上下文长度，避免上游处理中途返回 422
+func (p *ReplicateProvider) checkContextLength(input *ReplicateChatRequest, replicateModel *ReplicateModel, modelName string) *types.OpenAIErrorWithStatusCode {
+	limit, errWithCode := p.getContextLimit(replicateModel)
+	if errWithCode != nil || limit <= 0 {
+		return errWithCode
+	}
+
+	promptTokens := common.CountTokenText(input.SystemPrompt+input.Prompt, modelName)
+	if promptTokens+input.MaxTokens <= limit {
+		return nil
+	}
+
+	errWithCode = common.StringErrorWrapperLocal(fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.", limit, promptTokens+input.MaxTokens, promptTokens, input.MaxTokens), "context_length_exceeded", http.StatusBadRequest)
+	errWithCode.Type = errorTypeInvalidRequest
+	errWithCode.Param = "messages"
+
+	return errWithCode
+}
+
+// 客户端未设置时使用渠道插件 defaults 配置的默认参数，显式设置的值（包括 0）保持不变
+func (p *ReplicateProvider) applyChannelDefaults(input *ReplicateChatRequest) {
+	plugin := p.getPlugin("defaults")
+	if plugin == nil {
+		return
+	}
+
+	for _, param := range []struct {
+		key    string
+		target **float64
+	}{
+		{"temperature", &input.Temperature},
+		{"top_p", &input.TopP},
+		{"presence_penalty", &input.PresencePenalty},
+		{"frequency_penalty", &input.FrequencyPenalty},
+	} {
+		if *param.target != nil {
+			continue
+		}
+
+		if value := pluginFloat(plugin, param.key, math.NaN()); !math.IsNaN(value) {
+			*param.target = &value
+		}
+	}
+}
+
+// 不支持的参数组合，模型在渠道插件 param_combinations.capabilities 中声明支持对应能力时不检查
+type paramCombination struct {
+	capability string
+	param      string
+	message    string
+	invalid    func(request *types.ChatCompletionRequest, ranges map[string]paramRange) bool
+}
+
+var paramCombinations = []paramCombination{
+	{
+		// 流式输出只返回一个结果
+		capability: "stream_n",
+		param:      "n",
+		message:    "n greater than 1 is not supported when stream is true",
+		invalid: func(request *types.ChatCompletionRequest, _ map[string]paramRange) bool {
+			return request.Stream && request.N != nil && *request.N > 1
+		},
+	},
+	{
+		// temperature 在取值范围两端且 top_p 为下限时，采样结果不确定
+		capability: "extreme_sampling",
+		param:      "top_p",
+		message:    "temperature at the end of its range cannot be combined with the minimum top_p",
+		invalid: func(request *types.ChatCompletionRequest, ranges map[string]paramRange) bool {
+			if request.Temperature == nil || request.TopP == nil {
+				return false
+			}
+			temperature, topP := ranges["temperature"], ranges["top_p"]
+			return (*request.Temperature <= temperature[0] || *request.Temperature >= temperature[1]) && *request.TopP <= topP[0]
+		},
+	},
+	{
+		// 工具调用的输出不是 JSON 格式的回复
+		capability: "json_tools",
+		param:      "response_format",
+		message:    "JSON response_format cannot be combined with tools or functions",
+		invalid: func(request *types.ChatCompletionRequest, _ map[string]paramRange) bool {
+			if request.ResponseFormat == nil || (request.ResponseFormat.Type != "json_object" && request.ResponseFormat.Type != "json_schema") {
+				return false
+			}
+			return len(request.Tools) > 0 || len(request.Functions) > 0
+		},
+	},
+}
+
+// 获取模型支持的参数组合，渠道插件 param_combinations.capabilities 按模型配置，* 对所有模型生效
+func (p *ReplicateProvider) getModelCapabilities(replicateModel *ReplicateModel) (map[string]bool, *types.OpenAIErrorWithStatusCode) {
+	capabilities := make(map[string]bool)
+	config := pluginString(p.getPlugin("param_combinations"), "capabilities")
+	if config == "" {
+		return capabilities, nil
+	}
+
+	modelCapabilities := make(map[string][]string)
+	if err := json.Unmarshal([]byte(config), &modelCapabilities); err != nil {
+		return nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+	}
+
+	for _, key := range []string{"*", replicateModel.Slug()} {
+		for _, capability := range modelCapabilities[key] {
+			capabilities[capability] = true
+		}
+	}
+
+	return capabilities, nil
+}
+
+// 检查模型不支持的参数组合，返回与 OpenAI 格式一致的 400 错误
+func (p *ReplicateProvider) validateParamCombinations(request *types.ChatCompletionRequest, replicateModel *ReplicateModel) *types.OpenAIErrorWithStatusCode {
+	capabilities, errWithCode := p.getModelCapabilities(replicateModel)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	ranges, errWithCode := p.getParamRanges(replicateModel)
+	if errWithCode != nil {
+		return errWithCode
+	}
+
+	for _, combination := range paramCombinations {
+		if capabilities[combination.capability] || !combination.invalid(request, ranges) {
+			continue
+		}
+
+		errWithCode = common.StringErrorWrapperLocal(fmt.Sprintf("%s for model %s", combination.message, replicateModel.Slug()), "invalid_parameter_combination", http.StatusBadRequest)
+		errWithCode.Type = errorTypeInvalidRequest
+		errWithCode.Param = combination.param
+
+		return errWithCode
+	}
+
+	return nil
+}
@@ -0,0 +1,393 @@
+package replicate
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClampParamsTemperature(t *testing.T) {
+	plugin := model.PluginType{
+		"param_range": {"ranges": `{"meta/meta-llama-3-70b-instruct": {"temperature": [0.01, 1]}}`},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	request := getTestChatRequest("hi")
+	temperature := 2.0
+	request.Temperature = &temperature
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1.0, *replicateRequest.Input.Temperature)
+	// 原始请求不受影响
+	assert.Equal(t, 2.0, *request.Temperature)
+
+	plugin["param_range"]["strict"] = true
+	provider = getReplicateProvider("", plugin, nil)
+	_, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Contains(t, errWithCode.Message, "temperature")
+}
+
+func TestClampParamsTopP(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+
+	request := getTestChatRequest("hi")
+	topP := 1.5
+	request.TopP = &topP
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 1.0, *replicateRequest.Input.TopP)
+
+	plugin := model.PluginType{
+		"param_range": {"strict": true},
+	}
+	provider = getReplicateProvider("", plugin, nil)
+	_, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_parameter", errWithCode.Code)
+	assert.Contains(t, errWithCode.Message, "top_p")
+}
+
+func TestApplyMaxTokensFloor(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, defaultMaxTokensFloor, replicateRequest.Input.MaxTokens)
+
+	plugin := model.PluginType{
+		"max_tokens_floor": {"floors": `{"*":256,"meta/tiny-chat":0}`},
+	}
+	provider = getReplicateProvider("", plugin, nil)
+
+	request := getTestChatRequest("hi")
+	request.MaxTokens = 100
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 256, replicateRequest.Input.MaxTokens)
+
+	// 下限为 0 时不提高，未设置 max_tokens 时不发送
+	request = getTestChatRequest("hi")
+	request.Model = "meta/tiny-chat"
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.NotContains(t, marshalInput(t, replicateRequest), "max_tokens")
+
+	request.MaxTokens = 100
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 100, replicateRequest.Input.MaxTokens)
+
+	plugin["max_tokens_floor"]["floors"] = `{"*":-1}`
+	provider = getReplicateProvider("", plugin, nil)
+	_, _, errWithCode = provider.getReplicateChatRequest(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_replicate_config", errWithCode.Code)
+}
+
+func TestChatMaxTokens(t *testing.T) {
+	plugin := model.PluginType{
+		"max_tokens_floor": {"floors": `{"*":0}`},
+	}
+	getMaxTokens := func(plugin model.PluginType, maxTokens, maxCompletionTokens int) int {
+		request := getTestChatRequest("hi")
+		request.MaxTokens = maxTokens
+		request.MaxCompletionTokens = maxCompletionTokens
+		replicateRequest, _, errWithCode := getReplicateProvider("", plugin, nil).getReplicateChatRequest(request)
+		assert.Nil(t, errWithCode)
+		// 不修改原始请求
+		assert.Equal(t, maxTokens, request.MaxTokens)
+		return replicateRequest.Input.MaxTokens
+	}
+
+	assert.Equal(t, 100, getMaxTokens(plugin, 100, 0))
+	assert.Equal(t, 200, getMaxTokens(plugin, 0, 200))
+	// 同时设置时取较小值
+	assert.Equal(t, 100, getMaxTokens(plugin, 100, 200))
+	assert.Equal(t, 50, getMaxTokens(plugin, 100, 50))
+
+	// 推理模型的 max_tokens 加上预留的推理 token，max_completion_tokens 已包含推理 token
+	plugin["reasoning_tokens"] = map[string]interface{}{"reserve": `{"meta/meta-llama-3-70b-instruct":1000}`}
+	assert.Equal(t, 1100, getMaxTokens(plugin, 100, 0))
+	assert.Equal(t, 200, getMaxTokens(plugin, 0, 200))
+	assert.Equal(t, 1100, getMaxTokens(plugin, 100, 2000))
+	assert.Equal(t, 500, getMaxTokens(plugin, 100, 500))
+
+	plugin["reasoning_tokens"]["reserve"] = `{"*":-1}`
+	request := getTestChatRequest("hi")
+	request.MaxTokens = 100
+	_, _, errWithCode := getReplicateProvider("", plugin, nil).getReplicateChatRequest(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_replicate_config", errWithCode.Code)
+}
+
+func TestApplyZeroTemperature(t *testing.T) {
+	zero := 0.0
+	getTemperature := func(provider *ReplicateProvider, modelName string) any {
+		request := getTestChatRequest("hi")
+		request.Model = modelName
+		request.Temperature = &zero
+		replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+		assert.Nil(t, errWithCode)
+		assert.Equal(t, 0.0, *request.Temperature)
+
+		return marshalInput(t, replicateRequest)["temperature"]
+	}
+
+	// 拒绝 0 的模型默认替换为 0.01，接受 0 的模型原样发送
+	provider := getReplicateProvider("", nil, nil)
+	assert.Equal(t, 0.01, getTemperature(provider, "meta/llama-2-70b-chat"))
+	assert.Equal(t, 0.0, getTemperature(provider, "meta/meta-llama-3-70b-instruct"))
+
+	plugin := model.PluginType{
+		"zero_temperature": {"models": `{"*":0.001,"mistralai/mixtral-8x7b-instruct-v0.1":"omit","meta/llama-2-70b-chat":"keep"}`},
+	}
+	provider = getReplicateProvider("", plugin, nil)
+	assert.Equal(t, 0.001, getTemperature(provider, "meta/meta-llama-3-70b-instruct"))
+	assert.Nil(t, getTemperature(provider, "mistralai/mixtral-8x7b-instruct-v0.1"))
+	assert.Equal(t, 0.0, getTemperature(provider, "meta/llama-2-70b-chat"))
+
+	// 非 0 的 temperature 不受影响
+	request := getTestChatRequest("hi")
+	temperature := 0.7
+	request.Temperature = &temperature
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 0.7, *replicateRequest.Input.Temperature)
+
+	plugin["zero_temperature"]["models"] = `{"*":-1}`
+	provider = getReplicateProvider("", plugin, nil)
+	request.Temperature = &zero
+	_, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "invalid_replicate_config", errWithCode.Code)
+}
+
+func TestApplyMaxOutputTokens(t *testing.T) {
+	plugin := model.PluginType{
+		"max_output_tokens": {"limit": "512"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	request := getTestChatRequest("hi")
+	request.MaxTokens = 4096
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 512, replicateRequest.Input.MaxTokens)
+
+	// 上限低于最小输出长度时以上限为准
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 512, replicateRequest.Input.MaxTokens)
+
+	// 低于上限时不提高
+	plugin["max_tokens_floor"] = map[string]interface{}{"floors": `{"*":0}`}
+	provider = getReplicateProvider("", plugin, nil)
+	request = getTestChatRequest("hi")
+	request.MaxTokens = 100
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 100, replicateRequest.Input.MaxTokens)
+
+	// 未配置时不限制
+	request.MaxTokens = 4096
+	replicateRequest, _, errWithCode = getReplicateProvider("", nil, nil).getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 4096, replicateRequest.Input.MaxTokens)
+
+	// 透传的 max_new_tokens 等参数同样受上限限制
+	provider = getReplicateProvider("", plugin, strings.NewReader(`{"model":"meta/meta-llama-3-70b-instruct","messages":[{"role":"user","content":"hi"}],"max_tokens":100,"max_new_tokens":100000,"max_length":300,"max_output_tokens":"9999"}`))
+	request.MaxTokens = 100
+	replicateRequest, _, errWithCode = provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	input := marshalInput(t, replicateRequest)
+	assert.Equal(t, 512.0, input["max_new_tokens"])
+	assert.Equal(t, 300.0, input["max_length"])
+	assert.NotContains(t, input, "max_output_tokens")
+	assert.Equal(t, 100.0, input["max_tokens"])
+}
+
+func TestApplyLogitBias(t *testing.T) {
+	plugin := model.PluginType{
+		"logit_bias": {"models": "meta/meta-llama-3-70b-instruct"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	request := getTestChatRequest("hi")
+	request.LogitBias = map[string]any{"50256": -100.0, "1234": 5.5}
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	input := marshalInput(t, replicateRequest)
+	assert.Equal(t, map[string]any{"50256": -100.0, "1234": 5.5}, input["logit_bias"])
+
+	for _, logitBias := range []any{
+		map[string]any{"abc": 1.0},
+		map[string]any{"-1": 1.0},
+		map[string]any{"42": 101.0},
+		map[string]any{"42": "1"},
+		[]any{1, 2},
+	} {
+		request.LogitBias = logitBias
+		_, _, errWithCode = provider.getReplicateChatRequest(request)
+		assert.NotNil(t, errWithCode)
+		assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+		assert.Equal(t, "invalid_logit_bias", errWithCode.Code)
+	}
+}
+
+func TestApplyLogitBiasUnsupported(t *testing.T) {
+	plugin := model.PluginType{
+		"logit_bias": {"models": "meta/other-model"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	request := getTestChatRequest("hi")
+	request.LogitBias = map[string]any{"abc": 500.0}
+	replicateRequest, _, errWithCode := provider.getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+	assert.NotContains(t, marshalInput(t, replicateRequest), "logit_bias")
+}
+
+func TestCheckContextLength(t *testing.T) {
+	request := getTestChatRequest("hello world")
+	request.MaxTokens = 2000
+	replicateRequest := convertFromChatOpenai(request, false, false)
+	promptTokens := common.CountTokenText(replicateRequest.Input.SystemPrompt+replicateRequest.Input.Prompt, request.Model)
+
+	tests := []struct {
+		name     string
+		limit    int
+		exceeded bool
+	}{
+		{"under", promptTokens + 2001, false},
+		{"at", promptTokens + 2000, false},
+		{"over", promptTokens + 1999, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plugin := model.PluginType{
+				"context_limit": {"limits": fmt.Sprintf(`{"*":100000,"meta/meta-llama-3-70b-instruct":%d}`, test.limit)},
+			}
+			provider := getReplicateProvider("", plugin, nil)
+
+			_, _, errWithCode := provider.getReplicateChatRequest(request)
+			if !test.exceeded {
+				assert.Nil(t, errWithCode)
+				return
+			}
+
+			assert.NotNil(t, errWithCode)
+			assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+			assert.Equal(t, "context_length_exceeded", errWithCode.Code)
+			assert.Equal(t, "invalid_request_error", errWithCode.Type)
+			assert.True(t, errWithCode.LocalError)
+		})
+	}
+}
+
+func TestApplyChannelDefaults(t *testing.T) {
+	plugin := model.PluginType{
+		"defaults": {"temperature": "0.6", "top_p": float64(0.9), "presence_penalty": "", "frequency_penalty": "invalid"},
+	}
+	provider := getReplicateProvider("", plugin, nil)
+
+	// 未设置时使用默认值
+	replicateRequest, errWithCode := provider.convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 0.6, *replicateRequest.Input.Temperature)
+	assert.Equal(t, 0.9, *replicateRequest.Input.TopP)
+	assert.Nil(t, replicateRequest.Input.PresencePenalty)
+	assert.Nil(t, replicateRequest.Input.FrequencyPenalty)
+
+	// 客户端显式设置的值（包括 0）不被覆盖
+	request := getTestChatRequest("hi")
+	temperature := 0.0
+	topP := 0.5
+	request.Temperature = &temperature
+	request.TopP = &topP
+	replicateRequest, errWithCode = provider.convertFromChatOpenai(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 0.0, *replicateRequest.Input.Temperature)
+	assert.Equal(t, 0.5, *replicateRequest.Input.TopP)
+
+	input := marshalInput(t, replicateRequest)
+	assert.Equal(t, 0.0, input["temperature"])
+
+	// 未配置时不设置
+	replicateRequest, errWithCode = getReplicateProvider("", nil, nil).convertFromChatOpenai(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Nil(t, replicateRequest.Input.Temperature)
+	assert.Nil(t, replicateRequest.Input.TopP)
+}
+
+func TestValidateParamCombinations(t *testing.T) {
+	n := 2
+	zero, one, two := 0.0, 1.0, 2.0
+	testCases := []struct {
+		name   string
+		param  string
+		modify func(request *types.ChatCompletionRequest)
+	}{
+		{
+			name:  "stream with n",
+			param: "n",
+			modify: func(request *types.ChatCompletionRequest) {
+				request.Stream = true
+				request.N = &n
+			},
+		},
+		{
+			name:  "extreme temperature and top_p",
+			param: "top_p",
+			modify: func(request *types.ChatCompletionRequest) {
+				request.Temperature = &two
+				request.TopP = &zero
+			},
+		},
+		{
+			name:  "json response_format with tools",
+			param: "response_format",
+			modify: func(request *types.ChatCompletionRequest) {
+				request.ResponseFormat = &types.ChatCompletionResponseFormat{Type: "json_object"}
+				request.Tools = []*types.ChatCompletionTool{{Type: "function", Function: types.ChatCompletionFunction{Name: "get_weather"}}}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := getTestChatRequest("hi")
+			testCase.modify(request)
+
+			_, _, errWithCode := getReplicateProvider("", nil, nil).getReplicateChatRequest(request)
+			if assert.NotNil(t, errWithCode) {
+				assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+				assert.Equal(t, "invalid_parameter_combination", errWithCode.Code)
+				assert.Equal(t, "invalid_request_error", errWithCode.Type)
+				assert.Equal(t, testCase.param, errWithCode.Param)
+				assert.True(t, errWithCode.LocalError)
+			}
+
+			// 模型声明支持时不检查
+			plugin := model.PluginType{
+				"param_combinations": {"capabilities": `{"meta/meta-llama-3-70b-instruct":["stream_n","extreme_sampling","json_tools"]}`},
+			}
+			_, _, errWithCode = getReplicateProvider("", plugin, nil).getReplicateChatRequest(request)
+			assert.Nil(t, errWithCode)
+		})
+	}
+
+	// 单独设置时不报错
+	request := getTestChatRequest("hi")
+	request.Temperature = &two
+	request.TopP = &one
+	_, _, errWithCode := getReplicateProvider("", nil, nil).getReplicateChatRequest(request)
+	assert.Nil(t, errWithCode)
+}
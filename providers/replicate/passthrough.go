@@ -0,0 +1,142 @@
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	knownRequestKeys     map[string]bool
+	knownRequestKeysOnce sync.Once
+)
+
+// OpenAI chat 和 completions 请求中已定义的字段，这些字段不会透传给 Replicate
+func getKnownRequestKeys() map[string]bool {
+	knownRequestKeysOnce.Do(func() {
+		knownRequestKeys = make(map[string]bool)
+		for _, request := range []any{types.ChatCompletionRequest{}, types.CompletionRequest{}} {
+			requestType := reflect.TypeOf(request)
+			for i := 0; i < requestType.NumField(); i++ {
+				name := strings.Split(requestType.Field(i).Tag.Get("json"), ",")[0]
+				if name != "" && name != "-" {
+					knownRequestKeys[name] = true
+				}
+			}
+		}
+	})
+
+	return knownRequestKeys
+}
+
+// 承载提示词和图片的字段只能由消息转换得到，经过 scrubChatInput 检查，不从请求体透传
+var scrubbedInputKeys = map[string]bool{"prompt": true, "system_prompt": true, "image": true}
+
+// 获取透传给 Replicate 的额外输入参数
+// 渠道插件 passthrough.defaults 作为默认值，请求体中 OpenAI 未定义的字段会覆盖默认值
+func (p *ReplicateProvider) getExtraInput() (map[string]any, *types.OpenAIErrorWithStatusCode) {
+	plugin := p.getPlugin("passthrough")
+	extra := make(map[string]any)
+
+	if defaults := pluginString(plugin, "defaults"); defaults != "" {
+		if err := json.Unmarshal([]byte(defaults), &extra); err != nil {
+			return nil, common.ErrorWrapperLocal(err, "invalid_replicate_config", http.StatusInternalServerError)
+		}
+	}
+
+	requestInput, err := p.getRequestBodyMap()
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "invalid_request_body", http.StatusBadRequest)
+	}
+
+	knownKeys := getKnownRequestKeys()
+	for key, value := range requestInput {
+		if knownKeys[key] || scrubbedInputKeys[key] {
+			continue
+		}
+		extra[key] = value
+	}
+
+	var allowedKeys map[string]bool
+	if pluginBool(plugin, "strict") {
+		allowedKeys = make(map[string]bool)
+		for _, key := range pluginList(plugin, "allowed_keys") {
+			allowedKeys[key] = true
+		}
+	}
+
+	for key, value := range extra {
+		if allowedKeys != nil && !allowedKeys[key] {
+			return nil, common.StringErrorWrapperLocal(fmt.Sprintf("unknown input parameter: %s", key), "invalid_input", http.StatusBadRequest)
+		}
+
+		if !isValidExtraValue(value) {
+			return nil, common.StringErrorWrapperLocal(fmt.Sprintf("invalid type for input parameter: %s", key), "invalid_input", http.StatusBadRequest)
+		}
+	}
+
+	return extra, nil
+}
+
+// 读取原始请求体，读取后恢复，避免影响重试
+func (p *ReplicateProvider) getRequestBody() ([]byte, error) {
+	if p.Context == nil || p.Context.Request == nil || p.Context.Request.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(p.Context.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	p.Context.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	return body, nil
+}
+
+func (p *ReplicateProvider) getRequestBodyMap() (map[string]any, error) {
+	if p.Context == nil || p.Context.Request == nil || !strings.Contains(p.Context.Request.Header.Get("Content-Type"), "application/json") {
+		return nil, nil
+	}
+
+	body, err := p.getRequestBody()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	bodyMap := make(map[string]any)
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		return nil, err
+	}
+
+	return bodyMap, nil
+}
+
+// 只允许透传标量及标量数组
+func isValidExtraValue(value any) bool {
+	switch v := value.(type) {
+	case string, float64, bool:
+		return true
+	case []any:
+		for _, item := range v {
+			switch item.(type) {
+			case string, float64, bool:
+			default:
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
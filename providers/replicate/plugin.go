@@ -0,0 +1,77 @@
+package replicate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 获取渠道插件配置
+func (p *ReplicateProvider) getPlugin(name string) map[string]interface{} {
+	if p.Channel == nil || p.Channel.Plugin == nil {
+		return nil
+	}
+
+	return p.Channel.Plugin.Data()[name]
+}
+
+func pluginBool(params map[string]interface{}, key string) bool {
+	enable, ok := params[key].(bool)
+	return ok && enable
+}
+
+func pluginString(params map[string]interface{}, key string) string {
+	value, ok := params[key].(string)
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimSpace(value)
+}
+
+// 逗号分隔的插件配置
+func pluginList(params map[string]interface{}, key string) []string {
+	value := pluginString(params, key)
+	if value == "" {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// 数值型插件配置，兼容字符串与数字，未设置或非法时返回默认值
+func pluginInt(params map[string]interface{}, key string, defaultValue int) int {
+	switch value := params[key].(type) {
+	case float64:
+		if value > 0 {
+			return int(value)
+		}
+	case string:
+		if number, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && number > 0 {
+			return number
+		}
+	}
+
+	return defaultValue
+}
+
+// 浮点型插件配置，兼容字符串与数字，未设置或非法时返回默认值
+func pluginFloat(params map[string]interface{}, key string, defaultValue float64) float64 {
+	switch value := params[key].(type) {
+	case float64:
+		return value
+	case string:
+		if number, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return number
+		}
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,29 @@
+package replicate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 提示词以 "assistant: \n" 结尾，部分模型会在输出开头重复角色标签
+var leadingRoleLabel = regexp.MustCompile(`^(?i)\s*assistant\s*:[ \t]*\n?`)
+
+// 渠道插件 output_sanitize.models 中的模型去掉输出开头的角色标签和首尾空白，* 表示所有模型
+// 只去掉后面紧跟冒号的标签，以 assistant 开头的正常内容不受影响
+func (p *ReplicateProvider) sanitizeOutput(text, modelName string) string {
+	enabled := false
+	for _, model := range pluginList(p.getPlugin("output_sanitize"), "models") {
+		if model == "*" || model == modelName {
+			enabled = true
+			break
+		}
+	}
+
+	if !enabled {
+		return text
+	}
+
+	text = leadingRoleLabel.ReplaceAllString(text, "")
+
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,99 @@
+package replicate
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/types"
+	"strings"
+	"sync"
+)
+
+// 发送给 Replicate 前的提示词，钩子可以直接修改各字段
+type ScrubRequest struct {
+	// 请求中的模型名
+	Model        string
+	SystemPrompt string
+	Prompt       string
+	// 图片地址或 data URI
+	Images []string
+}
+
+// 发送前检查提示词的钩子，用于脱敏或拦截提示词注入；
+// 返回 *ScrubPolicyError 时以 400 拒绝请求，返回其他错误时以 500 拒绝请求
+type PromptScrubber interface {
+	Scrub(request *ScrubRequest) error
+}
+
+type PromptScrubberFunc func(request *ScrubRequest) error
+
+func (f PromptScrubberFunc) Scrub(request *ScrubRequest) error {
+	return f(request)
+}
+
+// 钩子按策略拒绝请求，Message 会返回给客户端
+type ScrubPolicyError struct {
+	Message string
+}
+
+func (e *ScrubPolicyError) Error() string {
+	return e.Message
+}
+
+type noopPromptScrubber struct{}
+
+func (noopPromptScrubber) Scrub(request *ScrubRequest) error {
+	return nil
+}
+
+var (
+	promptScrubber   PromptScrubber = noopPromptScrubber{}
+	promptScrubberMu sync.RWMutex
+)
+
+// 替换发送前的钩子，传入 nil 时恢复为不做处理
+func SetPromptScrubber(scrubber PromptScrubber) {
+	promptScrubberMu.Lock()
+	defer promptScrubberMu.Unlock()
+
+	if scrubber == nil {
+		scrubber = noopPromptScrubber{}
+	}
+	promptScrubber = scrubber
+}
+
+func getPromptScrubber() PromptScrubber {
+	promptScrubberMu.RLock()
+	defer promptScrubberMu.RUnlock()
+
+	return promptScrubber
+}
+
+// 消息转换为提示词后、上传图片和计算上下文长度前调用钩子，写回修改后的提示词和图片
+func scrubChatInput(input *ReplicateChatRequest, modelName string) *types.OpenAIErrorWithStatusCode {
+	request := &ScrubRequest{
+		Model:        modelName,
+		SystemPrompt: input.SystemPrompt,
+		Prompt:       input.Prompt,
+		Images:       append([]string(nil), input.Images...),
+	}
+
+	if err := getPromptScrubber().Scrub(request); err != nil {
+		var policyErr *ScrubPolicyError
+		if errors.As(err, &policyErr) {
+			errWithCode := common.StringErrorWrapperLocal(policyErr.Message, "prompt_blocked", http.StatusBadRequest)
+			errWithCode.Type = errorTypeInvalidRequest
+			return errWithCode
+		}
+		return common.ErrorWrapperLocal(err, "prompt_scrubber_failed", http.StatusInternalServerError)
+	}
+
+	input.SystemPrompt = request.SystemPrompt
+	input.Prompt = request.Prompt
+	if len(input.Images) > 0 || len(request.Images) > 0 {
+		input.Images = request.Images
+		input.Image = strings.Join(request.Images, ",")
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package replicate
+
+import (
+	"strings"
+)
+
+// 解析 OpenAI 的 stop 参数，支持字符串和数组
+func getStopSequences(stop any) []string {
+	var stops []string
+	switch value := stop.(type) {
+	case string:
+		stops = append(stops, value)
+	case []string:
+		stops = append(stops, value...)
+	case []any:
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				stops = append(stops, text)
+			}
+		}
+	}
+
+	var result []string
+	for _, item := range stops {
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// 在第一个 stop 处截断文本
+func truncateAtStop(text string, stops []string) (string, bool) {
+	index := -1
+	for _, stop := range stops {
+		if i := strings.Index(text, stop); i >= 0 && (index < 0 || i < index) {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return text, false
+	}
+
+	return text[:index], true
+}
+
+// 文本末尾可能是 stop 的开头，流式输出时需要暂缓发送的长度
+func stopPrefixLength(text string, stops []string) int {
+	length := 0
+	for _, stop := range stops {
+		for i := len(stop) - 1; i > length; i-- {
+			if strings.HasSuffix(text, stop[:i]) {
+				length = i
+				break
+			}
+		}
+	}
+
+	return length
+}
@@ -0,0 +1,141 @@
+package replicate
+
+import (
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertFromChatOpenaiStop(t *testing.T) {
+	request := getTestChatRequest("hi")
+	request.Stop = "###"
+	assert.Equal(t, "###", convertFromChatOpenai(request, false, false).Input.StopSequences)
+
+	request.Stop = []any{"###", "\nuser:"}
+	assert.Equal(t, "###,\nuser:", convertFromChatOpenai(request, false, false).Input.StopSequences)
+
+	request.Stop = nil
+	assert.NotContains(t, marshalInput(t, convertFromChatOpenai(request, false, false)), "stop_sequences")
+}
+
+func TestConvertToChatOpenaiStop(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+	request := getTestChatRequest("hi")
+
+	request.Stop = "###"
+	response, errWithCode := provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: []string{"Hello ", "world##", "# ignored"},
+	}, request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello world", response.Choices[0].Message.Content)
+	assert.Equal(t, types.FinishReasonStop, response.Choices[0].FinishReason)
+
+	request.Stop = []any{"END", "world"}
+	response, _ = provider.convertToChatOpenai(&ReplicateResponse[ReplicateChatOutput]{
+		Status: "succeeded",
+		Output: []string{"Hello world END"},
+	}, request)
+	assert.Equal(t, "Hello ", response.Choices[0].Message.Content)
+}
+
+func TestCreateChatCompletionStreamStop(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello#\n\nevent: output\ndata: #\n\nevent: output\ndata: #ignored\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded"}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	request.Stop = []any{"###"}
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+	assert.Greater(t, provider.Usage.CompletionTokens, 0)
+}
+
+func TestStopPrefixLength(t *testing.T) {
+	stops := []string{"###", "</s>"}
+	assert.Equal(t, 0, stopPrefixLength("hello", stops))
+	assert.Equal(t, 2, stopPrefixLength("hello##", stops))
+	assert.Equal(t, 2, stopPrefixLength("hello</", stops))
+}
+
+func TestCreateChatCompletionStreamMaxDuration(t *testing.T) {
+	plugin := model.PluginType{
+		"stream_limit": {"max_duration": 0.05},
+	}
+	reader, writer := io.Pipe()
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		default:
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       reader,
+			}
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	go func() {
+		writer.Write([]byte("event: output\ndata: Hello\n\n"))
+		time.Sleep(100 * time.Millisecond)
+		writer.Write([]byte("event: output\ndata:  world\n\n"))
+		writer.Close()
+	}()
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonLength, chunks[len(chunks)-1].Choices[0].FinishReason)
+	assert.Equal(t, "/v1/predictions/p1/cancel", doer.requests[len(doer.requests)-1].URL.Path)
+	assert.Equal(t, common.CountTokenText("Hello", request.Model), provider.Usage.CompletionTokens)
+}
+
+func TestCreateChatCompletionStreamStopBeforeMaxDuration(t *testing.T) {
+	plugin := model.PluginType{
+		"stream_limit": {"max_duration": 60},
+	}
+	provider, _ := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		return sseResponse("event: output\ndata: Hello###\n\nevent: output\ndata: ignored\n\nevent: done\ndata: {}\n\n")
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	request.Stop = []any{"###"}
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+}
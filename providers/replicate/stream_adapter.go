@@ -0,0 +1,104 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/types"
+	"strings"
+)
+
+// 渠道插件 stream_adapter.models 中的模型在非流式请求时也使用流式接口，* 表示所有模型；
+// 收集完整输出后返回非流式响应，不受轮询时间限制
+func (p *ReplicateProvider) useStreamAdapter(modelName string) bool {
+	for _, model := range pluginList(p.getPlugin("stream_adapter"), "models") {
+		if model == "*" || model == modelName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 使用流式接口创建预测，连接成功后审计记录由流式处理写入
+func (p *ReplicateProvider) createAdapterStream(replicateRequest *ReplicateRequest[ReplicateChatRequest], replicateModel *ReplicateModel, request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *ReplicateStreamHandler, *types.OpenAIErrorWithStatusCode) {
+	streamRequest := *replicateRequest
+	streamRequest.Stream = true
+
+	chatHandler := &ReplicateStreamHandler{
+		Usage:         p.Usage,
+		ModelName:     request.Model,
+		Provider:      p,
+		StopSequences: getStopSequences(request.Stop),
+	}
+	stream, errWithCode := p.createPredictionStream(&streamRequest, replicateModel, chatHandler)
+	if errWithCode != nil {
+		return nil, nil, errWithCode
+	}
+
+	return stream, chatHandler, nil
+}
+
+// 合并所有分块为非流式响应
+func (p *ReplicateProvider) getStreamedChatCompletion(stream requester.StreamReaderInterface[string], chatHandler *ReplicateStreamHandler, request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	content, finishReason, err := collectChatStream(stream)
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "prediction_failed", http.StatusInternalServerError)
+	}
+
+	response := &types.ChatCompletionResponse{
+		ID:      chatHandler.ID,
+		Object:  "chat.completion",
+		Created: chatHandler.Created,
+		Model:   request.Model,
+		Choices: []types.ChatCompletionChoice{{
+			Index: 0,
+			Message: types.ChatCompletionMessage{
+				Role:    types.ChatMessageRoleAssistant,
+				Content: content,
+			},
+			FinishReason: finishReason,
+		}},
+		Usage:             p.Usage,
+		SystemFingerprint: chatHandler.SystemFingerprint,
+	}
+
+	return response, nil
+}
+
+// 读取流式输出直到结束，按顺序拼接内容，忽略心跳等 SSE 注释
+func collectChatStream(stream requester.StreamReaderInterface[string]) (content string, finishReason any, err error) {
+	defer stream.Close()
+	dataChan, errChan := stream.Recv()
+
+	var builder strings.Builder
+	finishReason = types.FinishReasonStop
+	for {
+		select {
+		case data := <-dataChan:
+			if strings.HasPrefix(data, ":") {
+				continue
+			}
+
+			var chunk types.ChatCompletionStreamResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return "", nil, err
+			}
+			for _, choice := range chunk.Choices {
+				builder.WriteString(choice.Delta.Content)
+				if choice.FinishReason != nil && choice.FinishReason != "" {
+					finishReason = choice.FinishReason
+				}
+			}
+		case err := <-errChan:
+			if !errors.Is(err, io.EOF) {
+				return "", nil, err
+			}
+
+			return builder.String(), finishReason, nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package replicate
+
+import (
+	"io"
+	"net/http"
+	"one-api/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionStreamAdapter(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: output\ndata: , \n\nevent: output\ndata: world\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello",", ","world"],"metrics":{"input_token_count":5,"output_token_count":3}}`)
+		}
+	}
+	plugin := model.PluginType{
+		"stream_adapter": {"models": "*"},
+	}
+
+	provider, _ := getMockProvider(plugin, handler)
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+
+	// 非流式请求同样使用流式接口，合并后的内容与流式分块一致
+	provider, doer := getMockProvider(plugin, handler)
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, streamContent(chunks), response.Choices[0].Message.Content)
+	assert.Equal(t, chunks[len(chunks)-1].Choices[0].FinishReason, response.Choices[0].FinishReason)
+	assert.Equal(t, "p1", response.ID)
+	assert.Equal(t, 8, response.Usage.TotalTokens)
+	assert.Equal(t, 1, doer.count(http.MethodPost))
+	body, _ := io.ReadAll(doer.requests[0].Body)
+	assert.Contains(t, string(body), `"stream":true`)
+}
@@ -0,0 +1,738 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/requester"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sseResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// 读取全部流式数据
+func readStream(t *testing.T, stream requester.StreamReaderInterface[string]) ([]types.ChatCompletionStreamResponse, error) {
+	defer stream.Close()
+	dataChan, errChan := stream.Recv()
+
+	var chunks []types.ChatCompletionStreamResponse
+	for {
+		select {
+		case data := <-dataChan:
+			chunk := types.ChatCompletionStreamResponse{}
+			assert.Nil(t, json.Unmarshal([]byte(data), &chunk))
+			chunks = append(chunks, chunk)
+		case err := <-errChan:
+			if errors.Is(err, io.EOF) {
+				return chunks, nil
+			}
+			return chunks, err
+		}
+	}
+}
+
+func streamContent(chunks []types.ChatCompletionStreamResponse) string {
+	content := ""
+	for _, chunk := range chunks {
+		content += chunk.GetResponseText()
+	}
+	return content
+}
+
+func TestCreateChatCompletionStreamUsage(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: output\ndata: world\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello","world"]}`)
+		}
+	})
+	provider.Usage.PromptTokens = 10
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "Helloworld", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+
+	// 没有 metrics 时按实际发送的提示词计算 prompt tokens，替换 relay 层的预估，并根据输出计算 completion tokens
+	assert.Equal(t, common.CountTokenText("user: \nhi\nassistant: \n", request.Model), provider.Usage.PromptTokens)
+	assert.Greater(t, provider.Usage.CompletionTokens, 0)
+	assert.Equal(t, provider.Usage.PromptTokens+provider.Usage.CompletionTokens, provider.Usage.TotalTokens)
+}
+
+func TestCreateChatCompletionStreamIdentityEncoding(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		return sseResponse("event: output\ndata: Hi\n\nevent: done\ndata: {}\n\n")
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	stream.Close()
+
+	assert.Equal(t, "identity", doer.requests[1].Header.Get("Accept-Encoding"))
+}
+
+func TestCreateChatCompletionStreamUrlPending(t *testing.T) {
+	polls := 0
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: ready\n\nevent: done\ndata: {}\n\n")
+		case polls < 2:
+			polls++
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"starting"}`)
+		default:
+			polls++
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "ready", streamContent(chunks))
+	assert.GreaterOrEqual(t, doer.count(http.MethodGet), 3)
+}
+
+func TestCreateChatCompletionStreamUrlUnavailable(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"starting"}`)
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	_, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusServiceUnavailable, errWithCode.StatusCode)
+	assert.Equal(t, "stream_not_ready", errWithCode.Code)
+}
+
+func TestCreateChatCompletionStreamFallback(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return jsonResponse(http.StatusBadGateway, `{"detail":"bad gateway"}`)
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello"," ","world"],"metrics":{"input_token_count":3,"output_token_count":3}}`)
+		}
+	}
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+
+	provider, _ := getMockProvider(nil, handler)
+	_, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.NotNil(t, errWithCode)
+
+	plugin := model.PluginType{
+		"stream_fallback": {"enable": true},
+	}
+	provider, doer := getMockProvider(plugin, handler)
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Len(t, chunks, 2)
+	assert.Equal(t, "Hello world", chunks[0].Choices[0].Delta.Content)
+	assert.Equal(t, types.FinishReasonStop, chunks[1].Choices[0].FinishReason)
+	assert.Equal(t, 6, provider.Usage.TotalTokens)
+	assert.False(t, provider.Usage.Estimated)
+	assert.Equal(t, "/v1/predictions/p1", doer.requests[2].URL.Path)
+}
+
+func TestCreateChatCompletionStreamFromCreation(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"get":"https://api.replicate.com/v1/predictions/p1","stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: streamed\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["streamed"]}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	// 创建预测后直接连接流式地址，不需要再获取预测
+	assert.Equal(t, http.MethodGet, doer.requests[1].Method)
+	assert.Equal(t, "stream.replicate.com", doer.requests[1].URL.Host)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "streamed", streamContent(chunks))
+}
+
+func TestCreateChatCompletionStreamNotStreamingModel(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["line one\n","line two"],"metrics":{"input_token_count":4,"output_token_count":5}}`)
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "line one\nline two", streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+	assert.Equal(t, 9, provider.Usage.TotalTokens)
+	assert.Equal(t, 1, doer.count(http.MethodGet))
+
+	provider, _ = getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusCreated, `{"id":"p1","status":"failed","error":"model crashed"}`)
+	})
+	_, errWithCode = provider.CreateChatCompletionStream(request)
+	assert.NotNil(t, errWithCode)
+	assert.Contains(t, errWithCode.Message, "model crashed")
+}
+
+func TestCreateChatCompletionStreamMultiLine(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\r\nid: 1\r\ndata: Hello\r\n\r\nevent: output\r\ndata:  world\r\n\r\n: keep-alive\r\n\r\nevent: output\r\ndata: \r\ndata: \r\n\r\nevent: done\r\ndata: {}\r\n\r\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded"}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello world\n", streamContent(chunks))
+}
+
+func TestCreateChatCompletionStreamShutdown(t *testing.T) {
+	shutdownCancel := setShutdownContext(t)
+
+	reader, writer := io.Pipe()
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		default:
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       reader,
+			}
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	go writer.Write([]byte("event: output\ndata: Hello\n\n"))
+	assert.Contains(t, <-dataChan, "Hello")
+
+	// 服务关闭等待超时后，下一个事件触发取消并结算已输出的内容
+	shutdownCancel()
+	go writer.Write([]byte("event: output\ndata:  world\n\n"))
+	assert.Contains(t, <-dataChan, `"finish_reason":"stop"`)
+	assert.Equal(t, io.EOF, <-errChan)
+
+	assert.Equal(t, "/v1/predictions/p1/cancel", doer.requests[len(doer.requests)-1].URL.Path)
+	assert.True(t, provider.Usage.Canceled)
+	assert.Equal(t, common.CountTokenText("Hello", request.Model), provider.Usage.CompletionTokens)
+}
+
+func TestCreateChatCompletionStreamCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		default:
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       reader,
+			}
+		}
+	})
+	provider.Context.Request = provider.Context.Request.WithContext(ctx)
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	go writer.Write([]byte("event: output\ndata: Hello\n\n"))
+	assert.Contains(t, <-dataChan, "Hello")
+
+	// 客户端断开后收到的下一个事件触发取消
+	cancel()
+	go writer.Write([]byte("event: output\ndata:  world\n\n"))
+	finish := <-dataChan
+	assert.Contains(t, finish, `"finish_reason":"stop"`)
+	assert.Equal(t, io.EOF, <-errChan)
+
+	assert.Equal(t, "/v1/predictions/p1/cancel", doer.requests[len(doer.requests)-1].URL.Path)
+	assert.True(t, provider.Usage.Canceled)
+	assert.Equal(t, common.CountTokenText("Hello", request.Model), provider.Usage.CompletionTokens)
+	writer.Close()
+}
+
+func TestCreateChatCompletionStreamPartialUsage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.URL.Path == "/v1/predictions/p1/cancel":
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"canceled"}`)
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		default:
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       reader,
+			}
+		}
+	})
+	provider.Context.Request = provider.Context.Request.WithContext(ctx)
+	provider.Usage.PromptTokens = 7
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	go writer.Write([]byte("event: output\ndata: Hello\n\nevent: output\ndata:  world\n\n"))
+	assert.Contains(t, <-dataChan, "Hello")
+	assert.Contains(t, <-dataChan, "world")
+
+	cancel()
+	go writer.Write([]byte("event: output\ndata: !\n\n"))
+	assert.Contains(t, <-dataChan, `"finish_reason":"stop"`)
+	assert.Equal(t, io.EOF, <-errChan)
+	writer.Close()
+
+	assert.True(t, provider.Usage.Partial)
+	assert.True(t, provider.Usage.Estimated)
+	assert.Equal(t, common.CountTokenText("user: \nhi\nassistant: \n", request.Model), provider.Usage.PromptTokens)
+	assert.Equal(t, common.CountTokenText("Hello world", request.Model), provider.Usage.CompletionTokens)
+	assert.Equal(t, provider.Usage.PromptTokens+provider.Usage.CompletionTokens, provider.Usage.TotalTokens)
+}
+
+func TestCreateChatCompletionStreamBrokenPartialUsage(t *testing.T) {
+	reader, writer := io.Pipe()
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       reader,
+		}
+	})
+	provider.Usage.PromptTokens = 7
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	dataChan, errChan := stream.Recv()
+	go writer.Write([]byte("event: output\ndata: Hello\n\nevent: output\ndata:  world\n\n"))
+	assert.Contains(t, <-dataChan, "Hello")
+	assert.Contains(t, <-dataChan, "world")
+
+	// 上游连接中断，没有 done 事件
+	writer.CloseWithError(errors.New("connection reset"))
+	assert.NotEqual(t, io.EOF, <-errChan)
+
+	assert.True(t, provider.Usage.Partial)
+	assert.Equal(t, common.CountTokenText("user: \nhi\nassistant: \n", request.Model), provider.Usage.PromptTokens)
+	assert.Equal(t, common.CountTokenText("Hello", request.Model)+common.CountTokenText(" world", request.Model), provider.Usage.CompletionTokens)
+}
+
+func TestCreateChatCompletionStreamKeepAlive(t *testing.T) {
+	plugin := model.PluginType{
+		"stream_keep_alive": {"enable": true},
+	}
+	reader, writer := io.Pipe()
+	provider, _ := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       reader,
+		}
+	})
+	provider.KeepAliveInterval = 5 * time.Millisecond
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	defer stream.Close()
+
+	// 模拟预测启动较慢，第一个输出延迟到达
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writer.Write([]byte("event: output\ndata: Hello\n\n"))
+		time.Sleep(30 * time.Millisecond)
+		writer.Write([]byte("event: output\ndata:  world\n\nevent: done\ndata: {}\n\n"))
+		writer.Close()
+	}()
+
+	dataChan, errChan := stream.Recv()
+	var items []string
+	for done := false; !done; {
+		select {
+		case data := <-dataChan:
+			items = append(items, data)
+		case err := <-errChan:
+			assert.Equal(t, io.EOF, err)
+			done = true
+		}
+	}
+
+	assert.Greater(t, len(items), 3)
+	assert.Equal(t, requester.KeepAliveComment, items[0])
+
+	first := 0
+	for first < len(items) && items[first] == requester.KeepAliveComment {
+		first++
+	}
+	assert.Greater(t, first, 1)
+	assert.Contains(t, items[first], "Hello")
+	for _, item := range items[first:] {
+		assert.NotEqual(t, requester.KeepAliveComment, item)
+	}
+}
+
+func TestCreateChatCompletionStreamCreated(t *testing.T) {
+	provider, _ := getMockProvider(nil, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","created_at":"2024-05-01T12:00:00Z","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello"]}`)
+		}
+	})
+
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	for _, chunk := range chunks {
+		assert.EqualValues(t, float64(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Unix()), chunk.Created)
+	}
+}
+
+// 读取流式数据并记录每个分块的到达时间
+func readStreamTimes(t *testing.T, stream requester.StreamReaderInterface[string]) []time.Time {
+	defer stream.Close()
+	dataChan, errChan := stream.Recv()
+
+	var times []time.Time
+	for {
+		select {
+		case <-dataChan:
+			times = append(times, time.Now())
+		case err := <-errChan:
+			assert.ErrorIs(t, err, io.EOF)
+			return times
+		}
+	}
+}
+
+func TestCreateChatCompletionStreamPacing(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: a\n\nevent: output\ndata: b\n\nevent: output\ndata: c\n\nevent: output\ndata: d\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["a","b","c","d"]}`)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		plugin   model.PluginType
+		minGap   time.Duration
+		maxTotal time.Duration
+	}{
+		{name: "disabled", plugin: nil, minGap: 0, maxTotal: 40 * time.Millisecond},
+		{name: "enabled", plugin: model.PluginType{"stream_pacing": {"interval": "40"}}, minGap: 35 * time.Millisecond, maxTotal: time.Second},
+		// 累计延迟达到上限后不再等待
+		{name: "capped", plugin: model.PluginType{"stream_pacing": {"interval": "40", "max_delay": "50"}}, minGap: 0, maxTotal: 90 * time.Millisecond},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			provider, _ := getMockProvider(testCase.plugin, handler)
+			request := getTestChatRequest("hi")
+			request.Stream = true
+			stream, errWithCode := provider.CreateChatCompletionStream(request)
+			assert.Nil(t, errWithCode)
+
+			times := readStreamTimes(t, stream)
+			// 4 个内容分块 + 结束分块
+			assert.Len(t, times, 5)
+			for i := 1; i < 4; i++ {
+				assert.GreaterOrEqual(t, times[i].Sub(times[i-1]), testCase.minGap)
+			}
+			if testCase.minGap == 0 {
+				assert.Less(t, times[3].Sub(times[0]), testCase.maxTotal)
+			}
+			// 结束分块不等待
+			assert.Less(t, times[4].Sub(times[3]), 30*time.Millisecond)
+		})
+	}
+}
+
+func TestReplicateStreamHandlerGetDelta(t *testing.T) {
+	testCases := []struct {
+		name      string
+		mode      string
+		fragments []string
+		expected  []string
+	}{
+		{name: "delta", mode: "", fragments: []string{"Hel", "lo", "Hello"}, expected: []string{"Hel", "lo", "Hello"}},
+		{name: "cumulative", mode: streamDeltaCumulative, fragments: []string{"Hel", "Hello", "Hello wor", "Hello world"}, expected: []string{"Hel", "lo", " wor", "ld"}},
+		// 乱序到达的旧累计输出不重复发送，重叠片段只发送新增部分
+		{name: "cumulative out of order", mode: streamDeltaCumulative, fragments: []string{"Hello", "Hel", "Hello wor", "world!", "!"}, expected: []string{"Hello", "", " wor", "ld!", ""}},
+		{name: "auto delta", mode: streamDeltaAuto, fragments: []string{"Hel", "lo", " world"}, expected: []string{"Hel", "lo", " world"}},
+		{name: "auto cumulative", mode: streamDeltaAuto, fragments: []string{"Hel", "Hello", "Hello world"}, expected: []string{"Hel", "lo", " world"}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			handler := &ReplicateStreamHandler{DeltaMode: testCase.mode}
+			var deltas []string
+			for _, fragment := range testCase.fragments {
+				deltas = append(deltas, handler.getDelta(fragment))
+			}
+			assert.Equal(t, testCase.expected, deltas)
+		})
+	}
+}
+
+func TestCreateChatCompletionStreamCumulative(t *testing.T) {
+	testCases := []struct {
+		name   string
+		plugin model.PluginType
+		events string
+	}{
+		{name: "delta", plugin: nil, events: "event: output\ndata: Hello\n\nevent: output\ndata:  world\n\n"},
+		{name: "cumulative", plugin: model.PluginType{"stream_delta": {"mode": "cumulative"}}, events: "event: output\ndata: Hello\n\nevent: output\ndata: Hello world\n\nevent: output\ndata: Hello\n\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			provider, _ := getMockProvider(testCase.plugin, func(req *http.Request) *http.Response {
+				switch {
+				case req.Method == http.MethodPost:
+					return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+				case req.URL.Host == "stream.replicate.com":
+					return sseResponse(testCase.events + "event: done\ndata: {}\n\n")
+				default:
+					return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded"}`)
+				}
+			})
+
+			request := getTestChatRequest("hi")
+			request.Stream = true
+			stream, errWithCode := provider.CreateChatCompletionStream(request)
+			assert.Nil(t, errWithCode)
+
+			chunks, err := readStream(t, stream)
+			assert.Nil(t, err)
+			assert.Equal(t, "Hello world", streamContent(chunks))
+			// 两个内容分块 + 结束分块
+			assert.Len(t, chunks, 3)
+		})
+	}
+}
+
+func TestCreateChatCompletionStreamCoalesce(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: a\n\nevent: output\ndata: b\n\nevent: output\ndata: c\n\nevent: output\ndata: d\n\nevent: output\ndata: e\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["a","b","c","d","e"]}`)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		plugin model.PluginType
+		chunks []string
+	}{
+		{
+			name:   "off",
+			plugin: nil,
+			chunks: []string{"a", "b", "c", "d", "e", ""},
+		},
+		{
+			// 第一个分块立即发送，之后每 3 字节合并，剩余内容在结束分块前发送
+			name:   "size",
+			plugin: model.PluginType{"stream_coalesce": {"size": "3"}},
+			chunks: []string{"a", "bcd", "e", ""},
+		},
+		{
+			name:   "window",
+			plugin: model.PluginType{"stream_coalesce": {"window": "60000"}},
+			chunks: []string{"a", "bcde", ""},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			provider, _ := getMockProvider(testCase.plugin, handler)
+			request := getTestChatRequest("hi")
+			request.Stream = true
+			stream, errWithCode := provider.CreateChatCompletionStream(request)
+			assert.Nil(t, errWithCode)
+
+			chunks, err := readStream(t, stream)
+			assert.Nil(t, err)
+
+			var contents []string
+			for _, chunk := range chunks {
+				contents = append(contents, chunk.GetResponseText())
+			}
+			assert.Equal(t, testCase.chunks, contents)
+			assert.Equal(t, "abcde", streamContent(chunks))
+			assert.Equal(t, types.FinishReasonStop, chunks[len(chunks)-1].Choices[0].FinishReason)
+		})
+	}
+}
+
+func TestCreateChatCompletionStreamSplitRune(t *testing.T) {
+	// 😀 的 4 个字节拆到两帧
+	emoji := "😀"
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hi " + emoji[:2] + "\n\nevent: output\ndata: " + emoji[2:] + "!\n\nevent: output\ndata: " + emoji[:1] + "\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded"}`)
+		}
+	}
+
+	provider, _ := getMockProvider(nil, handler)
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	var contents []string
+	for _, chunk := range chunks {
+		contents = append(contents, chunk.GetResponseText())
+	}
+	// 不完整的序列留到下一帧，流结束时剩余的字节按原样发送（JSON 编码为替换字符）
+	assert.Equal(t, []string{"Hi ", emoji + "!", "�", ""}, contents)
+
+	// 关闭后原样转发
+	provider, _ = getMockProvider(model.PluginType{"stream_unicode": {"passthrough": true}}, handler)
+	stream, errWithCode = provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+	chunks, err = readStream(t, stream)
+	assert.Nil(t, err)
+	assert.NotContains(t, streamContent(chunks), emoji)
+}
+
+func TestIncompleteRuneLength(t *testing.T) {
+	emoji := "😀"
+	assert.Equal(t, 0, incompleteRuneLength(""))
+	assert.Equal(t, 0, incompleteRuneLength("abc"))
+	assert.Equal(t, 0, incompleteRuneLength("a"+emoji))
+	assert.Equal(t, 1, incompleteRuneLength("a"+emoji[:1]))
+	assert.Equal(t, 3, incompleteRuneLength("a"+emoji[:3]))
+	assert.Equal(t, 2, incompleteRuneLength("中"[:2]))
+	// 单独的后续字节不是不完整的序列
+	assert.Equal(t, 0, incompleteRuneLength(emoji[1:]))
+}
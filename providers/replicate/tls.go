@@ -0,0 +1,122 @@
+package replicate
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common/logger"
+	"one-api/common/requester"
+	"one-api/common/utils"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 按 TLS 及连接池配置缓存 HTTP 客户端，复用连接
+var httpClients sync.Map
+
+// TLS 配置错误时所有请求直接返回该错误
+type tlsErrorDoer struct {
+	err error
+}
+
+func (d tlsErrorDoer) Do(req *http.Request) (*http.Response, error) {
+	return nil, d.err
+}
+
+// 渠道插件 tls 配置自定义 CA 或跳过证书校验，connection_pool 配置连接池，均未配置时使用全局 HTTPClient
+func (p *ReplicateProvider) getHTTPDoer() requester.HTTPDoer {
+	plugin := p.getPlugin("tls")
+	caCert := pluginString(plugin, "ca_cert")
+	insecure := pluginBool(plugin, "insecure_skip_verify")
+	pool := p.getHTTPPool()
+	if caCert == "" && !insecure && pool == nil {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%t|%v", caCert, insecure, pool)))
+	key := hex.EncodeToString(hash[:])
+	if client, ok := httpClients.Load(key); ok {
+		return client.(*http.Client)
+	}
+
+	var tlsConfig *tls.Config
+	if caCert != "" || insecure {
+		var err error
+		tlsConfig, err = getTLSConfig(caCert, insecure)
+		if err != nil {
+			logger.SysError(fmt.Sprintf("replicate channel %d invalid tls config: %s", p.Channel.Id, err.Error()))
+			return tlsErrorDoer{err: fmt.Errorf("invalid tls config: %w", err)}
+		}
+	}
+
+	if insecure {
+		logger.SysError(fmt.Sprintf("WARNING: replicate channel %d skips TLS certificate verification, do not use in production", p.Channel.Id))
+	}
+
+	client, _ := httpClients.LoadOrStore(key, requester.CloneHTTPClient(tlsConfig, pool))
+	return client.(*http.Client)
+}
+
+// 渠道插件 connection_pool 覆盖全局的连接池设置，未配置的项沿用全局设置，均未配置时返回 nil
+func (p *ReplicateProvider) getHTTPPool() *requester.HTTPPool {
+	plugin := p.getPlugin("connection_pool")
+	maxIdleConns := pluginInt(plugin, "max_idle_conns", 0)
+	maxIdleConnsPerHost := pluginInt(plugin, "max_idle_conns_per_host", 0)
+	idleConnTimeout := pluginInt(plugin, "idle_conn_timeout", 0)
+	if maxIdleConns == 0 && maxIdleConnsPerHost == 0 && idleConnTimeout == 0 {
+		return nil
+	}
+
+	pool := requester.GetHTTPPool()
+	if maxIdleConns > 0 {
+		pool.MaxIdleConns = maxIdleConns
+	}
+	if maxIdleConnsPerHost > 0 {
+		pool.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	}
+	if idleConnTimeout > 0 {
+		pool.IdleConnTimeout = time.Duration(idleConnTimeout) * time.Second
+	}
+
+	return &pool
+}
+
+// ca_cert 可以是 PEM 内容或文件路径，跳过证书校验需要设置 allow_insecure_tls
+func getTLSConfig(caCert string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if insecure {
+		if !utils.GetOrDefault("allow_insecure_tls", false) {
+			return nil, errors.New("insecure_skip_verify requires allow_insecure_tls to be enabled")
+		}
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if caCert != "" {
+		pemData := []byte(caCert)
+		if !strings.HasPrefix(caCert, "-----BEGIN") {
+			data, err := os.ReadFile(caCert)
+			if err != nil {
+				return nil, fmt.Errorf("read ca_cert failed: %w", err)
+			}
+			pemData = data
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("no valid certificates found in ca_cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,83 @@
+package replicate
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/requester"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTLSTestServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"p1","status":"succeeded","output":["Hello"]}`))
+	}))
+}
+
+func TestCreateChatCompletionCustomCA(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	provider := getReplicateProvider(server.URL, model.PluginType{"tls": {"ca_cert": caCert}}, nil)
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+
+	// 未配置 CA 时证书校验失败
+	provider = getReplicateProvider(server.URL, nil, nil)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, "http_request_failed", errWithCode.Code)
+
+	provider = getReplicateProvider(server.URL, model.PluginType{"tls": {"ca_cert": "-----BEGIN CERTIFICATE-----\ninvalid"}}, nil)
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Contains(t, errWithCode.Message, "invalid tls config")
+}
+
+func TestCreateChatCompletionInsecureTLS(t *testing.T) {
+	server := newTLSTestServer()
+	defer server.Close()
+
+	plugin := model.PluginType{"tls": {"insecure_skip_verify": true}}
+	provider := getReplicateProvider(server.URL, plugin, nil)
+	_, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.NotNil(t, errWithCode)
+	assert.Contains(t, errWithCode.Message, "allow_insecure_tls")
+
+	viper.Set("allow_insecure_tls", true)
+	defer viper.Set("allow_insecure_tls", nil)
+
+	provider = getReplicateProvider(server.URL, plugin, nil)
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello", response.Choices[0].Message.Content)
+}
+
+func TestConnectionPool(t *testing.T) {
+	provider := getReplicateProvider("", nil, nil)
+	assert.Nil(t, provider.Requester.Doer)
+
+	plugin := model.PluginType{"connection_pool": {"max_idle_conns_per_host": "64", "idle_conn_timeout": "30"}}
+	provider = getReplicateProvider("", plugin, nil)
+	client, ok := provider.Requester.Doer.(*http.Client)
+	if !assert.True(t, ok) {
+		return
+	}
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, 64, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+	// 未配置的项沿用全局设置
+	assert.Equal(t, requester.GetHTTPPool().MaxIdleConns, transport.MaxIdleConns)
+
+	// 相同配置的渠道复用同一个客户端
+	assert.Same(t, client, getReplicateProvider("", plugin, nil).Requester.Doer)
+}
@@ -0,0 +1,260 @@
+package replicate
+
+import (
+	"encoding/json"
+	"one-api/common/utils"
+	"one-api/types"
+	"strings"
+)
+
+// 模型以提示词中相同的格式发起工具调用，每行一个：Action: name({"arg":"value"})
+const toolCallPrefix = "Action: "
+
+const (
+	toolCallStateText = iota
+	toolCallStateName
+	toolCallStateArguments
+	toolCallStateEnd
+)
+
+// 渠道插件 tool_calls.models 中的模型，请求带有 tools 时，流式输出中的工具调用转换为 delta.tool_calls
+func (p *ReplicateProvider) getToolCallAssembler(request *types.ChatCompletionRequest) *toolCallAssembler {
+	if len(request.Tools) == 0 {
+		return nil
+	}
+
+	for _, model := range pluginList(p.getPlugin("tool_calls"), "models") {
+		if model == "*" || model == request.Model {
+			return &toolCallAssembler{lineStart: true}
+		}
+	}
+
+	return nil
+}
+
+// 流式输出中的一段普通内容或一个工具调用分块
+type toolCallPiece struct {
+	text     string
+	toolCall *toolCallDelta
+}
+
+// 流式输出的工具调用片段，只有第一个分块带 id 和 type，之后的分块省略
+type toolCallDelta struct {
+	Id       string                                 `json:"id,omitempty"`
+	Type     string                                 `json:"type,omitempty"`
+	Function *types.ChatCompletionToolCallsFunction `json:"function"`
+	Index    int                                    `json:"index"`
+}
+
+type toolCallStreamChoiceDelta struct {
+	Role      string           `json:"role,omitempty"`
+	ToolCalls []*toolCallDelta `json:"tool_calls"`
+}
+
+type toolCallStreamChoice struct {
+	Index        int                       `json:"index"`
+	Delta        toolCallStreamChoiceDelta `json:"delta"`
+	FinishReason any                       `json:"finish_reason"`
+}
+
+// 工具调用分块的 choices 使用上面的片段类型，其余字段与普通分块相同
+type toolCallStreamResponse struct {
+	types.ChatCompletionStreamResponse
+	Choices []toolCallStreamChoice `json:"choices"`
+}
+
+// 从流式输出中逐段拼出工具调用：第一个分块带 index、id、name，之后的分块只有 arguments 片段，
+// 参数按 JSON 的括号层级判断结束；行首可能是 Action: 的内容暂缓输出
+type toolCallAssembler struct {
+	state     int
+	lineStart bool
+	// 行首尚未确定是否为工具调用的内容
+	line string
+	name string
+	// 本次输入中尚未发送的参数片段
+	arguments strings.Builder
+	depth     int
+	inString  bool
+	escaped   bool
+	// 已发起的工具调用数
+	count int
+}
+
+func (a *toolCallAssembler) feed(content string) []toolCallPiece {
+	var pieces []toolCallPiece
+	text := &strings.Builder{}
+	flushText := func() {
+		if text.Len() > 0 {
+			pieces = append(pieces, toolCallPiece{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for _, char := range content {
+		switch a.state {
+		case toolCallStateText:
+			if !a.lineStart {
+				text.WriteRune(char)
+				a.lineStart = char == '\n'
+				continue
+			}
+
+			a.line += string(char)
+			if a.line == toolCallPrefix {
+				flushText()
+				a.line = ""
+				a.lineStart = false
+				a.state = toolCallStateName
+			} else if !strings.HasPrefix(toolCallPrefix, a.line) {
+				text.WriteString(a.line)
+				a.line = ""
+				a.lineStart = char == '\n'
+			}
+		case toolCallStateName:
+			switch char {
+			case '(':
+				pieces = append(pieces, toolCallPiece{toolCall: &toolCallDelta{
+					Index:    a.count,
+					Id:       "call_" + utils.GetUUID(),
+					Type:     types.ChatMessageRoleFunction,
+					Function: &types.ChatCompletionToolCallsFunction{Name: strings.TrimSpace(a.name)},
+				}})
+				a.count++
+				a.name = ""
+				a.state = toolCallStateArguments
+			case '\n':
+				// 不是工具调用，原样输出
+				text.WriteString(toolCallPrefix + a.name + "\n")
+				a.name = ""
+				a.lineStart = true
+				a.state = toolCallStateText
+			default:
+				a.name += string(char)
+			}
+		case toolCallStateArguments:
+			// 没有参数或参数不是 JSON 时以 ) 结束
+			if char == ')' && a.depth == 0 && !a.inString {
+				pieces = a.appendArguments(pieces)
+				a.state = toolCallStateEnd
+				continue
+			}
+
+			a.arguments.WriteRune(char)
+			if a.trackJSON(char) {
+				pieces = a.appendArguments(pieces)
+				a.state = toolCallStateEnd
+			}
+		case toolCallStateEnd:
+			// 忽略参数之后到行尾的 ) 等内容
+			if char == '\n' {
+				a.lineStart = true
+				a.state = toolCallStateText
+			}
+		}
+	}
+
+	flushText()
+	if a.state == toolCallStateArguments {
+		pieces = a.appendArguments(pieces)
+	}
+
+	return pieces
+}
+
+// 流结束时输出暂缓的内容，返回是否发起过工具调用
+func (a *toolCallAssembler) finish() ([]toolCallPiece, bool) {
+	var pieces []toolCallPiece
+	switch a.state {
+	case toolCallStateText:
+		if a.line != "" {
+			pieces = append(pieces, toolCallPiece{text: a.line})
+		}
+	case toolCallStateName:
+		pieces = append(pieces, toolCallPiece{text: toolCallPrefix + a.name})
+	}
+	a.line = ""
+	a.name = ""
+	a.state = toolCallStateText
+
+	return pieces, a.count > 0
+}
+
+// 按 JSON 的括号和字符串更新层级，返回参数是否已经结束
+func (a *toolCallAssembler) trackJSON(char rune) bool {
+	if a.inString {
+		switch {
+		case a.escaped:
+			a.escaped = false
+		case char == '\\':
+			a.escaped = true
+		case char == '"':
+			a.inString = false
+		}
+		return false
+	}
+
+	switch char {
+	case '"':
+		a.inString = true
+	case '{', '[':
+		a.depth++
+	case '}', ']':
+		a.depth--
+		return a.depth <= 0
+	}
+
+	return false
+}
+
+// 发送已收到的参数片段
+func (a *toolCallAssembler) appendArguments(pieces []toolCallPiece) []toolCallPiece {
+	if a.arguments.Len() == 0 {
+		return pieces
+	}
+
+	pieces = append(pieces, toolCallPiece{toolCall: &toolCallDelta{
+		Index:    a.count - 1,
+		Function: &types.ChatCompletionToolCallsFunction{Arguments: a.arguments.String()},
+	}})
+	a.arguments.Reset()
+
+	return pieces
+}
+
+// 工具调用的流式分块
+func (h *ReplicateStreamHandler) getToolCallChunk(toolCall *toolCallDelta) string {
+	chatCompletion := toolCallStreamResponse{
+		ChatCompletionStreamResponse: types.ChatCompletionStreamResponse{
+			ID:                h.ID,
+			Object:            "chat.completion.chunk",
+			Created:           h.Created,
+			Model:             h.ModelName,
+			SystemFingerprint: h.SystemFingerprint,
+		},
+		Choices: []toolCallStreamChoice{{
+			Index: 0,
+			Delta: toolCallStreamChoiceDelta{
+				Role:      types.ChatMessageRoleAssistant,
+				ToolCalls: []*toolCallDelta{toolCall},
+			},
+		}},
+	}
+
+	responseBody, _ := json.Marshal(chatCompletion)
+
+	return string(responseBody)
+}
+
+// 发送普通内容和工具调用分块，工具调用前先发送缓冲的内容
+func (h *ReplicateStreamHandler) emitPieces(pieces []toolCallPiece, dataChan chan string) {
+	for _, piece := range pieces {
+		if piece.toolCall == nil {
+			h.emit(piece.text, dataChan)
+			continue
+		}
+
+		h.flush(dataChan)
+		h.pace()
+		dataChan <- h.getToolCallChunk(piece.toolCall)
+	}
+}
@@ -0,0 +1,125 @@
+package replicate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionStreamToolCalls(t *testing.T) {
+	provider, _ := getMockProvider(model.PluginType{"tool_calls": {"models": "*"}}, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Let me check.\ndata: Act\n\n" +
+				"event: output\ndata: ion: get_weather({\"city\":\n\n" +
+				"event: output\ndata:  \"Paris (FR)\", \"unit\": \"c\"})\ndata: Action: get_time(\n\n" +
+				"event: output\ndata: {})\n\n" +
+				"event: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","metrics":{"input_token_count":3,"output_token_count":20}}`)
+		}
+	})
+
+	request := getTestChatRequest("weather in Paris?")
+	request.Stream = true
+	request.Tools = []*types.ChatCompletionTool{
+		{Type: "function", Function: types.ChatCompletionFunction{Name: "get_weather"}},
+		{Type: "function", Function: types.ChatCompletionFunction{Name: "get_time"}},
+	}
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	if !assert.Nil(t, errWithCode) {
+		return
+	}
+	defer stream.Close()
+
+	var content string
+	var finishReason any
+	var toolCalls []map[string]any
+	arguments := map[int]string{}
+	dataChan, errChan := stream.Recv()
+	for done := false; !done; {
+		select {
+		case data := <-dataChan:
+			var chunk struct {
+				Choices []struct {
+					Delta struct {
+						Content   string           `json:"content"`
+						ToolCalls []map[string]any `json:"tool_calls"`
+					} `json:"delta"`
+					FinishReason any `json:"finish_reason"`
+				} `json:"choices"`
+			}
+			assert.Nil(t, json.Unmarshal([]byte(data), &chunk))
+			choice := chunk.Choices[0]
+			content += choice.Delta.Content
+			if choice.FinishReason != nil {
+				finishReason = choice.FinishReason
+			}
+			// 每个分块只有一个工具调用的片段
+			if len(choice.Delta.ToolCalls) > 0 {
+				assert.Len(t, choice.Delta.ToolCalls, 1)
+				toolCalls = append(toolCalls, choice.Delta.ToolCalls[0])
+			}
+		case err := <-errChan:
+			assert.True(t, errors.Is(err, io.EOF))
+			done = true
+		}
+	}
+
+	assert.Equal(t, "Let me check.\n", content)
+	assert.Equal(t, types.FinishReasonToolCalls, finishReason)
+
+	// 每个工具调用的第一个分块带 id、type、name 和空的 arguments，之后只有 index 和参数片段
+	headers := 0
+	for _, toolCall := range toolCalls {
+		index := int(toolCall["index"].(float64))
+		function := toolCall["function"].(map[string]any)
+		if _, ok := toolCall["id"]; ok {
+			headers++
+			assert.True(t, strings.HasPrefix(toolCall["id"].(string), "call_"))
+			assert.Equal(t, "function", toolCall["type"])
+			assert.Equal(t, []string{"get_weather", "get_time"}[index], function["name"])
+			assert.Equal(t, "", function["arguments"])
+			continue
+		}
+		assert.NotContains(t, toolCall, "type")
+		assert.NotContains(t, function, "name")
+		arguments[index] += function["arguments"].(string)
+	}
+	assert.Equal(t, 2, headers)
+	assert.Equal(t, `{"city": "Paris (FR)", "unit": "c"}`, arguments[0])
+	assert.Equal(t, `{}`, arguments[1])
+	// 参数分多个分块发送
+	assert.Greater(t, len(toolCalls), 4)
+
+	// 只有流式片段省略 id 和 type，共用的工具调用类型保持不变
+	data, _ := json.Marshal(types.ChatCompletionToolCalls{Function: &types.ChatCompletionToolCallsFunction{}})
+	assert.JSONEq(t, `{"id":"","type":"","function":{"arguments":""},"index":0}`, string(data))
+}
+
+func TestToolCallAssemblerPlainText(t *testing.T) {
+	// 不是工具调用的 Action: 行原样输出
+	assembler := &toolCallAssembler{lineStart: true}
+	text := ""
+	for _, content := range []string{"Acti", "on: none\nActions speak", " louder\nAct"} {
+		for _, piece := range assembler.feed(content) {
+			assert.Nil(t, piece.toolCall)
+			text += piece.text
+		}
+	}
+	pieces, called := assembler.finish()
+	for _, piece := range pieces {
+		text += piece.text
+	}
+	assert.False(t, called)
+	assert.Equal(t, "Action: none\nActions speak louder\nAct", text)
+}
@@ -0,0 +1,171 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common/logger"
+	"time"
+)
+
+// 预测的时间线，用于排查延迟：上游的创建、开始、完成时间，轮询次数，首字时间和收到的字节数
+type PredictionTrace struct {
+	PredictionId string `json:"prediction_id"`
+	// RFC 3339 格式，取自上游响应
+	CreatedAt   string `json:"created_at,omitempty"`
+	StartedAt   string `json:"started_at,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	Polls       int    `json:"polls"`
+	// 首个输出分块距预测创建的毫秒数，只有流式输出时记录
+	TTFTMs int64 `json:"ttft_ms,omitempty"`
+	// 从上游收到的响应体和流式事件的总字节数
+	TotalBytes int  `json:"total_bytes"`
+	Stream     bool `json:"stream"`
+}
+
+// 按预测 ID 获取时间线，没有时创建，调用方需持有 mu
+func (p *ReplicateProvider) getTrace(predictionID string) *PredictionTrace {
+	if p.traces == nil {
+		p.traces = make(map[string]*PredictionTrace)
+	}
+
+	trace, ok := p.traces[predictionID]
+	if !ok {
+		trace = &PredictionTrace{PredictionId: predictionID}
+		p.traces[predictionID] = trace
+		p.traceIds = append(p.traceIds, predictionID)
+	}
+
+	return trace
+}
+
+// 按预测的响应体更新时间线，上游只在对应阶段返回各个时间
+func (p *ReplicateProvider) recordTraceResponse(body []byte) {
+	var prediction struct {
+		ID          string `json:"id"`
+		CreatedAt   string `json:"created_at"`
+		StartedAt   string `json:"started_at"`
+		CompletedAt string `json:"completed_at"`
+	}
+	if err := json.Unmarshal(body, &prediction); err != nil || prediction.ID == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	trace := p.getTrace(prediction.ID)
+	trace.TotalBytes += len(body)
+	if prediction.CreatedAt != "" {
+		trace.CreatedAt = prediction.CreatedAt
+	}
+	if prediction.StartedAt != "" {
+		trace.StartedAt = prediction.StartedAt
+	}
+	if prediction.CompletedAt != "" {
+		trace.CompletedAt = prediction.CompletedAt
+	}
+}
+
+func (p *ReplicateProvider) recordTracePolls(predictionID string, polls int) {
+	if predictionID == "" || polls == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getTrace(predictionID).Polls += polls
+}
+
+// 流式输出的预测在流结束时记录日志
+func (p *ReplicateProvider) recordTraceStream(predictionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getTrace(predictionID).Stream = true
+}
+
+func (p *ReplicateProvider) recordTraceFirstToken(predictionID string, ttft time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getTrace(predictionID).TTFTMs = ttft.Milliseconds()
+}
+
+func (p *ReplicateProvider) recordTraceBytes(predictionID string, size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getTrace(predictionID).TotalBytes += size
+}
+
+// 时间线的副本，没有记录时返回 nil
+func (p *ReplicateProvider) getPredictionTrace(predictionID string) *PredictionTrace {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	trace, ok := p.traces[predictionID]
+	if !ok {
+		return nil
+	}
+	traceCopy := *trace
+
+	return &traceCopy
+}
+
+// 预测结束时记录时间线，与是否请求调试信息无关
+func (p *ReplicateProvider) logTrace(predictionID string) {
+	trace := p.getPredictionTrace(predictionID)
+	if trace == nil {
+		return
+	}
+
+	data, _ := json.Marshal(trace)
+	logger.LogInfo(p.getRequestContext(), fmt.Sprintf("replicate prediction trace: %s", data))
+}
+
+// 非流式预测结束时记录时间线，流式输出的预测由流式处理在结束时记录
+func (p *ReplicateProvider) logPredictionTrace(predictionID string) {
+	if trace := p.getPredictionTrace(predictionID); trace != nil && !trace.Stream {
+		p.logTrace(predictionID)
+	}
+}
+
+// 非流式响应的预测时间线，只返回给请求调试信息的管理员，多个预测（n > 1）时为数组
+func (p *ReplicateProvider) getTraceResponse() any {
+	if !p.debugEnabled() {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.traceIds) == 0 {
+		return nil
+	}
+	if len(p.traceIds) == 1 {
+		return *p.traces[p.traceIds[0]]
+	}
+
+	traces := make([]PredictionTrace, 0, len(p.traceIds))
+	for _, id := range p.traceIds {
+		traces = append(traces, *p.traces[id])
+	}
+
+	return traces
+}
+
+// 流式响应在结束分块前以 SSE 注释返回时间线
+func (p *ReplicateProvider) getTraceComment(predictionID string) string {
+	if !p.debugEnabled() {
+		return ""
+	}
+
+	trace := p.getPredictionTrace(predictionID)
+	if trace == nil {
+		return ""
+	}
+
+	data, _ := json.Marshal(trace)
+	return ": x_replicate_trace " + string(data)
+}
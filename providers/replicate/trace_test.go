@@ -0,0 +1,92 @@
+package replicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionTrace(t *testing.T) {
+	setAdminUser(t, true)
+
+	polls := 0
+	handler := func(req *http.Request) *http.Response {
+		if req.Method == http.MethodPost {
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","created_at":"2024-05-01T10:00:00.000Z"}`)
+		}
+		polls++
+		if polls < 3 {
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"processing","created_at":"2024-05-01T10:00:00.000Z","started_at":"2024-05-01T10:00:01.000Z"}`)
+		}
+		return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello"],"created_at":"2024-05-01T10:00:00.000Z","started_at":"2024-05-01T10:00:01.000Z","completed_at":"2024-05-01T10:00:02.500Z"}`)
+	}
+
+	provider, _ := getMockProvider(debugPlugin, handler)
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+
+	// 时间线由 relay 写入响应体的 x_replicate_trace，OpenAI 格式的响应不变
+	data, _ := json.Marshal(response)
+	assert.NotContains(t, string(data), "prediction_id")
+
+	var trace PredictionTrace
+	data, _ = json.Marshal(provider.GetResponseExtraFields()["x_replicate_trace"])
+	if assert.Nil(t, json.Unmarshal(data, &trace)) {
+		assert.Equal(t, "p1", trace.PredictionId)
+		assert.Equal(t, 3, trace.Polls)
+		assert.Equal(t, "2024-05-01T10:00:00.000Z", trace.CreatedAt)
+		assert.Equal(t, "2024-05-01T10:00:01.000Z", trace.StartedAt)
+		assert.Equal(t, "2024-05-01T10:00:02.500Z", trace.CompletedAt)
+		assert.Greater(t, trace.TotalBytes, 0)
+		assert.False(t, trace.Stream)
+	}
+
+	// 普通用户请求时不返回，只记录日志
+	setAdminUser(t, false)
+	polls = 0
+	provider, _ = getMockProvider(debugPlugin, handler)
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	_, errWithCode = provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, provider.GetResponseExtraFields())
+	assert.Equal(t, 3, provider.getPredictionTrace("p1").Polls)
+}
+
+func TestCreateChatCompletionStreamTrace(t *testing.T) {
+	setAdminUser(t, true)
+
+	provider, _ := getMockProvider(debugPlugin, func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","created_at":"2024-05-01T10:00:00.000Z","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello"],"created_at":"2024-05-01T10:00:00.000Z","completed_at":"2024-05-01T10:00:02.000Z"}`)
+		}
+	})
+	provider.Context.Request.Header.Set(debugHeader, "true")
+	items := readStreamData(t, provider)
+
+	var comment string
+	for _, item := range items {
+		if strings.HasPrefix(item, ": x_replicate_trace ") {
+			comment = strings.TrimPrefix(item, ": x_replicate_trace ")
+		}
+	}
+	var trace PredictionTrace
+	if assert.Nil(t, json.Unmarshal([]byte(comment), &trace)) {
+		assert.Equal(t, "p1", trace.PredictionId)
+		assert.True(t, trace.Stream)
+		// 流结束后获取用量的一次轮询
+		assert.Equal(t, 1, trace.Polls)
+		assert.Equal(t, "2024-05-01T10:00:00.000Z", trace.CreatedAt)
+		assert.Equal(t, "2024-05-01T10:00:02.000Z", trace.CompletedAt)
+	}
+	// 时间线在结束分块之前
+	assert.Contains(t, items[len(items)-1], `"finish_reason":"stop"`)
+}
@@ -0,0 +1,187 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/utils"
+	"one-api/types"
+	"path/filepath"
+	"strings"
+)
+
+// 使用 Replicate 上的 Whisper 模型转写音频，音频先暂存到 Replicate 可以访问的地址
+func (p *ReplicateProvider) CreateTranscriptions(request *types.AudioRequest) (response *types.AudioResponseWrapper, errWithCode *types.OpenAIErrorWithStatusCode) {
+	defer func() { errWithCode = normalizeError(errWithCode) }()
+
+	if errWithCode := p.validateRequest(); errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	responseFormat := request.ResponseFormat
+	if responseFormat == "" {
+		responseFormat = "json"
+	}
+	switch responseFormat {
+	case "json", "text", "srt", "vtt", "verbose_json":
+	default:
+		return nil, common.StringErrorWrapperLocal(fmt.Sprintf("unsupported response_format: %s", responseFormat), "invalid_response_format", http.StatusBadRequest)
+	}
+
+	replicateModel, errWithCode := p.resolveModel(request.Model)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	audioUrl, errWithCode := p.stageAudio(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	fullRequestURL, errWithCode := p.getPredictionURL(config.RelayModeAudioTranscription, replicateModel)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+
+	replicateRequest := &ReplicateRequest[map[string]any]{
+		Version: replicateModel.Version,
+		Input:   convertFromAudioOpenai(request, audioUrl),
+	}
+	// 客户端上传的是 multipart 表单，创建预测使用 JSON
+	headers := p.GetRequestHeaders()
+	headers["Content-Type"] = "application/json"
+	p.setPreferWait(headers)
+	req, err := p.Requester.NewRequest(http.MethodPost, fullRequestURL, p.Requester.WithBody(replicateRequest), p.Requester.WithHeader(headers))
+	if err != nil {
+		return nil, common.ErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	defer req.Body.Close()
+
+	replicateResponse := &ReplicateResponse[*ReplicateTranscriptionOutput]{}
+	if errWithCode := p.sendRequest(req, replicateResponse); errWithCode != nil {
+		return nil, errWithCode
+	}
+	p.logPrediction(replicateResponse.ID)
+
+	replicateResponse, err = getPrediction(p, replicateResponse)
+	if err != nil {
+		return nil, predictionErrorWrapper(err)
+	}
+	if replicateResponse.Output == nil {
+		return nil, common.StringErrorWrapper("replicate transcription returned no output", "prediction_failed", http.StatusInternalServerError)
+	}
+
+	output := replicateResponse.Output
+	text := strings.TrimSpace(output.Transcription)
+
+	audioResponseWrapper := &types.AudioResponseWrapper{
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}
+	switch responseFormat {
+	case "json":
+		audioResponseWrapper.Body, err = json.Marshal(&types.AudioResponse{Text: text})
+	case "verbose_json":
+		audioResponseWrapper.Body, err = json.Marshal(convertToVerboseTranscription(output, text, request.Language))
+	default:
+		audioResponseWrapper.Headers["Content-Type"] = "text/plain; charset=utf-8"
+		audioResponseWrapper.Body = []byte(getTranscriptionText(output, text, responseFormat))
+	}
+	if err != nil {
+		return nil, common.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError)
+	}
+
+	p.Usage.CompletionTokens = common.CountTokenText(text, request.Model)
+	p.Usage.TotalTokens = p.Usage.PromptTokens + p.Usage.CompletionTokens
+
+	return audioResponseWrapper, nil
+}
+
+// 读取上传的音频并暂存，渠道插件 transcription.target 为 storage 时上传到系统存储
+func (p *ReplicateProvider) stageAudio(request *types.AudioRequest) (string, *types.OpenAIErrorWithStatusCode) {
+	if request.File == nil {
+		return "", common.StringErrorWrapperLocal("file is required", "invalid_request", http.StatusBadRequest)
+	}
+
+	file, err := request.File.Open()
+	if err != nil {
+		return "", common.ErrorWrapperLocal(err, "read_file_failed", http.StatusBadRequest)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", common.ErrorWrapperLocal(err, "read_file_failed", http.StatusBadRequest)
+	}
+
+	filename := utils.GetUUID() + filepath.Ext(request.File.Filename)
+
+	return p.stageFile(data, filename, pluginString(p.getPlugin("transcription"), "target"))
+}
+
+func convertFromAudioOpenai(request *types.AudioRequest, audioUrl string) map[string]any {
+	input := map[string]any{
+		"audio": audioUrl,
+	}
+	if request.Language != "" {
+		input["language"] = request.Language
+	}
+	if request.Prompt != "" {
+		input["initial_prompt"] = request.Prompt
+	}
+	if request.Temperature > 0 {
+		input["temperature"] = request.Temperature
+	}
+
+	return input
+}
+
+func convertToVerboseTranscription(output *ReplicateTranscriptionOutput, text, language string) *types.AudioResponse {
+	response := &types.AudioResponse{
+		Task:     "transcribe",
+		Language: language,
+		Text:     text,
+		Segments: output.Segments,
+	}
+	if output.DetectedLanguage != "" {
+		response.Language = output.DetectedLanguage
+	}
+	if len(output.Segments) > 0 {
+		response.Duration = output.Segments[len(output.Segments)-1].End
+	}
+
+	return response
+}
+
+// 根据分段生成 srt / vtt 字幕，没有分段时返回纯文本
+func getTranscriptionText(output *ReplicateTranscriptionOutput, text, format string) string {
+	if format == "text" || len(output.Segments) == 0 {
+		return text
+	}
+
+	var builder strings.Builder
+	if format == "vtt" {
+		builder.WriteString("WEBVTT\n\n")
+	}
+
+	for index, segment := range output.Segments {
+		if format == "srt" {
+			fmt.Fprintf(&builder, "%d\n", index+1)
+		}
+		fmt.Fprintf(&builder, "%s --> %s\n%s\n\n", formatSubtitleTime(segment.Start, format), formatSubtitleTime(segment.End, format), strings.TrimSpace(segment.Text))
+	}
+
+	return builder.String()
+}
+
+func formatSubtitleTime(seconds float64, format string) string {
+	milliseconds := int64(seconds*1000 + 0.5)
+	separator := ","
+	if format == "vtt" {
+		separator = "."
+	}
+
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, separator, milliseconds%1000)
+}
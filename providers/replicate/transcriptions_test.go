@@ -0,0 +1,119 @@
+package replicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"one-api/model"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testWhisperOutput = `{"transcription":" Hello world. How are you?","detected_language":"english","segments":[{"id":0,"seek":0,"start":0,"end":1.5,"text":" Hello world.","tokens":[1,2]},{"id":1,"seek":0,"start":1.5,"end":3.25,"text":" How are you?","tokens":[3,4]}]}`
+
+func getTestAudioRequest(t *testing.T, responseFormat string) *types.AudioRequest {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "speech.mp3")
+	assert.Nil(t, err)
+	part.Write([]byte("fake audio"))
+	writer.Close()
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	assert.Nil(t, err)
+
+	return &types.AudioRequest{
+		File:           form.File["file"][0],
+		Model:          "whisper-1",
+		Language:       "en",
+		ResponseFormat: responseFormat,
+	}
+}
+
+func getTranscriptionMockProvider(t *testing.T) (*ReplicateProvider, *testDoer) {
+	plugin := model.PluginType{
+		"model_alias": {"mapping": `{"whisper-1":"openai/whisper:abc123"}`},
+	}
+
+	provider, doer := getMockProvider(plugin, func(req *http.Request) *http.Response {
+		switch req.URL.Path {
+		case "/v1/files":
+			assert.Nil(t, req.ParseMultipartForm(1<<20))
+			_, header, err := req.FormFile("content")
+			assert.Nil(t, err)
+			assert.True(t, strings.HasSuffix(header.Filename, ".mp3"))
+			return jsonResponse(http.StatusCreated, `{"id":"f1","urls":{"get":"https://api.replicate.com/v1/files/f1"}}`)
+		case "/v1/predictions":
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":`+testWhisperOutput+`}`)
+		}
+	})
+	provider.Context.Request.Header.Set("Content-Type", "multipart/form-data; boundary=test")
+
+	return provider, doer
+}
+
+func TestCreateTranscriptionsJson(t *testing.T) {
+	provider, doer := getTranscriptionMockProvider(t)
+
+	response, errWithCode := provider.CreateTranscriptions(getTestAudioRequest(t, "json"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "application/json", response.Headers["Content-Type"])
+	assert.JSONEq(t, `{"text":"Hello world. How are you?"}`, string(response.Body))
+	assert.Greater(t, provider.Usage.CompletionTokens, 0)
+
+	assert.Equal(t, "/v1/predictions", doer.requests[1].URL.Path)
+	assert.Equal(t, "application/json", doer.requests[1].Header.Get("Content-Type"))
+	body, _ := io.ReadAll(doer.requests[1].Body)
+	assert.Contains(t, string(body), `"version":"abc123"`)
+	assert.Contains(t, string(body), `"audio":"https://api.replicate.com/v1/files/f1"`)
+	assert.Contains(t, string(body), `"language":"en"`)
+}
+
+func TestCreateTranscriptionsVerboseJson(t *testing.T) {
+	provider, _ := getTranscriptionMockProvider(t)
+
+	response, errWithCode := provider.CreateTranscriptions(getTestAudioRequest(t, "verbose_json"))
+	assert.Nil(t, errWithCode)
+
+	result := struct {
+		Task     string                    `json:"task"`
+		Language string                    `json:"language"`
+		Duration float64                   `json:"duration"`
+		Text     string                    `json:"text"`
+		Segments []ReplicateWhisperSegment `json:"segments"`
+	}{}
+	assert.Nil(t, json.Unmarshal(response.Body, &result))
+	assert.Equal(t, "transcribe", result.Task)
+	assert.Equal(t, "english", result.Language)
+	assert.Equal(t, 3.25, result.Duration)
+	assert.Equal(t, "Hello world. How are you?", result.Text)
+	assert.Len(t, result.Segments, 2)
+	assert.Equal(t, " How are you?", result.Segments[1].Text)
+}
+
+func TestCreateTranscriptionsSubtitles(t *testing.T) {
+	provider, _ := getTranscriptionMockProvider(t)
+
+	response, errWithCode := provider.CreateTranscriptions(getTestAudioRequest(t, "srt"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "1\n00:00:00,000 --> 00:00:01,500\nHello world.\n\n2\n00:00:01,500 --> 00:00:03,250\nHow are you?\n\n", string(response.Body))
+
+	response, errWithCode = provider.CreateTranscriptions(getTestAudioRequest(t, "vtt"))
+	assert.Nil(t, errWithCode)
+	assert.True(t, strings.HasPrefix(string(response.Body), "WEBVTT\n\n00:00:00.000 --> 00:00:01.500\n"))
+
+	response, errWithCode = provider.CreateTranscriptions(getTestAudioRequest(t, "text"))
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "Hello world. How are you?", string(response.Body))
+
+	_, errWithCode = provider.CreateTranscriptions(getTestAudioRequest(t, "xml"))
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+}
@@ -1,14 +1,28 @@
 package replicate
 
+import (
+	"encoding/json"
+	"one-api/common/utils"
+	"time"
+)
+
 type ReplicateError struct {
-	Detail string `json:"detail"`
-	Status int    `json:"status"`
-	Title  string `json:"title"`
+	Detail        string                  `json:"detail"`
+	Status        int                     `json:"status"`
+	Title         string                  `json:"title"`
+	InvalidFields []ReplicateInvalidField `json:"invalid_fields,omitempty"`
+}
+
+type ReplicateInvalidField struct {
+	Type        string `json:"type"`
+	Field       string `json:"field"`
+	Description string `json:"description"`
 }
 
 type ReplicateRequest[T any] struct {
-	Stream bool `json:"stream,omitempty"`
-	Input  T    `json:"input"`
+	Version string `json:"version,omitempty"`
+	Stream  bool   `json:"stream,omitempty"`
+	Input   T      `json:"input"`
 }
 
 type ReplicateImageRequest struct {
@@ -25,30 +39,231 @@ type ReplicateChatRequest struct {
 	TopK             float64  `json:"top_k,omitempty"`
 	TopP             *float64 `json:"top_p,omitempty"`
 	Prompt           string   `json:"prompt"`
-  Image            string   `json:"image,omitempty"` 
+	Image            string   `json:"image,omitempty"`
 	MaxTokens        int      `json:"max_tokens,omitempty"`
 	MinTokens        int      `json:"min_tokens,omitempty"`
 	Temperature      *float64 `json:"temperature,omitempty"`
 	SystemPrompt     string   `json:"system_prompt,omitempty"`
 	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
 	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	StopSequences    string   `json:"stop_sequences,omitempty"`
+
+	// 原始图片地址，Image 为逗号拼接后的结果
+	Images []string `json:"-"`
+	// 上传前的图片，上传后 Images 为上传得到的地址，计算请求哈希时使用
+	SourceImages []string `json:"-"`
+
+	// 透传的额外输入参数，不覆盖已映射的字段
+	Extra map[string]any `json:"-"`
+}
+
+func (r ReplicateChatRequest) MarshalJSON() ([]byte, error) {
+	type alias ReplicateChatRequest
+	data, err := json.Marshal(alias(r))
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+
+	input := make(map[string]any)
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+
+	for key, value := range r.Extra {
+		if _, exists := input[key]; exists {
+			continue
+		}
+		input[key] = value
+	}
+
+	return json.Marshal(input)
 }
 
 type ReplicateResponse[T any] struct {
-	ID      string            `json:"id"`
-	Model   string            `json:"model"`
-	Urls    ReplicateImageUrl `json:"urls"`
-	Status  string            `json:"status"` // starting / succeeded
-	Error   string            `json:"error,omitempty"`
-	Output  T                 `json:"output,omitempty"`
-	Metrics ReplicateMetrics  `json:"metrics,omitempty"`
+	ID      string           `json:"id"`
+	Model   string           `json:"model"`
+	Version string           `json:"version,omitempty"`
+	Urls    ReplicateUrls    `json:"urls"`
+	Status  string           `json:"status"` // starting / succeeded
+	Error   string           `json:"error,omitempty"`
+	Output  T                `json:"output,omitempty"`
+	Input   map[string]any   `json:"input,omitempty"`
+	Logs    string           `json:"logs,omitempty"`
+	Metrics ReplicateMetrics `json:"metrics,omitempty"`
+	// RFC 3339 格式的预测创建时间
+	CreatedAt string `json:"created_at,omitempty"`
+	// 对话模型输出中的 token 概率，见 ReplicateTokenLogprob
+	Logprobs []ReplicateTokenLogprob `json:"-"`
+	// 合并请求时共享其他请求创建的预测，见 createSharedChatPrediction
+	Coalesced bool `json:"-"`
 }
 
-type ReplicateImageUrl struct {
-	Stream string `json:"stream"`
+func (r *ReplicateResponse[T]) UnmarshalJSON(data []byte) error {
+	type alias ReplicateResponse[T]
+	if err := json.Unmarshal(data, (*alias)(r)); err != nil {
+		return err
+	}
+
+	if _, ok := any(r.Output).(ReplicateChatOutput); !ok {
+		return nil
+	}
+
+	// 只有对象形式的输出带 logprobs，其他形式忽略
+	var output struct {
+		Output struct {
+			Logprobs []ReplicateTokenLogprob `json:"logprobs"`
+		} `json:"output"`
+	}
+	if err := json.Unmarshal(data, &output); err == nil {
+		r.Logprobs = output.Output.Logprobs
+	}
+
+	return nil
+}
+
+// 预测创建时间的 Unix 秒数，缺失或无法解析时使用当前时间
+func (r *ReplicateResponse[T]) getCreated() int64 {
+	if createdAt, err := time.Parse(time.RFC3339Nano, r.CreatedAt); err == nil {
+		return createdAt.Unix()
+	}
+
+	return utils.GetTimestamp()
+}
+
+// 对话模型的输出，兼容字符串数组、字符串以及包含 text 字段的对象
+type ReplicateChatOutput []string
+
+func (o *ReplicateChatOutput) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*o = list
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*o = ReplicateChatOutput{text}
+		return nil
+	}
+
+	var object struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	*o = nil
+	if object.Text != "" {
+		*o = ReplicateChatOutput{object.Text}
+	}
+
+	return nil
+}
+
+// 分类模型的输出，兼容标签到分数的对象以及 label/score 数组
+type ReplicateModerationOutput map[string]float64
+
+func (o *ReplicateModerationOutput) UnmarshalJSON(data []byte) error {
+	scores := make(map[string]float64)
+	if err := json.Unmarshal(data, &scores); err == nil {
+		*o = scores
+		return nil
+	}
+
+	var labels []struct {
+		Label string  `json:"label"`
+		Score float64 `json:"score"`
+	}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return err
+	}
+
+	for _, label := range labels {
+		scores[label.Label] = label.Score
+	}
+	*o = scores
+
+	return nil
+}
+
+type ReplicateModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type ReplicateTranscriptionOutput struct {
+	Transcription    string                    `json:"transcription"`
+	DetectedLanguage string                    `json:"detected_language,omitempty"`
+	Segments         []ReplicateWhisperSegment `json:"segments,omitempty"`
+}
+
+type ReplicateWhisperSegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+type ReplicateUrls struct {
+	Get    string `json:"get,omitempty"`
+	Cancel string `json:"cancel,omitempty"`
+	Stream string `json:"stream,omitempty"`
+}
+
+type ReplicateFile struct {
+	ID        string        `json:"id"`
+	Urls      ReplicateUrls `json:"urls"`
+	ExpiresAt string        `json:"expires_at,omitempty"`
 }
 
 type ReplicateMetrics struct {
 	InputTokenCount  int `json:"input_token_count,omitempty"`
 	OutputTokenCount int `json:"output_token_count,omitempty"`
 }
+
+// 合并轮询过程中的用量，优先使用最新的值，缺失时沿用之前的值
+func (m ReplicateMetrics) merge(latest ReplicateMetrics) ReplicateMetrics {
+	if latest.InputTokenCount == 0 {
+		latest.InputTokenCount = m.InputTokenCount
+	}
+	if latest.OutputTokenCount == 0 {
+		latest.OutputTokenCount = m.OutputTokenCount
+	}
+
+	return latest
+}
+
+type ReplicateCollection struct {
+	Name   string               `json:"name"`
+	Slug   string               `json:"slug"`
+	Models []ReplicateModelInfo `json:"models"`
+}
+
+type ReplicateModelInfo struct {
+	Owner       string `json:"owner"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Visibility  string `json:"visibility,omitempty"`
+}
+
+// OpenAI /v1/models 格式的模型列表
+type ReplicateModelListResponse struct {
+	Object string                   `json:"object"`
+	Data   []ReplicateModelListItem `json:"data"`
+}
+
+type ReplicateModelListItem struct {
+	Id           string   `json:"id"`
+	Object       string   `json:"object"`
+	Created      int64    `json:"created"`
+	OwnedBy      string   `json:"owned_by"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
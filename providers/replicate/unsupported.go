@@ -0,0 +1,33 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/providers/base"
+	"one-api/types"
+)
+
+// Replicate 未提供以下接口，返回统一的 501 错误，避免中继层误判为渠道异常
+
+func (p *ReplicateProvider) CreateEmbeddings(request *types.EmbeddingRequest) (*types.EmbeddingResponse, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "embeddings"))
+}
+
+func (p *ReplicateProvider) CreateSpeech(request *types.SpeechAudioRequest) (*http.Response, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "audio/speech"))
+}
+
+func (p *ReplicateProvider) CreateTranslation(request *types.AudioRequest) (*types.AudioResponseWrapper, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "audio/translations"))
+}
+
+func (p *ReplicateProvider) CreateImageEdits(request *types.ImageEditRequest) (*types.ImageResponse, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "images/edits"))
+}
+
+func (p *ReplicateProvider) CreateImageVariations(request *types.ImageEditRequest) (*types.ImageResponse, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "images/variations"))
+}
+
+func (p *ReplicateProvider) CreateRerank(request *types.RerankRequest) (*types.RerankResponse, *types.OpenAIErrorWithStatusCode) {
+	return nil, normalizeError(base.ErrorEndpointNotSupported(metricsProvider, "rerank"))
+}
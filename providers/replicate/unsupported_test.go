@@ -0,0 +1,62 @@
+package replicate
+
+import (
+	"net/http"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsupportedEndpoints(t *testing.T) {
+	provider, doer := getMockProvider(nil, func(req *http.Request) *http.Response {
+		return jsonResponse(http.StatusOK, `{}`)
+	})
+
+	tests := []struct {
+		endpoint string
+		call     func() *types.OpenAIErrorWithStatusCode
+	}{
+		{"embeddings", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateEmbeddings(&types.EmbeddingRequest{})
+			return errWithCode
+		}},
+		{"audio/speech", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateSpeech(&types.SpeechAudioRequest{})
+			return errWithCode
+		}},
+		{"audio/translations", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateTranslation(&types.AudioRequest{})
+			return errWithCode
+		}},
+		{"images/edits", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateImageEdits(&types.ImageEditRequest{})
+			return errWithCode
+		}},
+		{"images/variations", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateImageVariations(&types.ImageEditRequest{})
+			return errWithCode
+		}},
+		{"rerank", func() *types.OpenAIErrorWithStatusCode {
+			_, errWithCode := provider.CreateRerank(&types.RerankRequest{})
+			return errWithCode
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			errWithCode := tt.call()
+			if assert.NotNil(t, errWithCode) {
+				assert.Equal(t, http.StatusNotImplemented, errWithCode.StatusCode)
+				assert.Equal(t, "invalid_request_error", errWithCode.Type)
+				assert.Equal(t, "endpoint_not_supported", errWithCode.Code)
+				assert.Equal(t, tt.endpoint, errWithCode.Param)
+				assert.Contains(t, errWithCode.Message, "replicate")
+				assert.Contains(t, errWithCode.Message, tt.endpoint)
+				assert.True(t, errWithCode.LocalError)
+			}
+		})
+	}
+
+	assert.Equal(t, 0, len(doer.requests))
+}
@@ -0,0 +1,145 @@
+package replicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/common/requester"
+	"one-api/common/utils"
+	"one-api/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认上游 input_token_count 与本地计数相差超过 20% 时记录日志
+const defaultPromptTokensThreshold = 0.2
+
+// 实际发送给 Replicate 的提示词，包含 system prompt 和角色标签
+func getSentPrompt(input *ReplicateChatRequest) string {
+	return input.SystemPrompt + input.Prompt
+}
+
+// 计费的提示词用量，上游返回 input_token_count 时以上游为准，否则按实际发送的提示词计算；
+// 两者相差超过渠道插件 prompt_tokens.discrepancy_threshold（比例）时记录日志
+func (p *ReplicateProvider) getPromptTokens(predictionId string, reported int, inputPrompt, modelName string) (tokens int, estimated bool) {
+	counted := common.CountTokenText(inputPrompt, modelName)
+	if reported <= 0 {
+		return counted, true
+	}
+
+	threshold := pluginFloat(p.getPlugin("prompt_tokens"), "discrepancy_threshold", defaultPromptTokensThreshold)
+	if isPromptTokensDiscrepant(reported, counted, threshold) {
+		logger.LogWarn(p.getRequestContext(), fmt.Sprintf("replicate prediction %s reported %d prompt tokens, counted %d with %s", predictionId, reported, counted, common.GetTokenizer(modelName).Name()))
+	}
+
+	return reported, false
+}
+
+func isPromptTokensDiscrepant(reported, counted int, threshold float64) bool {
+	if counted <= 0 {
+		return reported > 0
+	}
+
+	diff := reported - counted
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return float64(diff)/float64(counted) > threshold
+}
+
+const usageOnlyHeader = "X-Replicate-Usage-Only"
+
+// 请求头 X-Replicate-Usage-Only: true 时只返回用量，不返回输出内容，用于估算成本
+func (p *ReplicateProvider) usageOnlyRequested() bool {
+	if p.Context == nil || p.Context.Request == nil {
+		return false
+	}
+
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(p.Context.GetHeader(usageOnlyHeader)))
+	return enabled
+}
+
+// 渠道插件 usage_only.estimate 开启时不创建预测，只按实际发送的提示词估算 prompt tokens，
+// completion tokens 为 0，不产生上游费用也不计费；未开启时照常创建预测，按实际用量计费
+func (p *ReplicateProvider) usageOnlyEstimate() bool {
+	return pluginBool(p.getPlugin("usage_only"), "estimate")
+}
+
+// 返回估算的用量，清空计费用量
+func (p *ReplicateProvider) estimateUsage(input *ReplicateChatRequest, modelName string) *types.Usage {
+	usage := &types.Usage{
+		PromptTokens: common.CountTokenText(getSentPrompt(input), modelName),
+		Estimated:    true,
+	}
+	usage.TotalTokens = usage.PromptTokens
+
+	p.Usage.PromptTokens = 0
+	p.Usage.CompletionTokens = 0
+	p.Usage.TotalTokens = 0
+
+	return usage
+}
+
+func (p *ReplicateProvider) createUsageOnlyChatCompletion(request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	if p.usageOnlyEstimate() {
+		replicateRequest, _, errWithCode := p.getReplicateChatRequest(request)
+		if errWithCode != nil {
+			return nil, errWithCode
+		}
+
+		return &types.ChatCompletionResponse{
+			ID:      "chatcmpl-" + utils.GetUUID(),
+			Object:  "chat.completion",
+			Created: utils.GetTimestamp(),
+			Model:   request.Model,
+			Choices: []types.ChatCompletionChoice{},
+			Usage:   p.estimateUsage(&replicateRequest.Input, request.Model),
+		}, nil
+	}
+
+	response, errWithCode := p.createChatCompletion(request)
+	if errWithCode != nil {
+		return nil, errWithCode
+	}
+	response.Choices = []types.ChatCompletionChoice{}
+
+	return response, nil
+}
+
+// 不创建预测，返回只有结束分块（带估算用量）的流
+func (p *ReplicateProvider) createUsageOnlyEstimateStream(replicateRequest *ReplicateRequest[ReplicateChatRequest], chatHandler *ReplicateStreamHandler) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	chatHandler.Usage = p.estimateUsage(&replicateRequest.Input, chatHandler.ModelName)
+	chatHandler.ID = "chatcmpl-" + utils.GetUUID()
+	chatHandler.Created = utils.GetTimestamp()
+	chatHandler.StartTime = time.Now()
+	chatHandler.Input = &replicateRequest.Input
+	chatHandler.Prediction = &ReplicateResponse[ReplicateChatOutput]{ID: chatHandler.ID, Status: predictionSucceeded}
+
+	return requester.RequestEventStream(p.Requester, getCompletedStreamResponse(nil), chatHandler.HandlerChatStream)
+}
+
+// 只返回用量时的结束分块，choices 中没有内容
+func (h *ReplicateStreamHandler) getUsageOnlyChunk(finishReason string) string {
+	usage := *h.Usage
+	chatCompletion := types.ChatCompletionStreamResponse{
+		ID:      h.ID,
+		Object:  "chat.completion.chunk",
+		Created: h.Created,
+		Model:   h.ModelName,
+		// 与内容分块一致
+		SystemFingerprint: h.SystemFingerprint,
+		Choices: []types.ChatCompletionStreamChoice{{
+			Index:        0,
+			Delta:        types.ChatCompletionStreamChoiceDelta{Role: types.ChatMessageRoleAssistant},
+			FinishReason: finishReason,
+		}},
+		Usage: &usage,
+	}
+
+	responseBody, _ := json.Marshal(chatCompletion)
+
+	return string(responseBody)
+}
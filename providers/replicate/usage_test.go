@@ -0,0 +1,143 @@
+package replicate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateChatCompletionPromptTokens(t *testing.T) {
+	var input ReplicateChatRequest
+	handler := func(metrics string) func(req *http.Request) *http.Response {
+		return func(req *http.Request) *http.Response {
+			if req.Method == http.MethodPost {
+				body, _ := io.ReadAll(req.Body)
+				var request ReplicateRequest[ReplicateChatRequest]
+				json.Unmarshal(body, &request)
+				input = request.Input
+				return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting"}`)
+			}
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["ok"]`+metrics+`}`)
+		}
+	}
+
+	request := getTestChatRequest("")
+	request.Messages = []types.ChatCompletionMessage{
+		{Role: types.ChatMessageRoleSystem, Content: "You are a helpful assistant."},
+		{Role: types.ChatMessageRoleUser, Content: "first question"},
+		{Role: types.ChatMessageRoleUser, Content: "second question"},
+	}
+	plugin := model.PluginType{
+		"merge_messages": {"models": "*"},
+	}
+
+	// 没有 metrics 时按实际发送的提示词（含 system prompt 和角色标签）计费
+	provider, _ := getMockProvider(plugin, handler(""))
+	// relay 层按原始消息的预估
+	provider.Usage.PromptTokens = 1
+	response, errWithCode := provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, "user: \nfirst question\n\nsecond question\nassistant: \n", input.Prompt)
+	assert.Equal(t, common.CountTokenText(input.SystemPrompt+input.Prompt, request.Model), response.Usage.PromptTokens)
+	assert.True(t, response.Usage.Estimated)
+
+	// 上游返回 input_token_count 时以上游为准
+	provider, _ = getMockProvider(plugin, handler(`,"metrics":{"input_token_count":100,"output_token_count":1}`))
+	response, errWithCode = provider.CreateChatCompletion(request)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, 100, response.Usage.PromptTokens)
+	assert.False(t, response.Usage.Estimated)
+}
+
+func TestIsPromptTokensDiscrepant(t *testing.T) {
+	assert.False(t, isPromptTokensDiscrepant(10, 10, defaultPromptTokensThreshold))
+	assert.False(t, isPromptTokensDiscrepant(12, 10, defaultPromptTokensThreshold))
+	assert.True(t, isPromptTokensDiscrepant(13, 10, defaultPromptTokensThreshold))
+	assert.True(t, isPromptTokensDiscrepant(7, 10, defaultPromptTokensThreshold))
+	assert.True(t, isPromptTokensDiscrepant(5, 0, defaultPromptTokensThreshold))
+	assert.False(t, isPromptTokensDiscrepant(13, 10, 0.5))
+}
+
+func TestCreateChatCompletionUsageOnly(t *testing.T) {
+	handler := func(req *http.Request) *http.Response {
+		switch {
+		case req.Method == http.MethodPost:
+			return jsonResponse(http.StatusCreated, `{"id":"p1","status":"starting","urls":{"stream":"https://stream.replicate.com/v1/files/p1"}}`)
+		case req.URL.Host == "stream.replicate.com":
+			return sseResponse("event: output\ndata: Hello\n\nevent: output\ndata: world\n\nevent: done\ndata: {}\n\n")
+		default:
+			return jsonResponse(http.StatusOK, `{"id":"p1","status":"succeeded","output":["Hello","world"],"metrics":{"input_token_count":5,"output_token_count":2}}`)
+		}
+	}
+
+	// 照常创建预测并按实际用量计费，只是不返回内容
+	provider, _ := getMockProvider(nil, handler)
+	provider.Context.Request.Header.Set(usageOnlyHeader, "true")
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, response.Choices)
+	assert.Equal(t, 5, response.Usage.PromptTokens)
+	assert.Equal(t, 2, response.Usage.CompletionTokens)
+	assert.Equal(t, 7, provider.Usage.TotalTokens)
+
+	provider, _ = getMockProvider(nil, handler)
+	provider.Context.Request.Header.Set(usageOnlyHeader, "true")
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Len(t, chunks, 1)
+	assert.Empty(t, streamContent(chunks))
+	assert.Equal(t, types.FinishReasonStop, chunks[0].Choices[0].FinishReason)
+	if assert.NotNil(t, chunks[0].Usage) {
+		assert.Equal(t, 5, chunks[0].Usage.PromptTokens)
+		assert.Equal(t, 2, chunks[0].Usage.CompletionTokens)
+	}
+	assert.Equal(t, 7, provider.Usage.TotalTokens)
+}
+
+func TestCreateChatCompletionUsageOnlyEstimate(t *testing.T) {
+	plugin := model.PluginType{
+		"usage_only": {"estimate": true},
+	}
+	promptTokens := common.CountTokenText("user: \nhi\nassistant: \n", "meta/meta-llama-3-70b-instruct")
+
+	// 不创建预测，只估算提示词用量，不计费
+	provider, doer := getMockProvider(plugin, nil)
+	provider.Context.Request.Header.Set(usageOnlyHeader, "true")
+	provider.Usage.PromptTokens = 3
+	response, errWithCode := provider.CreateChatCompletion(getTestChatRequest("hi"))
+	assert.Nil(t, errWithCode)
+	assert.Empty(t, doer.requests)
+	assert.Empty(t, response.Choices)
+	assert.Equal(t, promptTokens, response.Usage.PromptTokens)
+	assert.Equal(t, 0, response.Usage.CompletionTokens)
+	assert.True(t, response.Usage.Estimated)
+	assert.Equal(t, 0, provider.Usage.TotalTokens)
+
+	provider, doer = getMockProvider(plugin, nil)
+	provider.Context.Request.Header.Set(usageOnlyHeader, "true")
+	request := getTestChatRequest("hi")
+	request.Stream = true
+	stream, errWithCode := provider.CreateChatCompletionStream(request)
+	assert.Nil(t, errWithCode)
+
+	chunks, err := readStream(t, stream)
+	assert.Nil(t, err)
+	assert.Empty(t, doer.requests)
+	assert.Len(t, chunks, 1)
+	assert.Empty(t, streamContent(chunks))
+	if assert.NotNil(t, chunks[0].Usage) {
+		assert.Equal(t, promptTokens, chunks[0].Usage.PromptTokens)
+	}
+	assert.Equal(t, 0, provider.Usage.TotalTokens)
+}
@@ -70,6 +70,13 @@ func (r *relayBase) setOriginalModel(modelName string) {
 	r.originalModel = parts[0]
 }
 
+// 记录请求中的 user（终端用户标识），写入审计记录、用量事件和消费日志，用于按终端用户追踪滥用
+func (r *relayBase) setEndUser(user string) {
+	if user = strings.TrimSpace(user); user != "" {
+		r.c.Set("end_user", user)
+	}
+}
+
 func (r *relayBase) getContext() *gin.Context {
 	return r.c
 }
@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/metrics"
+	"one-api/types"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 渠道请求队列，slots 为正在处理的请求，waiting 为排队等待的请求数
+type channelQueue struct {
+	slots   chan struct{}
+	mu      sync.Mutex
+	waiting int
+}
+
+var (
+	channelQueues   = make(map[int]*channelQueue)
+	channelQueuesMu sync.Mutex
+)
+
+func getChannelQueue(channelId int) *channelQueue {
+	channelQueuesMu.Lock()
+	defer channelQueuesMu.Unlock()
+
+	queue, ok := channelQueues[channelId]
+	if !ok {
+		queue = &channelQueue{slots: make(chan struct{}, config.ChannelQueueConcurrency)}
+		channelQueues[channelId] = queue
+	}
+
+	return queue
+}
+
+// 获取渠道的处理名额，名额已满时排队等待，排队超过 config.ChannelQueueDepth 时返回 503 并设置 Retry-After；
+// 返回的 release 在请求处理完成后调用
+func acquireChannelSlot(c *gin.Context, channelId int) (release func(), errWithCode *types.OpenAIErrorWithStatusCode) {
+	if config.ChannelQueueConcurrency <= 0 {
+		return func() {}, nil
+	}
+
+	queue := getChannelQueue(channelId)
+	release = func() { <-queue.slots }
+
+	select {
+	case queue.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if !queue.enqueue(channelId) {
+		metrics.RecordChannelQueueShed(channelId)
+		c.Header("Retry-After", strconv.Itoa(config.ChannelQueueRetryAfter))
+		return nil, common.StringErrorWrapperLocal(fmt.Sprintf("channel #%d is overloaded, please retry later", channelId), "channel_queue_full", http.StatusServiceUnavailable)
+	}
+	defer queue.dequeue(channelId)
+
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+
+	select {
+	case queue.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, common.ErrorWrapperLocal(ctx.Err(), "request_canceled", http.StatusRequestTimeout)
+	}
+}
+
+func (q *channelQueue) enqueue(channelId int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.waiting >= config.ChannelQueueDepth {
+		return false
+	}
+	q.waiting++
+	metrics.RecordChannelQueueDepth(channelId, q.waiting)
+
+	return true
+}
+
+func (q *channelQueue) dequeue(channelId int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waiting--
+	metrics.RecordChannelQueueDepth(channelId, q.waiting)
+}
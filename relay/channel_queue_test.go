@@ -0,0 +1,105 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/config"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQueueContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	return c, recorder
+}
+
+func TestAcquireChannelSlotShed(t *testing.T) {
+	config.ChannelQueueConcurrency = 1
+	config.ChannelQueueDepth = 1
+	config.ChannelQueueRetryAfter = 3
+	defer func() {
+		config.ChannelQueueConcurrency = 0
+		config.ChannelQueueDepth = 0
+		config.ChannelQueueRetryAfter = 1
+	}()
+	channelId := 9101
+
+	// 第一个请求处理中
+	c, _ := newTestQueueContext()
+	release, errWithCode := acquireChannelSlot(c, channelId)
+	assert.Nil(t, errWithCode)
+
+	// 第二个请求排队
+	acquired := make(chan func())
+	go func() {
+		c, _ := newTestQueueContext()
+		release, errWithCode := acquireChannelSlot(c, channelId)
+		assert.Nil(t, errWithCode)
+		acquired <- release
+	}()
+	assert.Eventually(t, func() bool {
+		queue := getChannelQueue(channelId)
+		queue.mu.Lock()
+		defer queue.mu.Unlock()
+		return queue.waiting == 1
+	}, time.Second, time.Millisecond)
+
+	// 超出容量的请求返回 503
+	c, recorder := newTestQueueContext()
+	_, errWithCode = acquireChannelSlot(c, channelId)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusServiceUnavailable, errWithCode.StatusCode)
+		assert.Equal(t, "channel_queue_full", errWithCode.Code)
+		assert.True(t, errWithCode.LocalError)
+	}
+	assert.Equal(t, "3", recorder.Header().Get("Retry-After"))
+
+	// 第一个请求完成后排队的请求开始处理
+	release()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("queued request was not started")
+	}
+
+	// 其他渠道不受影响
+	c, _ = newTestQueueContext()
+	release, errWithCode = acquireChannelSlot(c, channelId+1)
+	assert.Nil(t, errWithCode)
+	release()
+}
+
+func TestAcquireChannelSlotCanceled(t *testing.T) {
+	config.ChannelQueueConcurrency = 1
+	config.ChannelQueueDepth = 1
+	defer func() {
+		config.ChannelQueueConcurrency = 0
+		config.ChannelQueueDepth = 0
+	}()
+	channelId := 9201
+
+	c, _ := newTestQueueContext()
+	release, errWithCode := acquireChannelSlot(c, channelId)
+	assert.Nil(t, errWithCode)
+	defer release()
+
+	// 排队时客户端断开
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, _ = newTestQueueContext()
+	c.Request = c.Request.WithContext(ctx)
+	_, errWithCode = acquireChannelSlot(c, channelId)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusRequestTimeout, errWithCode.StatusCode)
+	}
+	assert.Equal(t, 0, getChannelQueue(channelId).waiting)
+}
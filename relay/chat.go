@@ -1,15 +1,13 @@
 package relay
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"math"
 	"net/http"
 	"one-api/common"
 	"one-api/common/requester"
-	"one-api/common/utils"
 	providersBase "one-api/providers/base"
+	"one-api/relay/relay_util"
 	"one-api/types"
 	"time"
 
@@ -45,6 +43,16 @@ func (r *relayChat) setRequest() error {
 	}
 
 	r.setOriginalModel(r.chatRequest.Model)
+	r.setEndUser(r.chatRequest.User)
+
+	return nil
+}
+
+func (r *relayChat) setProvider(modelName string) error {
+	if err := r.relayBase.setProvider(modelName); err != nil {
+		return err
+	}
+	bindRequestContext(r.c, r.provider)
 
 	return nil
 }
@@ -70,6 +78,11 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 		return
 	}
 
+	if err = relay_util.GetModelCapabilities().CheckChatRequest(r.originalModel, &r.chatRequest); err != nil {
+		done = true
+		return
+	}
+
 	r.chatRequest.Model = r.modelName
 
 	if r.chatRequest.Stream {
@@ -84,7 +97,7 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 		}
 
 		var firstResponseTime time.Time
-		firstResponseTime, err = responseStreamClient(r.c, response, doneStr)
+		firstResponseTime, err = responseStreamClient(r.c, withSystemFingerprint(response, r.getSystemFingerprint()), doneStr)
 		r.SetFirstResponseTime(firstResponseTime)
 	} else {
 		var response *types.ChatCompletionResponse
@@ -92,7 +105,10 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 		if err != nil {
 			return
 		}
-		err = responseJsonClient(r.c, response)
+		if response.SystemFingerprint == "" {
+			response.SystemFingerprint = r.getSystemFingerprint()
+		}
+		err = responseProviderJsonClient(r.c, r.provider, response)
 
 	}
 
@@ -104,23 +120,5 @@ func (r *relayChat) send() (err *types.OpenAIErrorWithStatusCode, done bool) {
 }
 
 func (r *relayChat) getUsageResponse() string {
-	if r.chatRequest.StreamOptions != nil && r.chatRequest.StreamOptions.IncludeUsage {
-		usageResponse := types.ChatCompletionStreamResponse{
-			ID:      fmt.Sprintf("chatcmpl-%s", utils.GetUUID()),
-			Object:  "chat.completion.chunk",
-			Created: utils.GetTimestamp(),
-			Model:   r.chatRequest.Model,
-			Choices: []types.ChatCompletionStreamChoice{},
-			Usage:   r.provider.GetUsage(),
-		}
-
-		responseBody, err := json.Marshal(usageResponse)
-		if err != nil {
-			return ""
-		}
-
-		return string(responseBody)
-	}
-
-	return ""
+	return getStreamUsageResponse(r.chatRequest.StreamOptions, "chat.completion.chunk", r.chatRequest.Model, r.provider.GetUsage())
 }
@@ -0,0 +1,136 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/config"
+	"one-api/common/requester"
+	"one-api/model"
+	providersBase "one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayChatEndUser(t *testing.T) {
+	for _, testCase := range []struct {
+		body     string
+		expected string
+	}{
+		{`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"user":" end-user-1 "}`, "end-user-1"},
+		{`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, ""},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(testCase.body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		relay := NewRelayChat(c)
+		assert.Nil(t, relay.setRequest())
+
+		// 审计记录、用量事件和消费日志都从 end_user 读取
+		endUser, exists := c.Get("end_user")
+		assert.Equal(t, testCase.expected != "", exists)
+		if exists {
+			assert.Equal(t, testCase.expected, endUser)
+		}
+	}
+}
+
+type testChatProvider struct {
+	testProvider
+	response *types.ChatCompletionResponse
+}
+
+func (p *testChatProvider) CreateChatCompletion(request *types.ChatCompletionRequest) (*types.ChatCompletionResponse, *types.OpenAIErrorWithStatusCode) {
+	response := *p.response
+	return &response, nil
+}
+
+func (p *testChatProvider) CreateChatCompletionStream(request *types.ChatCompletionRequest) (requester.StreamReaderInterface[string], *types.OpenAIErrorWithStatusCode) {
+	chunk := *p.response
+	chunk.Object = "chat.completion.chunk"
+	data, _ := json.Marshal(chunk)
+	return &testStreamReader{items: []string{string(data)}}, nil
+}
+
+func TestRelayChatSystemFingerprint(t *testing.T) {
+	seed := 42
+	send := func(channelType int, stream bool, seed *int, fingerprint string) string {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+		relay := NewRelayChat(c)
+		relay.modelName = "gpt-4o"
+		relay.chatRequest = types.ChatCompletionRequest{Model: "gpt-4o", Seed: seed, Stream: stream}
+		relay.provider = &testChatProvider{
+			testProvider: testProvider{BaseProvider: providersBase.BaseProvider{Channel: &model.Channel{Id: 1, Type: channelType}}},
+			response:     &types.ChatCompletionResponse{ID: "chatcmpl-1", Model: "gpt-4o-2024-08-06", SystemFingerprint: fingerprint},
+		}
+		errWithCode, _ := relay.send()
+		assert.Nil(t, errWithCode)
+
+		body := recorder.Body.String()
+		if stream {
+			body = strings.TrimPrefix(strings.SplitN(body, "\n", 2)[0], "data: ")
+		}
+		response := &types.ChatCompletionResponse{}
+		assert.Nil(t, json.Unmarshal([]byte(body), response))
+		return response.SystemFingerprint
+	}
+
+	// 上游没有返回时按供应商、模型和 seed 生成，流式和非流式结果一致
+	for _, channelType := range []int{config.ChannelTypeOpenAI, config.ChannelTypeAnthropic} {
+		fingerprint := send(channelType, false, &seed, "")
+		assert.NotEmpty(t, fingerprint)
+		assert.Equal(t, fingerprint, send(channelType, false, &seed, ""))
+		assert.Equal(t, fingerprint, send(channelType, true, &seed, ""))
+	}
+	assert.Equal(t, "openai_v_gpt-4o_seed_42", send(config.ChannelTypeOpenAI, true, &seed, ""))
+	assert.Equal(t, "claude_v_gpt-4o_seed_42", send(config.ChannelTypeAnthropic, true, &seed, ""))
+
+	// 上游返回的保持不变，没有 seed 时不生成
+	assert.Equal(t, "fp_upstream", send(config.ChannelTypeOpenAI, false, &seed, "fp_upstream"))
+	assert.Equal(t, "fp_upstream", send(config.ChannelTypeOpenAI, true, &seed, "fp_upstream"))
+	assert.Empty(t, send(config.ChannelTypeOpenAI, false, nil, ""))
+	assert.Empty(t, send(config.ChannelTypeOpenAI, true, nil, ""))
+}
+
+type testExtraFieldsChatProvider struct {
+	testChatProvider
+	fields map[string]any
+}
+
+func (p *testExtraFieldsChatProvider) GetResponseExtraFields() map[string]any {
+	return p.fields
+}
+
+func TestRelayChatResponseExtraFields(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	relay := NewRelayChat(c)
+	relay.modelName = "gpt-4o"
+	relay.chatRequest = types.ChatCompletionRequest{Model: "gpt-4o"}
+	relay.provider = &testExtraFieldsChatProvider{
+		testChatProvider: testChatProvider{
+			testProvider: testProvider{BaseProvider: providersBase.BaseProvider{Channel: &model.Channel{Id: 1, Type: config.ChannelTypeReplicate}}},
+			response:     &types.ChatCompletionResponse{ID: "chatcmpl-1", Model: "gpt-4o"},
+		},
+		fields: map[string]any{"x_replicate_debug": map[string]any{"id": "p1"}, "id": "ignored"},
+	}
+	errWithCode, _ := relay.send()
+	assert.Nil(t, errWithCode)
+
+	// 额外字段写入响应体顶层，不覆盖已有字段
+	body := map[string]any{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, map[string]any{"id": "p1"}, body["x_replicate_debug"])
+	assert.Equal(t, "chatcmpl-1", body["id"])
+	assert.Equal(t, "gpt-4o", body["model"])
+}
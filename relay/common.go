@@ -1,6 +1,7 @@
 package relay
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -17,8 +18,11 @@ import (
 	"one-api/model"
 	"one-api/providers"
 	providersBase "one-api/providers/base"
+	"one-api/relay/relay_util"
 	"one-api/types"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -97,9 +101,37 @@ func fetchChannel(c *gin.Context, modelName string) (channel *model.Channel, fai
 		return fetchChannelById(channelId)
 	}
 
+	if channel := fetchChannelByRoute(c, modelName); channel != nil {
+		return channel, nil
+	}
+
 	return fetchChannelByModel(c, modelName)
 }
 
+// 按配置的模型路由选择渠道，路由渠道同样需要在令牌分组内提供该模型并通过渠道筛选，均不可用时返回 nil
+func fetchChannelByRoute(c *gin.Context, modelName string) *model.Channel {
+	skipChannelIds, _ := utils.GetGinValue[[]int](c, "skip_channel_ids")
+	channelIds := relay_util.GetChannelRouter().Match(modelName, skipChannelIds)
+	if len(channelIds) == 0 {
+		return nil
+	}
+
+	group := c.GetString("token_group")
+	filters := getChannelFilters(c)
+	// 按路由顺序逐个尝试，分组内没有该渠道或渠道冷却、熔断时使用下一个
+	for _, channelId := range channelIds {
+		channel, err := model.ChannelGroup.Next(group, modelName, append(filters, model.FilterAllowedChannelIds([]int{channelId}))...)
+		if err != nil {
+			logger.LogWarn(c.Request.Context(), fmt.Sprintf("route channel %d for model %s unavailable in group %s", channelId, modelName, group))
+			continue
+		}
+
+		return channel
+	}
+
+	return nil
+}
+
 func fetchChannelById(channelId int) (*model.Channel, error) {
 	channel, err := model.GetChannelById(channelId)
 	if err != nil {
@@ -114,9 +146,25 @@ func fetchChannelById(channelId int) (*model.Channel, error) {
 
 func fetchChannelByModel(c *gin.Context, modelName string) (*model.Channel, error) {
 	group := c.GetString("token_group")
-	skipOnlyChat := c.GetBool("skip_only_chat")
+	filters := getChannelFilters(c)
+
+	channel, err := model.ChannelGroup.Next(group, modelName, filters...)
+	if err != nil {
+		message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", group, modelName)
+		if channel != nil {
+			logger.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
+			message = "数据库一致性已被破坏，请联系管理员"
+		}
+		return nil, errors.New(message)
+	}
+
+	return channel, nil
+}
+
+// 请求对应的渠道筛选条件
+func getChannelFilters(c *gin.Context) []model.ChannelsFilterFunc {
 	var filters []model.ChannelsFilterFunc
-	if skipOnlyChat {
+	if c.GetBool("skip_only_chat") {
 		filters = append(filters, model.FilterOnlyChat())
 	}
 
@@ -131,29 +179,71 @@ func fetchChannelByModel(c *gin.Context, modelName string) (*model.Channel, erro
 		}
 	}
 
-	channel, err := model.ChannelGroup.Next(group, modelName, filters...)
+	return filters
+}
+
+func responseJsonClient(c *gin.Context, data interface{}) *types.OpenAIErrorWithStatusCode {
+	// 将data转换为 JSON
+	responseBody, err := json.Marshal(data)
 	if err != nil {
-		message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", group, modelName)
-		if channel != nil {
-			logger.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
-			message = "数据库一致性已被破坏，请联系管理员"
-		}
-		return nil, errors.New(message)
+		return common.ErrorWrapperLocal(err, "marshal_response_body_failed", http.StatusInternalServerError)
 	}
 
-	return channel, nil
+	return writeJsonBody(c, responseBody)
 }
 
-func responseJsonClient(c *gin.Context, data interface{}) *types.OpenAIErrorWithStatusCode {
-	// 将data转换为 JSON
+// 与 responseJsonClient 相同，供应商返回额外字段时追加到响应体顶层，已有的字段不覆盖
+func responseProviderJsonClient(c *gin.Context, provider providersBase.ProviderInterface, data interface{}) *types.OpenAIErrorWithStatusCode {
+	extra, ok := provider.(providersBase.ResponseExtraFieldsInterface)
+	if !ok {
+		return responseJsonClient(c, data)
+	}
+	fields := extra.GetResponseExtraFields()
+	if len(fields) == 0 {
+		return responseJsonClient(c, data)
+	}
+
 	responseBody, err := json.Marshal(data)
 	if err != nil {
 		return common.ErrorWrapperLocal(err, "marshal_response_body_failed", http.StatusInternalServerError)
 	}
 
+	existing := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(responseBody, &existing); err != nil || existing == nil {
+		return writeJsonBody(c, responseBody)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if _, exists := existing[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	buffer := bytes.NewBuffer(responseBody[:len(responseBody)-1])
+	for i, key := range keys {
+		value, err := json.Marshal(fields[key])
+		if err != nil {
+			return common.ErrorWrapperLocal(err, "marshal_response_body_failed", http.StatusInternalServerError)
+		}
+		if i > 0 || len(existing) > 0 {
+			buffer.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	buffer.WriteByte('}')
+
+	return writeJsonBody(c, buffer.Bytes())
+}
+
+func writeJsonBody(c *gin.Context, responseBody []byte) *types.OpenAIErrorWithStatusCode {
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(http.StatusOK)
-	_, err = c.Writer.Write(responseBody)
+	_, err := c.Writer.Write(responseBody)
 	if err != nil {
 		return common.ErrorWrapperLocal(err, "write_response_body_failed", http.StatusInternalServerError)
 	}
@@ -163,6 +253,33 @@ func responseJsonClient(c *gin.Context, data interface{}) *types.OpenAIErrorWith
 
 type StreamEndHandler func() string
 
+// 当 stream_options.include_usage 为 true 时，在 [DONE] 之前追加一个 choices 为空的用量块
+func getStreamUsageResponse(streamOptions *types.StreamOptions, object, modelName string, usage *types.Usage) string {
+	if streamOptions == nil || !streamOptions.IncludeUsage || usage == nil {
+		return ""
+	}
+
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	usageResponse := types.ChatCompletionStreamResponse{
+		ID:      fmt.Sprintf("chatcmpl-%s", utils.GetUUID()),
+		Object:  object,
+		Created: utils.GetTimestamp(),
+		Model:   modelName,
+		Choices: []types.ChatCompletionStreamChoice{},
+		Usage:   usage,
+	}
+
+	responseBody, err := json.Marshal(usageResponse)
+	if err != nil {
+		return ""
+	}
+
+	return string(responseBody)
+}
+
 func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface[string], endHandler StreamEndHandler) (firstResponseTime time.Time, errWithOP *types.OpenAIErrorWithStatusCode) {
 	requester.SetEventStreamHeaders(c)
 	dataChan, errChan := stream.Recv()
@@ -174,6 +291,7 @@ func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface
 	defer stream.Close()
 
 	var isFirstResponse bool
+	writer := newStreamWriter(c)
 
 	// 在新的goroutine中处理stream数据
 	go func() {
@@ -185,63 +303,56 @@ func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface
 				if !ok {
 					return
 				}
-				streamData := "data: " + data + "\n\n"
+
+				// 心跳及调试等 SSE 注释原样发送，不计入首字时间
+				if strings.HasPrefix(data, ":") {
+					if writer.write(data+"\n\n") != nil {
+						return
+					}
+					continue
+				}
 
 				if !isFirstResponse {
 					firstResponseTime = time.Now()
 					isFirstResponse = true
 				}
 
-				// 尝试写入数据，如果客户端断开也继续处理
-				select {
-				case <-c.Request.Context().Done():
-					// 客户端已断开，不执行任何操作，直接跳过
-				default:
-					// 客户端正常，发送数据
-					c.Writer.Write([]byte(streamData))
-					c.Writer.Flush()
+				// 客户端断开后停止读取，关闭 stream 以取消上游
+				if err := writer.write("data: " + data + "\n\n"); err != nil {
+					logger.LogWarn(c.Request.Context(), fmt.Sprintf("stream stopped after %d bytes: %s", writer.written(), err.Error()))
+					return
 				}
 
+			case <-c.Request.Context().Done():
+				// 上游没有输出时也能及时发现客户端断开，关闭 stream 以取消上游
+				logger.LogWarn(c.Request.Context(), fmt.Sprintf("client disconnected after %d bytes", writer.written()))
+				return
+
 			case err := <-errChan:
 				if !errors.Is(err, io.EOF) {
-					// 处理错误情况
-					errMsg := "data: " + err.Error() + "\n\n"
-					select {
-					case <-c.Request.Context().Done():
-						// 客户端已断开，不执行任何操作，直接跳过
-					default:
-						// 客户端正常，发送错误信息
-						c.Writer.Write([]byte(errMsg))
-						c.Writer.Flush()
+					logger.LogError(c.Request.Context(), "Stream err:"+err.Error())
+					errWithCode := getStreamError(err)
+
+					// 尚未发送任何内容时仍可返回带状态码的错误
+					if writer.written() == 0 && !c.Writer.Written() {
+						requester.ResetEventStreamHeaders(c)
+						finalErr = errWithCode
+						return
 					}
 
-					finalErr = common.StringErrorWrapper(err.Error(), "stream_error", 900)
-					logger.LogError(c.Request.Context(), "Stream err:"+err.Error())
+					// 已开始输出，状态码无法修改，以 error 分块结束流
+					writeStreamError(c, writer, errWithCode)
 				} else {
 					// 正常结束，处理endHandler
 					if finalErr == nil && endHandler != nil {
 						streamData := endHandler()
 						if streamData != "" {
-							select {
-							case <-c.Request.Context().Done():
-								// 客户端已断开，不执行任何操作，直接跳过
-							default:
-								// 客户端正常，发送数据
-								c.Writer.Write([]byte("data: " + streamData + "\n\n"))
-								c.Writer.Flush()
-							}
+							writer.write("data: " + streamData + "\n\n")
 						}
 					}
 
 					// 发送结束标记
-					streamData := "data: [DONE]\n\n"
-					select {
-					case <-c.Request.Context().Done():
-						// 客户端已断开，不执行任何操作，直接跳过
-					default:
-						c.Writer.Write([]byte(streamData))
-						c.Writer.Flush()
-					}
+					writer.write("data: [DONE]\n\n")
 				}
 				return
 			}
@@ -250,7 +361,36 @@ func responseStreamClient(c *gin.Context, stream requester.StreamReaderInterface
 
 	// 等待处理完成
 	<-done
-	return firstResponseTime, nil
+	return firstResponseTime, finalErr
+}
+
+// 转换流式过程中上游返回的错误，保留错误中的状态码
+func getStreamError(err error) *types.OpenAIErrorWithStatusCode {
+	var errWithCode *types.OpenAIErrorWithStatusCode
+	if errors.As(err, &errWithCode) {
+		return errWithCode
+	}
+
+	var openaiErr *types.OpenAIError
+	if errors.As(err, &openaiErr) {
+		return &types.OpenAIErrorWithStatusCode{OpenAIError: *openaiErr, StatusCode: http.StatusInternalServerError}
+	}
+
+	return common.StringErrorWrapper(err.Error(), "stream_error", http.StatusInternalServerError)
+}
+
+// 发送包含 error 对象的最后一个分块及结束标记
+func writeStreamError(c *gin.Context, writer *streamWriter, errWithCode *types.OpenAIErrorWithStatusCode) {
+	newErr := FilterOpenAIErr(c, errWithCode)
+	data, err := json.Marshal(types.OpenAIErrorResponse{Error: newErr.OpenAIError})
+	if err != nil {
+		return
+	}
+
+	if writer.write("data: "+string(data)+"\n\n") != nil {
+		return
+	}
+	writer.write("data: [DONE]\n\n")
 }
 
 func responseGeneralStreamClient(c *gin.Context, stream requester.StreamReaderInterface[string], endHandler StreamEndHandler) (firstResponseTime time.Time) {
@@ -263,6 +403,7 @@ func responseGeneralStreamClient(c *gin.Context, stream requester.StreamReaderIn
 
 	defer stream.Close()
 	var isFirstResponse bool
+	writer := newStreamWriter(c)
 
 	// 在新的goroutine中处理stream数据
 	go func() {
@@ -278,27 +419,20 @@ func responseGeneralStreamClient(c *gin.Context, stream requester.StreamReaderIn
 					firstResponseTime = time.Now()
 					isFirstResponse = true
 				}
-				// 尝试写入数据，如果客户端断开也继续处理
-				select {
-				case <-c.Request.Context().Done():
-					// 客户端已断开，不执行任何操作，直接跳过
-				default:
-					// 客户端正常，发送数据
-					fmt.Fprint(c.Writer, data)
-					c.Writer.Flush()
+				// 客户端断开后停止读取，关闭 stream 以取消上游
+				if err := writer.write(data); err != nil {
+					logger.LogWarn(c.Request.Context(), fmt.Sprintf("stream stopped after %d bytes: %s", writer.written(), err.Error()))
+					return
 				}
 
+			case <-c.Request.Context().Done():
+				logger.LogWarn(c.Request.Context(), fmt.Sprintf("client disconnected after %d bytes", writer.written()))
+				return
+
 			case err := <-errChan:
 				if !errors.Is(err, io.EOF) {
 					// 处理错误情况
-					select {
-					case <-c.Request.Context().Done():
-						// 客户端已断开，不执行任何操作，直接跳过
-					default:
-						// 客户端正常，发送错误信息
-						fmt.Fprint(c.Writer, err.Error())
-						c.Writer.Flush()
-					}
+					writer.write(err.Error())
 
 					logger.LogError(c.Request.Context(), "Stream err:"+err.Error())
 				} else {
@@ -306,14 +440,7 @@ func responseGeneralStreamClient(c *gin.Context, stream requester.StreamReaderIn
 					if endHandler != nil {
 						streamData := endHandler()
 						if streamData != "" {
-							select {
-							case <-c.Request.Context().Done():
-								// 客户端已断开，只记录数据
-							default:
-								// 客户端正常，发送数据
-								fmt.Fprint(c.Writer, streamData)
-								c.Writer.Flush()
-							}
+							writer.write(streamData)
 						}
 					}
 				}
@@ -387,6 +514,10 @@ func shouldRetry(c *gin.Context, apiErr *types.OpenAIErrorWithStatusCode, channe
 		return false
 	}
 
+	if len(config.FailoverRetryOn) > 0 {
+		return isFailoverCondition(apiErr, config.FailoverRetryOn)
+	}
+
 	switch apiErr.StatusCode {
 	case http.StatusTooManyRequests, http.StatusTemporaryRedirect:
 		return true
@@ -406,6 +537,33 @@ func shouldRetry(c *gin.Context, apiErr *types.OpenAIErrorWithStatusCode, channe
 	return true
 }
 
+// 判断错误是否满足配置的重试条件：timeout、5xx、429 或具体状态码
+func isFailoverCondition(apiErr *types.OpenAIErrorWithStatusCode, conditions []string) bool {
+	for _, condition := range conditions {
+		switch condition = strings.ToLower(strings.TrimSpace(condition)); condition {
+		case "timeout":
+			switch apiErr.StatusCode {
+			case http.StatusRequestTimeout, http.StatusGatewayTimeout, 524:
+				return true
+			}
+			// 请求上游超时
+			if apiErr.Code == "http_request_failed" && strings.Contains(strings.ToLower(apiErr.Message), "timeout") {
+				return true
+			}
+		case "5xx":
+			if apiErr.StatusCode/100 == 5 {
+				return true
+			}
+		default:
+			if condition == strconv.Itoa(apiErr.StatusCode) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func shouldRetryBadRequest(channelType int, apiErr *types.OpenAIErrorWithStatusCode) bool {
 	switch channelType {
 	case config.ChannelTypeAnthropic:
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/common/requester"
+	"one-api/model"
+	"one-api/relay/relay_util"
+	"one-api/types"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestGetStreamUsageResponse(t *testing.T) {
+	usage := &types.Usage{PromptTokens: 10, CompletionTokens: 5}
+
+	assert.Empty(t, getStreamUsageResponse(nil, "chat.completion.chunk", "gpt-4o", usage))
+	assert.Empty(t, getStreamUsageResponse(&types.StreamOptions{IncludeUsage: false}, "chat.completion.chunk", "gpt-4o", usage))
+
+	for _, object := range []string{"chat.completion.chunk", "text_completion"} {
+		data := getStreamUsageResponse(&types.StreamOptions{IncludeUsage: true}, object, "gpt-4o", usage)
+		assert.NotEmpty(t, data)
+
+		response := map[string]any{}
+		assert.Nil(t, json.Unmarshal([]byte(data), &response))
+		assert.Equal(t, object, response["object"])
+		assert.Equal(t, []any{}, response["choices"])
+		assert.Equal(t, float64(15), response["usage"].(map[string]any)["total_tokens"])
+	}
+}
+
+type testStreamReader struct {
+	items []string
+	// 发送完 items 后返回的错误，为空时正常结束
+	err error
+}
+
+func (r *testStreamReader) Recv() (<-chan string, <-chan error) {
+	dataChan := make(chan string)
+	errChan := make(chan error)
+	go func() {
+		for _, item := range r.items {
+			dataChan <- item
+		}
+		if r.err != nil {
+			errChan <- r.err
+			return
+		}
+		errChan <- io.EOF
+	}()
+
+	return dataChan, errChan
+}
+
+func (r *testStreamReader) Close() {}
+
+func TestResponseStreamClientKeepAlive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	stream := &testStreamReader{items: []string{requester.KeepAliveComment, `{"id":"1"}`, `: debug {"id":"1"}`}}
+	_, errWithCode := responseStreamClient(c, stream, nil)
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, ": keep-alive\n\ndata: {\"id\":\"1\"}\n\n: debug {\"id\":\"1\"}\n\ndata: [DONE]\n\n", recorder.Body.String())
+}
+
+func TestResponseStreamClientErrorBeforeFirstByte(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	stream := &testStreamReader{err: common.StringErrorWrapper("model failed to boot", "upstream_error", http.StatusServiceUnavailable)}
+	_, errWithCode := responseStreamClient(c, stream, nil)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusServiceUnavailable, errWithCode.StatusCode)
+		assert.Equal(t, "model failed to boot", errWithCode.Message)
+	}
+	assert.Empty(t, recorder.Body.String())
+	assert.Empty(t, c.Writer.Header().Get("Content-Type"))
+
+	// 未携带状态码的错误按 500 返回
+	recorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	_, errWithCode = responseStreamClient(c, &testStreamReader{err: errors.New("prediction failed")}, nil)
+	if assert.NotNil(t, errWithCode) {
+		assert.Equal(t, http.StatusInternalServerError, errWithCode.StatusCode)
+		assert.Equal(t, "stream_error", errWithCode.Code)
+	}
+}
+
+func TestResponseStreamClientErrorAfterFirstChunk(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	stream := &testStreamReader{items: []string{`{"id":"1"}`}, err: errors.New("prediction failed")}
+	_, errWithCode := responseStreamClient(c, stream, func() string { return `{"usage":{}}` })
+	assert.Nil(t, errWithCode)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+
+	events := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n\n"), "\n\n")
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, `data: {"id":"1"}`, events[0])
+		assert.Equal(t, "data: [DONE]", events[2])
+
+		response := types.OpenAIErrorResponse{}
+		assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(events[1], "data: ")), &response))
+		assert.Contains(t, response.Error.Message, "prediction failed")
+		assert.Equal(t, "stream_error", response.Error.Code)
+	}
+}
+
+func TestFetchChannelByRoute(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	channels := model.ChannelGroup.Channels
+	rule := model.ChannelGroup.Rule
+	defer func() {
+		model.ChannelGroup.Channels = channels
+		model.ChannelGroup.Rule = rule
+		relay_util.SetChannelRouter(nil)
+	}()
+
+	// 渠道 2 只在 vip 分组中
+	model.ChannelGroup.Channels = map[int]*model.ChannelChoice{
+		1: {Channel: &model.Channel{Id: 1, Status: config.ChannelStatusEnabled}},
+		2: {Channel: &model.Channel{Id: 2, Status: config.ChannelStatusEnabled}},
+		3: {Channel: &model.Channel{Id: 3, Status: config.ChannelStatusEnabled}},
+	}
+	model.ChannelGroup.Rule = map[string]map[string][][]int{
+		"default": {"flux-schnell": {{3}, {1}}},
+		"vip":     {"flux-schnell": {{2}}},
+	}
+
+	router, err := relay_util.NewChannelRouter(relay_util.ChannelRouteConfig{
+		DefaultChannelId: 1,
+		Routes:           []relay_util.ChannelRoute{{Pattern: "flux-*", ChannelId: 2}},
+	})
+	assert.Nil(t, err)
+	relay_util.SetChannelRouter(router)
+
+	getContext := func(group string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/images/generations", nil)
+		c.Set("token_group", group)
+		return c
+	}
+
+	// 路由渠道不在令牌分组内时不使用，改用同样命中路由的默认渠道
+	channel, err := fetchChannel(getContext("default"), "flux-schnell")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, channel.Id)
+
+	channel, err = fetchChannel(getContext("vip"), "flux-schnell")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, channel.Id)
+
+	// 路由渠道均不可用时按原有方式选择
+	c := getContext("default")
+	c.Set("skip_channel_ids", []int{1})
+	channel, err = fetchChannel(c, "flux-schnell")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, channel.Id)
+}
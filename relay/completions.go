@@ -1,14 +1,11 @@
 package relay
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"math"
 	"net/http"
 	"one-api/common"
 	"one-api/common/requester"
-	"one-api/common/utils"
 	providersBase "one-api/providers/base"
 	"one-api/types"
 	"time"
@@ -41,6 +38,16 @@ func (r *relayCompletions) setRequest() error {
 	}
 
 	r.setOriginalModel(r.request.Model)
+	r.setEndUser(r.request.User)
+
+	return nil
+}
+
+func (r *relayCompletions) setProvider(modelName string) error {
+	if err := r.relayBase.setProvider(modelName); err != nil {
+		return err
+	}
+	bindRequestContext(r.c, r.provider)
 
 	return nil
 }
@@ -87,7 +94,7 @@ func (r *relayCompletions) send() (err *types.OpenAIErrorWithStatusCode, done bo
 		if err != nil {
 			return
 		}
-		err = responseJsonClient(r.c, response)
+		err = responseProviderJsonClient(r.c, r.provider, response)
 	}
 
 	if err != nil {
@@ -98,23 +105,5 @@ func (r *relayCompletions) send() (err *types.OpenAIErrorWithStatusCode, done bo
 }
 
 func (r *relayCompletions) getUsageResponse() string {
-	if r.request.StreamOptions != nil && r.request.StreamOptions.IncludeUsage {
-		usageResponse := types.CompletionResponse{
-			ID:      fmt.Sprintf("chatcmpl-%s", utils.GetUUID()),
-			Object:  "chat.completion.chunk",
-			Created: utils.GetTimestamp(),
-			Model:   r.request.Model,
-			Choices: []types.CompletionChoice{},
-			Usage:   r.provider.GetUsage(),
-		}
-
-		responseBody, err := json.Marshal(usageResponse)
-		if err != nil {
-			return ""
-		}
-
-		return string(responseBody)
-	}
-
-	return ""
+	return getStreamUsageResponse(r.request.StreamOptions, "text_completion", r.request.Model, r.provider.GetUsage())
 }
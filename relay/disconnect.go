@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"net/http"
+	"one-api/common"
+	providersBase "one-api/providers/base"
+	"one-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上游请求使用客户端请求的 context，客户端断开时进行中的请求随之中止
+// 只用于对话和补全，任务、MJ 等异步接口的上游请求不随客户端断开中止
+func bindRequestContext(c *gin.Context, provider providersBase.ProviderInterface) {
+	if c == nil || c.Request == nil {
+		return
+	}
+
+	if httpRequester := provider.GetRequester(); httpRequester != nil {
+		httpRequester.Context = c.Request.Context()
+	}
+}
+
+// 客户端已断开
+func isClientGone(c *gin.Context) bool {
+	return c.Request != nil && c.Request.Context().Err() != nil
+}
+
+// 客户端断开导致的失败不是渠道的问题，转换为本地错误，不换用其他渠道、不记录渠道失败
+func clientGoneError(c *gin.Context, apiErr *types.OpenAIErrorWithStatusCode) *types.OpenAIErrorWithStatusCode {
+	if apiErr == nil || apiErr.LocalError || !isClientGone(c) {
+		return apiErr
+	}
+
+	return common.StringErrorWrapperLocal("client closed request: "+apiErr.Message, "client_disconnected", http.StatusRequestTimeout)
+}
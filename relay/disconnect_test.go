@@ -0,0 +1,142 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common/config"
+	"one-api/common/logger"
+	"one-api/common/requester"
+	"one-api/model"
+	"one-api/providers"
+	providersBase "one-api/providers/base"
+	"one-api/types"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRelayStreamCancelOnDisconnect(t *testing.T) {
+	if requester.HTTPClient == nil {
+		requester.HTTPClient = &http.Client{}
+		defer func() { requester.HTTPClient = nil }()
+	}
+	config.DisableTokenEncoders = true
+	defer func() { config.DisableTokenEncoders = false }()
+
+	// 模拟 Replicate：流式输出一个分块后不再返回事件，直到连接关闭
+	var upstreamURL string
+	canceled := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/cancel"):
+			canceled <- r.URL.Path
+			fmt.Fprint(w, `{"id":"p1","status":"canceled"}`)
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":"p1","status":"starting","urls":{"stream":"%s/stream/p1"}}`, upstreamURL)
+		case r.URL.Path == "/stream/p1":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: output\ndata: Hello\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			fmt.Fprint(w, `{"id":"p1","status":"processing"}`)
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL = upstream.URL
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		proxy := ""
+		channel := &model.Channel{Id: 1, Type: config.ChannelTypeReplicate, Key: "r8_" + strings.Repeat("a", 37), BaseURL: &upstreamURL, Proxy: &proxy}
+		provider := providers.GetProvider(channel, c)
+		bindRequestContext(c, provider)
+		provider.SetUsage(&types.Usage{})
+
+		stream, errWithCode := provider.(providersBase.ChatInterface).CreateChatCompletionStream(&types.ChatCompletionRequest{
+			Model:    "meta/meta-llama-3-70b-instruct",
+			Messages: []types.ChatCompletionMessage{{Role: types.ChatMessageRoleUser, Content: "hi"}},
+			Stream:   true,
+		})
+		if !assert.Nil(t, errWithCode) {
+			return
+		}
+		responseStreamClient(c, stream, nil)
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(`{}`))
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	// 收到第一个分块后断开
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Contains(t, line, "Hello")
+	cancel()
+
+	select {
+	case path := <-canceled:
+		assert.Equal(t, "/v1/predictions/p1/cancel", path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("prediction was not canceled after client disconnected")
+	}
+}
+
+func TestSetProviderBindRequestContext(t *testing.T) {
+	logger.Logger = zap.NewNop()
+	channels := model.ChannelGroup.Channels
+	rule := model.ChannelGroup.Rule
+	defer func() {
+		model.ChannelGroup.Channels = channels
+		model.ChannelGroup.Rule = rule
+	}()
+
+	proxy := ""
+	model.ChannelGroup.Channels = map[int]*model.ChannelChoice{
+		1: {Channel: &model.Channel{Id: 1, Type: config.ChannelTypeOpenAI, Status: config.ChannelStatusEnabled, Proxy: &proxy}},
+	}
+	model.ChannelGroup.Rule = map[string]map[string][][]int{
+		"default": {"gpt-4o": {{1}}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(ctx)
+		c.Set("token_group", "default")
+		return c
+	}
+
+	// 对话和补全绑定客户端请求的 context
+	for _, relay := range []RelayBaseInterface{NewRelayChat(getContext()), NewRelayCompletions(getContext())} {
+		assert.Nil(t, relay.setProvider("gpt-4o"))
+		assert.Equal(t, ctx, relay.getProvider().GetRequester().Context)
+	}
+
+	// 其他接口和任务、MJ 等直接调用 GetProvider 的不绑定
+	relay := NewRelayEmbeddings(getContext())
+	assert.Nil(t, relay.setProvider("gpt-4o"))
+	assert.NotEqual(t, ctx, relay.getProvider().GetRequester().Context)
+
+	provider, _, err := GetProvider(getContext(), "gpt-4o")
+	assert.Nil(t, err)
+	assert.NotEqual(t, ctx, provider.GetRequester().Context)
+}
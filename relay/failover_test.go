@@ -0,0 +1,142 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/common/config"
+	"one-api/common/utils"
+	"one-api/model"
+	providersBase "one-api/providers/base"
+	"one-api/types"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testProvider struct {
+	providersBase.BaseProvider
+}
+
+func (p *testProvider) GetRequestHeaders() map[string]string {
+	return nil
+}
+
+// 按顺序切换渠道的测试 relay
+type testFailoverRelay struct {
+	relayBase
+	channels []*model.Channel
+	next     int
+}
+
+func (r *testFailoverRelay) setProvider(modelName string) error {
+	channel := r.channels[r.next%len(r.channels)]
+	r.next++
+	r.provider = &testProvider{BaseProvider: providersBase.BaseProvider{Channel: channel}}
+	return nil
+}
+
+func (r *testFailoverRelay) send() (*types.OpenAIErrorWithStatusCode, bool) { return nil, false }
+func (r *testFailoverRelay) getPromptTokens() (int, error)                  { return 0, nil }
+func (r *testFailoverRelay) setRequest() error                              { return nil }
+
+// 模拟 RelayHandler 的计费流程：预扣、失败退还、成功结算
+type testBilling struct {
+	preConsumed int
+	undone      int
+	consumed    []int
+}
+
+func (b *testBilling) handler(failChannels map[int]*types.OpenAIErrorWithStatusCode) func(relay RelayBaseInterface) (*types.OpenAIErrorWithStatusCode, bool) {
+	return func(relay RelayBaseInterface) (*types.OpenAIErrorWithStatusCode, bool) {
+		b.preConsumed++
+		channelId := relay.getProvider().GetChannel().Id
+		if apiErr, ok := failChannels[channelId]; ok {
+			b.undone++
+			return apiErr, false
+		}
+
+		b.consumed = append(b.consumed, channelId)
+		return nil, false
+	}
+}
+
+func newTestFailoverRelay(channelIds ...int) *testFailoverRelay {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	relay := &testFailoverRelay{}
+	relay.c = c
+	for _, channelId := range channelIds {
+		relay.channels = append(relay.channels, &model.Channel{Id: channelId, Name: "test"})
+	}
+	relay.setProvider("")
+
+	return relay
+}
+
+func TestRelayWithFailover(t *testing.T) {
+	maxAttempts := config.FailoverMaxAttempts
+	config.FailoverMaxAttempts = 3
+	defer func() { config.FailoverMaxAttempts = maxAttempts }()
+
+	relay := newTestFailoverRelay(1, 2)
+	billing := &testBilling{}
+	apiErr := relayWithFailover(relay, billing.handler(map[int]*types.OpenAIErrorWithStatusCode{
+		1: common.StringErrorWrapper("upstream unavailable", "upstream_error", http.StatusServiceUnavailable),
+	}))
+
+	assert.Nil(t, apiErr)
+	assert.Equal(t, 2, billing.preConsumed)
+	assert.Equal(t, 1, billing.undone)
+	assert.Equal(t, []int{2}, billing.consumed)
+
+	skipChannelIds, _ := utils.GetGinValue[[]int](relay.c, "skip_channel_ids")
+	assert.Equal(t, []int{1}, skipChannelIds)
+}
+
+func TestRelayWithFailoverConditions(t *testing.T) {
+	maxAttempts := config.FailoverMaxAttempts
+	retryOn := config.FailoverRetryOn
+	config.FailoverMaxAttempts = 3
+	config.FailoverRetryOn = []string{"timeout", "429"}
+	defer func() {
+		config.FailoverMaxAttempts = maxAttempts
+		config.FailoverRetryOn = retryOn
+	}()
+
+	// 500 不在重试条件中
+	relay := newTestFailoverRelay(1, 2)
+	billing := &testBilling{}
+	apiErr := relayWithFailover(relay, billing.handler(map[int]*types.OpenAIErrorWithStatusCode{
+		1: common.StringErrorWrapper("upstream error", "upstream_error", http.StatusInternalServerError),
+	}))
+	assert.NotNil(t, apiErr)
+	assert.Equal(t, 1, billing.preConsumed)
+	assert.Empty(t, billing.consumed)
+
+	// 超时与 429 会重试，尝试次数不超过 max_attempts
+	relay = newTestFailoverRelay(1, 2, 3, 4)
+	billing = &testBilling{}
+	apiErr = relayWithFailover(relay, billing.handler(map[int]*types.OpenAIErrorWithStatusCode{
+		1: common.StringErrorWrapper("Client.Timeout exceeded while awaiting headers", "http_request_failed", http.StatusInternalServerError),
+		2: common.StringErrorWrapper("rate limited", "upstream_error", http.StatusTooManyRequests),
+		3: common.StringErrorWrapper("gateway timeout", "upstream_error", http.StatusGatewayTimeout),
+	}))
+	assert.NotNil(t, apiErr)
+	assert.Equal(t, http.StatusGatewayTimeout, apiErr.StatusCode)
+	assert.Equal(t, 3, billing.preConsumed)
+	assert.Equal(t, 3, billing.undone)
+	assert.Empty(t, billing.consumed)
+
+	// 本地错误不重试
+	relay = newTestFailoverRelay(1, 2)
+	billing = &testBilling{}
+	apiErr = relayWithFailover(relay, billing.handler(map[int]*types.OpenAIErrorWithStatusCode{
+		1: common.StringErrorWrapperLocal("request timeout", "local_error", http.StatusRequestTimeout),
+	}))
+	assert.NotNil(t, apiErr)
+	assert.Equal(t, 1, billing.preConsumed)
+}
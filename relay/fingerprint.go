@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"encoding/json"
+	"one-api/common/requester"
+	"one-api/providers"
+	providersBase "one-api/providers/base"
+	"strings"
+	"sync"
+)
+
+// 上游没有返回 system_fingerprint 时按供应商、实际请求的模型（模型映射后）和 seed 生成，
+// 流式和非流式使用相同的输入，同一请求得到相同的结果；没有 seed 时不生成
+func (r *relayChat) getSystemFingerprint() string {
+	if r.chatRequest.Seed == nil {
+		return ""
+	}
+
+	return providersBase.FormatSystemFingerprint(providers.GetProviderName(r.provider.GetChannel().Type), r.modelName, r.chatRequest.Seed)
+}
+
+type fingerprintStreamReader struct {
+	stream      requester.StreamReaderInterface[string]
+	fingerprint string
+	done        chan struct{}
+	once        sync.Once
+}
+
+// 为没有 system_fingerprint 的流式分块补充 fingerprint
+func withSystemFingerprint(stream requester.StreamReaderInterface[string], fingerprint string) requester.StreamReaderInterface[string] {
+	if fingerprint == "" {
+		return stream
+	}
+
+	return &fingerprintStreamReader{
+		stream:      stream,
+		fingerprint: fingerprint,
+		done:        make(chan struct{}),
+	}
+}
+
+func (r *fingerprintStreamReader) Recv() (<-chan string, <-chan error) {
+	dataChan, errChan := r.stream.Recv()
+	fingerprintDataChan := make(chan string)
+	fingerprintErrChan := make(chan error)
+
+	go func() {
+		for {
+			select {
+			case data := <-dataChan:
+				select {
+				case fingerprintDataChan <- r.setFingerprint(data):
+				case <-r.done:
+					return
+				}
+			case err := <-errChan:
+				select {
+				case fingerprintErrChan <- err:
+				case <-r.done:
+				}
+				return
+			case <-r.done:
+				return
+			}
+		}
+	}()
+
+	return fingerprintDataChan, fingerprintErrChan
+}
+
+// 只处理 JSON 分块，SSE 注释和上游已返回 fingerprint 的分块原样返回
+func (r *fingerprintStreamReader) setFingerprint(data string) string {
+	if !strings.HasPrefix(data, "{") {
+		return data
+	}
+
+	value, _ := json.Marshal(r.fingerprint)
+	if !strings.Contains(data, `"system_fingerprint"`) {
+		separator := ","
+		if strings.HasPrefix(strings.TrimSpace(data[1:]), "}") {
+			separator = ""
+		}
+		return `{"system_fingerprint":` + string(value) + separator + data[1:]
+	}
+
+	for _, empty := range []string{`"system_fingerprint":null`, `"system_fingerprint":""`} {
+		data = strings.Replace(data, empty, `"system_fingerprint":`+string(value), 1)
+	}
+
+	return data
+}
+
+func (r *fingerprintStreamReader) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.stream.Close()
+}
@@ -34,16 +34,27 @@ func Relay(c *gin.Context) {
 		return
 	}
 
-	apiErr, done := RelayHandler(relay)
+	if apiErr := relayWithFailover(relay, RelayHandler); apiErr != nil {
+		relay.HandleError(apiErr)
+	}
+}
+
+// 发送请求，遇到可重试的上游错误时换用其他渠道，失败的尝试会退还预扣额度，只有成功的请求计费
+func relayWithFailover(relay RelayBaseInterface, handler func(relay RelayBaseInterface) (*types.OpenAIErrorWithStatusCode, bool)) *types.OpenAIErrorWithStatusCode {
+	c := relay.getContext()
+
+	apiErr, done := handler(relay)
+	apiErr = clientGoneError(c, apiErr)
+	channel := relay.getProvider().GetChannel()
+	recordChannelResult(channel.Id, apiErr)
 	if apiErr == nil {
 		metrics.RecordProvider(c, 200)
-		return
+		return nil
 	}
 
-	channel := relay.getProvider().GetChannel()
 	go processChannelRelayError(c.Request.Context(), channel.Id, channel.Name, apiErr, channel.Type)
 
-	retryTimes := config.RetryTimes
+	retryTimes := getFailoverRetryTimes()
 	if done || !shouldRetry(c, apiErr, channel.Type) {
 		logger.LogError(c.Request.Context(), fmt.Sprintf("relay error happen, status code is %d, won't retry in this case", apiErr.StatusCode))
 		retryTimes = 0
@@ -58,10 +69,12 @@ func Relay(c *gin.Context) {
 
 		channel = relay.getProvider().GetChannel()
 		logger.LogError(c.Request.Context(), fmt.Sprintf("using channel #%d(%s) to retry (remain times %d)", channel.Id, channel.Name, i))
-		apiErr, done = RelayHandler(relay)
+		apiErr, done = handler(relay)
+		apiErr = clientGoneError(c, apiErr)
+		recordChannelResult(channel.Id, apiErr)
 		if apiErr == nil {
 			metrics.RecordProvider(c, 200)
-			return
+			return nil
 		}
 		go processChannelRelayError(c.Request.Context(), channel.Id, channel.Name, apiErr, channel.Type)
 		if done || !shouldRetry(c, apiErr, channel.Type) {
@@ -69,12 +82,26 @@ func Relay(c *gin.Context) {
 		}
 	}
 
-	if apiErr != nil {
-		relay.HandleError(apiErr)
+	return apiErr
+}
+
+func getFailoverRetryTimes() int {
+	if config.FailoverMaxAttempts > 0 {
+		return config.FailoverMaxAttempts - 1
 	}
+
+	return config.RetryTimes
 }
 
 func RelayHandler(relay RelayBaseInterface) (err *types.OpenAIErrorWithStatusCode, done bool) {
+	// 渠道排队已满时不换用其他渠道，直接返回 503
+	release, err := acquireChannelSlot(relay.getContext(), relay.getProvider().GetChannel().Id)
+	if err != nil {
+		done = true
+		return
+	}
+	defer release()
+
 	promptTokens, tonkeErr := relay.getPromptTokens()
 	if tonkeErr != nil {
 		err = common.ErrorWrapperLocal(tonkeErr, "token_error", http.StatusBadRequest)
@@ -108,6 +135,22 @@ func RelayHandler(relay RelayBaseInterface) (err *types.OpenAIErrorWithStatusCod
 	return
 }
 
+// 记录渠道请求结果用于熔断，本地错误及客户端错误不计入失败
+func recordChannelResult(channelId int, apiErr *types.OpenAIErrorWithStatusCode) {
+	if apiErr == nil {
+		model.ChannelGroup.RecordResult(channelId, true)
+		return
+	}
+
+	if apiErr.LocalError {
+		return
+	}
+
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode/100 == 5 {
+		model.ChannelGroup.RecordResult(channelId, false)
+	}
+}
+
 func shouldCooldowns(c *gin.Context, channel *model.Channel, apiErr *types.OpenAIErrorWithStatusCode) {
 	modelName := c.GetString("new_model")
 	channelId := channel.Id
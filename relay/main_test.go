@@ -0,0 +1,16 @@
+package relay
+
+import (
+	"one-api/common/logger"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	logger.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
@@ -6,6 +6,7 @@ import (
 	"one-api/common"
 	"one-api/common/utils"
 	"one-api/model"
+	"one-api/relay/relay_util"
 	"one-api/types"
 	"sort"
 
@@ -18,6 +19,8 @@ type OpenAIModels struct {
 	Object  string  `json:"object"`
 	Created int     `json:"created"`
 	OwnedBy *string `json:"owned_by"`
+	// 配置了 model_capabilities 时返回模型支持的特性
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 func ListModelsByToken(c *gin.Context) {
@@ -120,12 +123,14 @@ func getModelOwnedBy(channelType int) (ownedBy *string) {
 
 func getOpenAIModelWithName(modelName string) *OpenAIModels {
 	price := model.PricingInstance.GetPrice(modelName)
+	capabilities, _ := relay_util.GetModelCapabilities().Get(modelName)
 
 	return &OpenAIModels{
-		Id:      modelName,
-		Object:  "model",
-		Created: 1677649963,
-		OwnedBy: getModelOwnedBy(price.ChannelType),
+		Id:           modelName,
+		Object:       "model",
+		Created:      1677649963,
+		OwnedBy:      getModelOwnedBy(price.ChannelType),
+		Capabilities: capabilities,
 	}
 }
 
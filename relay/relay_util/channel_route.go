@@ -0,0 +1,154 @@
+package relay_util
+
+import (
+	"fmt"
+	"one-api/common/logger"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// 模型路由规则，pattern 含 * 或 ? 时按通配符匹配，否则按前缀匹配
+type ChannelRoute struct {
+	Pattern   string `mapstructure:"pattern"`
+	ChannelId int    `mapstructure:"channel_id"`
+}
+
+type ChannelRouteConfig struct {
+	DefaultChannelId int            `mapstructure:"default_channel_id"`
+	Routes           []ChannelRoute `mapstructure:"routes"`
+}
+
+type channelRoute struct {
+	ChannelRoute
+	matcher *regexp.Regexp
+	// 去掉通配符后的字符长度，越长越优先
+	weight int
+}
+
+type ChannelRouter struct {
+	defaultChannelId int
+	routes           []channelRoute
+}
+
+var (
+	channelRouter   *ChannelRouter
+	channelRouterMu sync.RWMutex
+)
+
+// 根据配置 channel_routes 初始化模型路由表
+func InitChannelRoutes() {
+	routeConfig := ChannelRouteConfig{}
+	if err := viper.UnmarshalKey("channel_routes", &routeConfig); err != nil {
+		logger.SysError("failed to load channel_routes: " + err.Error())
+		return
+	}
+
+	router, err := NewChannelRouter(routeConfig)
+	if err != nil {
+		logger.SysError("failed to load channel_routes: " + err.Error())
+		return
+	}
+
+	SetChannelRouter(router)
+}
+
+func NewChannelRouter(routeConfig ChannelRouteConfig) (*ChannelRouter, error) {
+	router := &ChannelRouter{defaultChannelId: routeConfig.DefaultChannelId}
+
+	for _, route := range routeConfig.Routes {
+		route.Pattern = strings.TrimSpace(route.Pattern)
+		if route.Pattern == "" || route.ChannelId <= 0 {
+			return nil, fmt.Errorf("invalid route: pattern %q, channel_id %d", route.Pattern, route.ChannelId)
+		}
+
+		compiled := channelRoute{
+			ChannelRoute: route,
+			weight:       len(strings.NewReplacer("*", "", "?", "").Replace(route.Pattern)),
+		}
+		if strings.ContainsAny(route.Pattern, "*?") {
+			expr := regexp.QuoteMeta(route.Pattern)
+			expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+			compiled.matcher = regexp.MustCompile("^" + expr + "$")
+		}
+
+		router.routes = append(router.routes, compiled)
+	}
+
+	return router, nil
+}
+
+func SetChannelRouter(router *ChannelRouter) {
+	channelRouterMu.Lock()
+	defer channelRouterMu.Unlock()
+
+	channelRouter = router
+}
+
+func GetChannelRouter() *ChannelRouter {
+	channelRouterMu.RLock()
+	defer channelRouterMu.RUnlock()
+
+	return channelRouter
+}
+
+// 获取模型对应的候选渠道，精确匹配优先，其次为最长的规则，相同长度按配置顺序
+// 命中规则时依次返回规则渠道与默认渠道，忽略 skipChannelIds 中的渠道；未命中任何规则时返回空，按原有方式选择渠道
+func (r *ChannelRouter) Match(modelName string, skipChannelIds []int) []int {
+	if r == nil {
+		return nil
+	}
+
+	var matched *channelRoute
+	for i := range r.routes {
+		route := &r.routes[i]
+		if !route.match(modelName) {
+			continue
+		}
+
+		if route.Pattern == modelName {
+			matched = route
+			break
+		}
+
+		if matched == nil || route.weight > matched.weight {
+			matched = route
+		}
+	}
+
+	if matched == nil {
+		return nil
+	}
+
+	var channelIds []int
+	for _, channelId := range []int{matched.ChannelId, r.defaultChannelId} {
+		if channelId > 0 && !slices.Contains(skipChannelIds, channelId) && !slices.Contains(channelIds, channelId) {
+			channelIds = append(channelIds, channelId)
+		}
+	}
+
+	return channelIds
+}
+
+// 模型名包含 owner 时（如 meta/llama-3），规则也可只匹配名称部分
+func (r *channelRoute) match(modelName string) bool {
+	names := []string{modelName}
+	if index := strings.LastIndex(modelName, "/"); index >= 0 {
+		names = append(names, modelName[index+1:])
+	}
+
+	for _, name := range names {
+		if r.matcher != nil {
+			if r.matcher.MatchString(name) {
+				return true
+			}
+		} else if strings.HasPrefix(name, r.Pattern) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package relay_util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelRouterMatch(t *testing.T) {
+	router, err := NewChannelRouter(ChannelRouteConfig{
+		DefaultChannelId: 9,
+		Routes: []ChannelRoute{
+			{Pattern: "flux-*", ChannelId: 1},
+			{Pattern: "flux-1.1-pro*", ChannelId: 2},
+			{Pattern: "meta/llama-", ChannelId: 3},
+			{Pattern: "meta/llama-3-70b-instruct", ChannelId: 4},
+			{Pattern: "llama-?-8b*", ChannelId: 5},
+		},
+	})
+	assert.Nil(t, err)
+
+	tests := map[string][]int{
+		"black-forest-labs/flux-schnell":    {1, 9},
+		"black-forest-labs/flux-1.1-pro":    {2, 9},
+		"meta/llama-2-70b-chat":             {3, 9},
+		"meta/llama-3-70b-instruct":         {4, 9},
+		"meta/meta-llama-3-8b":              nil,
+		"meta/llama-3-8b-instruct":          {3, 9},
+		"acme/llama-3-8b-base":              {5, 9},
+		"gpt-4o":                            nil,
+		"stability-ai/stable-diffusion-3.5": nil,
+	}
+
+	for modelName, expected := range tests {
+		assert.Equal(t, expected, router.Match(modelName, nil), modelName)
+	}
+}
+
+func TestChannelRouterFallback(t *testing.T) {
+	router, err := NewChannelRouter(ChannelRouteConfig{
+		DefaultChannelId: 9,
+		Routes:           []ChannelRoute{{Pattern: "flux-*", ChannelId: 1}},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []int{9}, router.Match("flux-dev", []int{1}))
+	assert.Empty(t, router.Match("flux-dev", []int{1, 9}))
+
+	router, err = NewChannelRouter(ChannelRouteConfig{Routes: []ChannelRoute{{Pattern: "flux-*", ChannelId: 1}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, router.Match("flux-dev", nil))
+	assert.Empty(t, router.Match("flux-dev", []int{1}))
+
+	var empty *ChannelRouter
+	assert.Empty(t, empty.Match("flux-dev", nil))
+
+	_, err = NewChannelRouter(ChannelRouteConfig{Routes: []ChannelRoute{{Pattern: " ", ChannelId: 1}}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,229 @@
+package relay_util
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/common/logger"
+	"one-api/types"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	CapabilityTools    = "tools"
+	CapabilityVision   = "vision"
+	CapabilityJSONMode = "json_mode"
+)
+
+// 特性对应的请求参数，用于错误提示
+var capabilityParams = map[string]string{
+	CapabilityTools:    "tools",
+	CapabilityVision:   "messages",
+	CapabilityJSONMode: "response_format",
+}
+
+// 模型支持的特性，model 以 * 结尾时按前缀匹配
+type ModelCapability struct {
+	Model    string   `mapstructure:"model"`
+	Supports []string `mapstructure:"supports"`
+}
+
+type ModelCapabilityConfig struct {
+	// 模型不支持时直接移除而不报错的特性
+	Strip  []string          `mapstructure:"strip"`
+	Models []ModelCapability `mapstructure:"models"`
+}
+
+type ModelCapabilities struct {
+	strip    map[string]bool
+	models   map[string][]string
+	prefixes []ModelCapability
+}
+
+var (
+	modelCapabilities   *ModelCapabilities
+	modelCapabilitiesMu sync.RWMutex
+)
+
+// 根据配置 model_capabilities 初始化模型特性表
+func InitModelCapabilities() {
+	capabilityConfig := ModelCapabilityConfig{}
+	if err := viper.UnmarshalKey("model_capabilities", &capabilityConfig); err != nil {
+		logger.SysError("failed to load model_capabilities: " + err.Error())
+		return
+	}
+
+	SetModelCapabilities(NewModelCapabilities(capabilityConfig))
+}
+
+func NewModelCapabilities(capabilityConfig ModelCapabilityConfig) *ModelCapabilities {
+	capabilities := &ModelCapabilities{
+		strip:  make(map[string]bool),
+		models: make(map[string][]string),
+	}
+
+	for _, feature := range capabilityConfig.Strip {
+		capabilities.strip[strings.TrimSpace(feature)] = true
+	}
+
+	for _, model := range capabilityConfig.Models {
+		model.Model = strings.TrimSpace(model.Model)
+		if model.Model == "" {
+			continue
+		}
+
+		if model.Supports == nil {
+			model.Supports = []string{}
+		}
+
+		if strings.HasSuffix(model.Model, "*") {
+			model.Model = strings.TrimSuffix(model.Model, "*")
+			capabilities.prefixes = append(capabilities.prefixes, model)
+			continue
+		}
+		capabilities.models[model.Model] = model.Supports
+	}
+
+	return capabilities
+}
+
+func SetModelCapabilities(capabilities *ModelCapabilities) {
+	modelCapabilitiesMu.Lock()
+	defer modelCapabilitiesMu.Unlock()
+
+	modelCapabilities = capabilities
+}
+
+func GetModelCapabilities() *ModelCapabilities {
+	modelCapabilitiesMu.RLock()
+	defer modelCapabilitiesMu.RUnlock()
+
+	return modelCapabilities
+}
+
+// 获取模型支持的特性，未配置的模型返回 false，不做检查
+func (m *ModelCapabilities) Get(modelName string) ([]string, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	if supports, ok := m.models[modelName]; ok {
+		return supports, true
+	}
+
+	// 匹配最长的前缀
+	var matched *ModelCapability
+	for i := range m.prefixes {
+		prefix := &m.prefixes[i]
+		if strings.HasPrefix(modelName, prefix.Model) && (matched == nil || len(prefix.Model) > len(matched.Model)) {
+			matched = prefix
+		}
+	}
+	if matched != nil {
+		return matched.Supports, true
+	}
+
+	return nil, false
+}
+
+// 发送前检查请求使用的特性，配置为 strip 的特性直接从请求中移除，其余不支持的特性返回 400
+func (m *ModelCapabilities) CheckChatRequest(modelName string, request *types.ChatCompletionRequest) *types.OpenAIErrorWithStatusCode {
+	supports, ok := m.Get(modelName)
+	if !ok {
+		return nil
+	}
+
+	var unsupported []string
+	for _, feature := range getChatRequestFeatures(request) {
+		if slices.Contains(supports, feature) {
+			continue
+		}
+
+		if m.strip[feature] {
+			stripChatRequestFeature(request, feature)
+			continue
+		}
+		unsupported = append(unsupported, feature)
+	}
+
+	if len(unsupported) == 0 {
+		return nil
+	}
+
+	errWithCode := common.StringErrorWrapperLocal(fmt.Sprintf("The model '%s' does not support the following features: %s", modelName, strings.Join(unsupported, ", ")), "unsupported_capability", http.StatusBadRequest)
+	errWithCode.Type = "invalid_request_error"
+	errWithCode.Param = capabilityParams[unsupported[0]]
+
+	return errWithCode
+}
+
+// 获取请求使用的特性
+func getChatRequestFeatures(request *types.ChatCompletionRequest) []string {
+	var features []string
+
+	if len(request.Tools) > 0 || len(request.Functions) > 0 {
+		features = append(features, CapabilityTools)
+	}
+
+	for _, message := range request.Messages {
+		if hasImageContent(message) {
+			features = append(features, CapabilityVision)
+			break
+		}
+	}
+
+	if request.ResponseFormat != nil && (request.ResponseFormat.Type == "json_object" || request.ResponseFormat.Type == "json_schema") {
+		features = append(features, CapabilityJSONMode)
+	}
+
+	return features
+}
+
+func hasImageContent(message types.ChatCompletionMessage) bool {
+	for _, content := range message.ParseContent() {
+		if content.Type == types.ContentTypeImageURL {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stripChatRequestFeature(request *types.ChatCompletionRequest, feature string) {
+	switch feature {
+	case CapabilityTools:
+		request.Tools = nil
+		request.ToolChoice = nil
+		request.Functions = nil
+		request.FunctionCall = nil
+	case CapabilityVision:
+		for i := range request.Messages {
+			contentList, ok := request.Messages[i].Content.([]any)
+			if !ok {
+				continue
+			}
+
+			textList := make([]any, 0, len(contentList))
+			for _, contentItem := range contentList {
+				contentMap, ok := contentItem.(map[string]any)
+				if !ok {
+					continue
+				}
+				if _, ok := contentMap["image_url"]; ok {
+					continue
+				}
+				if _, ok := contentMap["image"]; ok {
+					continue
+				}
+				textList = append(textList, contentItem)
+			}
+			request.Messages[i].Content = textList
+		}
+	case CapabilityJSONMode:
+		request.ResponseFormat = nil
+	}
+}
@@ -0,0 +1,71 @@
+package relay_util
+
+import (
+	"net/http"
+	"one-api/types"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getVisionRequest(model string) *types.ChatCompletionRequest {
+	return &types.ChatCompletionRequest{
+		Model: model,
+		Messages: []types.ChatCompletionMessage{
+			{Role: types.ChatMessageRoleUser, Content: []any{
+				map[string]any{"type": "text", "text": "what is this?"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/cat.png"}},
+			}},
+		},
+	}
+}
+
+func TestCheckChatRequestVisionUnsupported(t *testing.T) {
+	capabilities := NewModelCapabilities(ModelCapabilityConfig{
+		Models: []ModelCapability{
+			{Model: "meta/meta-llama-3-70b-instruct", Supports: []string{CapabilityJSONMode}},
+			{Model: "yorickvp/llava-*", Supports: []string{CapabilityVision}},
+		},
+	})
+
+	request := getVisionRequest("meta/meta-llama-3-70b-instruct")
+	request.Tools = []*types.ChatCompletionTool{{Type: "function"}}
+	errWithCode := capabilities.CheckChatRequest(request.Model, request)
+	assert.NotNil(t, errWithCode)
+	assert.Equal(t, http.StatusBadRequest, errWithCode.StatusCode)
+	assert.Equal(t, "unsupported_capability", errWithCode.Code)
+	assert.Equal(t, "invalid_request_error", errWithCode.Type)
+	assert.Equal(t, "tools", errWithCode.Param)
+	assert.Contains(t, errWithCode.Message, "tools, vision")
+	assert.True(t, errWithCode.LocalError)
+
+	request = getVisionRequest("yorickvp/llava-13b")
+	assert.Nil(t, capabilities.CheckChatRequest(request.Model, request))
+
+	// 未配置的模型不检查
+	request = getVisionRequest("openai/gpt-4o")
+	assert.Nil(t, capabilities.CheckChatRequest(request.Model, request))
+
+	var empty *ModelCapabilities
+	assert.Nil(t, empty.CheckChatRequest(request.Model, request))
+}
+
+func TestCheckChatRequestStrip(t *testing.T) {
+	capabilities := NewModelCapabilities(ModelCapabilityConfig{
+		Strip:  []string{CapabilityVision, CapabilityJSONMode},
+		Models: []ModelCapability{{Model: "meta/meta-llama-3-70b-instruct"}},
+	})
+
+	request := getVisionRequest("meta/meta-llama-3-70b-instruct")
+	request.ResponseFormat = &types.ChatCompletionResponseFormat{Type: "json_object"}
+	assert.Nil(t, capabilities.CheckChatRequest(request.Model, request))
+	assert.Nil(t, request.ResponseFormat)
+
+	parts := request.Messages[0].ParseContent()
+	assert.Len(t, parts, 1)
+	assert.Equal(t, "what is this?", parts[0].Text)
+
+	supports, ok := capabilities.Get("meta/meta-llama-3-70b-instruct")
+	assert.True(t, ok)
+	assert.Empty(t, supports)
+}
@@ -9,6 +9,7 @@ import (
 	"one-api/common"
 	"one-api/common/config"
 	"one-api/common/logger"
+	"one-api/common/shutdown"
 	"one-api/model"
 	"one-api/types"
 	"time"
@@ -29,6 +30,8 @@ type Quota struct {
 	userId           int
 	channelId        int
 	tokenId          int
+	requestId        string
+	endUser          string
 	HandelStatus     bool
 
 	startTime         time.Time
@@ -135,6 +138,7 @@ func (q *Quota) completedQuotaConsumption(usage *types.Usage, tokenName string,
 	}()
 
 	quota := q.GetTotalQuotaByUsage(usage)
+	q.reportUsage(ctx, usage, quota, isStream)
 
 	if quota > 0 {
 		quotaDelta := quota - q.preConsumedQuota
@@ -171,26 +175,50 @@ func (q *Quota) completedQuotaConsumption(usage *types.Usage, tokenName string,
 func (q *Quota) Undo(c *gin.Context) {
 	tokenId := c.GetInt("token_id")
 	if q.HandelStatus {
-		go func(ctx context.Context) {
+		ctx := c.Request.Context()
+		shutdown.Go(func() {
 			// return pre-consumed quota
 			err := model.PostConsumeTokenQuota(tokenId, -q.preConsumedQuota)
 			if err != nil {
 				logger.LogError(ctx, "error return pre-consumed quota: "+err.Error())
 			}
-		}(c.Request.Context())
+		})
 	}
 }
 
 func (q *Quota) Consume(c *gin.Context, usage *types.Usage, isStream bool) {
 	tokenName := c.GetString("token_name")
 	q.startTime = c.GetTime("requestStartTime")
-	// 如果没有报错，则消费配额
-	go func(ctx context.Context) {
+	q.requestId = c.GetString(logger.RequestIdKey)
+	q.endUser = c.GetString("end_user")
+	// 如果没有报错，则消费配额，服务关闭时等待结算完成
+	ctx := c.Request.Context()
+	shutdown.Go(func() {
 		err := q.completedQuotaConsumption(usage, tokenName, isStream, ctx)
 		if err != nil {
 			logger.LogError(ctx, err.Error())
 		}
-	}(c.Request.Context())
+	})
+}
+
+// 上报用量事件到外部计费系统
+func (q *Quota) reportUsage(ctx context.Context, usage *types.Usage, quota int, isStream bool) {
+	GetUsageReporter().ReportUsage(ctx, &UsageReportEvent{
+		RequestId:        q.requestId,
+		UserId:           q.userId,
+		TokenId:          q.tokenId,
+		EndUser:          q.endUser,
+		ChannelId:        q.channelId,
+		Model:            q.modelName,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.PromptTokens + usage.CompletionTokens,
+		Quota:            quota,
+		IsStream:         isStream,
+		Source:           getUsageSource(usage.Estimated, usage.Cached),
+		Canceled:         usage.Canceled,
+		Partial:          usage.Partial,
+	})
 }
 
 func (q *Quota) GetInputRatio() float64 {
@@ -211,6 +239,10 @@ func (q *Quota) GetLogMeta(usage *types.Usage) map[string]any {
 		meta["first_response"] = firstResponseTime
 	}
 
+	if q.endUser != "" {
+		meta["end_user"] = q.endUser
+	}
+
 	if usage != nil {
 		promptDetails := usage.PromptTokensDetails
 		completionDetails := usage.CompletionTokensDetails
@@ -244,6 +276,22 @@ func (q *Quota) GetLogMeta(usage *types.Usage) map[string]any {
 		if completionDetails.TextTokens != 0 {
 			meta["output_text_tokens"] = completionDetails.TextTokens
 		}
+
+		if usage.Estimated {
+			meta["usage_estimated"] = true
+		}
+
+		if usage.Cached {
+			meta["response_cached"] = true
+		}
+
+		if usage.Canceled {
+			meta["upstream_canceled"] = true
+		}
+
+		if usage.Partial {
+			meta["usage_partial"] = true
+		}
 	}
 
 	return meta
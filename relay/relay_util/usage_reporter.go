@@ -0,0 +1,102 @@
+package relay_util
+
+import (
+	"context"
+	"encoding/json"
+	"one-api/common/logger"
+	"one-api/common/utils"
+	"sync"
+)
+
+// 用量来源
+const (
+	UsageSourceUpstream  = "upstream"
+	UsageSourceEstimated = "estimated"
+	UsageSourceCache     = "cache"
+)
+
+// 每次请求完成后上报的用量事件，流式请求在流结束后上报
+type UsageReportEvent struct {
+	RequestId string `json:"request_id"`
+	UserId    int    `json:"user_id"`
+	TokenId   int    `json:"token_id"`
+	// 请求中的 user（终端用户标识）
+	EndUser          string `json:"end_user,omitempty"`
+	ChannelId        int    `json:"channel_id"`
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	Quota            int    `json:"quota"`
+	IsStream         bool   `json:"is_stream"`
+	// 用量来源，上游返回、本地估算或命中缓存
+	Source   string `json:"source"`
+	Canceled bool   `json:"canceled,omitempty"`
+	// 流式响应中断，只包含已输出部分的用量
+	Partial bool `json:"partial,omitempty"`
+}
+
+// 用量上报接口，用于将用量同步到外部计费系统
+type UsageReporter interface {
+	ReportUsage(ctx context.Context, event *UsageReportEvent)
+}
+
+type NoopUsageReporter struct{}
+
+func (NoopUsageReporter) ReportUsage(ctx context.Context, event *UsageReportEvent) {}
+
+// 将用量事件写入日志
+type LoggingUsageReporter struct{}
+
+func (LoggingUsageReporter) ReportUsage(ctx context.Context, event *UsageReportEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.LogError(ctx, "marshal usage event failed: "+err.Error())
+		return
+	}
+
+	logger.LogInfo(ctx, "usage event: "+string(data))
+}
+
+var (
+	usageReporter   UsageReporter = NoopUsageReporter{}
+	usageReporterMu sync.RWMutex
+)
+
+// 根据配置 usage_reporter 初始化，log 为写入日志，默认不上报
+func InitUsageReporter() {
+	switch utils.GetOrDefault("usage_reporter", "") {
+	case "log":
+		SetUsageReporter(LoggingUsageReporter{})
+	default:
+		SetUsageReporter(NoopUsageReporter{})
+	}
+}
+
+func SetUsageReporter(reporter UsageReporter) {
+	usageReporterMu.Lock()
+	defer usageReporterMu.Unlock()
+
+	if reporter == nil {
+		reporter = NoopUsageReporter{}
+	}
+	usageReporter = reporter
+}
+
+func GetUsageReporter() UsageReporter {
+	usageReporterMu.RLock()
+	defer usageReporterMu.RUnlock()
+
+	return usageReporter
+}
+
+func getUsageSource(estimated, cached bool) string {
+	switch {
+	case cached:
+		return UsageSourceCache
+	case estimated:
+		return UsageSourceEstimated
+	default:
+		return UsageSourceUpstream
+	}
+}
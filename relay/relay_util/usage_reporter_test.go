@@ -0,0 +1,72 @@
+package relay_util
+
+import (
+	"context"
+	"one-api/model"
+	"one-api/types"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUsageReporter struct {
+	mu     sync.Mutex
+	events []*UsageReportEvent
+}
+
+func (r *testUsageReporter) ReportUsage(ctx context.Context, event *UsageReportEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestReportUsage(t *testing.T) {
+	reporter := &testUsageReporter{}
+	SetUsageReporter(reporter)
+	defer SetUsageReporter(nil)
+
+	q := &Quota{
+		modelName:   "meta/meta-llama-3-70b-instruct",
+		price:       model.Price{Type: model.TokensPriceType, Input: 1, Output: 2},
+		inputRatio:  1,
+		outputRatio: 2,
+		userId:      1,
+		channelId:   2,
+		tokenId:     3,
+		requestId:   "req-1",
+		endUser:     "end-user-1",
+	}
+
+	usage := &types.Usage{PromptTokens: 10, CompletionTokens: 5, Estimated: true, Partial: true}
+	q.reportUsage(context.Background(), usage, q.GetTotalQuotaByUsage(usage), true)
+
+	assert.Len(t, reporter.events, 1)
+	assert.Equal(t, &UsageReportEvent{
+		RequestId:        "req-1",
+		UserId:           1,
+		TokenId:          3,
+		EndUser:          "end-user-1",
+		ChannelId:        2,
+		Model:            "meta/meta-llama-3-70b-instruct",
+		PromptTokens:     10,
+		CompletionTokens: 5,
+		TotalTokens:      15,
+		Quota:            20,
+		IsStream:         true,
+		Source:           UsageSourceEstimated,
+		Partial:          true,
+	}, reporter.events[0])
+
+	// 消费日志中也记录终端用户
+	assert.Equal(t, "end-user-1", q.GetLogMeta(usage)["end_user"])
+}
+
+func TestGetUsageSource(t *testing.T) {
+	assert.Equal(t, UsageSourceUpstream, getUsageSource(false, false))
+	assert.Equal(t, UsageSourceEstimated, getUsageSource(true, false))
+	assert.Equal(t, UsageSourceCache, getUsageSource(true, true))
+
+	SetUsageReporter(nil)
+	assert.Equal(t, NoopUsageReporter{}, GetUsageReporter())
+}
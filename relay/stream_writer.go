@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 客户端已断开，流式循环收到后应停止并关闭上游
+var ErrClientGone = errors.New("client disconnected")
+
+// 流式响应写入器，检查写入错误并统计已发送字节数
+type streamWriter struct {
+	c     *gin.Context
+	bytes int64
+	err   error
+}
+
+func newStreamWriter(c *gin.Context) *streamWriter {
+	return &streamWriter{c: c}
+}
+
+// 写入并刷新数据，客户端断开或写入失败后始终返回 ErrClientGone
+func (w *streamWriter) write(data string) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	select {
+	case <-w.c.Request.Context().Done():
+		w.err = ErrClientGone
+		return w.err
+	default:
+	}
+
+	n, err := w.c.Writer.Write([]byte(data))
+	w.bytes += int64(n)
+	if err != nil {
+		w.err = fmt.Errorf("%w: %v", ErrClientGone, err)
+		return w.err
+	}
+	w.c.Writer.Flush()
+
+	return nil
+}
+
+func (w *streamWriter) written() int64 {
+	return w.bytes
+}
@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingResponseWriter struct {
+	gin.ResponseWriter
+	writes int
+}
+
+// 第二次写入开始返回错误，模拟客户端断开
+func (w *failingResponseWriter) Write(data []byte) (int, error) {
+	w.writes++
+	if w.writes >= 2 {
+		return 0, errors.New("broken pipe")
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// 持续产生数据直到被关闭
+type endlessStreamReader struct {
+	closed chan struct{}
+}
+
+func (r *endlessStreamReader) Recv() (<-chan string, <-chan error) {
+	dataChan := make(chan string)
+	errChan := make(chan error)
+	go func() {
+		for {
+			select {
+			case dataChan <- `{"id":"1"}`:
+			case <-r.closed:
+				return
+			}
+		}
+	}()
+
+	return dataChan, errChan
+}
+
+func (r *endlessStreamReader) Close() {
+	close(r.closed)
+}
+
+func TestResponseStreamClientWriteError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	writer := &failingResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+
+	stream := &endlessStreamReader{closed: make(chan struct{})}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		responseStreamClient(c, stream, nil)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream loop did not stop after write error")
+	}
+
+	assert.Equal(t, 2, writer.writes)
+	assert.Equal(t, "data: {\"id\":\"1\"}\n\n", recorder.Body.String())
+
+	select {
+	case <-stream.closed:
+	default:
+		t.Fatal("stream was not closed")
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Writer = &failingResponseWriter{ResponseWriter: c.Writer}
+
+	writer := newStreamWriter(c)
+	assert.Nil(t, writer.write("hello"))
+	assert.Equal(t, int64(5), writer.written())
+
+	err := writer.write("world")
+	assert.ErrorIs(t, err, ErrClientGone)
+	assert.ErrorIs(t, writer.write("again"), ErrClientGone)
+	assert.Equal(t, int64(5), writer.written())
+}
@@ -430,6 +430,7 @@ type ChatCompletionStreamResponse struct {
 	Choices           []ChatCompletionStreamChoice `json:"choices"`
 	PromptAnnotations any                          `json:"prompt_annotations,omitempty"`
 	Usage             *Usage                       `json:"usage,omitempty"`
+	SystemFingerprint string                       `json:"system_fingerprint,omitempty"`
 }
 
 func (c *ChatCompletionStreamResponse) GetResponseText() (responseText string) {
@@ -11,6 +11,15 @@ type Usage struct {
 	TotalTokens             int                     `json:"total_tokens"`
 	PromptTokensDetails     PromptTokensDetails     `json:"prompt_tokens_details"`
 	CompletionTokensDetails CompletionTokensDetails `json:"completion_tokens_details"`
+
+	// 上游未返回用量，由本地 tokenizer 估算
+	Estimated bool `json:"-"`
+	// 命中响应缓存
+	Cached bool `json:"-"`
+	// 客户端断开后上游请求被取消，只计费已返回的内容
+	Canceled bool `json:"-"`
+	// 流式响应未正常结束，用量只统计已输出的内容
+	Partial bool `json:"-"`
 }
 
 type PromptTokensDetails struct {